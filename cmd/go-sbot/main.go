@@ -20,7 +20,6 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/cryptix/go/logging"
-	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/margaret"
@@ -30,34 +29,73 @@ import (
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/indexes"
 	"go.cryptoscope.co/ssb/internal/ctxutils"
+	"go.cryptoscope.co/ssb/internal/passphrase"
 	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/network"
+	"go.cryptoscope.co/ssb/plugins/feedgc"
+	"go.cryptoscope.co/ssb/plugins/pubmode"
+	"go.cryptoscope.co/ssb/plugins/quota"
 	"go.cryptoscope.co/ssb/plugins2"
 	"go.cryptoscope.co/ssb/plugins2/bytype"
 	"go.cryptoscope.co/ssb/plugins2/names"
+	"go.cryptoscope.co/ssb/plugins2/query"
 	"go.cryptoscope.co/ssb/plugins2/tangles"
 	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/repo/atrest"
 	mksbot "go.cryptoscope.co/ssb/sbot"
 )
 
 var (
 	// flags
-	flagCleanup  bool
-	flagReindex  bool
-	flagFSCK     string
-	flagRepair   bool
-	flagFatBot   bool
-	flagHops     uint
-	flagEnAdv    bool
-	flagEnDiscov bool
-	flagPromisc  bool
+	flagCleanup   bool
+	flagReindex   bool
+	flagFSCK      string
+	flagRepair    bool
+	flagFatBot    bool
+	flagHops      uint
+	flagEnAdv     bool
+	flagEnDiscov  bool
+	flagEnRoom    bool
+	flagEnPeerInv bool
+	flagPromisc   bool
+
+	flagMaxConns uint
+	flagMaxPerIP uint
+
+	flagBWGlobal int64
+	flagBWPeer   int64
+	flagBWBlobs  int64
+
+	flagPub           bool
+	flagPubAddr       string
+	flagPubFollowBack string
+	flagPubPrune      time.Duration
+
+	flagQuota         string
+	flagQuotaInterval time.Duration
+
+	flagGCGrace    time.Duration
+	flagGCInterval time.Duration
+	flagGCDryRun   bool
+	flagGCExempt   string
 
 	flagDecryptPrivate  bool
 	flagDisableUNIXSock bool
-
-	listenAddr string
-	debugAddr  string
-	repoDir    string
-	dbgLogDir  string
+	flagPassFD          int
+
+	flagMigrateDryRun   bool
+	flagMigrateNoBackup bool
+	flagAtRest          string
+	flagCompact         bool
+
+	listenAddr   string
+	wsListenAddr string
+	socksAddr    string
+	socksAll     bool
+	onionAddr    string
+	debugAddr    string
+	repoDir      string
+	dbgLogDir    string
 
 	// helper
 	log        logging.Interface
@@ -89,31 +127,58 @@ func initFlags() {
 	checkFatal(err)
 
 	flag.UintVar(&flagHops, "hops", 1, "how many hops to fetch (1: friends, 2:friends of friends)")
+	flag.UintVar(&flagMaxConns, "maxconns", 0, "maximum number of peer connections (0: unlimited). wanted peers can evict unwanted ones when full")
+	flag.UintVar(&flagMaxPerIP, "maxperip", 0, "maximum number of peer connections from the same IP (0: unlimited)")
+	flag.Int64Var(&flagBWGlobal, "bwlimit", 0, "bytes per second for all connections together (0: unlimited)")
+	flag.Int64Var(&flagBWPeer, "bwpeer", 0, "bytes per second for each peer (0: unlimited)")
+	flag.Int64Var(&flagBWBlobs, "bwblobs", 0, "bytes per second for sending blobs, so they can't starve feed replication (0: unlimited)")
 	flag.BoolVar(&flagPromisc, "promisc", false, "bypass graph auth and fetch remote's feed")
 
 	flag.StringVar(&appKey, "shscap", "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=", "secret-handshake app-key (or capability)")
 	flag.StringVar(&hmacSec, "hmac", "", "if set, sign with hmac hash of msg, instead of plain message object, using this key")
 
 	flag.StringVar(&listenAddr, "l", ":8008", "address to listen on")
+	flag.StringVar(&wsListenAddr, "wslisten", "", "if set, also accept connections over websockets on this address (like :8989)")
+	flag.StringVar(&socksAddr, "socks5", "", "SOCKS5 proxy to dial onion: addresses with (like tor on localhost:9050)")
+	flag.BoolVar(&socksAll, "socks5all", false, "dial all connections through the -socks5 proxy, not just onion ones")
+	flag.StringVar(&onionAddr, "onion", "", "announce this onion service (xyz.onion:8008) as an address. it needs to forward to -l")
 	flag.BoolVar(&flagEnAdv, "localadv", false, "enable sending local UDP brodcasts")
 	flag.BoolVar(&flagEnDiscov, "localdiscov", false, "enable connecting to incomming UDP brodcasts")
+	flag.BoolVar(&flagEnPeerInv, "peerinvites", false, "confirm peer invites that are created by friends")
+	flag.BoolVar(&flagEnRoom, "room", false, "act as a room and relay tunnel connections between connected peers")
+	flag.BoolVar(&flagPub, "pub", false, "act as a pub: announce the address in a pub message and follow feeds back")
+	flag.StringVar(&flagPubAddr, "pubaddr", "", "host:port to announce in pub mode (defaults to -l if that has a specific IP)")
+	flag.StringVar(&flagPubFollowBack, "pubfollowback", "invites", "who to follow in pub mode (invites, followers or peers)")
+	flag.DurationVar(&flagPubPrune, "pubprune", 0, "in pub mode, unfollow followed-back feeds that didn't connect for this long (0: never)")
+	flag.StringVar(&flagQuota, "quota", "", "storage limits of replicated feeds, per hop or feed (like 2:messages=1000,age=720h;@feed.ed25519:bytes=1048576)")
+	flag.DurationVar(&flagQuotaInterval, "quotainterval", time.Hour, "how often feeds are checked against -quota")
+	flag.DurationVar(&flagGCGrace, "gcgrace", 0, "drop stored feeds that are out of hop range and not replicated for this long (0: never)")
+	flag.DurationVar(&flagGCInterval, "gcinterval", time.Hour, "how often the stored feeds are checked for -gcgrace")
+	flag.BoolVar(&flagGCDryRun, "gcdryrun", false, "only log the feeds that -gcgrace would drop")
+	flag.StringVar(&flagGCExempt, "gcexempt", "", "comma separated feeds that -gcgrace never drops")
 
 	flag.BoolVar(&flagDecryptPrivate, "decryptprivate", false, "store which messages can be decrypted")
 	flag.BoolVar(&flagDisableUNIXSock, "nounixsock", false, "disable the UNIX socket RPC interface")
 
 	flag.StringVar(&repoDir, "repo", filepath.Join(u.HomeDir, ".ssb-go"), "where to put the log and indexes")
+	flag.StringVar(&flagAtRest, "atrest", "", "encrypt a new repo at rest with a key derived from its secret or a passphrase (secret or passphrase)")
+	flag.IntVar(&flagPassFD, "passfd", -1, "read the passphrase of an encrypted secret from this file descriptor (otherwise $SSB_PASSPHRASE or a prompt)")
 
 	flag.StringVar(&debugAddr, "dbg", "localhost:6078", "listen addr for metrics and pprof HTTP server")
 	flag.StringVar(&dbgLogDir, "dbgdir", "", "where to write debug output to")
 
-	flag.BoolVar(&flagFatBot, "fatbot", false, "if set, sbot loads additional index plugins (bytype, get, tangles)")
+	flag.BoolVar(&flagFatBot, "fatbot", false, "if set, sbot loads additional index plugins (bytype, get, tangles, query)")
 	flag.BoolVar(&flagReindex, "reindex", false, "if set, sbot exits after having its indicies updated")
 
-	flag.BoolVar(&flagCleanup, "cleanup", false, "remove blocked feeds")
+	flag.BoolVar(&flagCleanup, "cleanup", false, "remove blocked feeds (see -gcgrace for feeds that are out of range)")
 
 	flag.StringVar(&flagFSCK, "fsck", "", "run a filesystem check on the repo (possible values: length, sequences)")
 	flag.BoolVar(&flagRepair, "repair", false, "run repo healing if fsck fails")
 
+	flag.BoolVar(&flagMigrateDryRun, "migratedryrun", false, "print the pending repo migrations and exit")
+	flag.BoolVar(&flagMigrateNoBackup, "migratenobackup", false, "don't back up the repo before destructive migrations")
+	flag.BoolVar(&flagCompact, "compact", false, "remove deleted messages from the root log before starting (rebuilds the indexes)")
+
 	flag.BoolVar(&flagPrintVersion, "version", false, "print version number and build date")
 
 	flag.Parse()
@@ -143,6 +208,53 @@ func runSbot() error {
 		return nil
 	}
 
+	pass := passphrase.Source("SSB_PASSPHRASE", flagPassFD)
+
+	// the lock is held from the migrations until the bot is closed, it is handed to the bot below.
+	// closing it again is a noop.
+	r := repo.New(repoDir)
+	lock, err := repo.Lock(r)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if flagAtRest != "" {
+		if err := enableAtRest(r, atrest.Mode(flagAtRest), pass); err != nil {
+			return err
+		}
+	}
+
+	// backups before destructive migrations need the key
+	r, err = repo.Unlocked(r, pass)
+	if err != nil {
+		return err
+	}
+	steps, err := migrations.Run(context.Background(), log, r, migrations.Options{
+		DryRun:   flagMigrateDryRun,
+		NoBackup: flagMigrateNoBackup,
+		Progress: func(m migrations.Migration, done, total int64) {
+			if done%1000 == 0 || done == total {
+				level.Info(log).Log("event", "migration progress", "version", m.Version, "done", done, "total", total)
+			}
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "sbot: repo migration failed")
+	}
+	if flagMigrateDryRun {
+		for _, step := range steps {
+			fmt.Printf("%d %s: %s\n", step.Version, step.Name, step.Plan)
+		}
+		return nil
+	}
+
+	if flagCompact {
+		if err := compactLog(r); err != nil {
+			return err
+		}
+	}
+
 	ctx, cancel := ctxutils.WithError(context.Background(), ssb.ErrShuttingDown)
 	defer func() {
 		cancel()
@@ -163,37 +275,86 @@ func runSbot() error {
 		mksbot.WithInfo(log),
 		mksbot.WithAppKey(ak),
 		mksbot.WithRepoPath(repoDir),
+		mksbot.WithRepoLock(lock),
+		mksbot.WithPassphrase(pass),
 		mksbot.WithListenAddr(listenAddr),
 		mksbot.EnableAdvertismentBroadcasts(flagEnAdv),
 		mksbot.EnableAdvertismentDialing(flagEnDiscov),
+		mksbot.EnableRoom(flagEnRoom),
 	}
 
-	if !flagDisableUNIXSock {
-		opts = append(opts, mksbot.LateOption(mksbot.WithUNIXSocket()))
+	if wsListenAddr != "" {
+		opts = append(opts, mksbot.WithWebsocketAddress(wsListenAddr))
 	}
 
-	if flagDecryptPrivate {
-		// TODO: refactor into plugins2
-		r := repo.New(repoDir)
-		kpsByPath, err := repo.AllKeyPairs(r)
+	if socksAddr != "" {
+		opts = append(opts, mksbot.WithSOCKS5(socksAddr, socksAll))
+	}
+
+	if onionAddr != "" {
+		opts = append(opts, mksbot.WithOnionAddress(onionAddr))
+	}
+
+	if flagEnPeerInv {
+		opts = append(opts, mksbot.EnablePeerInvites())
+	}
+
+	if flagPub {
+		fb, err := pubmode.ParseFollowBack(flagPubFollowBack)
 		if err != nil {
-			return errors.Wrap(err, "sbot: failed to open all keypairs in repo")
+			return err
 		}
+		opts = append(opts, mksbot.EnablePubMode(pubmode.Options{
+			Address:    flagPubAddr,
+			FollowBack: fb,
+			PruneAfter: flagPubPrune,
+		}))
+	}
 
-		var kps []*ssb.KeyPair
-		for _, v := range kpsByPath {
-			kps = append(kps, v)
+	if flagQuota != "" {
+		qopts, err := quota.ParseOptions(flagQuota)
+		if err != nil {
+			return err
 		}
+		qopts.Interval = flagQuotaInterval
+		opts = append(opts, mksbot.WithStoragePolicy(qopts))
+	}
 
-		defKP, err := repo.DefaultKeyPair(r)
+	if flagGCGrace > 0 {
+		exempt, err := feedgc.ParseExempt(flagGCExempt)
 		if err != nil {
-			return errors.Wrap(err, "sbot: failed to open default keypair")
+			return err
 		}
-		kps = append(kps, defKP)
+		opts = append(opts, mksbot.WithFeedGC(feedgc.Options{
+			Grace:    flagGCGrace,
+			Interval: flagGCInterval,
+			Exempt:   exempt,
+			DryRun:   flagGCDryRun,
+		}))
+	}
+
+	if flagBWGlobal > 0 || flagBWPeer > 0 || flagBWBlobs > 0 {
+		opts = append(opts, mksbot.WithBandwidthLimits(network.BandwidthLimits{
+			Global: flagBWGlobal,
+			Peer:   flagBWPeer,
+			Blobs:  flagBWBlobs,
+		}))
+	}
 
-		mlogPriv := multilogs.NewPrivateRead(kitlog.With(log, "module", "privLogs"), kps...)
+	if flagMaxConns > 0 || flagMaxPerIP > 0 {
+		opts = append(opts, mksbot.WithConnPolicy(network.ConnPolicy{
+			MaxConns: flagMaxConns,
+			MaxPerIP: flagMaxPerIP,
+		}))
+	}
 
-		opts = append(opts, mksbot.LateOption(mksbot.MountMultiLog("privLogs", mlogPriv.OpenRoaring)))
+	if !flagDisableUNIXSock {
+		opts = append(opts, mksbot.LateOption(mksbot.WithUNIXSocket()))
+	}
+
+	if flagDecryptPrivate {
+		// unboxes for the key pair of the bot and the ones in the secrets folder
+		opts = append(opts, mksbot.EnablePrivateReads())
 	}
 
 	if flagFatBot {
@@ -202,6 +363,7 @@ func runSbot() error {
 			mksbot.LateOption(mksbot.MountPlugin(&tangles.Plugin{}, plugins2.AuthMaster)),
 			mksbot.LateOption(mksbot.MountPlugin(&names.Plugin{}, plugins2.AuthMaster)),
 			mksbot.LateOption(mksbot.MountPlugin(&bytype.Plugin{}, plugins2.AuthMaster)),
+			mksbot.LateOption(mksbot.MountPlugin(&query.Plugin{}, plugins2.AuthMaster)),
 		)
 	}
 
@@ -261,6 +423,8 @@ func runSbot() error {
 	}()
 	logging.SetCloseChan(c)
 
+	registerBandwidthMetrics(sbot.BandwidthStats)
+
 	id := sbot.KeyPair.Id
 	uf, ok := sbot.GetMultiLog(multilogs.IndexNameFeeds)
 	if !ok {
@@ -423,3 +587,59 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// enableAtRest sets up encryption at rest for a new repo, or checks that an existing one uses mode
+func enableAtRest(r repo.Interface, mode atrest.Mode, askPass ssb.PassphraseFunc) error {
+	if mode != atrest.ModeSecret && mode != atrest.ModePassphrase {
+		return errors.Errorf("sbot: unknown -atrest mode %q", mode)
+	}
+	params, err := repo.ReadAtRestParams(r)
+	if err != nil {
+		return err
+	}
+	if params != nil {
+		if params.Mode != mode {
+			return errors.Errorf("sbot: the repo is encrypted at rest with mode %s", params.Mode)
+		}
+		return nil
+	}
+
+	kp, err := repo.DefaultKeyPairWithPassphrase(r, askPass)
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to get keypair")
+	}
+	var pass []byte
+	if mode == atrest.ModePassphrase {
+		pass, err = askPass(r.GetPath(repo.AtRestConfigName))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = repo.EnableAtRest(r, mode, kp, pass)
+	if err != nil {
+		return err
+	}
+	level.Info(log).Log("event", "repo encrypted at rest", "mode", mode)
+	return nil
+}
+
+// compactLog copies the root log without the nulled messages, the bot puts the copy in place when it starts.
+// The caller holds the lock of the repo.
+func compactLog(r repo.Interface) error {
+	rootLog, err := repo.OpenLog(r)
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to open root log for compaction")
+	}
+	defer rootLog.Close()
+
+	stats, err := repo.CompactLog(context.Background(), r, rootLog, func(done, total int64) {
+		if done%1000 == 0 || done == total {
+			level.Info(log).Log("event", "compaction progress", "done", done, "total", total)
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "sbot: compaction failed")
+	}
+	level.Info(log).Log("event", "root log copied for compaction", "copied", stats.Copied, "dropped", stats.Dropped)
+	return nil
+}
@@ -16,6 +16,8 @@ import (
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/netwrap"
+
+	"go.cryptoscope.co/ssb"
 )
 
 var (
@@ -214,6 +216,47 @@ func (lw *latencyWrapper) Serve(ctx context.Context) error {
 	return srv.Serve(ctx)
 }
 
+func registerBandwidthMetrics(stats func() ssb.BandwidthStats) {
+	if debugAddr == "" {
+		return
+	}
+	stdprometheus.MustRegister(newBandwidthCollector(stats))
+}
+
+// bandwidthCollector exposes the byte counters of the network
+type bandwidthCollector struct {
+	stats func() ssb.BandwidthStats
+
+	wire, peer *stdprometheus.Desc
+}
+
+func newBandwidthCollector(stats func() ssb.BandwidthStats) *bandwidthCollector {
+	return &bandwidthCollector{
+		stats: stats,
+		wire: stdprometheus.NewDesc("gossb_network_wire_bytes_total",
+			"bytes on the wire, including handshakes and encryption overhead",
+			[]string{"direction"}, nil),
+		peer: stdprometheus.NewDesc("gossb_network_peer_bytes_total",
+			"muxrpc bytes exchanged with each connected peer",
+			[]string{"peer", "direction"}, nil),
+	}
+}
+
+func (bc *bandwidthCollector) Describe(ch chan<- *stdprometheus.Desc) {
+	ch <- bc.wire
+	ch <- bc.peer
+}
+
+func (bc *bandwidthCollector) Collect(ch chan<- stdprometheus.Metric) {
+	st := bc.stats()
+	ch <- stdprometheus.MustNewConstMetric(bc.wire, stdprometheus.CounterValue, float64(st.RX), "rx")
+	ch <- stdprometheus.MustNewConstMetric(bc.wire, stdprometheus.CounterValue, float64(st.TX), "tx")
+	for _, p := range st.Peers {
+		ch <- stdprometheus.MustNewConstMetric(bc.peer, stdprometheus.CounterValue, float64(p.RX), p.Peer, "rx")
+		ch <- stdprometheus.MustNewConstMetric(bc.peer, stdprometheus.CounterValue, float64(p.TX), p.Peer, "tx")
+	}
+}
+
 type promCount struct {
 	*countconn.Reader
 	*countconn.Writer
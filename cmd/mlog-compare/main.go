@@ -16,6 +16,7 @@ import (
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
 
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/repo"
 )
@@ -44,6 +45,15 @@ func main() {
 
 	r := repo.New(repoPath)
 
+	lock, err := repo.Lock(r)
+	check(err)
+	defer lock.Close()
+
+	r, err = repo.Unlocked(r, ssb.SecretPassphrase)
+	check(err)
+	check(repo.UnsealIndexes(r))
+	defer func() { check(repo.SealIndexes(r)) }()
+
 	rootLog, err := repo.OpenLog(r)
 	check(err)
 
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb/plugins/admin"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+var backupCmd = &cli.Command{
+	Name:      "backup",
+	Usage:     "make a consistent copy of the repo of the running bot (admin.backup)",
+	UsageText: "backup [--blobs none|list|all] <folder>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "blobs", Value: string(repo.BackupBlobsList), Usage: "none, list (only the refs) or all (copy them)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.Args().Len() != 1 {
+			return errors.Errorf("backup: expected the destination folder as the only argument")
+		}
+		// the bot writes the backup, relative paths would be relative to its working directory
+		dst, err := filepath.Abs(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		arg := admin.BackupArgs{
+			Path:  dst,
+			Blobs: repo.BackupBlobs(ctx.String("blobs")),
+		}
+		v, err := client.Async(longctx, repo.BackupManifest{}, muxrpc.Method{"admin", "backup"}, arg)
+		if err != nil {
+			return errors.Wrap(err, "admin.backup: async call failed")
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	},
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 
 	"github.com/pkg/errors"
@@ -17,6 +18,7 @@ var friendsCmd = &cli.Command{
 		friendsIsFollowingCmd,
 		friendsBlocksCmd,
 		friendsHopsCmd,
+		friendsExplainCmd,
 	},
 }
 
@@ -133,3 +135,43 @@ var friendsBlocksCmd = &cli.Command{
 		return err
 	},
 }
+
+var friendsExplainCmd = &cli.Command{
+	Name:      "explain",
+	Usage:     "why is a feed (not) replicated",
+	ArgsUsage: "<to> [from]",
+	Action: func(ctx *cli.Context) error {
+		var arg friends.ExplainArgs
+
+		to := ctx.Args().Get(0)
+		if to == "" {
+			return errors.New("friends.explain: needs the feed to explain as param 1")
+		}
+		var err error
+		arg.To, err = ssb.ParseFeedRef(to)
+		if err != nil {
+			return err
+		}
+
+		if from := ctx.Args().Get(1); from != "" {
+			arg.From, err = ssb.ParseFeedRef(from)
+			if err != nil {
+				return err
+			}
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, friends.Explanation{}, muxrpc.Method{"friends", "explain"}, arg)
+		if err != nil {
+			return errors.Wrapf(err, "friends.explain: async call failed.")
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	},
+}
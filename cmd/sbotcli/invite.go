@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/invite"
+	"go.cryptoscope.co/ssb/plugins/legacyinvites"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var inviteCmd = &cli.Command{
+	Name: "invite",
+	Subcommands: []*cli.Command{
+		inviteCreateCmd,
+		inviteListCmd,
+		inviteRevokeCmd,
+		inviteUseCmd,
+	},
+}
+
+var inviteCreateCmd = &cli.Command{
+	Name:  "create",
+	Usage: "create a new invite code",
+	Flags: []cli.Flag{
+		&cli.UintFlag{Name: "uses", Value: 1, Usage: "how many times the invite can be used"},
+		&cli.StringFlag{Name: "note", Usage: "a note to organize invites (also posted when used)"},
+		&cli.DurationFlag{Name: "expires", Usage: "how long the invite is valid (0 means forever)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		var arg legacyinvites.CreateArguments
+		arg.Uses = ctx.Uint("uses")
+		arg.Note = ctx.String("note")
+		if d := ctx.Duration("expires"); d > 0 {
+			arg.Expires = time.Now().Add(d).Unix() * 1000
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, "str", muxrpc.Method{"invite", "create"}, arg)
+		if err != nil {
+			return errors.Wrapf(err, "invite.create: async call failed.")
+		}
+
+		code, ok := v.(string)
+		if !ok {
+			return errors.Errorf("invite.create: invalid return type: %T", v)
+		}
+		log.Log("event", "invite.create", "invite", code)
+		return nil
+	},
+}
+
+var inviteListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list the invites with remaining uses and who used them",
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, []legacyinvites.Invite{}, muxrpc.Method{"invite", "list"})
+		if err != nil {
+			return errors.Wrapf(err, "invite.list: async call failed.")
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	},
+}
+
+var inviteRevokeCmd = &cli.Command{
+	Name:      "revoke",
+	Usage:     "revoke an invite by its id or code",
+	ArgsUsage: "<id|invite>",
+	Action: func(ctx *cli.Context) error {
+		which := ctx.Args().Get(0)
+		if which == "" {
+			return errors.New("invite.revoke: needs id or invite code as param 1")
+		}
+
+		var arg legacyinvites.RevokeArguments
+		if ref, err := ssb.ParseFeedRef(which); err == nil {
+			arg.ID = ref
+		} else {
+			arg.Invite = which
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, "str", muxrpc.Method{"invite", "revoke"}, arg)
+		if err != nil {
+			return errors.Wrapf(err, "invite.revoke: async call failed.")
+		}
+		log.Log("event", "invite.revoke", "result", v)
+		return nil
+	},
+}
+
+var inviteUseCmd = &cli.Command{
+	Name:      "use",
+	Usage:     "redeem an invite code with the local key",
+	ArgsUsage: "<invite>",
+	Action: func(ctx *cli.Context) error {
+		code := ctx.Args().Get(0)
+		if code == "" {
+			return errors.New("invite.use: needs invite code as param 1")
+		}
+
+		tok, err := invite.ParseLegacyToken(code)
+		if err != nil {
+			return errors.Wrap(err, "invite.use: invalid invite")
+		}
+
+		localKey, err := ssb.LoadKeyPair(ctx.String("key"))
+		if err != nil {
+			return err
+		}
+
+		err = invite.Redeem(longctx, tok, localKey.Id)
+		if err != nil {
+			return err
+		}
+		log.Log("event", "invite.use", "peer", tok.Peer.Ref())
+		return nil
+	},
+}
@@ -25,12 +25,14 @@ import (
 	"github.com/go-kit/kit/log/term"
 	"github.com/pkg/errors"
 	goon "github.com/shurcooL/go-goon"
+	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/netwrap"
 	"go.cryptoscope.co/secretstream"
 	"go.cryptoscope.co/ssb"
 	ssbClient "go.cryptoscope.co/ssb/client"
 	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/plugins2/query"
 	"golang.org/x/crypto/ed25519"
 	cli "gopkg.in/urfave/cli.v2"
 )
@@ -77,15 +79,20 @@ var app = cli.App{
 
 	Before: initClient,
 	Commands: []*cli.Command{
+		backupCmd,
 		blobsCmd,
 		blockCmd,
 		friendsCmd,
+		inviteCmd,
+		peerInvitesCmd,
 		logStreamCmd,
+		feedStreamCmd,
 		typeStreamCmd,
 		historyStreamCmd,
 		replicateUptoCmd,
 		callCmd,
 		connectCmd,
+		connEventsCmd,
 		queryCmd,
 		privateCmd,
 		publishCmd,
@@ -317,8 +324,50 @@ var blockCmd = &cli.Command{
 }
 
 var queryCmd = &cli.Command{
-	Name:   "qry",
-	Action: todo, //query,
+	Name:      "qry",
+	Usage:     "filter messages using query.read",
+	UsageText: `qry '{"value": {"content": {"type": "post"}}}'`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{Name: "limit", Value: -1},
+		&cli.BoolFlag{Name: "reverse"},
+		&cli.BoolFlag{Name: "live"},
+		&cli.Int64Flag{Name: "gt", Value: -1, Usage: "only return results after this cursor"},
+		&cli.Int64Flag{Name: "lt", Value: -1, Usage: "only return results before this cursor"},
+	},
+	Action: func(ctx *cli.Context) error {
+		filter := ctx.Args().First()
+		if filter == "" {
+			filter = "{}"
+		}
+		if !json.Valid([]byte(filter)) {
+			return errors.Errorf("qry: filter is not valid JSON")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var args = query.ReadArgs{
+			Query:   json.RawMessage(filter),
+			Live:    ctx.Bool("live"),
+			Reverse: ctx.Bool("reverse"),
+			Limit:   ctx.Int64("limit"),
+		}
+		if gt := ctx.Int64("gt"); gt >= 0 {
+			args.Gt = &gt
+		}
+		if lt := ctx.Int64("lt"); lt >= 0 {
+			args.Lt = &lt
+		}
+
+		src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"query", "read"}, args)
+		if err != nil {
+			return errors.Wrap(err, "source stream call failed")
+		}
+		err = luigi.Pump(longctx, jsonDrain(os.Stdout), src)
+		return errors.Wrap(err, "query/read failed")
+	},
 }
 
 var privateCmd = &cli.Command{
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb/plugins/peerinvites"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var peerInvitesCmd = &cli.Command{
+	Name:  "peerInvites",
+	Usage: "invites that are created by friends and confirmed by a pub",
+	Subcommands: []*cli.Command{
+		peerInvitesCreateCmd,
+		peerInvitesOpenCmd,
+		peerInvitesAcceptCmd,
+	},
+}
+
+var peerInvitesCreateCmd = &cli.Command{
+	Name:  "create",
+	Usage: "publish a new peer invite",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{Name: "pub", Usage: "multiserver address of a pub that replicates us (defaults to the address of the bot)"},
+		&cli.BoolFlag{Name: "allowWithoutPubs", Usage: "create the invite even if no pub is known"},
+	},
+	Action: func(ctx *cli.Context) error {
+		var arg peerinvites.CreateArgs
+		arg.Pubs = ctx.StringSlice("pub")
+		arg.AllowWithoutPubs = ctx.Bool("allowWithoutPubs")
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, "str", muxrpc.Method{"peerInvites", "create"}, arg)
+		if err != nil {
+			return errors.Wrapf(err, "peerInvites.create: async call failed.")
+		}
+		log.Log("event", "peerInvites.create", "invite", v)
+		return nil
+	},
+}
+
+var peerInvitesOpenCmd = &cli.Command{
+	Name:      "open",
+	Usage:     "fetch and check an invite from its pubs",
+	ArgsUsage: "<invite>",
+	Action: func(ctx *cli.Context) error {
+		inv := ctx.Args().Get(0)
+		if inv == "" {
+			return errors.New("peerInvites.openInvite: needs invite as param 1")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, peerinvites.OpenedInvite{}, muxrpc.Method{"peerInvites", "openInvite"}, inv)
+		if err != nil {
+			return errors.Wrapf(err, "peerInvites.openInvite: async call failed.")
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	},
+}
+
+var peerInvitesAcceptCmd = &cli.Command{
+	Name:      "accept",
+	Usage:     "redeem an invite with the key of the bot",
+	ArgsUsage: "<invite>",
+	Action: func(ctx *cli.Context) error {
+		inv := ctx.Args().Get(0)
+		if inv == "" {
+			return errors.New("peerInvites.acceptInvite: needs invite as param 1")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, json.RawMessage{}, muxrpc.Method{"peerInvites", "acceptInvite"}, inv)
+		if err != nil {
+			return errors.Wrapf(err, "peerInvites.acceptInvite: async call failed.")
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	},
+}
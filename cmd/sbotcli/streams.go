@@ -12,6 +12,9 @@ import (
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/plugins/conn"
+	"go.cryptoscope.co/ssb/plugins/feedstream"
 	cli "gopkg.in/urfave/cli.v2"
 )
 
@@ -87,6 +90,44 @@ var logStreamCmd = &cli.Command{
 	},
 }
 
+var feedStreamCmd = &cli.Command{
+	Name:      "feed",
+	UsageText: "aka createFeedStream, messages of all feeds ordered by claimed timestamp",
+	Flags: []cli.Flag{
+		&cli.Int64Flag{Name: "limit", Value: -1},
+		&cli.BoolFlag{Name: "reverse"},
+		&cli.BoolFlag{Name: "live"},
+		&cli.BoolFlag{Name: "keys", Value: false},
+		&cli.Int64Flag{Name: "gt", Usage: "only messages after this timestamp (in milliseconds)"},
+		&cli.Int64Flag{Name: "lt", Usage: "only messages before this timestamp (in milliseconds)"},
+		&cli.IntFlag{Name: "hops", Value: -1, Usage: "only messages of feeds this many hops away"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var args feedstream.Args
+		args.Limit = ctx.Int64("limit")
+		args.Reverse = ctx.Bool("reverse")
+		args.Live = ctx.Bool("live")
+		args.Keys = ctx.Bool("keys")
+		args.Gt = ctx.Int64("gt")
+		args.Lt = ctx.Int64("lt")
+		if hops := ctx.Int("hops"); hops >= 0 {
+			args.Hops = &hops
+		}
+
+		src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"createFeedStream"}, args)
+		if err != nil {
+			return errors.Wrap(err, "source stream call failed")
+		}
+		err = luigi.Pump(longctx, jsonDrain(os.Stdout), src)
+		return errors.Wrap(err, "feed stream failed")
+	},
+}
+
 var privateReadCmd = &cli.Command{
 	Name:  "read",
 	Flags: streamFlags,
@@ -125,6 +166,26 @@ var replicateUptoCmd = &cli.Command{
 	},
 }
 
+var connEventsCmd = &cli.Command{
+	Name:      "events",
+	Usage:     "stream peers connecting and disconnecting (conn.events)",
+	UsageText: "events [type...] (connect, disconnect, handshake-failure, auth-denied)",
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		args := conn.EventsArgs{Types: ctx.Args().Slice()}
+		src, err := client.Source(longctx, ssb.ConnEvent{}, muxrpc.Method{"conn", "events"}, args)
+		if err != nil {
+			return errors.Wrap(err, "source stream call failed")
+		}
+		err = luigi.Pump(longctx, jsonDrain(os.Stdout), src)
+		return errors.Wrap(err, "conn events failed")
+	},
+}
+
 func jsonDrain(w io.Writer) luigi.Sink {
 	i := 0
 	return luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
@@ -145,19 +206,3 @@ func jsonDrain(w io.Writer) luigi.Sink {
 		return nil
 	})
 }
-
-/*
-
-func query(ctx *cli.Context) error {
-	reply := make(chan map[string]interface{})
-	go func() {
-		for r := range reply {
-			goon.Dump(r)
-		}
-	}()
-	if err := client.Source("query.read", reply, ctx.Args().Get(0)); err != nil {
-		return errors.Wrap(err, "source stream call failed")
-	}
-	return client.Close()
-}
-*/
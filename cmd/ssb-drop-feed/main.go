@@ -2,10 +2,13 @@
 
 // ssb-drop-feed nulls entries of one particular feed from repo
 // there is no warning or undo
+//
+// if a bot is running on the repo, it asks that bot to do it over its UNIX socket (admin.nullFeed)
 package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -13,8 +16,10 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/client"
 	"go.cryptoscope.co/ssb/repo"
 	"go.cryptoscope.co/ssb/sbot"
 )
@@ -60,6 +65,11 @@ func main() {
 	rmbot, err := sbot.New(
 		sbot.WithRepoPath(os.Args[1]),
 		sbot.WithUNIXSocket())
+	if repo.IsLocked(err) {
+		log.Println("repo is in use, asking the running bot to null the feeds:", errors.Cause(err))
+		check(nullThroughBot(r, refs))
+		return
+	}
 	check(errors.Wrap(err, "failed to open bot"))
 
 	for i, fr := range refs {
@@ -75,8 +85,13 @@ func main() {
 	check(err)
 
 	start := time.Now()
+	lock, err := repo.Lock(r)
+	check(err)
+	r, err = repo.Unlocked(r, ssb.SecretPassphrase)
+	check(err)
 	err = sbot.DropIndicies(r)
 	check(err)
+	check(lock.Close())
 	log.Println("idexes dropped", time.Since(start))
 
 	start = time.Now()
@@ -84,3 +99,22 @@ func main() {
 	check(err)
 	log.Println("idexes rebuilt", time.Since(start))
 }
+
+// nullThroughBot asks the bot that has the repo open to null the feeds
+func nullThroughBot(r repo.Interface, refs []*ssb.FeedRef) error {
+	c, err := client.NewUnix(r.GetPath("socket"))
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the running bot")
+	}
+	defer c.Close()
+
+	for i, fr := range refs {
+		start := time.Now()
+		_, err := c.Async(context.TODO(), true, muxrpc.Method{"admin", "nullFeed"}, fr.Ref())
+		if err != nil {
+			return errors.Wrapf(err, "admin.nullFeed of %s failed", fr.Ref())
+		}
+		log.Printf("feed(%d) %s nulled by the running bot (took %v)", i, fr.Ref(), time.Since(start))
+	}
+	return nil
+}
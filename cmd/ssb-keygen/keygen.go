@@ -11,6 +11,7 @@ import (
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/passphrase"
 	"go.cryptoscope.co/ssb/repo"
 )
 
@@ -30,6 +31,11 @@ func fail(err error) {
 var (
 	repoDir  string
 	feedAlgo string
+
+	encrypt   bool
+	rewrap    bool
+	passFD    int
+	newPassFD int
 )
 
 func init() {
@@ -38,6 +44,10 @@ func init() {
 
 	flag.StringVar(&repoDir, "repo", filepath.Join(u.HomeDir, ".ssb-go"), "where to store the key")
 	flag.StringVar(&feedAlgo, "format", ssb.RefAlgoFeedSSB1, "format to use")
+	flag.BoolVar(&encrypt, "encrypt", false, "encrypt the new secret with a passphrase ($SSB_NEW_PASSPHRASE, -newpassfd or a prompt)")
+	flag.BoolVar(&rewrap, "rewrap", false, "change the passphrase of an existing secret (or encrypt a plain one)")
+	flag.IntVar(&passFD, "passfd", -1, "read the current passphrase from this file descriptor (otherwise $SSB_PASSPHRASE or a prompt)")
+	flag.IntVar(&newPassFD, "newpassfd", -1, "read the new passphrase from this file descriptor")
 
 	flag.Parse()
 
@@ -48,19 +58,48 @@ func main() {
 	args := flag.Args()
 
 	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "usage: %s (-format=algo, -repo=location) <name>", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s (-format=algo, -repo=location, -encrypt, -rewrap) <name>\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "use - as the name for the default secret of the repo")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
+	r := repo.New(repoDir)
+
+	if rewrap {
+		newPass, err := newPassphrase()
+		check(err)
+		kp, err := repo.RewrapKeyPair(r, args[0], passphrase.Source("SSB_PASSPHRASE", passFD), newPass)
+		check(err)
+		fmt.Println(kp.Id.Ref())
+		return
+	}
+
 	if feedAlgo != ssb.RefAlgoFeedSSB1 && feedAlgo != ssb.RefAlgoFeedGabby { //  enums would be nice
 		check(errors.Errorf("invalid feed refrence algo. %s or %s", ssb.RefAlgoFeedSSB1, ssb.RefAlgoFeedGabby))
 	}
 
-	r := repo.New(repoDir)
-
-	kp, err := repo.NewKeyPair(r, args[0], feedAlgo)
-	check(err)
+	var kp *ssb.KeyPair
+	if encrypt {
+		newPass, err := newPassphrase()
+		check(err)
+		kp, err = repo.NewEncryptedKeyPair(r, args[0], feedAlgo, newPass)
+		check(err)
+	} else {
+		var err error
+		kp, err = repo.NewKeyPair(r, args[0], feedAlgo)
+		check(err)
+	}
 
 	fmt.Println(kp.Id.Ref())
 }
+
+func newPassphrase() ([]byte, error) {
+	if newPassFD >= 0 {
+		return passphrase.FromFD(newPassFD)("")
+	}
+	if p, has := os.LookupEnv("SSB_NEW_PASSPHRASE"); has && p != "" {
+		return []byte(p), nil
+	}
+	return passphrase.PromptNew()
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime/debug"
@@ -11,6 +12,8 @@ import (
 
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/client"
+	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/repo"
 
 	"github.com/pkg/errors"
@@ -46,6 +49,18 @@ func main() {
 
 	repoFrom := repo.New(fromPath)
 
+	lock, err := repo.Lock(repoFrom)
+	if repo.IsLocked(err) {
+		// a bot has the repo open, read the log through it instead
+		check(catThroughBot(repoFrom, int64(startSeq.Seq()+1), int64(limit)))
+		return
+	}
+	check(err)
+	defer lock.Close()
+
+	repoFrom, err = repo.Unlocked(repoFrom, ssb.SecretPassphrase)
+	check(err)
+
 	from, err := repo.OpenLog(repoFrom)
 	check(err)
 
@@ -80,3 +95,37 @@ func main() {
 	check(err)
 
 }
+
+// catThroughBot prints the messages from createLogStream of the bot that has the repo open
+func catThroughBot(r repo.Interface, seq, limit int64) error {
+	c, err := client.NewUnix(r.GetPath("socket"))
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the running bot")
+	}
+	defer c.Close()
+
+	var args message.CreateLogArgs
+	args.Keys = true
+	args.Seq = seq
+	args.Limit = limit
+	args.MarshalType = ssb.KeyValueRaw{}
+	src, err := c.CreateLogStream(args)
+	if err != nil {
+		return err
+	}
+	for {
+		v, err := src.Next(context.TODO())
+		if luigi.IsEOS(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "createLogStream failed")
+		}
+		msg, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode message")
+		}
+		os.Stdout.Write(msg)
+		os.Stdout.WriteString("\n")
+	}
+}
@@ -3,6 +3,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
 	"runtime/debug"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/repo"
 	"go.cryptoscope.co/ssb/repo/migrations"
 	"go.cryptoscope.co/ssb/sbot"
@@ -32,27 +35,50 @@ func fail(err error) {
 func main() {
 	logging.SetupLogging(nil)
 	logger := logging.Logger("migrate")
-	if len(os.Args) != 2 {
-		fmt.Fprintln(os.Stderr, "usage: migrate2 <repo>")
+
+	dryRun := flag.Bool("dry-run", false, "only print what the pending migrations would do")
+	noBackup := flag.Bool("no-backup", false, "don't back up the repo before destructive migrations")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ssb-migrate-log [-dry-run] [-no-backup] <repo>")
 		os.Exit(1)
 	}
-	repoDir := os.Args[1]
+	repoDir := flag.Arg(0)
 
-	repo := repo.New(repoDir)
-	didUpgrade, err := migrations.UpgradeToMultiMessage(logger, repo)
+	r := repo.New(repoDir)
+	lock, err := repo.Lock(r)
+	check(err)
+	r, err = repo.Unlocked(r, ssb.SecretPassphrase)
+	check(err)
+	steps, err := migrations.Run(context.Background(), logger, r, migrations.Options{
+		DryRun:   *dryRun,
+		NoBackup: *noBackup,
+	})
 	check(errors.Wrap(err, "BotInit: repo migration failed"))
+	// the reindexing bot takes the lock itself
+	check(lock.Close())
 
-	if didUpgrade {
-		os.RemoveAll(repo.GetPath("indexes"))
-		os.RemoveAll(repo.GetPath("sublogs"))
-
-		sbot, err := sbot.New(
-			sbot.WithInfo(logger),
-			sbot.WithRepoPath(repoDir),
-			sbot.DisableNetworkNode(),
-			sbot.DisableLiveIndexMode())
-		check(errors.Wrap(err, "BotInit: failed to make reindexing sbot"))
-		err = sbot.Close()
-		check(err)
+	if len(steps) == 0 {
+		fmt.Println("repo is up to date at version", migrations.Latest())
+		return
+	}
+	for _, step := range steps {
+		fmt.Printf("%d %s: %s\n", step.Version, step.Name, step.Plan)
+		if step.Backup != "" {
+			fmt.Println("  backup:", step.Backup)
+		}
 	}
+	if *dryRun {
+		return
+	}
+
+	// rebuild the indexes the migrations removed
+	sbot, err := sbot.New(
+		sbot.WithInfo(logger),
+		sbot.WithRepoPath(repoDir),
+		sbot.DisableNetworkNode(),
+		sbot.DisableLiveIndexMode())
+	check(errors.Wrap(err, "BotInit: failed to make reindexing sbot"))
+	err = sbot.Close()
+	check(err)
 }
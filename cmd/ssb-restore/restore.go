@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+
+// ssb-restore rebuilds a repo from a backup that was made with admin.backup (sbotcli backup) or repo.Backup.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/cryptix/go/logging"
+	"github.com/go-kit/kit/log/level"
+
+	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %+v\n", err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	u, err := user.Current()
+	check(err)
+
+	var (
+		repoDir string
+		reindex bool
+	)
+	flag.StringVar(&repoDir, "repo", filepath.Join(u.HomeDir, ".ssb-go"), "the repo to create, it needs to be empty or not exist")
+	flag.BoolVar(&reindex, "reindex", false, "ignore the index data of the backup and rebuild the indexes")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s (-repo=location, -reindex) <backup folder>\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	logging.SetupLogging(os.Stderr)
+	log := logging.Logger("restore")
+
+	m, withIndexes, err := repo.Restore(flag.Arg(0), repoDir, repo.RestoreOptions{SkipIndexes: reindex})
+	check(err)
+	level.Info(log).Log("event", "restored", "created", m.Created, "seq", m.Sequence, "secrets", len(m.Secrets), "blobs", m.Blobs, "blobCount", m.BlobCount)
+	if m.Blobs == repo.BackupBlobsList {
+		level.Warn(log).Log("msg", "the backup only has a list of blobs, they need to be fetched from peers again", "list", filepath.Join(repoDir, repo.BlobListName))
+	}
+
+	if withIndexes {
+		return
+	}
+
+	// without index data the bot would rebuild them on the first start, do it now instead
+	level.Info(log).Log("event", "reindexing")
+	bot, err := sbot.New(
+		sbot.WithInfo(log),
+		sbot.WithRepoPath(repoDir),
+		sbot.DisableNetworkNode(),
+		sbot.DisableLiveIndexMode(),
+	)
+	check(err)
+	bot.WaitUntilIndexesAreSynced()
+	bot.Shutdown()
+	check(bot.Close())
+	level.Info(log).Log("event", "done")
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/repo"
 
 	"github.com/pkg/errors"
@@ -44,6 +45,20 @@ func main() {
 	repoFrom := repo.New(fromPath)
 	repoTo := repo.New(toPath)
 
+	// a running bot would keep appending to the source, stop it first
+	lockFrom, err := repo.Lock(repoFrom)
+	check(err)
+	defer lockFrom.Close()
+	lockTo, err := repo.Lock(repoTo)
+	check(err)
+	defer lockTo.Close()
+
+	// the copy is encrypted like the source
+	repoFrom, err = repo.Unlocked(repoFrom, ssb.SecretPassphrase)
+	check(err)
+	repoTo, err = repo.ShareAtRest(repoFrom, repoTo)
+	check(err)
+
 	from, err := repo.OpenLog(repoFrom)
 	check(err)
 
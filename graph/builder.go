@@ -13,10 +13,10 @@ import (
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	libbadger "go.cryptoscope.co/librarian/badger"
+	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
 	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/path"
-	"gonum.org/v1/gonum/graph/simple"
 
 	"go.cryptoscope.co/ssb"
 )
@@ -53,6 +53,15 @@ type builder struct {
 
 	cacheLock   sync.Mutex
 	cachedGraph *Graph
+	hops        map[hopKey]*hopSet
+	hopsClock   uint64 // counts the calls to Hops, for pruneHops
+
+	edgeSink   luigi.Sink
+	edges      luigi.Broadcast
+	hopSink    luigi.Sink
+	hopChanges luigi.Broadcast
+
+	migrations FeedMigrations
 }
 
 // NewBuilder creates a Builder that is backed by a badger database
@@ -61,26 +70,40 @@ func NewBuilder(log kitlog.Logger, db *badger.DB) *builder {
 		kv:  db,
 		idx: libbadger.NewIndex(db, 0),
 		log: log,
+
+		hops: make(map[hopKey]*hopSet),
 	}
+	b.edgeSink, b.edges = luigi.NewBroadcast()
+	b.hopSink, b.hopChanges = luigi.NewBroadcast()
 	return b
 }
 
 func (b *builder) indexUpdateFunc(ctx context.Context, seq margaret.Seq, val interface{}, idx librarian.SetterIndex) error {
+	edge, hopChanges, err := b.updateContact(ctx, val, idx)
+	if err != nil {
+		return err
+	}
+	b.notify(ctx, edge, hopChanges)
+	return nil
+}
+
+// updateContact writes the new relation to the index and patches the caches
+func (b *builder) updateContact(ctx context.Context, val interface{}, idx librarian.SetterIndex) (*Edge, []HopChange, error) {
 	b.cacheLock.Lock()
 	defer b.cacheLock.Unlock()
 
 	if nulled, ok := val.(error); ok {
 		if margaret.IsErrNulled(nulled) {
-			return nil
+			return nil, nil, nil
 		}
-		return nulled
+		return nil, nil, nulled
 	}
 
 	abs, ok := val.(ssb.Message)
 	if !ok {
 		err := errors.Errorf("graph/idx: invalid msg value %T", val)
 		b.log.Log("msg", "contact eval failed", "reason", err)
-		return err
+		return nil, nil, err
 	}
 
 	var c ssb.Contact
@@ -88,16 +111,25 @@ func (b *builder) indexUpdateFunc(ctx context.Context, seq margaret.Seq, val int
 	if err != nil {
 		// just ignore invalid messages, nothing to do with them (unless you are debugging something)
 		//level.Warn(b.log).Log("msg", "skipped contact message", "reason", err)
-		return nil
+		return nil, nil, nil
 	}
 
-	addr := abs.Author().StoredAddr()
+	author := abs.Author()
+	wasFollowing, err := b.isFollowing(author, c.Contact)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "db/idx contacts: failed to get previous state")
+	}
+
+	addr := author.StoredAddr()
 	addr += c.Contact.StoredAddr()
+	w := math.Inf(-1)
 	switch {
 	case c.Following:
 		err = idx.Set(ctx, addr, 1)
+		w = 1
 	case c.Blocking:
 		err = idx.Set(ctx, addr, 2)
+		w = math.Inf(1)
 	default:
 		err = idx.Set(ctx, addr, 0)
 		// cryptix: not sure why this doesn't work
@@ -106,12 +138,43 @@ func (b *builder) indexUpdateFunc(ctx context.Context, seq margaret.Seq, val int
 		// err = idx.Delete(ctx, librarian.Addr(addr))
 	}
 	if err != nil {
-		return errors.Wrapf(err, "db/idx contacts: failed to update index. %+v", c)
+		return nil, nil, errors.Wrapf(err, "db/idx contacts: failed to update index. %+v", c)
 	}
 
-	b.cachedGraph = nil
-	// TODO: patch existing graph instead of invalidating
-	return nil
+	// contacts of feeds that moved change implied follows, too, those are worked out from scratch
+	migrated, err := b.migratedFeed(c.Contact)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "db/idx contacts: failed to check feed migrations")
+	}
+
+	if migrated {
+		b.cachedGraph = nil
+	} else if b.cachedGraph != nil {
+		b.cachedGraph.Lock()
+		b.cachedGraph.patchEdge(author, c.Contact, w)
+		b.cachedGraph.Unlock()
+	}
+
+	edge := &Edge{
+		From:      author.Copy(),
+		To:        c.Contact.Copy(),
+		Following: c.Following,
+		Blocking:  c.Blocking && !c.Following,
+	}
+
+	var hopChanges []HopChange
+	if migrated {
+		hopChanges, err = b.rewalkAll()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "db/idx contacts: failed to update hops")
+		}
+	} else if wasFollowing != c.Following && !author.Equal(c.Contact) {
+		hopChanges, err = b.updateHops(author, c.Contact, c.Following)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "db/idx contacts: failed to update hops")
+		}
+	}
+	return edge, hopChanges, nil
 }
 
 func (b *builder) OpenIndex() (librarian.SeqSetterIndex, librarian.SinkIndex) {
@@ -123,9 +186,8 @@ func (b *builder) OpenIndex() (librarian.SeqSetterIndex, librarian.SinkIndex) {
 
 func (b *builder) DeleteAuthor(who *ssb.FeedRef) error {
 	b.cacheLock.Lock()
-	defer b.cacheLock.Unlock()
-	b.cachedGraph = nil
-	return b.kv.Update(func(txn *badger.Txn) error {
+
+	err := b.kv.Update(func(txn *badger.Txn) error {
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
 
@@ -140,6 +202,25 @@ func (b *builder) DeleteAuthor(who *ssb.FeedRef) error {
 		}
 		return nil
 	})
+	if err != nil {
+		b.cacheLock.Unlock()
+		return err
+	}
+
+	if b.cachedGraph != nil {
+		b.cachedGraph.Lock()
+		b.cachedGraph.removeFrom(who)
+		b.cachedGraph.Unlock()
+	}
+
+	hopChanges, err := b.refreshHops(who)
+	b.cacheLock.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "DeleteAuthor: failed to update hops")
+	}
+
+	b.notify(context.TODO(), nil, hopChanges)
+	return nil
 }
 
 func (b *builder) Authorizer(from *ssb.FeedRef, maxHops int) ssb.Authorizer {
@@ -161,6 +242,10 @@ func (b *builder) Build() (*Graph, error) {
 		return b.cachedGraph, nil
 	}
 
+	var (
+		related = make(map[string]struct{})
+		follows [][2]*ssb.FeedRef
+	)
 	err := b.kv.View(func(txn *badger.Txn) error {
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
@@ -171,6 +256,7 @@ func (b *builder) Build() (*Graph, error) {
 			if len(k) != 66 {
 				continue
 			}
+			related[string(k)] = struct{}{}
 
 			rawFrom := k[:33]
 			rawTo := k[33:]
@@ -188,37 +274,17 @@ func (b *builder) Build() (*Graph, error) {
 				return errors.Wrap(err, "builder: couldnt idx key value (to)")
 			}
 
-			bfrom := librarian.Addr(rawFrom)
-			nFrom, has := dg.lookup[bfrom]
-			if !has {
-				fromRef, err := from.FeedRef()
-				if err != nil {
-					return err
-				}
-
-				nFrom = &contactNode{dg.NewNode(), fromRef.Copy(), ""}
-				dg.AddNode(nFrom)
-				dg.lookup[bfrom] = nFrom
-			}
-
-			bto := librarian.Addr(rawTo)
-			nTo, has := dg.lookup[bto]
-			if !has {
-				toRef, err := to.FeedRef()
-				if err != nil {
-					return err
-				}
-				nTo = &contactNode{dg.NewNode(), toRef.Copy(), ""}
-				dg.AddNode(nTo)
-				dg.lookup[bto] = nTo
+			fromRef, err := from.FeedRef()
+			if err != nil {
+				return err
 			}
-
-			if nFrom.ID() == nTo.ID() {
-				continue
+			toRef, err := to.FeedRef()
+			if err != nil {
+				return err
 			}
 
 			w := math.Inf(-1)
-			err := it.Value(func(v []byte) error {
+			err = it.Value(func(v []byte) error {
 				if len(v) >= 1 {
 					switch v[0] {
 					case '0': // not following
@@ -236,21 +302,33 @@ func (b *builder) Build() (*Graph, error) {
 				return errors.Wrapf(err, "failed to get value from item:%q", string(k))
 			}
 
-			if math.IsInf(w, -1) {
-				//dg.RemoveEdge(nFrom.ID(), nTo.ID())
-				continue
+			// not following still adds both nodes
+			dg.patchEdge(fromRef, toRef, w)
+			if w == 1 {
+				follows = append(follows, [2]*ssb.FeedRef{fromRef, toRef})
 			}
-
-			dg.SetWeightedEdge(contactEdge{
-				WeightedEdge: simple.WeightedEdge{F: nFrom, T: nTo, W: w},
-				isBlock:      math.IsInf(w, 1),
-			})
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// following a feed that moved is following its successors, unless there is a contact for them
+	for _, f := range follows {
+		succs, err := b.successors(f[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range succs {
+			if _, has := related[string(f[0].StoredAddr()+s.StoredAddr())]; !has {
+				dg.patchEdge(f[0], s, 1)
+			}
+		}
+	}
 
 	b.cachedGraph = dg
-	return dg, err
+	return dg, nil
 }
 
 type Lookup struct {
@@ -267,11 +345,22 @@ func (l Lookup) Dist(to *ssb.FeedRef) ([]graph.Node, float64) {
 	return l.dijk.To(nTo.ID())
 }
 
+// Path is like Dist but returns the feeds on the path, including both ends
+func (l Lookup) Path(to *ssb.FeedRef) ([]*ssb.FeedRef, float64) {
+	nodes, d := l.Dist(to)
+	refs := make([]*ssb.FeedRef, len(nodes))
+	for i, n := range nodes {
+		refs[i] = n.(*contactNode).feed.Copy()
+	}
+	return refs, d
+}
+
 func (b *builder) Follows(forRef *ssb.FeedRef) (*ssb.StrFeedSet, error) {
 	if forRef == nil {
 		panic("nil feed ref")
 	}
 	fs := ssb.NewFeedSet(50)
+	related := make(map[librarian.Addr]struct{})
 	err := b.kv.View(func(txn *badger.Txn) error {
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
@@ -280,6 +369,7 @@ func (b *builder) Follows(forRef *ssb.FeedRef) (*ssb.StrFeedSet, error) {
 		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
 			it := iter.Item()
 			k := it.Key()
+			related[librarian.Addr(k[33:])] = struct{}{}
 
 			err := it.Value(func(v []byte) error {
 				if len(v) >= 1 && v[0] == '1' {
@@ -302,66 +392,25 @@ func (b *builder) Follows(forRef *ssb.FeedRef) (*ssb.StrFeedSet, error) {
 		}
 		return nil
 	})
-	return fs, err
-}
-
-// Hops returns a slice of feed refrences that are in a particulare range of from
-// max == 0: only direct follows of from
-// max == 1: max:0 + follows of friends of from
-// max == 2: max:1 + follows of their friends
-func (b *builder) Hops(from *ssb.FeedRef, max int) *ssb.StrFeedSet {
-	max++
-	walked := ssb.NewFeedSet(0)
-	visited := make(map[string]struct{}) // tracks the nodes we already recursed from (so we don't do them multiple times on common friends)
-	err := b.recurseHops(walked, visited, from, max)
-	if err != nil {
-		b.log.Log("event", "error", "msg", "recurse failed", "err", err)
-		return nil
-	}
-	walked.Delete(from)
-	return walked
-}
-
-func (b *builder) recurseHops(walked *ssb.StrFeedSet, vis map[string]struct{}, from *ssb.FeedRef, depth int) error {
-	if depth == 0 {
-		return nil
-	}
-
-	if _, ok := vis[from.Ref()]; ok {
-		return nil
-	}
-
-	fromFollows, err := b.Follows(from)
-	if err != nil {
-		return errors.Wrapf(err, "recurseHops(%d): from follow listing failed", depth)
+	if err != nil || b.migrations == nil {
+		return fs, err
 	}
 
-	followLst, err := fromFollows.List()
+	// following a feed that moved is following its successors, unless there is a contact for them
+	direct, err := fs.List()
 	if err != nil {
-		return errors.Wrapf(err, "recurseHops(%d): invalid entry in feed set", depth)
+		return nil, errors.Wrapf(err, "follows(%s): invalid entry in feed set", forRef.Ref())
 	}
-
-	for i, followedByFrom := range followLst {
-		err := walked.AddRef(followedByFrom)
-		if err != nil {
-			return errors.Wrapf(err, "recurseHops(%d): add list entry(%d) failed", depth, i)
-		}
-
-		dstFollows, err := b.Follows(followedByFrom)
+	for _, followed := range direct {
+		succs, err := b.successors(followed)
 		if err != nil {
-			return errors.Wrapf(err, "recurseHops(%d): follows from entry(%d) failed", depth, i)
+			return nil, errors.Wrapf(err, "follows(%s): failed to get feed migrations", forRef.Ref())
 		}
-
-		isF := dstFollows.Has(from)
-		if isF { // found a friend, recurse
-			if err := b.recurseHops(walked, vis, followedByFrom, depth-1); err != nil {
-				return err
+		for _, s := range succs {
+			if _, has := related[s.StoredAddr()]; !has {
+				fs.AddRef(s)
 			}
 		}
-		// b.log.Log("depth", depth, "from", from.ShortRef(), "follows", followedByFrom.ShortRef(), "friend", isF, "cnt", dstFollows.Count())
 	}
-
-	vis[from.Ref()] = struct{}{}
-
-	return nil
+	return fs, nil
 }
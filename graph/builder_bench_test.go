@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+const largeRepo = "../plugins/gossip/testdata/largeRepo"
+
+// openLargeRepo copies the contacts index of the gossip test data, so that it can be written to
+func openLargeRepo(t testing.TB) (*builder, *ssb.FeedRef, func()) {
+	r := require.New(t)
+
+	tmp, err := ioutil.TempDir("", "largeContacts")
+	r.NoError(err)
+
+	dbPath := filepath.Join(tmp, "db")
+	out, err := exec.Command("cp", "-rL", filepath.Join(largeRepo, "indexes", "contacts", "db"), dbPath).CombinedOutput()
+	r.NoError(err, "copy failed:%s", string(out))
+
+	opts := badger.DefaultOptions(dbPath)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+
+	kp, err := ssb.LoadKeyPair(filepath.Join(largeRepo, "secret"))
+	r.NoError(err)
+
+	b := NewBuilder(testutils.NewRelativeTimeLogger(nil), db)
+	return b, kp.Id, func() {
+		r.NoError(db.Close())
+		os.RemoveAll(tmp)
+	}
+}
+
+func contactMsg(author, contact *ssb.FeedRef, following bool) ssb.Message {
+	var kv ssb.KeyValueRaw
+	kv.Value.Author = *author
+	kv.Value.Content = []byte(fmt.Sprintf(`{"type":"contact","contact":%q,"following":%v}`, contact.Ref(), following))
+	return kv
+}
+
+func randomFeed(t testing.TB) *ssb.FeedRef {
+	id := make([]byte, 32)
+	_, err := rand.Read(id)
+	require.NoError(t, err)
+	return &ssb.FeedRef{ID: id, Algo: ssb.RefAlgoFeedSSB1}
+}
+
+func (b *builder) testUpdate(t testing.TB, author, contact *ssb.FeedRef, following bool) {
+	err := b.indexUpdateFunc(context.TODO(), margaret.BaseSeq(0), contactMsg(author, contact, following), b.idx)
+	require.NoError(t, err)
+}
+
+// TestPatchedGraph makes sure the patched graph and hop sets are the same as the ones built from scratch
+func TestPatchedGraph(t *testing.T) {
+	r := require.New(t)
+
+	b, self, done := openLargeRepo(t)
+	defer done()
+
+	// fill the caches
+	_, err := b.Build()
+	r.NoError(err)
+	for hops := 0; hops < 3; hops++ {
+		r.NotNil(b.Hops(self, hops))
+	}
+
+	follows, err := b.Follows(self)
+	r.NoError(err)
+	followLst, err := follows.List()
+	r.NoError(err)
+	r.True(len(followLst) > 0, "no follows in test data")
+
+	var friend *ssb.FeedRef
+	for _, f := range followLst {
+		back, err := b.isFollowing(f, self)
+		r.NoError(err)
+		if back {
+			friend = f
+			break
+		}
+	}
+	r.NotNil(friend, "no friends in test data")
+
+	newbie := randomFeed(t)
+
+	b.testUpdate(t, self, followLst[0], false) // plain unfollow
+	b.testUpdate(t, self, friend, false)       // breaks a friendship
+	b.testUpdate(t, friend, newbie, true)      // follow of a friend
+	b.testUpdate(t, newbie, friend, true)
+	b.testUpdate(t, self, newbie, true) // newbie is now a friend of friend
+	b.testUpdate(t, self, friend, true) // and we are friends again
+
+	fresh := NewBuilder(b.log, b.kv)
+
+	patched, err := b.Build()
+	r.NoError(err)
+	rebuilt, err := fresh.Build()
+	r.NoError(err)
+
+	r.Equal(rebuilt.NodeCount(), patched.NodeCount())
+	r.Equal(rebuilt.Edges().Len(), patched.Edges().Len())
+	edgs := rebuilt.Edges()
+	for edgs.Next() {
+		e := edgs.Edge().(contactEdge)
+		from, to := e.From().(*contactNode).feed, e.To().(*contactNode).feed
+		r.Equal(rebuilt.Follows(from, to), patched.Follows(from, to), "follow %s -> %s", from.ShortRef(), to.ShortRef())
+		r.Equal(rebuilt.Blocks(from, to), patched.Blocks(from, to), "block %s -> %s", from.ShortRef(), to.ShortRef())
+	}
+
+	for hops := 0; hops < 3; hops++ {
+		want, err := fresh.Hops(self, hops).List()
+		r.NoError(err)
+		got := b.Hops(self, hops)
+		r.Equal(len(want), got.Count(), "hops %d", hops)
+		for _, f := range want {
+			r.True(got.Has(f), "hops %d: missing %s", hops, f.ShortRef())
+		}
+	}
+}
+
+func BenchmarkBuildLargeRepo(bench *testing.B) {
+	b, _, done := openLargeRepo(bench)
+	defer done()
+
+	bench.ResetTimer()
+	for i := 0; i < bench.N; i++ {
+		b.cacheLock.Lock()
+		b.cachedGraph = nil
+		b.cacheLock.Unlock()
+
+		_, err := b.Build()
+		if err != nil {
+			bench.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHopsLargeRepo(bench *testing.B) {
+	for _, memo := range []bool{false, true} {
+		bench.Run(fmt.Sprintf("memoized=%v", memo), func(bench *testing.B) {
+			b, self, done := openLargeRepo(bench)
+			defer done()
+
+			bench.ResetTimer()
+			for i := 0; i < bench.N; i++ {
+				if !memo {
+					b.cacheLock.Lock()
+					b.hops = make(map[hopKey]*hopSet)
+					b.cacheLock.Unlock()
+				}
+				if b.Hops(self, 2) == nil {
+					bench.Fatal("hops failed")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkUpdateLargeRepo compares patching the graph and the hop sets against building them again after each contact message.
+func BenchmarkUpdateLargeRepo(bench *testing.B) {
+	for _, patch := range []bool{false, true} {
+		bench.Run(fmt.Sprintf("patched=%v", patch), func(bench *testing.B) {
+			b, self, done := openLargeRepo(bench)
+			defer done()
+
+			other := randomFeed(bench)
+			bench.ResetTimer()
+			for i := 0; i < bench.N; i++ {
+				b.testUpdate(bench, self, other, i%2 == 0)
+				if !patch {
+					b.cacheLock.Lock()
+					b.cachedGraph = nil
+					b.hops = make(map[hopKey]*hopSet)
+					b.cacheLock.Unlock()
+				}
+
+				g, err := b.Build()
+				if err != nil {
+					bench.Fatal(err)
+				}
+				if _, err := g.MakeDijkstra(self); err != nil {
+					bench.Fatal(err)
+				}
+				if b.Hops(self, 2) == nil {
+					bench.Fatal("hops failed")
+				}
+			}
+		})
+	}
+}
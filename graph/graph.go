@@ -19,13 +19,85 @@ type Graph struct {
 	sync.Mutex
 	*simple.WeightedDirectedGraph
 	lookup key2node
+
+	// shortest paths are memoized until the next change to the graph
+	dijkstras map[librarian.Addr]*Lookup
 }
 
 func NewGraph() *Graph {
 	return &Graph{
 		WeightedDirectedGraph: simple.NewWeightedDirectedGraph(0, math.Inf(1)),
 		lookup:                make(key2node),
+		dijkstras:             make(map[librarian.Addr]*Lookup),
+	}
+}
+
+// node returns the node for ref and adds it to the graph if it isn't there yet.
+// the caller needs to hold the lock.
+func (g *Graph) node(ref *ssb.FeedRef) *contactNode {
+	addr := ref.StoredAddr()
+	n, has := g.lookup[addr]
+	if !has {
+		n = &contactNode{g.NewNode(), ref.Copy(), ""}
+		g.AddNode(n)
+		g.lookup[addr] = n
+	}
+	return n
+}
+
+// patchEdge sets the relation between from and to.
+// w is 1 for a follow and +Inf for a block, -Inf removes the edge but keeps both nodes.
+// the caller needs to hold the lock.
+func (g *Graph) patchEdge(from, to *ssb.FeedRef, w float64) {
+	if from.Equal(to) {
+		// contact self?!
+		return
+	}
+	nFrom, nTo := g.node(from), g.node(to)
+
+	if math.IsInf(w, -1) {
+		if g.HasEdgeFromTo(nFrom.ID(), nTo.ID()) {
+			g.RemoveEdge(nFrom.ID(), nTo.ID())
+		}
+	} else {
+		g.SetWeightedEdge(contactEdge{
+			WeightedEdge: simple.WeightedEdge{F: nFrom, T: nTo, W: w},
+			isBlock:      math.IsInf(w, 1),
+		})
+	}
+	g.resetDijkstras()
+}
+
+func (g *Graph) resetDijkstras() {
+	if len(g.dijkstras) > 0 {
+		g.dijkstras = make(map[librarian.Addr]*Lookup)
+	}
+}
+
+// removeFrom drops all the edges that start at who.
+// The node itself is also removed if no other edges point to it.
+// the caller needs to hold the lock.
+func (g *Graph) removeFrom(who *ssb.FeedRef) {
+	addr := who.StoredAddr()
+	n, has := g.lookup[addr]
+	if !has {
+		return
+	}
+
+	var tos []int64
+	edgs := g.From(n.ID())
+	for edgs.Next() {
+		tos = append(tos, edgs.Node().ID())
+	}
+	for _, to := range tos {
+		g.RemoveEdge(n.ID(), to)
 	}
+
+	if g.To(n.ID()).Len() == 0 {
+		g.RemoveNode(n.ID())
+		delete(g.lookup, addr)
+	}
+	g.resetDijkstras()
 }
 
 func (g *Graph) getEdge(from, to *ssb.FeedRef) (graph.WeightedEdge, bool) {
@@ -84,15 +156,45 @@ func (g *Graph) BlockedList(from *ssb.FeedRef) *ssb.StrFeedSet {
 	return blocked
 }
 
+// EdgeList returns all the follow and block relations in the graph
+func (g *Graph) EdgeList() []Edge {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+	var edges []Edge
+	it := g.Edges()
+	for it.Next() {
+		e := it.Edge().(contactEdge)
+		edges = append(edges, Edge{
+			From:      e.From().(*contactNode).feed.Copy(),
+			To:        e.To().(*contactNode).feed.Copy(),
+			Following: e.Weight() == 1,
+			Blocking:  e.isBlock,
+		})
+	}
+	return edges
+}
+
 func (g *Graph) MakeDijkstra(from *ssb.FeedRef) (*Lookup, error) {
 	g.Mutex.Lock()
 	defer g.Mutex.Unlock()
-	nFrom, has := g.lookup[from.StoredAddr()]
+	addr := from.StoredAddr()
+	if l, has := g.dijkstras[addr]; has {
+		return l, nil
+	}
+	nFrom, has := g.lookup[addr]
 	if !has {
 		return nil, ErrNoSuchFrom{Who: from}
 	}
-	return &Lookup{
+
+	// the lookup table is copied since the graph is patched in place
+	lookup := make(key2node, len(g.lookup))
+	for k, n := range g.lookup {
+		lookup[k] = n
+	}
+	l := &Lookup{
 		path.DijkstraFrom(nFrom, g),
-		g.lookup,
-	}, nil
+		lookup,
+	}
+	g.dijkstras[addr] = l
+	return l, nil
 }
@@ -0,0 +1,361 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// maxUnwatchedHops is how many hop sets without a WatchHops call are kept around.
+// Watched ones are kept until they are released, on top of these.
+const maxUnwatchedHops = 32
+
+type hopKey struct {
+	from librarian.Addr
+	max  int
+}
+
+// hopSet is the memoized result of Hops(from, max).
+// It keeps enough of the walk around to patch it when a single follow changes.
+type hopSet struct {
+	from *ssb.FeedRef
+	max  int
+
+	// the friend distance of every feed we took the follows from
+	dist map[librarian.Addr]int
+
+	// how many of those feeds follow a feed
+	followed map[librarian.Addr]int
+	refs     map[librarian.Addr]*ssb.FeedRef
+
+	watchers int    // open WatchHops calls
+	lastUse  uint64 // the builder's hopsClock when Hops last asked for it
+}
+
+// add counts one more follow of ref and returns true if it is new to the set
+func (hs *hopSet) add(ref *ssb.FeedRef) bool {
+	addr := ref.StoredAddr()
+	hs.followed[addr]++
+	if _, has := hs.refs[addr]; has {
+		return false
+	}
+	hs.refs[addr] = ref.Copy()
+	return true
+}
+
+// remove counts one less follow of ref and returns true if it left the set
+func (hs *hopSet) remove(ref *ssb.FeedRef) bool {
+	addr := ref.StoredAddr()
+	cnt, has := hs.followed[addr]
+	if !has {
+		return false
+	}
+	if cnt > 1 {
+		hs.followed[addr] = cnt - 1
+		return false
+	}
+	delete(hs.followed, addr)
+	delete(hs.refs, addr)
+	return true
+}
+
+// feedSet returns a fresh copy since callers are free to modify the result
+func (hs *hopSet) feedSet() *ssb.StrFeedSet {
+	fs := ssb.NewFeedSet(len(hs.refs))
+	for _, ref := range hs.refs {
+		fs.AddRef(ref)
+	}
+	fs.Delete(hs.from)
+	return fs
+}
+
+// Hops returns a slice of feed refrences that are in a particulare range of from
+// max == 0: only direct follows of from
+// max == 1: max:0 + follows of friends of from
+// max == 2: max:1 + follows of their friends
+func (b *builder) Hops(from *ssb.FeedRef, max int) *ssb.StrFeedSet {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	hs, err := b.memoHops(from, max)
+	if err != nil {
+		b.log.Log("event", "error", "msg", "hops walk failed", "err", err)
+		return nil
+	}
+	return hs.feedSet()
+}
+
+// WatchHops keeps the range of Hops(from, max) up to date, so that HopChanges has all the changes to it, until the returned func is called.
+func (b *builder) WatchHops(from *ssb.FeedRef, max int) func() {
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	hs, err := b.memoHops(from, max)
+	if err != nil {
+		b.log.Log("event", "error", "msg", "hops walk failed", "err", err)
+		return func() {}
+	}
+	hs.watchers++
+
+	key := hopKey{from: from.StoredAddr(), max: max}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.cacheLock.Lock()
+			defer b.cacheLock.Unlock()
+			if hs, has := b.hops[key]; has {
+				hs.watchers--
+				b.pruneHops()
+			}
+		})
+	}
+}
+
+// memoHops returns the memoized set of Hops(from, max) or walks it.
+// It needs to be called with the cacheLock held.
+func (b *builder) memoHops(from *ssb.FeedRef, max int) (*hopSet, error) {
+	b.hopsClock++
+	key := hopKey{from: from.StoredAddr(), max: max}
+	hs, has := b.hops[key]
+	if !has {
+		var err error
+		hs, err = b.walkHops(from, max)
+		if err != nil {
+			return nil, err
+		}
+		b.hops[key] = hs
+	}
+	hs.lastUse = b.hopsClock
+	if !has {
+		b.pruneHops()
+	}
+	return hs, nil
+}
+
+// pruneHops drops the least recently used sets that aren't watched, so that there are at most maxUnwatchedHops of them.
+// It needs to be called with the cacheLock held.
+func (b *builder) pruneHops() {
+	var unwatched []hopKey
+	for key, hs := range b.hops {
+		if hs.watchers == 0 {
+			unwatched = append(unwatched, key)
+		}
+	}
+	if len(unwatched) <= maxUnwatchedHops {
+		return
+	}
+	sort.Slice(unwatched, func(i, j int) bool {
+		return b.hops[unwatched[i]].lastUse < b.hops[unwatched[j]].lastUse
+	})
+	for _, key := range unwatched[:len(unwatched)-maxUnwatchedHops] {
+		delete(b.hops, key)
+	}
+}
+
+// walkHops does a breadth-first walk over the friends (mutual follows) of from, up to max steps away.
+func (b *builder) walkHops(from *ssb.FeedRef, max int) (*hopSet, error) {
+	hs := &hopSet{
+		from:     from.Copy(),
+		max:      max,
+		dist:     make(map[librarian.Addr]int),
+		followed: make(map[librarian.Addr]int),
+		refs:     make(map[librarian.Addr]*ssb.FeedRef),
+	}
+	if max < 0 {
+		return hs, nil
+	}
+
+	hs.dist[from.StoredAddr()] = 0
+	queue := []*ssb.FeedRef{from}
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+		d := hs.dist[curr.StoredAddr()]
+
+		currFollows, err := b.Follows(curr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "walkHops(%d): follow listing failed", d)
+		}
+		followLst, err := currFollows.List()
+		if err != nil {
+			return nil, errors.Wrapf(err, "walkHops(%d): invalid entry in feed set", d)
+		}
+
+		for _, followed := range followLst {
+			hs.add(followed)
+
+			if d == max {
+				continue
+			}
+			if _, seen := hs.dist[followed.StoredAddr()]; seen {
+				continue
+			}
+			isF, err := b.isFollowing(followed, curr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "walkHops(%d): friend check failed", d)
+			}
+			if isF { // found a friend, walk their follows, too
+				hs.dist[followed.StoredAddr()] = d + 1
+				queue = append(queue, followed)
+			}
+		}
+	}
+	return hs, nil
+}
+
+// updateHops patches the memoized hop sets after author started or stopped following contact.
+// Sets where this might change who counts as a friend are walked again.
+func (b *builder) updateHops(author, contact *ssb.FeedRef, following bool) ([]HopChange, error) {
+	if len(b.hops) == 0 {
+		return nil, nil
+	}
+
+	var (
+		changes []HopChange
+
+		authorAddr  = author.StoredAddr()
+		contactAddr = contact.StoredAddr()
+
+		checkedBack, followsBack bool
+	)
+	for key, hs := range b.hops {
+		da, authorIn := hs.dist[authorAddr]
+		dc, contactIn := hs.dist[contactAddr]
+
+		if (authorIn && da < hs.max) || (contactIn && dc < hs.max) {
+			if !checkedBack {
+				var err error
+				followsBack, err = b.isFollowing(contact, author)
+				if err != nil {
+					return nil, err
+				}
+				checkedBack = true
+			}
+			if followsBack { // a friendship was made or broken
+				rewalked, err := b.rewalkHops(key, hs)
+				if err != nil {
+					return nil, err
+				}
+				changes = append(changes, rewalked...)
+				continue
+			}
+		}
+
+		if !authorIn || contact.Equal(hs.from) {
+			continue
+		}
+		if following {
+			if hs.add(contact) {
+				changes = append(changes, HopChange{From: hs.from, Max: hs.max, Feed: contact.Copy(), Added: true})
+			}
+		} else {
+			if hs.remove(contact) {
+				changes = append(changes, HopChange{From: hs.from, Max: hs.max, Feed: contact.Copy(), Added: false})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// refreshHops walks all the hop sets who contributed to again
+func (b *builder) refreshHops(who *ssb.FeedRef) ([]HopChange, error) {
+	var (
+		changes []HopChange
+		addr    = who.StoredAddr()
+	)
+	for key, hs := range b.hops {
+		if _, has := hs.dist[addr]; !has {
+			continue
+		}
+		rewalked, err := b.rewalkHops(key, hs)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, rewalked...)
+	}
+	return changes, nil
+}
+
+// rewalkAll walks all the hop sets again
+func (b *builder) rewalkAll() ([]HopChange, error) {
+	var changes []HopChange
+	for key, hs := range b.hops {
+		rewalked, err := b.rewalkHops(key, hs)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, rewalked...)
+	}
+	return changes, nil
+}
+
+// rewalkHops replaces the memoized set and returns the differences to the old one
+func (b *builder) rewalkHops(key hopKey, old *hopSet) ([]HopChange, error) {
+	fresh, err := b.walkHops(old.from, old.max)
+	if err != nil {
+		return nil, err
+	}
+	fresh.watchers, fresh.lastUse = old.watchers, old.lastUse
+	b.hops[key] = fresh
+
+	var changes []HopChange
+	for addr, ref := range fresh.refs {
+		if _, had := old.refs[addr]; !had && !ref.Equal(fresh.from) {
+			changes = append(changes, HopChange{From: fresh.from, Max: fresh.max, Feed: ref, Added: true})
+		}
+	}
+	for addr, ref := range old.refs {
+		if _, has := fresh.refs[addr]; !has && !ref.Equal(old.from) {
+			changes = append(changes, HopChange{From: old.from, Max: old.max, Feed: ref, Added: false})
+		}
+	}
+	return changes, nil
+}
+
+// isFollowing checks the index directly, without building the graph.
+// Without a contact for to, following a feed that moved to it counts.
+func (b *builder) isFollowing(from, to *ssb.FeedRef) (bool, error) {
+	following, found, err := b.contactState(from, to)
+	if err != nil || found {
+		return following, err
+	}
+
+	preds, err := b.predecessors(to)
+	if err != nil {
+		return false, errors.Wrapf(err, "isFollowing(%s): failed to get feed migrations", from.ShortRef())
+	}
+	for _, p := range preds {
+		following, _, err := b.contactState(from, p)
+		if err != nil || following {
+			return following, err
+		}
+	}
+	return false, nil
+}
+
+// contactState returns if there is a contact from from to to and if it's a follow
+func (b *builder) contactState(from, to *ssb.FeedRef) (bool, bool, error) {
+	var following, found bool
+	err := b.kv.View(func(txn *badger.Txn) error {
+		it, err := txn.Get([]byte(from.StoredAddr() + to.StoredAddr()))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			return err
+		}
+		found = true
+		return it.Value(func(v []byte) error {
+			following = len(v) >= 1 && v[0] == '1'
+			return nil
+		})
+	})
+	return following, found, errors.Wrapf(err, "isFollowing(%s): failed to get contact", from.ShortRef())
+}
@@ -13,7 +13,6 @@ import (
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
 	"gonum.org/v1/gonum/graph"
-	"gonum.org/v1/gonum/graph/simple"
 	"gonum.org/v1/gonum/graph/traverse"
 
 	"go.cryptoscope.co/ssb"
@@ -105,7 +104,6 @@ func (b *logBuilder) buildGraph(ctx context.Context, v interface{}, err error) e
 
 	b.current.Lock()
 	defer b.current.Unlock()
-	dg := b.current.WeightedDirectedGraph
 
 	abs, ok := v.(ssb.Message)
 	if !ok {
@@ -120,65 +118,13 @@ func (b *logBuilder) buildGraph(ctx context.Context, v interface{}, err error) e
 		return nil
 	}
 
-	author := abs.Author()
-	contact := c.Contact
-
-	if author.Equal(contact) {
-		// contact self?!
-		return nil
-	}
-
-	bfrom := author.StoredAddr()
-	nFrom, has := b.current.lookup[bfrom]
-	if !has {
-		// stupid copy
-		sr, err := ssb.NewStorageRef(author)
-		if err != nil {
-			return errors.Wrap(err, "failed to create graph node for author")
-		}
-		fr, err := sr.FeedRef()
-		if err != nil {
-			return errors.Wrap(err, "failed to create graph node for author")
-		}
-		nFrom = &contactNode{dg.NewNode(), fr, ""}
-		dg.AddNode(nFrom)
-		b.current.lookup[bfrom] = nFrom
-	}
-
-	bto := contact.StoredAddr()
-	nTo, has := b.current.lookup[bto]
-	if !has {
-		sr, err := ssb.NewStorageRef(contact)
-		if err != nil {
-			return errors.Wrap(err, "failed to create graph node for contact")
-		}
-		fr, err := sr.FeedRef()
-		if err != nil {
-			return errors.Wrap(err, "failed to create graph node for author")
-		}
-		nTo = &contactNode{dg.NewNode(), fr, ""}
-		dg.AddNode(nTo)
-		b.current.lookup[bto] = nTo
-	}
-
 	w := math.Inf(-1)
 	if c.Following {
 		w = 1
 	} else if c.Blocking {
 		w = math.Inf(1)
-	} else if !c.Following && !c.Blocking {
-		if dg.HasEdgeFromTo(nFrom.ID(), nTo.ID()) {
-			dg.RemoveEdge(nFrom.ID(), nTo.ID())
-		}
-		return nil
 	}
-
-	edg := simple.WeightedEdge{F: nFrom, T: nTo, W: w}
-	dg.SetWeightedEdge(contactEdge{
-		WeightedEdge: edg,
-		isBlock:      c.Blocking,
-	})
-
+	b.current.patchEdge(abs.Author(), c.Contact, w)
 	return nil
 }
 
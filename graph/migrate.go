@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// FeedMigrations knows which feeds moved to a new key.
+// A migration only counts once the old feed pointed at the new one and the new one countersigned it, see ssb.FeedMigration.
+type FeedMigrations interface {
+	// Successor returns the feed that old moved to or nil
+	Successor(old *ssb.FeedRef) (*ssb.FeedRef, error)
+
+	// Predecessors returns the feeds that moved to new
+	Predecessors(new *ssb.FeedRef) ([]*ssb.FeedRef, error)
+
+	// Changes emits a Migration whenever one was completed
+	Changes() luigi.Broadcast
+}
+
+// Migration is a completed move of the feed Old to the key New
+type Migration struct {
+	Old *ssb.FeedRef `json:"old"`
+	New *ssb.FeedRef `json:"new"`
+}
+
+// MigrationAware builders count follows of a migrated feed as follows of the feeds it moved to
+type MigrationAware interface {
+	SetFeedMigrations(FeedMigrations)
+}
+
+// Successors returns the chain of feeds that ref moved to, the current one last.
+// It is empty if ref didn't move.
+func Successors(m FeedMigrations, ref *ssb.FeedRef) ([]*ssb.FeedRef, error) {
+	var (
+		chain []*ssb.FeedRef
+		seen  = map[librarian.Addr]struct{}{ref.StoredAddr(): {}}
+	)
+	for {
+		next, err := m.Successor(ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "graph: failed to get successor of %s", ref.ShortRef())
+		}
+		if next == nil {
+			return chain, nil
+		}
+		if _, loop := seen[next.StoredAddr()]; loop {
+			return chain, nil
+		}
+		seen[next.StoredAddr()] = struct{}{}
+		chain = append(chain, next)
+		ref = next
+	}
+}
+
+// AllPredecessors returns all the feeds that moved to ref, directly or over other feeds.
+// The closer ones come first.
+func AllPredecessors(m FeedMigrations, ref *ssb.FeedRef) ([]*ssb.FeedRef, error) {
+	var (
+		preds []*ssb.FeedRef
+		seen  = map[librarian.Addr]struct{}{ref.StoredAddr(): {}}
+		queue = []*ssb.FeedRef{ref}
+	)
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+
+		direct, err := m.Predecessors(curr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "graph: failed to get predecessors of %s", curr.ShortRef())
+		}
+		for _, p := range direct {
+			if _, has := seen[p.StoredAddr()]; has {
+				continue
+			}
+			seen[p.StoredAddr()] = struct{}{}
+			preds = append(preds, p)
+			queue = append(queue, p)
+		}
+	}
+	return preds, nil
+}
+
+// ResolveFeed returns the feed that ref moved to in the end, or ref if it didn't move
+func ResolveFeed(m FeedMigrations, ref *ssb.FeedRef) (*ssb.FeedRef, error) {
+	chain, err := Successors(m, ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return ref, nil
+	}
+	return chain[len(chain)-1], nil
+}
+
+var _ MigrationAware = (*builder)(nil)
+
+// SetFeedMigrations makes the builder count a follow of a feed that moved as a follow of its successors,
+// unless there is a contact message for the successor itself.
+func (b *builder) SetFeedMigrations(m FeedMigrations) {
+	b.cacheLock.Lock()
+	b.migrations = m
+	b.cacheLock.Unlock()
+
+	m.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return nil
+		}
+		if _, ok := v.(Migration); ok {
+			b.migrated(ctx)
+		}
+		return nil
+	}))
+
+	// the migrations that were indexed before change what was built until now
+	b.migrated(context.TODO())
+}
+
+// migrated drops the cached graph and walks the hop sets again after a feed moved
+func (b *builder) migrated(ctx context.Context) {
+	b.cacheLock.Lock()
+	b.cachedGraph = nil
+	hopChanges, err := b.rewalkAll()
+	b.cacheLock.Unlock()
+	if err != nil {
+		level.Warn(b.log).Log("msg", "failed to update hops after feed migration", "err", err)
+	}
+	b.notify(ctx, nil, hopChanges)
+}
+
+// successors returns the feeds that ref moved to, if there are any migrations
+func (b *builder) successors(ref *ssb.FeedRef) ([]*ssb.FeedRef, error) {
+	if b.migrations == nil {
+		return nil, nil
+	}
+	return Successors(b.migrations, ref)
+}
+
+// predecessors returns the feeds that moved to ref, if there are any migrations
+func (b *builder) predecessors(ref *ssb.FeedRef) ([]*ssb.FeedRef, error) {
+	if b.migrations == nil {
+		return nil, nil
+	}
+	return AllPredecessors(b.migrations, ref)
+}
+
+// migratedFeed returns true if ref moved or another feed moved to it
+func (b *builder) migratedFeed(ref *ssb.FeedRef) (bool, error) {
+	succs, err := b.successors(ref)
+	if err != nil || len(succs) > 0 {
+		return len(succs) > 0, err
+	}
+	preds, err := b.predecessors(ref)
+	return len(preds) > 0, err
+}
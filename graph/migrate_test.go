@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+type testMigrations struct {
+	successors   map[string]*ssb.FeedRef
+	predecessors map[string][]*ssb.FeedRef
+
+	sink    luigi.Sink
+	changes luigi.Broadcast
+}
+
+func newTestMigrations() *testMigrations {
+	tm := &testMigrations{
+		successors:   make(map[string]*ssb.FeedRef),
+		predecessors: make(map[string][]*ssb.FeedRef),
+	}
+	tm.sink, tm.changes = luigi.NewBroadcast()
+	return tm
+}
+
+func (tm *testMigrations) migrate(t testing.TB, old, new *ssb.FeedRef) {
+	tm.successors[old.Ref()] = new
+	tm.predecessors[new.Ref()] = append(tm.predecessors[new.Ref()], old)
+	require.NoError(t, tm.sink.Pour(context.TODO(), Migration{Old: old, New: new}))
+}
+
+func (tm *testMigrations) Successor(old *ssb.FeedRef) (*ssb.FeedRef, error) {
+	return tm.successors[old.Ref()], nil
+}
+
+func (tm *testMigrations) Predecessors(new *ssb.FeedRef) ([]*ssb.FeedRef, error) {
+	return tm.predecessors[new.Ref()], nil
+}
+
+func (tm *testMigrations) Changes() luigi.Broadcast { return tm.changes }
+
+func TestFeedMigrations(t *testing.T) {
+	r := require.New(t)
+
+	tmp, err := ioutil.TempDir("", "migrations")
+	r.NoError(err)
+	defer os.RemoveAll(tmp)
+
+	opts := badger.DefaultOptions(tmp)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	r.NoError(err)
+	defer db.Close()
+
+	b := NewBuilder(testutils.NewRelativeTimeLogger(nil), db)
+	tm := newTestMigrations()
+	b.SetFeedMigrations(tm)
+
+	var hops []HopChange
+	cancel := b.HopChanges().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		hops = append(hops, v.(HopChange))
+		return nil
+	}))
+	defer cancel()
+
+	var (
+		alice  = randomFeed(t)
+		old    = randomFeed(t)
+		middle = randomFeed(t)
+		new    = randomFeed(t)
+	)
+	b.testUpdate(t, alice, old, true)
+	b.testUpdate(t, old, alice, true)
+	r.Equal(1, b.Hops(alice, 1).Count())
+
+	tm.migrate(t, old, middle)
+	tm.migrate(t, middle, new)
+
+	// alice follows the whole chain now
+	fs, err := b.Follows(alice)
+	r.NoError(err)
+	r.Equal(3, fs.Count())
+	r.True(fs.Has(new))
+	r.True(b.Hops(alice, 1).Has(new))
+	r.Len(hops, 2)
+	r.True(hops[1].Feed.Equal(new))
+	r.True(hops[1].Added)
+
+	g, err := b.Build()
+	r.NoError(err)
+	r.True(g.Follows(alice, middle))
+	r.True(g.Follows(alice, new))
+
+	// the new feed is a friend of alice once it follows her back
+	friend := randomFeed(t)
+	b.testUpdate(t, new, friend, true)
+	r.False(b.Hops(alice, 1).Has(friend))
+	b.testUpdate(t, new, alice, true)
+	r.True(b.Hops(alice, 1).Has(friend))
+
+	// a contact for the new feed itself wins
+	b.testUpdate(t, alice, new, false)
+	fs, err = b.Follows(alice)
+	r.NoError(err)
+	r.False(fs.Has(new))
+	r.True(fs.Has(middle))
+	g, err = b.Build()
+	r.NoError(err)
+	r.False(g.Follows(alice, new))
+	r.False(b.Hops(alice, 1).Has(new))
+}
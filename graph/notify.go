@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// Notifier is implemented by builders that can tell about changes to the graph as they are indexed
+type Notifier interface {
+	// Changes emits an Edge for every contact message that is indexed
+	Changes() luigi.Broadcast
+
+	// HopChanges emits a HopChange whenever a feed enters or leaves a range that is watched with WatchHops.
+	// Ranges of earlier calls to Hops might get changes, too, but only as long as they are memoized.
+	HopChanges() luigi.Broadcast
+
+	// WatchHops makes HopChanges emit the changes to the range of Hops(from, max) until the returned func is called
+	WatchHops(from *ssb.FeedRef, max int) func()
+}
+
+// Edge is the current relation between two feeds
+type Edge struct {
+	From *ssb.FeedRef `json:"from"`
+	To   *ssb.FeedRef `json:"to"`
+
+	Following bool `json:"following"`
+	Blocking  bool `json:"blocking"`
+}
+
+// HopChange tells that Feed entered (Added) or left the range of Hops(From, Max)
+type HopChange struct {
+	From *ssb.FeedRef `json:"from"`
+	Max  int          `json:"max"`
+
+	Feed  *ssb.FeedRef `json:"feed"`
+	Added bool         `json:"added"`
+}
+
+var _ Notifier = (*builder)(nil)
+
+func (b *builder) Changes() luigi.Broadcast    { return b.edges }
+func (b *builder) HopChanges() luigi.Broadcast { return b.hopChanges }
+
+// notify sends the changes to all the listeners.
+// It shouldn't be called while holding the cacheLock, listeners might want to look at the graph.
+func (b *builder) notify(ctx context.Context, edge *Edge, hops []HopChange) {
+	if edge != nil {
+		if err := b.edgeSink.Pour(ctx, *edge); err != nil {
+			level.Warn(b.log).Log("msg", "failed to notify about changed edge", "err", err)
+		}
+	}
+	for _, hc := range hops {
+		if err := b.hopSink.Pour(ctx, hc); err != nil {
+			level.Warn(b.log).Log("msg", "failed to notify about hop change", "err", err)
+		}
+	}
+}
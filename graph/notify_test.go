@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+)
+
+func TestNotifyChanges(t *testing.T) {
+	r := require.New(t)
+
+	b, self, done := openLargeRepo(t)
+	defer done()
+
+	var (
+		edges []Edge
+		hops  []HopChange
+	)
+	cancelEdges := b.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		edges = append(edges, v.(Edge))
+		return nil
+	}))
+	defer cancelEdges()
+	cancelHops := b.HopChanges().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		hops = append(hops, v.(HopChange))
+		return nil
+	}))
+	defer cancelHops()
+
+	r.NotNil(b.Hops(self, 1))
+
+	newbie := randomFeed(t)
+	b.testUpdate(t, self, newbie, true)
+
+	r.Len(edges, 1)
+	r.True(edges[0].From.Equal(self))
+	r.True(edges[0].To.Equal(newbie))
+	r.True(edges[0].Following)
+
+	r.Len(hops, 1)
+	r.True(hops[0].From.Equal(self))
+	r.Equal(1, hops[0].Max)
+	r.True(hops[0].Feed.Equal(newbie))
+	r.True(hops[0].Added)
+
+	// following again doesn't change the range
+	b.testUpdate(t, self, newbie, true)
+	r.Len(edges, 2)
+	r.Len(hops, 1)
+
+	b.testUpdate(t, self, newbie, false)
+	r.Len(edges, 3)
+	r.False(edges[2].Following)
+	r.Len(hops, 2)
+	r.True(hops[1].Feed.Equal(newbie))
+	r.False(hops[1].Added)
+}
+
+func TestWatchHops(t *testing.T) {
+	r := require.New(t)
+
+	b, self, done := openLargeRepo(t)
+	defer done()
+
+	var hops []HopChange
+	cancelHops := b.HopChanges().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		hops = append(hops, v.(HopChange))
+		return nil
+	}))
+	defer cancelHops()
+
+	release := b.WatchHops(self, 1)
+
+	// more unwatched sets than are kept around
+	for i := 0; i < maxUnwatchedHops+5; i++ {
+		r.NotNil(b.Hops(randomFeed(t), 1))
+	}
+	b.cacheLock.Lock()
+	r.Len(b.hops, maxUnwatchedHops+1)
+	_, has := b.hops[hopKey{from: self.StoredAddr(), max: 1}]
+	b.cacheLock.Unlock()
+	r.True(has, "watched set was dropped")
+
+	newbie := randomFeed(t)
+	b.testUpdate(t, self, newbie, true)
+	r.Len(hops, 1)
+	r.True(hops[0].From.Equal(self))
+	r.True(hops[0].Feed.Equal(newbie))
+
+	// released sets are dropped like the others, the one of self is used the longest ago
+	release()
+	release()
+	b.cacheLock.Lock()
+	r.Len(b.hops, maxUnwatchedHops)
+	_, has = b.hops[hopKey{from: self.StoredAddr(), max: 1}]
+	b.cacheLock.Unlock()
+	r.False(has)
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	libbadger "go.cryptoscope.co/librarian/badger"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+const FolderNameFeedMigrations = "feedmigrations"
+
+// FeedMigrations keeps track of the feed/migrate messages and resolves which feeds moved to a new key.
+// The keys are a one byte prefix followed by the stored addresses of two feeds:
+// a+old+new when old announced new, c+new+old when new countersigned it,
+// s+old for the successor of old and p+new+old for its predecessors once both are there.
+// If a feed announces more than one successor, the first one that was countersigned wins.
+type FeedMigrations struct {
+	librarian.SeqSetterIndex
+
+	db *badger.DB
+
+	changeSink luigi.Sink
+	changes    luigi.Broadcast
+}
+
+var _ graph.FeedMigrations = (*FeedMigrations)(nil)
+
+const (
+	migrateAnnounced     = 'a'
+	migrateCountersigned = 'c'
+	migrateSuccessor     = 's'
+	migratePredecessor   = 'p'
+)
+
+func migrateKey(prefix byte, refs ...*ssb.FeedRef) librarian.Addr {
+	k := librarian.Addr([]byte{prefix})
+	for _, r := range refs {
+		k += r.StoredAddr()
+	}
+	return k
+}
+
+// OpenFeedMigrations supplies the index of completed feed migrations
+func OpenFeedMigrations(r repo.Interface) (librarian.Index, librarian.SinkIndex, error) {
+	fm := &FeedMigrations{}
+	fm.changeSink, fm.changes = luigi.NewBroadcast()
+
+	updateFn := func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
+		fm.db = db
+		fm.SeqSetterIndex = libbadger.NewIndex(db, margaret.BaseSeq(0))
+		return fm.SeqSetterIndex, librarian.NewSinkIndex(fm.update, fm.SeqSetterIndex)
+	}
+
+	_, _, sinkIdx, err := repo.OpenBadgerIndex(r, FolderNameFeedMigrations, updateFn)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting feed migrations index")
+	}
+	return fm, sinkIdx, nil
+}
+
+func (fm *FeedMigrations) update(ctx context.Context, seq margaret.Seq, val interface{}, idx librarian.SetterIndex) error {
+	if nulled, ok := val.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
+	}
+	msg, ok := val.(ssb.Message)
+	if !ok {
+		return errors.Errorf("index/feedmigrations: unexpected message type: %T", val)
+	}
+
+	var mig ssb.FeedMigration
+	if err := json.Unmarshal(msg.ContentBytes(), &mig); err != nil {
+		// not a migration or invalid, nothing to do with it
+		return nil
+	}
+
+	var other librarian.Addr
+	switch author := msg.Author(); {
+	case author.Equal(mig.Old):
+		err := idx.Set(ctx, migrateKey(migrateAnnounced, mig.Old, mig.New), seq.Seq())
+		if err != nil {
+			return errors.Wrap(err, "index/feedmigrations: failed to store announcement")
+		}
+		other = migrateKey(migrateCountersigned, mig.New, mig.Old)
+	case author.Equal(mig.New):
+		err := idx.Set(ctx, migrateKey(migrateCountersigned, mig.New, mig.Old), seq.Seq())
+		if err != nil {
+			return errors.Wrap(err, "index/feedmigrations: failed to store countersignature")
+		}
+		other = migrateKey(migrateAnnounced, mig.Old, mig.New)
+	default:
+		// only the two feeds can sign a migration
+		return nil
+	}
+
+	complete, err := fm.has(other)
+	if err != nil || !complete {
+		return err
+	}
+	moved, err := fm.has(migrateKey(migrateSuccessor, mig.Old))
+	if err != nil || moved {
+		return err
+	}
+
+	if err := idx.Set(ctx, migrateKey(migrateSuccessor, mig.Old), mig.New.Ref()); err != nil {
+		return errors.Wrap(err, "index/feedmigrations: failed to store successor")
+	}
+	if err := idx.Set(ctx, migrateKey(migratePredecessor, mig.New, mig.Old), seq.Seq()); err != nil {
+		return errors.Wrap(err, "index/feedmigrations: failed to store predecessor")
+	}
+	return fm.changeSink.Pour(ctx, graph.Migration{Old: mig.Old.Copy(), New: mig.New.Copy()})
+}
+
+func (fm *FeedMigrations) has(k librarian.Addr) (bool, error) {
+	var has bool
+	err := fm.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(k))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		has = err == nil
+		return err
+	})
+	return has, errors.Wrap(err, "index/feedmigrations: lookup failed")
+}
+
+// Successor returns the feed that old moved to or nil
+func (fm *FeedMigrations) Successor(old *ssb.FeedRef) (*ssb.FeedRef, error) {
+	var successor *ssb.FeedRef
+	err := fm.db.View(func(txn *badger.Txn) error {
+		it, err := txn.Get([]byte(migrateKey(migrateSuccessor, old)))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return it.Value(func(v []byte) error {
+			var ref string
+			if err := json.Unmarshal(v, &ref); err != nil {
+				return err
+			}
+			successor, err = ssb.ParseFeedRef(ref)
+			return err
+		})
+	})
+	return successor, errors.Wrapf(err, "index/feedmigrations: failed to get successor of %s", old.ShortRef())
+}
+
+// Predecessors returns the feeds that moved to new
+func (fm *FeedMigrations) Predecessors(new *ssb.FeedRef) ([]*ssb.FeedRef, error) {
+	var preds []*ssb.FeedRef
+	err := fm.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		prefix := []byte(migrateKey(migratePredecessor, new))
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			var sr ssb.StorageRef
+			if err := sr.Unmarshal(iter.Item().Key()[len(prefix):]); err != nil {
+				return err
+			}
+			ref, err := sr.FeedRef()
+			if err != nil {
+				return err
+			}
+			preds = append(preds, ref)
+		}
+		return nil
+	})
+	return preds, errors.Wrapf(err, "index/feedmigrations: failed to get predecessors of %s", new.ShortRef())
+}
+
+// Changes emits a graph.Migration whenever one was completed
+func (fm *FeedMigrations) Changes() luigi.Broadcast { return fm.changes }
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestFeedMigrations(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath, err := ioutil.TempDir("", "feedmigrations")
+	r.NoError(err)
+	defer os.RemoveAll(tRepoPath)
+
+	idx, sink, err := OpenFeedMigrations(repo.New(tRepoPath))
+	r.NoError(err)
+	defer sink.Close()
+	fm, ok := idx.(*FeedMigrations)
+	r.True(ok)
+
+	var completed []graph.Migration
+	cancel := fm.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return nil
+		}
+		completed = append(completed, v.(graph.Migration))
+		return nil
+	}))
+	defer cancel()
+
+	newFeed := func() *ssb.FeedRef {
+		kp, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		return kp.Id
+	}
+	var (
+		old     = newFeed()
+		new     = newFeed()
+		other   = newFeed()
+		mallory = newFeed()
+	)
+
+	seq := 0
+	publish := func(author, from, to *ssb.FeedRef) {
+		var kv ssb.KeyValueRaw
+		kv.Value.Author = *author
+		kv.Value.Content, err = json.Marshal(ssb.NewFeedMigration(from, to))
+		r.NoError(err)
+		r.NoError(sink.Pour(context.TODO(), margaret.WrapWithSeq(kv, margaret.BaseSeq(seq))))
+		seq++
+	}
+
+	// only the two feeds can sign it
+	publish(mallory, old, new)
+	publish(old, old, new)
+	succ, err := fm.Successor(old)
+	r.NoError(err)
+	r.Nil(succ, "not countersigned yet")
+
+	publish(new, old, new)
+	succ, err = fm.Successor(old)
+	r.NoError(err)
+	r.NotNil(succ)
+	r.True(succ.Equal(new))
+	preds, err := fm.Predecessors(new)
+	r.NoError(err)
+	r.Len(preds, 1)
+	r.True(preds[0].Equal(old))
+	r.Len(completed, 1)
+
+	// the first migration stays
+	publish(old, old, other)
+	publish(other, old, other)
+	succ, err = fm.Successor(old)
+	r.NoError(err)
+	r.True(succ.Equal(new))
+	preds, err = fm.Predecessors(other)
+	r.NoError(err)
+	r.Len(preds, 0)
+	r.Len(completed, 1)
+
+	// countersigning first works, too
+	publish(other, new, other)
+	publish(new, new, other)
+	current, err := graph.ResolveFeed(fm, old)
+	r.NoError(err)
+	r.True(current.Equal(other))
+	r.Len(completed, 2)
+}
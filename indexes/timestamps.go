@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	libbadger "go.cryptoscope.co/librarian/badger"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+const FolderNameTimestamps = "timestamps"
+
+// Timestamps orders all messages by the timestamp their authors claimed.
+// The keys are the 8 bytes of the (clamped) timestamp in milliseconds followed by 8 bytes of the rootlog sequence,
+// both big-endian, so that badger iterates them in the right order.
+type Timestamps struct {
+	librarian.SeqSetterIndex
+
+	db *badger.DB
+}
+
+// TimestampEntry is one message on the timestamp index
+type TimestampEntry struct {
+	Claimed int64 // milliseconds since the epoch
+	Seq     margaret.BaseSeq
+}
+
+const timestampKeyLen = 16
+
+func (te TimestampEntry) key() []byte {
+	k := make([]byte, timestampKeyLen)
+	binary.BigEndian.PutUint64(k[:8], uint64(te.Claimed))
+	binary.BigEndian.PutUint64(k[8:], uint64(te.Seq))
+	return k
+}
+
+func timestampEntryFromKey(k []byte) TimestampEntry {
+	return TimestampEntry{
+		Claimed: int64(binary.BigEndian.Uint64(k[:8])),
+		Seq:     margaret.BaseSeq(binary.BigEndian.Uint64(k[8:])),
+	}
+}
+
+// ClampedTimestamp returns the claimed timestamp of a message in milliseconds.
+// Timestamps from before the epoch are set to zero and
+// timestamps from the future (later then when we received the message) are set to the receive time,
+// so that wrong clocks can't pin a message to the top of the stream.
+func ClampedTimestamp(msg ssb.Message) int64 {
+	claimed := msg.Claimed().UnixNano() / int64(time.Millisecond)
+	if claimed < 0 {
+		return 0
+	}
+	if rx := msg.Received().UnixNano() / int64(time.Millisecond); rx > 0 && claimed > rx {
+		return rx
+	}
+	return claimed
+}
+
+// OpenTimestamps supplies the claimed timestamp -> rootLogSeq idx
+func OpenTimestamps(r repo.Interface) (librarian.Index, librarian.SinkIndex, error) {
+	var ts Timestamps
+	updateFn := func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
+		ts.db = db
+		ts.SeqSetterIndex = libbadger.NewIndex(db, margaret.BaseSeq(0))
+		sink := librarian.NewSinkIndex(func(ctx context.Context, seq margaret.Seq, val interface{}, idx librarian.SetterIndex) error {
+			if nulled, ok := val.(error); ok {
+				if margaret.IsErrNulled(nulled) {
+					return nil
+				}
+				return nulled
+			}
+			msg, ok := val.(ssb.Message)
+			if !ok {
+				return errors.Errorf("index/timestamps: unexpected message type: %T", val)
+			}
+			te := TimestampEntry{
+				Claimed: ClampedTimestamp(msg),
+				Seq:     margaret.BaseSeq(seq.Seq()),
+			}
+			err := idx.Set(ctx, librarian.Addr(te.key()), seq.Seq())
+			return errors.Wrapf(err, "index/timestamps: failed to update message %s (seq: %d)", msg.Key().Ref(), seq.Seq())
+		}, ts.SeqSetterIndex)
+		return ts.SeqSetterIndex, sink
+	}
+
+	_, _, sinkIdx, err := repo.OpenBadgerIndex(r, FolderNameTimestamps, updateFn)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting timestamps index")
+	}
+	return &ts, sinkIdx, nil
+}
+
+// FirstEntry and LastEntry can be used as the start of Range to get all of the index
+var (
+	FirstEntry = TimestampEntry{Claimed: 0, Seq: 0}
+	LastEntry  = TimestampEntry{Claimed: math.MaxInt64, Seq: math.MaxInt64}
+)
+
+// Range returns up to n entries, starting at from (inclusive), in ascending or descending order.
+// The transaction is only held for one page, so it is safe to do slow things with the result.
+// Use Next() or Prev() of the last returned entry to get the next page.
+func (ts *Timestamps) Range(from TimestampEntry, reverse bool, n int) ([]TimestampEntry, error) {
+	var entries []TimestampEntry
+	err := ts.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = reverse
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Seek(from.key()); iter.Valid(); iter.Next() {
+			k := iter.Item().Key()
+			if len(k) != timestampKeyLen {
+				// the current sequence of the index
+				continue
+			}
+			entries = append(entries, timestampEntryFromKey(k))
+			if len(entries) == n {
+				break
+			}
+		}
+		return nil
+	})
+	return entries, errors.Wrap(err, "index/timestamps: range failed")
+}
+
+// Next returns the position directly after this entry
+func (te TimestampEntry) Next() TimestampEntry {
+	if te.Seq == math.MaxInt64 {
+		return TimestampEntry{Claimed: te.Claimed + 1}
+	}
+	return TimestampEntry{Claimed: te.Claimed, Seq: te.Seq + 1}
+}
+
+// Prev returns the position directly before this entry, or false if this is FirstEntry and there is nothing before it
+func (te TimestampEntry) Prev() (TimestampEntry, bool) {
+	if te.Seq == 0 {
+		if te.Claimed <= 0 {
+			return TimestampEntry{}, false
+		}
+		return TimestampEntry{Claimed: te.Claimed - 1, Seq: math.MaxInt64}, true
+	}
+	return TimestampEntry{Claimed: te.Claimed, Seq: te.Seq - 1}, true
+}
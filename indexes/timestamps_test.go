@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cryptix/go/encodedTime"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestTimestampsOrder(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath, err := ioutil.TempDir("", "timestamps")
+	r.NoError(err)
+	defer os.RemoveAll(tRepoPath)
+
+	idx, sink, err := OpenTimestamps(repo.New(tRepoPath))
+	r.NoError(err)
+	ts, ok := idx.(*Timestamps)
+	r.True(ok)
+
+	received := time.Unix(1590000000, 0)
+	rxMillis := received.UnixNano() / int64(time.Millisecond)
+
+	var claimed = []int64{
+		300,
+		100,
+		200,
+		100,
+		rxMillis + 3600*1000, // from the future
+	}
+
+	for i, c := range claimed {
+		var kv ssb.KeyValueRaw
+		kv.Key_ = &ssb.MessageRef{Hash: bytes.Repeat([]byte{byte(i)}, 32), Algo: ssb.RefAlgoMessageSSB1}
+		kv.Value.Timestamp = encodedTime.Millisecs(time.Unix(0, c*int64(time.Millisecond)))
+		kv.Timestamp = encodedTime.Millisecs(received)
+
+		err = sink.Pour(context.TODO(), margaret.WrapWithSeq(kv, margaret.BaseSeq(i)))
+		r.NoError(err, "failed to index message %d", i)
+	}
+
+	var want = []TimestampEntry{
+		{100, 1},
+		{100, 3},
+		{200, 2},
+		{300, 0},
+		{rxMillis, 4}, // clamped to the receive time
+	}
+
+	all, err := ts.Range(FirstEntry, false, 10)
+	r.NoError(err)
+	r.Equal(want, all)
+
+	rev, err := ts.Range(LastEntry, true, 10)
+	r.NoError(err)
+	r.Len(rev, len(want))
+	for i := range rev {
+		r.Equal(want[len(want)-1-i], rev[i], "reverse entry %d", i)
+	}
+
+	// paging
+	first, err := ts.Range(FirstEntry, false, 2)
+	r.NoError(err)
+	r.Equal(want[:2], first)
+
+	second, err := ts.Range(first[1].Next(), false, 2)
+	r.NoError(err)
+	r.Equal(want[2:4], second)
+
+	// reverse paging, starting before 300
+	before300, ok := TimestampEntry{Claimed: 300}.Prev()
+	r.True(ok)
+	prev, err := ts.Range(before300, true, 2)
+	r.NoError(err)
+	r.Equal([]TimestampEntry{{200, 2}, {100, 3}}, prev)
+
+	// nothing comes before the first entry, it doesn't wrap around to the last one
+	_, ok = FirstEntry.Prev()
+	r.False(ok)
+	_, ok = TimestampEntry{Claimed: 0, Seq: 1}.Prev()
+	r.True(ok)
+
+	r.NoError(sink.Close())
+}
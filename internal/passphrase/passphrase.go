@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+// Package passphrase gets passphrases for encrypted secret files from the places the commands support:
+// an environment variable, an open file descriptor or a prompt on the terminal.
+package passphrase
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// FromReader reads the first line from r, without the line ending
+func FromReader(r io.Reader) ([]byte, error) {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("passphrase: failed to read: %w", err)
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return nil, ssb.ErrNoPassphrase
+	}
+	return line, nil
+}
+
+// FromFD reads the passphrase from the open file descriptor fd, like 3 in `go-sbot -passfd 3 3<pass.txt`.
+// The descriptor can only be read once, so the passphrase is remembered.
+func FromFD(fd int) ssb.PassphraseFunc {
+	var (
+		once sync.Once
+		pass []byte
+		err  error
+	)
+	return func(string) ([]byte, error) {
+		once.Do(func() {
+			f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+			if f == nil {
+				err = fmt.Errorf("passphrase: invalid file descriptor %d", fd)
+				return
+			}
+			defer f.Close()
+			pass, err = FromReader(f)
+		})
+		return pass, err
+	}
+}
+
+// Prompt asks for the passphrase on the terminal (without echoing it) and remembers it for the following calls.
+// It returns ssb.ErrNoPassphrase if stdin isn't a terminal.
+func Prompt() ssb.PassphraseFunc {
+	var (
+		mu   sync.Mutex
+		pass []byte
+	)
+	return func(path string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if pass != nil {
+			return pass, nil
+		}
+
+		p, err := prompt(fmt.Sprintf("Passphrase for %s: ", path))
+		if err != nil {
+			return nil, err
+		}
+		pass = p
+		return pass, nil
+	}
+}
+
+// PromptNew asks for a new passphrase twice and errors if they don't match
+func PromptNew() ([]byte, error) {
+	first, err := prompt("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	second, err := prompt("Repeat new passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(first, second) {
+		return nil, fmt.Errorf("passphrase: the passphrases don't match")
+	}
+	return first, nil
+}
+
+func prompt(text string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, ssb.ErrNoPassphrase
+	}
+	fmt.Fprint(os.Stderr, text)
+	p, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("passphrase: failed to read from terminal: %w", err)
+	}
+	if len(p) == 0 {
+		return nil, ssb.ErrNoPassphrase
+	}
+	return p, nil
+}
+
+// Source returns the passphrase from fd if it isn't negative. Otherwise it uses the environment variable env
+// and, if that isn't set, prompts for it.
+func Source(env string, fd int) ssb.PassphraseFunc {
+	if fd >= 0 {
+		return FromFD(fd)
+	}
+	fromEnv := ssb.PassphraseFromEnv(env)
+	fromPrompt := Prompt()
+	return func(path string) ([]byte, error) {
+		p, err := fromEnv(path)
+		if err == ssb.ErrNoPassphrase {
+			return fromPrompt(path)
+		}
+		return p, err
+	}
+}
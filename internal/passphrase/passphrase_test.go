@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: MIT
+
+package passphrase
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestFromReader(t *testing.T) {
+	r := require.New(t)
+
+	p, err := FromReader(strings.NewReader("secret words\r\nsecond line\n"))
+	r.NoError(err)
+	r.Equal("secret words", string(p))
+
+	p, err = FromReader(strings.NewReader("no newline"))
+	r.NoError(err)
+	r.Equal("no newline", string(p))
+
+	_, err = FromReader(strings.NewReader("\n"))
+	r.Equal(ssb.ErrNoPassphrase, err)
+}
+
+func TestFromFD(t *testing.T) {
+	r := require.New(t)
+
+	pr, pw, err := os.Pipe()
+	r.NoError(err)
+	_, err = pw.WriteString("from a pipe\n")
+	r.NoError(err)
+	r.NoError(pw.Close())
+
+	pf := FromFD(int(pr.Fd()))
+	p, err := pf("secret")
+	r.NoError(err)
+	r.Equal("from a pipe", string(p))
+
+	// the descriptor is closed but the passphrase is remembered
+	p, err = pf("secrets/other")
+	r.NoError(err)
+	r.Equal("from a pipe", string(p))
+}
+
+func TestSourceEnv(t *testing.T) {
+	r := require.New(t)
+
+	os.Setenv("SSB_TEST_PASSPHRASE", "from env")
+	defer os.Unsetenv("SSB_TEST_PASSPHRASE")
+
+	p, err := Source("SSB_TEST_PASSPHRASE", -1)("secret")
+	r.NoError(err)
+	r.Equal("from env", string(p))
+}
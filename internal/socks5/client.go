@@ -0,0 +1,230 @@
+// SPDX-License-Identifier: MIT
+
+// Package socks5 implements the client side of SOCKS5 (RFC 1928) CONNECT requests, like they are needed to dial through tor.
+// It also has a minimal server which is used as a stand-in for tor in tests.
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	version5 = 0x05
+
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+var replyMessages = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// ReplyError is returned when the proxy couldn't establish the requested connection
+type ReplyError struct {
+	Code byte
+}
+
+func (re ReplyError) Error() string {
+	msg, ok := replyMessages[re.Code]
+	if !ok {
+		msg = "unknown error"
+	}
+	return fmt.Sprintf("socks5: proxy replied %d (%s)", re.Code, msg)
+}
+
+// Dialer opens connections through the SOCKS5 proxy at ProxyAddr.
+// Tor uses different circuits for different usernames, which can be used to isolate streams.
+type Dialer struct {
+	ProxyAddr string
+
+	Username, Password string
+
+	// HandshakeTimeout limits the negotiation with the proxy, defaults to 30 seconds
+	HandshakeTimeout time.Duration
+}
+
+// DialContext asks the proxy to connect to address (host:port).
+// Hostnames are passed to the proxy as they are, so that it resolves them (necessary for .onion names).
+func (d Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("socks5: unsupported network %q", network)
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: invalid destination: %w", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: invalid destination port: %w", err)
+	}
+
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: failed to reach proxy: %w", err)
+	}
+
+	timeout := d.HandshakeTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if dl, has := ctx.Deadline(); has && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	if err := d.handshake(conn, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Dial is DialContext with a background context
+func (d Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d Dialer) handshake(conn net.Conn, host string, port uint16) error {
+	methods := []byte{methodNoAuth}
+	if d.Username != "" {
+		methods = append(methods, methodUserPass)
+	}
+	greeting := append([]byte{version5, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("socks5: failed to send greeting: %w", err)
+	}
+
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return fmt.Errorf("socks5: failed to read method choice: %w", err)
+	}
+	if choice[0] != version5 {
+		return fmt.Errorf("socks5: unexpected version %d", choice[0])
+	}
+	switch choice[1] {
+	case methodNoAuth:
+	case methodUserPass:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	case methodNoAcceptable:
+		return fmt.Errorf("socks5: proxy accepted none of our authentication methods")
+	default:
+		return fmt.Errorf("socks5: proxy chose unoffered method %d", choice[1])
+	}
+
+	req := []byte{version5, cmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, atypIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, atypIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("socks5: hostname too long")
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("socks5: failed to send request: %w", err)
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("socks5: failed to read reply: %w", err)
+	}
+	if reply[1] != 0x00 {
+		return ReplyError{Code: reply[1]}
+	}
+
+	// skip the bound address, we don't need it
+	if _, err := readAddr(conn, reply[3]); err != nil {
+		return fmt.Errorf("socks5: failed to read bound address: %w", err)
+	}
+	return nil
+}
+
+func (d Dialer) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return fmt.Errorf("socks5: username or password too long")
+	}
+	req := []byte{0x01, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("socks5: failed to send credentials: %w", err)
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return fmt.Errorf("socks5: failed to read authentication status: %w", err)
+	}
+	if resp[1] != 0x00 {
+		return fmt.Errorf("socks5: authentication failed")
+	}
+	return nil
+}
+
+// readAddr reads an address of type atyp and the port that follows it and returns them as host:port
+func readAddr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case atypIPv4, atypIPv6:
+		sz := net.IPv4len
+		if atyp == atypIPv6 {
+			sz = net.IPv6len
+		}
+		ip := make(net.IP, sz)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case atypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("unknown address type %d", atyp)
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package socks5
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Server is a minimal SOCKS5 server that only supports CONNECT without authentication.
+// It is meant as a stand-in for tor in tests and not for production use.
+type Server struct {
+	// Resolve maps requested destinations (like xyz.onion:8008) to addresses it can dial.
+	// If it's nil, destinations are dialed as they are.
+	Resolve func(hostport string) (string, error)
+
+	mu        sync.Mutex
+	requested []string
+}
+
+// Requested returns the destinations that were asked for so far
+func (s *Server) Requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requested...)
+}
+
+// Serve handles the connections of l until it is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return nil
+			}
+			return err
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer c.Close()
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil || hdr[0] != version5 {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return
+	}
+	var hasNoAuth bool
+	for _, m := range methods {
+		if m == methodNoAuth {
+			hasNoAuth = true
+		}
+	}
+	if !hasNoAuth {
+		c.Write([]byte{version5, methodNoAcceptable})
+		return
+	}
+	if _, err := c.Write([]byte{version5, methodNoAuth}); err != nil {
+		return
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(c, req[:]); err != nil {
+		return
+	}
+	if req[1] != cmdConnect {
+		s.reply(c, 0x07)
+		return
+	}
+	dest, err := readAddr(c, req[3])
+	if err != nil {
+		s.reply(c, 0x08)
+		return
+	}
+
+	s.mu.Lock()
+	s.requested = append(s.requested, dest)
+	s.mu.Unlock()
+
+	dialAddr := dest
+	if s.Resolve != nil {
+		dialAddr, err = s.Resolve(dest)
+		if err != nil {
+			s.reply(c, 0x04)
+			return
+		}
+	}
+
+	out, err := net.Dial("tcp", dialAddr)
+	if err != nil {
+		s.reply(c, 0x05)
+		return
+	}
+	defer out.Close()
+
+	if err := s.reply(c, 0x00); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(out, c)
+		out.Close()
+		close(done)
+	}()
+	io.Copy(c, out)
+	c.Close()
+	<-done
+}
+
+func (s *Server) reply(c net.Conn, code byte) error {
+	// bound address is always reported as 0.0.0.0:0
+	_, err := c.Write([]byte{version5, code, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return fmt.Errorf("socks5: failed to send reply: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: MIT
+
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialThroughServer(t *testing.T) {
+	r := require.New(t)
+
+	// the destination echos everything back
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	const onion = "abcdefghijklmnop.onion:8008"
+	srv := &Server{
+		Resolve: func(hostport string) (string, error) {
+			if hostport == onion {
+				return echo.Addr().String(), nil
+			}
+			return "", fmt.Errorf("unknown host: %s", hostport)
+		},
+	}
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer proxy.Close()
+	go srv.Serve(proxy)
+
+	d := Dialer{ProxyAddr: proxy.Addr().String()}
+
+	c, err := d.DialContext(context.TODO(), "tcp", onion)
+	r.NoError(err)
+
+	_, err = c.Write([]byte("hello"))
+	r.NoError(err)
+	got := make([]byte, 5)
+	_, err = io.ReadFull(c, got)
+	r.NoError(err)
+	r.Equal("hello", string(got))
+	r.NoError(c.Close())
+
+	_, err = d.DialContext(context.TODO(), "tcp", "unknown.onion:8008")
+	r.Error(err)
+	re, ok := err.(ReplyError)
+	r.True(ok, "wrong error type: %T", err)
+	r.EqualValues(0x04, re.Code)
+
+	r.Equal([]string{onion, "unknown.onion:8008"}, srv.Requested())
+}
+
+func TestDialIPDestination(t *testing.T) {
+	r := require.New(t)
+
+	dest, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer dest.Close()
+	go func() {
+		c, err := dest.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte("ok"))
+		c.Close()
+	}()
+
+	var srv Server
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer proxy.Close()
+	go srv.Serve(proxy)
+
+	c, err := Dialer{ProxyAddr: proxy.Addr().String()}.Dial("tcp", dest.Addr().String())
+	r.NoError(err)
+	got := make([]byte, 2)
+	_, err = io.ReadFull(c, got)
+	r.NoError(err)
+	r.Equal("ok", string(got))
+	c.Close()
+
+	r.Equal([]string{dest.Addr().String()}, srv.Requested())
+}
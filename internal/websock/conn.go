@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: MIT
+
+// Package websock implements just enough of RFC 6455 to carry a byte stream (like secret-handshake and boxstream) over binary WebSocket messages.
+// Message boundaries are not preserved, text messages are treated like binary ones and extensions are not supported.
+package websock
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// magic value from the RFC that is used to compute Sec-WebSocket-Accept
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxControlPayload is the largest payload a control frame can have
+const maxControlPayload = 125
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Conn is a net.Conn that reads and writes the payload of websocket frames.
+// Local and remote addresses are the ones of the underlying connection.
+type Conn struct {
+	net.Conn
+
+	// client connections mask what they send, servers don't
+	client bool
+
+	rmu       sync.Mutex
+	br        *bufio.Reader
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+	gotClose  bool
+
+	wmu       sync.Mutex
+	sentClose bool
+}
+
+func newConn(c net.Conn, br *bufio.Reader, client bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(c)
+	}
+	return &Conn{
+		Conn:   c,
+		br:     br,
+		client: client,
+	}
+}
+
+// Read returns the payload of binary and text frames and handles control frames inbetween.
+// It returns io.EOF once the remote sent a close frame.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for c.remaining == 0 {
+		if c.gotClose {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.br.Read(b)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			b[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	c.remaining -= uint64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame reads frame headers until it finds one with data.
+// Control frames are answered here.
+func (c *Conn) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return err
+	}
+
+	op := hdr[0] & 0x0f
+	if hdr[0]&0x70 != 0 {
+		return fmt.Errorf("websock: reserved bits set but no extensions negotiated")
+	}
+
+	c.masked = hdr[1]&0x80 != 0
+	if c.masked == c.client {
+		// servers must not mask, clients must
+		return fmt.Errorf("websock: unexpected masking (masked:%v client:%v)", c.masked, c.client)
+	}
+
+	length := uint64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if c.masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return err
+		}
+	}
+	c.maskPos = 0
+
+	switch op {
+	case opContinuation, opText, opBinary:
+		c.remaining = length
+		return nil
+
+	case opClose, opPing, opPong:
+		if length > maxControlPayload {
+			return fmt.Errorf("websock: control frame too large (%d)", length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		if c.masked {
+			for i := range payload {
+				payload[i] ^= c.mask[i%4]
+			}
+		}
+
+		switch op {
+		case opPing:
+			return c.writeFrame(opPong, payload)
+		case opClose:
+			c.gotClose = true
+			// echo the status code back, as the RFC asks
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			err := c.writeFrame(opClose, payload)
+			if err != nil && err != errAlreadyClosed {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("websock: unknown opcode %x", op)
+	}
+}
+
+// Write sends b as a single binary frame
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(opBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+var errAlreadyClosed = fmt.Errorf("websock: close frame already sent")
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.sentClose {
+		return errAlreadyClosed
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|op) // always FIN, we never fragment
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(n))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(n))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := io.ReadFull(rand.Reader, mask[:]); err != nil {
+			return fmt.Errorf("websock: failed to make masking key: %w", err)
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range frame[start:] {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	if op == opClose {
+		c.sentClose = true
+	}
+
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends a normal closure frame (if none was sent yet) and closes the underlying connection
+func (c *Conn) Close() error {
+	_ = c.writeFrame(opClose, []byte{0x03, 0xe8}) // 1000: normal closure
+	return c.Conn.Close()
+}
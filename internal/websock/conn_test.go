@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+package websock
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// example from RFC 6455, section 1.3
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestRoundtrip(t *testing.T) {
+	r := require.New(t)
+
+	l, err := Listen(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	r.NoError(err)
+	defer l.Close()
+
+	// echo everything back
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	u := &url.URL{Scheme: "ws", Host: l.Addr().String(), Path: "/"}
+	c, err := Dial(context.TODO(), u)
+	r.NoError(err)
+
+	// one of each length encoding
+	for _, sz := range []int{1, 125, 126, 4096, 70000} {
+		msg := make([]byte, sz)
+		rand.Read(msg)
+
+		go func() {
+			_, err := c.Write(msg)
+			r.NoError(err)
+		}()
+
+		got := make([]byte, sz)
+		_, err = io.ReadFull(c, got)
+		r.NoError(err, "size %d", sz)
+		r.True(bytes.Equal(msg, got), "size %d", sz)
+	}
+
+	// ping is answered transparently
+	r.NoError(c.writeFrame(opPing, []byte("hi")))
+	_, err = c.Write([]byte("after ping"))
+	r.NoError(err)
+	got := make([]byte, 10)
+	_, err = io.ReadFull(c, got)
+	r.NoError(err)
+	r.Equal("after ping", string(got))
+
+	r.NoError(c.Close())
+	_, err = c.Write([]byte("nope"))
+	r.Error(err)
+}
+
+func TestRejectPlainHTTP(t *testing.T) {
+	r := require.New(t)
+
+	l, err := Listen(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	r.NoError(err)
+	defer l.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	r.NoError(err)
+	defer c.Close()
+
+	_, err = io.WriteString(c, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	r.NoError(err)
+
+	buf := make([]byte, 12)
+	_, err = io.ReadFull(c, buf)
+	r.NoError(err)
+	r.Equal("HTTP/1.1 400", string(buf))
+}
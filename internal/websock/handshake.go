@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: MIT
+
+package websock
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.cryptoscope.co/netwrap"
+)
+
+// Upgrade does the server side of the opening handshake and takes over the connection of the request
+func Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "websocket: expected GET", http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("websock: bad method: %s", req.Method)
+	}
+	if !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket: expected upgrade", http.StatusBadRequest)
+		return nil, fmt.Errorf("websock: not an upgrade request")
+	}
+	if v := req.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket: unsupported version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("websock: unsupported version: %q", v)
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "websocket: missing key", http.StatusBadRequest)
+		return nil, fmt.Errorf("websock: missing Sec-WebSocket-Key")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket: can't hijack", http.StatusInternalServerError)
+		return nil, fmt.Errorf("websock: response writer is not a http.Hijacker")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websock: hijack failed: %w", err)
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := io.WriteString(conn, resp); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websock: failed to send handshake response: %w", err)
+	}
+
+	return newConn(conn, brw.Reader, false), nil
+}
+
+// Client does the client side of the opening handshake over conn, asking for the passed URL.
+func Client(conn net.Conn, u *url.URL) (*Conn, error) {
+	var rawKey [16]byte
+	if _, err := io.ReadFull(rand.Reader, rawKey[:]); err != nil {
+		return nil, fmt.Errorf("websock: failed to make key: %w", err)
+	}
+	key := base64.StdEncoding.EncodeToString(rawKey[:])
+
+	path := u.RequestURI()
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Opaque: path},
+		Host:       u.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               []string{"websocket"},
+			"Connection":            []string{"Upgrade"},
+			"Sec-WebSocket-Key":     []string{key},
+			"Sec-WebSocket-Version": []string{"13"},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("websock: failed to send handshake: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("websock: failed to read handshake response: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websock: server refused upgrade: %s", resp.Status)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != acceptKey(key) {
+		return nil, fmt.Errorf("websock: invalid Sec-WebSocket-Accept: %q", got)
+	}
+
+	return newConn(conn, br, true), nil
+}
+
+// ClientWrapper returns a netwrap.ConnWrapper that does the opening handshake for u on dialed connections
+func ClientWrapper(u *url.URL) netwrap.ConnWrapper {
+	return func(c net.Conn) (net.Conn, error) {
+		return Client(c, u)
+	}
+}
+
+// Dial opens a TCP connection to the host of u and does the opening handshake
+func Dial(ctx context.Context, u *url.URL) (*Conn, error) {
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("websock: unsupported scheme: %q", u.Scheme)
+	}
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	wc, err := Client(c, u)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return wc, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errClosed uses the same text as the net package so that accept loops treat it the same way
+var errClosed = fmt.Errorf("websock: use of closed network connection")
+
+// Listener serves HTTP on a net.Listener and returns upgraded requests from Accept
+type Listener struct {
+	l   net.Listener
+	srv *http.Server
+
+	conns chan net.Conn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ net.Listener = (*Listener)(nil)
+
+// Listen opens a TCP listener on addr and returns websocket connections from it
+func Listen(addr net.Addr) (*Listener, error) {
+	l, err := net.Listen(addr.Network(), addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(l), nil
+}
+
+// NewListener serves websocket upgrades on every path of l
+func NewListener(l net.Listener) *Listener {
+	wl := &Listener{
+		l:      l,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	wl.srv = &http.Server{
+		Handler:           wl,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go wl.srv.Serve(l)
+	return wl
+}
+
+// ServeHTTP upgrades the request and hands it to Accept
+func (wl *Listener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c, err := Upgrade(w, req)
+	if err != nil {
+		return
+	}
+	select {
+	case wl.conns <- c:
+	case <-wl.closed:
+		c.Close()
+	}
+}
+
+func (wl *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-wl.conns:
+		return c, nil
+	case <-wl.closed:
+		return nil, errClosed
+	}
+}
+
+func (wl *Listener) Close() error {
+	var err error
+	wl.closeOnce.Do(func() {
+		close(wl.closed)
+		err = wl.srv.Close()
+	})
+	return err
+}
+
+func (wl *Listener) Addr() net.Addr { return wl.l.Addr() }
@@ -1,6 +1,7 @@
 package invite
 
 import (
+	"bytes"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -42,6 +43,16 @@ func (c Token) String() string {
 	return s.String()
 }
 
+// GuestID returns the public key that is derived from the seed of the token.
+// The peer that created the invite knows it by this key.
+func (c Token) GuestID() (*ssb.FeedRef, error) {
+	kp, err := ssb.NewKeyPair(bytes.NewReader(c.Seed[:]))
+	if err != nil {
+		return nil, fmt.Errorf("invite: couldn't make keypair from seed: %w", err)
+	}
+	return kp.Id, nil
+}
+
 func NewPubMessageFromToken(tok Token) (*ssb.OldPubMessage, error) {
 	addr := netwrap.GetAddr(tok.Address, "tcp")
 	if addr == nil {
@@ -53,14 +64,20 @@ func NewPubMessageFromToken(tok Token) (*ssb.OldPubMessage, error) {
 		return nil, fmt.Errorf("invalid invite token - wrong address type: %T", addr)
 	}
 
+	return NewPubMessage(tok.Peer, tcpAddr.IP.String(), tcpAddr.Port), nil
+}
+
+// NewPubMessage returns the pub message that announces that peer can be reached at host:port.
+// Host can be an IP or a domain name.
+func NewPubMessage(peer ssb.FeedRef, host string, port int) *ssb.OldPubMessage {
 	return &ssb.OldPubMessage{
 		Type: "pub",
 		Address: ssb.OldAddress{
-			Key:  tok.Peer,
-			Host: tcpAddr.IP.String(),
-			Port: tcpAddr.Port,
+			Key:  peer,
+			Host: host,
+			Port: port,
 		},
-	}, nil
+	}
 }
 
 // ParseLegacyToken takes an legacy invite token of the form
@@ -9,7 +9,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/keks/nocomment"
 	"github.com/pkg/errors"
@@ -25,8 +24,11 @@ type KeyPair struct {
 type ssbSecret struct {
 	Curve   string   `json:"curve"`
 	ID      *FeedRef `json:"id"`
-	Private string   `json:"private"`
+	Private string   `json:"private,omitempty"`
 	Public  string   `json:"public"`
+
+	// Encrypted replaces Private in secrets that are encrypted with a passphrase (not understood by the js implementations)
+	Encrypted *encryptedSecret `json:"encrypted,omitempty"`
 }
 
 // IsValidFeedFormat checks if the passed FeedRef is for one of the two supported formats,
@@ -92,8 +94,14 @@ func EncodeKeyPairAsJSON(kp *KeyPair, w io.Writer) error {
 	return errors.Wrap(err, "ssb.EncodeKeyPairAsJSON: encoding failed")
 }
 
-// LoadKeyPair opens fname, ignores any line starting with # and passes it ParseKeyPair
+// LoadKeyPair opens fname, ignores any line starting with # and passes it ParseKeyPair.
+// Encrypted secrets are opened with the passphrase from SecretPassphrase.
 func LoadKeyPair(fname string) (*KeyPair, error) {
+	return LoadKeyPairWithPassphrase(fname, SecretPassphrase)
+}
+
+// LoadKeyPairWithPassphrase is like LoadKeyPair but asks passphrase if the secret is encrypted
+func LoadKeyPairWithPassphrase(fname string, passphrase PassphraseFunc) (*KeyPair, error) {
 	f, err := os.Open(fname)
 	if err != nil {
 		return nil, errors.Wrapf(err, "ssb.LoadKeyPair: could not open key file %s", fname)
@@ -108,39 +116,25 @@ func LoadKeyPair(fname string) (*KeyPair, error) {
 		return nil, fmt.Errorf("ssb.LoadKeyPair: expected key file permissions %s, but got %s", SecretPerms, perms)
 	}
 
-	return ParseKeyPair(nocomment.NewReader(f))
+	var s ssbSecret
+	if err := json.NewDecoder(nocomment.NewReader(f)).Decode(&s); err != nil {
+		return nil, errors.Wrapf(err, "ssb.LoadKeyPair: JSON decoding failed")
+	}
+	return s.keyPair(fname, passphrase)
 }
 
 // ParseKeyPair json decodes an object from the reader.
-// It expects std base64 encoded data under the `private` and `public` fields.
+// It expects std base64 encoded data under the `private` and `public` fields,
+// or an encrypted private key which is opened with the passphrase from SecretPassphrase.
 func ParseKeyPair(r io.Reader) (*KeyPair, error) {
+	return ParseKeyPairWithPassphrase(r, SecretPassphrase)
+}
+
+// ParseKeyPairWithPassphrase is like ParseKeyPair but asks passphrase if the secret is encrypted
+func ParseKeyPairWithPassphrase(r io.Reader, passphrase PassphraseFunc) (*KeyPair, error) {
 	var s ssbSecret
 	if err := json.NewDecoder(r).Decode(&s); err != nil {
 		return nil, errors.Wrapf(err, "ssb.Parse: JSON decoding failed")
 	}
-
-	if err := IsValidFeedFormat(s.ID); err != nil {
-		return nil, err
-	}
-
-	public, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Public, ".ed25519"))
-	if err != nil {
-		return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of public part failed")
-	}
-
-	private, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Private, ".ed25519"))
-	if err != nil {
-		return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of private part failed")
-	}
-
-	pair, err := secrethandshake.NewKeyPair(public, private)
-	if err != nil {
-		return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of private part failed")
-	}
-
-	ssbkp := KeyPair{
-		Id:   s.ID,
-		Pair: *pair,
-	}
-	return &ssbkp, errors.Wrap(err, "ssb.Parse: broken keypair?")
+	return s.keyPair("", passphrase)
 }
@@ -0,0 +1,274 @@
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keks/nocomment"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/secretstream/secrethandshake"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseFunc returns the passphrase for the encrypted secret file at path
+type PassphraseFunc func(path string) ([]byte, error)
+
+// PassphraseFromEnv returns the value of the environment variable name as the passphrase
+func PassphraseFromEnv(name string) PassphraseFunc {
+	return func(string) ([]byte, error) {
+		v, has := os.LookupEnv(name)
+		if !has {
+			return nil, ErrNoPassphrase
+		}
+		return []byte(v), nil
+	}
+}
+
+// SecretPassphrase is used by LoadKeyPair and ParseKeyPair if they come across an encrypted secret.
+// By default it reads the SSB_PASSPHRASE environment variable. Programs can replace it to read from other sources, like a prompt.
+var SecretPassphrase = PassphraseFromEnv("SSB_PASSPHRASE")
+
+var (
+	// ErrNoPassphrase is returned when an encrypted secret is loaded but no passphrase is available
+	ErrNoPassphrase = errors.New("ssb: secret is encrypted but no passphrase is available")
+
+	// ErrWrongPassphrase is returned when an encrypted secret can't be opened with the passphrase
+	ErrWrongPassphrase = errors.New("ssb: wrong passphrase for encrypted secret")
+)
+
+// the scrypt parameters for new secrets, they are stored with the secret so that they can be raised later
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// the limits for the scrypt parameters of a secret file, so that a changed file can't make loading it use up all memory or time
+const (
+	maxScryptN   = 1 << 20
+	maxScryptR   = 32
+	maxScryptP   = 16
+	maxScryptMem = 1 << 30 // scrypt needs 128*N*R bytes
+)
+
+// encryptedSecret holds the private key in a secretbox, the key for the box is derived from the passphrase with scrypt
+type encryptedSecret struct {
+	KDF   string `json:"kdf"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  string `json:"salt"`
+	Nonce string `json:"nonce"`
+	Box   string `json:"box"`
+}
+
+func (es encryptedSecret) key(passphrase []byte) (*[32]byte, error) {
+	if es.KDF != "scrypt" {
+		return nil, errors.Errorf("ssb: unsupported key derivation function %q", es.KDF)
+	}
+	if es.N < 2 || es.N > maxScryptN || es.R < 1 || es.R > maxScryptR || es.P < 1 || es.P > maxScryptP || 128*es.N*es.R > maxScryptMem {
+		return nil, errors.Errorf("ssb: scrypt parameters out of range (n:%d r:%d p:%d)", es.N, es.R, es.P)
+	}
+	salt, err := base64.StdEncoding.DecodeString(es.Salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssb: invalid salt")
+	}
+	derived, err := scrypt.Key(passphrase, salt, es.N, es.R, es.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssb: key derivation failed")
+	}
+	var k [32]byte
+	copy(k[:], derived)
+	return &k, nil
+}
+
+func sealSecret(private, passphrase []byte) (*encryptedSecret, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("ssb: empty passphrase")
+	}
+	es := encryptedSecret{KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP}
+
+	var salt [16]byte
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return nil, errors.Wrap(err, "ssb: failed to make salt")
+	}
+	es.Salt = base64.StdEncoding.EncodeToString(salt[:])
+
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "ssb: failed to make nonce")
+	}
+	es.Nonce = base64.StdEncoding.EncodeToString(nonce[:])
+
+	k, err := es.key(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	es.Box = base64.StdEncoding.EncodeToString(secretbox.Seal(nil, private, &nonce, k))
+	return &es, nil
+}
+
+func (es encryptedSecret) open(passphrase []byte) ([]byte, error) {
+	nonceBytes, err := base64.StdEncoding.DecodeString(es.Nonce)
+	if err != nil || len(nonceBytes) != 24 {
+		return nil, errors.New("ssb: invalid nonce")
+	}
+	var nonce [24]byte
+	copy(nonce[:], nonceBytes)
+
+	box, err := base64.StdEncoding.DecodeString(es.Box)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssb: invalid box")
+	}
+
+	k, err := es.key(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := secretbox.Open(nil, box, &nonce, k)
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	return private, nil
+}
+
+// EncodeEncryptedKeyPairAsJSON is like EncodeKeyPairAsJSON but the private key is encrypted with the passphrase.
+// The id and public key stay readable.
+func EncodeEncryptedKeyPairAsJSON(kp *KeyPair, passphrase []byte, w io.Writer) error {
+	es, err := sealSecret(kp.Pair.Secret[:], passphrase)
+	if err != nil {
+		return err
+	}
+	var sec = ssbSecret{
+		Curve:     "ed25519",
+		ID:        kp.Id,
+		Public:    base64.StdEncoding.EncodeToString(kp.Pair.Public[:]) + ".ed25519",
+		Encrypted: es,
+	}
+	err = json.NewEncoder(w).Encode(sec)
+	return errors.Wrap(err, "ssb.EncodeEncryptedKeyPairAsJSON: encoding failed")
+}
+
+// SaveEncryptedKeyPair is like SaveKeyPair but encrypts the private key with the passphrase.
+// It errors if path already exists.
+func SaveEncryptedKeyPair(kp *KeyPair, passphrase []byte, path string) error {
+	if err := IsValidFeedFormat(kp.Id); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return errors.Errorf("ssb.SaveEncryptedKeyPair: key already exists:%q", path)
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil && !os.IsExist(err) {
+		return errors.Wrap(err, "failed to create folder for keypair")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, SecretPerms)
+	if err != nil {
+		return errors.Wrap(err, "ssb.SaveEncryptedKeyPair: failed to create file")
+	}
+
+	if err := EncodeEncryptedKeyPairAsJSON(kp, passphrase, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return errors.Wrap(f.Close(), "ssb.SaveEncryptedKeyPair: failed to close file")
+}
+
+// RewrapKeyPair replaces the secret file at path with one that is encrypted with newPassphrase.
+// The current file is opened with passphrase (only asked for if it is encrypted).
+// The new file is written next to the old one and then moved over it, so that the secret isn't lost if that fails.
+func RewrapKeyPair(path string, passphrase PassphraseFunc, newPassphrase []byte) (*KeyPair, error) {
+	kp, err := LoadKeyPairWithPassphrase(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpPath := path + ".rewrap"
+	os.Remove(tmpPath)
+	if err := SaveEncryptedKeyPair(kp, newPassphrase, tmpPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return nil, errors.Wrap(err, "ssb.RewrapKeyPair: failed to replace secret file")
+	}
+	return kp, nil
+}
+
+// IsEncryptedKeyPair returns true if the secret file at path holds an encrypted private key
+func IsEncryptedKeyPair(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var s ssbSecret
+	if err := json.NewDecoder(nocomment.NewReader(f)).Decode(&s); err != nil {
+		return false, errors.Wrap(err, "ssb.IsEncryptedKeyPair: JSON decoding failed")
+	}
+	return s.Encrypted != nil, nil
+}
+
+// private returns the private key of the secret, decrypting it if necessary
+func (s ssbSecret) private(path string, passphrase PassphraseFunc) ([]byte, error) {
+	if s.Encrypted == nil {
+		private, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Private, ".ed25519"))
+		return private, errors.Wrapf(err, "ssb.Parse: base64 decode of private part failed")
+	}
+
+	if passphrase == nil {
+		return nil, ErrNoPassphrase
+	}
+	pass, err := passphrase(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.Encrypted.open(pass)
+}
+
+func (s ssbSecret) keyPair(path string, passphrase PassphraseFunc) (*KeyPair, error) {
+	if err := IsValidFeedFormat(s.ID); err != nil {
+		return nil, err
+	}
+
+	public, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Public, ".ed25519"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of public part failed")
+	}
+
+	if len(public) != ed25519.PublicKeySize || !bytes.Equal(public, s.ID.PubKey()) {
+		return nil, errors.New("ssb.Parse: public key doesn't match the id")
+	}
+
+	private, err := s.private(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// the second half of an ed25519 private key is the public key
+	if len(private) != ed25519.PrivateKeySize || !bytes.Equal(private[32:], public) {
+		return nil, errors.New("ssb.Parse: private key doesn't match the public key")
+	}
+
+	pair, err := secrethandshake.NewKeyPair(public, private)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ssb.Parse: broken keypair")
+	}
+
+	return &KeyPair{
+		Id:   s.ID,
+		Pair: *pair,
+	}, nil
+}
@@ -1,8 +1,13 @@
 package ssb
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -62,3 +67,158 @@ func TestLoadKeyPair(t *testing.T) {
 		})
 	}
 }
+
+func TestEncryptedKeyPair(t *testing.T) {
+	r := require.New(t)
+
+	fname := path.Join(os.TempDir(), "secret-encrypted")
+	os.Remove(fname)
+	defer os.Remove(fname)
+
+	keys, err := NewKeyPair(nil)
+	r.NoError(err)
+	r.NoError(SaveEncryptedKeyPair(keys, []byte("correct horse"), fname))
+
+	stat, err := os.Stat(fname)
+	r.NoError(err)
+	r.Equal(SecretPerms, stat.Mode(), "file permissions")
+
+	data, err := ioutil.ReadFile(fname)
+	r.NoError(err)
+	r.NotContains(string(data), base64.StdEncoding.EncodeToString(keys.Pair.Secret[:]))
+	r.Contains(string(data), keys.Id.Ref(), "the id should stay readable")
+
+	isEnc, err := IsEncryptedKeyPair(fname)
+	r.NoError(err)
+	r.True(isEnc)
+
+	pass := func(p string) PassphraseFunc {
+		return func(string) ([]byte, error) { return []byte(p), nil }
+	}
+
+	loaded, err := LoadKeyPairWithPassphrase(fname, pass("correct horse"))
+	r.NoError(err)
+	r.True(loaded.Id.Equal(keys.Id))
+	r.Equal(keys.Pair.Secret, loaded.Pair.Secret)
+
+	_, err = LoadKeyPairWithPassphrase(fname, pass("wrong horse"))
+	r.Equal(ErrWrongPassphrase, err)
+
+	_, err = LoadKeyPairWithPassphrase(fname, nil)
+	r.Equal(ErrNoPassphrase, err)
+
+	// the default reads the environment
+	os.Unsetenv("SSB_PASSPHRASE")
+	_, err = LoadKeyPair(fname)
+	r.Equal(ErrNoPassphrase, err)
+	os.Setenv("SSB_PASSPHRASE", "correct horse")
+	defer os.Unsetenv("SSB_PASSPHRASE")
+	_, err = LoadKeyPair(fname)
+	r.NoError(err)
+
+	// change the passphrase
+	_, err = RewrapKeyPair(fname, pass("wrong horse"), []byte("battery staple"))
+	r.Equal(ErrWrongPassphrase, err)
+	_, err = RewrapKeyPair(fname, pass("correct horse"), []byte("battery staple"))
+	r.NoError(err)
+	_, err = LoadKeyPairWithPassphrase(fname, pass("correct horse"))
+	r.Equal(ErrWrongPassphrase, err)
+	loaded, err = LoadKeyPairWithPassphrase(fname, pass("battery staple"))
+	r.NoError(err)
+	r.True(loaded.Id.Equal(keys.Id))
+
+	stat, err = os.Stat(fname)
+	r.NoError(err)
+	r.Equal(SecretPerms, stat.Mode(), "file permissions after rewrap")
+}
+
+func TestRewrapPlainKeyPair(t *testing.T) {
+	r := require.New(t)
+
+	fname := path.Join(os.TempDir(), "secret-plain")
+	os.Remove(fname)
+	defer os.Remove(fname)
+
+	keys, err := NewKeyPair(nil)
+	r.NoError(err)
+	r.NoError(SaveKeyPair(keys, fname))
+
+	isEnc, err := IsEncryptedKeyPair(fname)
+	r.NoError(err)
+	r.False(isEnc)
+
+	// plain secrets don't need the current passphrase
+	_, err = RewrapKeyPair(fname, nil, []byte("new"))
+	r.NoError(err)
+
+	isEnc, err = IsEncryptedKeyPair(fname)
+	r.NoError(err)
+	r.True(isEnc)
+
+	loaded, err := LoadKeyPairWithPassphrase(fname, func(string) ([]byte, error) { return []byte("new"), nil })
+	r.NoError(err)
+	r.Equal(keys.Pair.Secret, loaded.Pair.Secret)
+}
+
+func TestParseMismatchedKeyPair(t *testing.T) {
+	r := require.New(t)
+
+	encode := func(kp *KeyPair) string {
+		var buf bytes.Buffer
+		r.NoError(EncodeKeyPairAsJSON(kp, &buf))
+		return buf.String()
+	}
+
+	one, err := NewKeyPair(nil)
+	r.NoError(err)
+	two, err := NewKeyPair(nil)
+	r.NoError(err)
+
+	_, err = ParseKeyPair(strings.NewReader(encode(one)))
+	r.NoError(err)
+
+	// the private key of another pair
+	mixed := *one
+	mixed.Pair.Secret = two.Pair.Secret
+	_, err = ParseKeyPair(strings.NewReader(encode(&mixed)))
+	r.Error(err)
+
+	// the id of another pair
+	mixed = *one
+	mixed.Id = two.Id
+	_, err = ParseKeyPair(strings.NewReader(encode(&mixed)))
+	r.Error(err)
+}
+
+func TestEncryptedKeyPairLimits(t *testing.T) {
+	r := require.New(t)
+
+	keys, err := NewKeyPair(nil)
+	r.NoError(err)
+	var buf bytes.Buffer
+	r.NoError(EncodeEncryptedKeyPairAsJSON(keys, []byte("pass"), &buf))
+
+	var sec ssbSecret
+	r.NoError(json.Unmarshal(buf.Bytes(), &sec))
+
+	pass := func(string) ([]byte, error) { return []byte("pass"), nil }
+	for _, params := range [][3]int{
+		{1 << 30, 8, 1},
+		{1 << 20, 1024, 1},
+		{1 << 15, 8, 1 << 20},
+		{0, 8, 1},
+	} {
+		changed := sec
+		enc := *sec.Encrypted
+		enc.N, enc.R, enc.P = params[0], params[1], params[2]
+		changed.Encrypted = &enc
+		data, err := json.Marshal(changed)
+		r.NoError(err)
+		_, err = ParseKeyPairWithPassphrase(bytes.NewReader(data), pass)
+		r.Error(err, "params %v", params)
+	}
+
+	loaded, err := ParseKeyPairWithPassphrase(bytes.NewReader(buf.Bytes()), pass)
+	r.NoError(err)
+	r.True(loaded.Id.Equal(keys.Id))
+}
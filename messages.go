@@ -112,6 +112,13 @@ func NewContactFollow(who *FeedRef) *Contact {
 	}
 }
 
+func NewContactUnfollow(who *FeedRef) *Contact {
+	return &Contact{
+		Type:    "contact",
+		Contact: who,
+	}
+}
+
 func NewContactBlock(who *FeedRef) *Contact {
 	return &Contact{
 		Type:     "contact",
@@ -250,6 +257,63 @@ func (a *About) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// FeedMigration moves a feed to a new key.
+// It needs to be published twice: by the old feed, to point at the new one, and by the new feed, to countersign it.
+// The migration only counts once both are known.
+type FeedMigration struct {
+	Type string   `json:"type"`
+	Old  *FeedRef `json:"old"`
+	New  *FeedRef `json:"new"`
+}
+
+func NewFeedMigration(old, new *FeedRef) *FeedMigration {
+	return &FeedMigration{
+		Type: "feed/migrate",
+		Old:  old,
+		New:  new,
+	}
+}
+
+func (fm *FeedMigration) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return ErrWrongType{want: "feed/migrate", has: "private.box?"}
+	}
+
+	var potential map[string]interface{}
+	err := json.Unmarshal(b, &potential)
+	if err != nil {
+		return errors.Wrap(err, "feed/migrate: map stage failed")
+	}
+
+	t, ok := potential["type"].(string)
+	if !ok {
+		return ErrMalfromedMsg{"feed/migrate: no type on message", nil}
+	}
+
+	if t != "feed/migrate" {
+		return ErrWrongType{want: "feed/migrate", has: t}
+	}
+
+	newFM := NewFeedMigration(nil, nil)
+	for field, ptr := range map[string]**FeedRef{"old": &newFM.Old, "new": &newFM.New} {
+		ref, ok := potential[field].(string)
+		if !ok {
+			return ErrMalfromedMsg{"feed/migrate: no string " + field + " field", potential}
+		}
+		*ptr, err = ParseFeedRef(ref)
+		if err != nil {
+			return errors.Wrapf(err, "feed/migrate: failed to parse %s field", field)
+		}
+	}
+
+	if newFM.Old.Equal(newFM.New) {
+		return ErrMalfromedMsg{"feed/migrate: can't migrate a feed to itself", potential}
+	}
+
+	*fm = *newFM
+	return nil
+}
+
 type Typed struct {
 	Value
 	Content struct {
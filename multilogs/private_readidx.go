@@ -6,11 +6,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"sync"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
 	gabbygrove "go.mindeco.de/ssb-gabbygrove"
@@ -34,39 +36,143 @@ func NewPrivateRead(log kitlog.Logger, kps ...*ssb.KeyPair) *Private {
 type Private struct {
 	logger kitlog.Logger
 
+	// mu is held while a message is indexed, so that AddKeyPair can catch up without racing the index
+	mu       sync.Mutex
 	keyPairs []*ssb.KeyPair
+	mlog     multilog.MultiLog // set by OpenRoaring and OpenBadger
+}
+
+// AddKeyPair makes the index unbox messages for kp, too.
+// The messages that are already in root are looked at again for kp, which holds up the index until that is done.
+func (pr *Private) AddKeyPair(ctx context.Context, root margaret.Log, kp *ssb.KeyPair) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.mlog != nil {
+		src, err := root.Query(margaret.SeqWrap(true))
+		if err != nil {
+			return errors.Wrap(err, "private/readidx: failed to query root log")
+		}
+		for {
+			v, err := src.Next(ctx)
+			if luigi.IsEOS(err) {
+				break
+			} else if err != nil {
+				return errors.Wrap(err, "private/readidx: failed to read root log")
+			}
+			sw, ok := v.(margaret.SeqWrapper)
+			if !ok {
+				return errors.Errorf("private/readidx: unexpected value %T from root log", v)
+			}
+			boxed, err := pr.boxedContent(sw.Value())
+			if err != nil {
+				return err
+			}
+			if boxed == nil {
+				continue
+			}
+			if _, err := private.Unbox(kp, boxed); err != nil {
+				continue
+			}
+			if err := appendPrivate(pr.mlog, kp, sw.Seq().Seq()); err != nil {
+				return err
+			}
+		}
+	}
+	pr.keyPairs = append(pr.keyPairs, kp)
+	return nil
 }
 
 // OpenRoaring uses roaring bitmaps with a slim key-value store backend
-func (pr Private) OpenRoaring(r repo.Interface) (multilog.MultiLog, librarian.SinkIndex, error) {
-	return repo.OpenMultiLog(r, IndexNamePrivates, pr.update)
+func (pr *Private) OpenRoaring(r repo.Interface) (multilog.MultiLog, librarian.SinkIndex, error) {
+	mlog, snk, err := repo.OpenMultiLog(r, IndexNamePrivates, pr.update)
+	if err != nil {
+		return nil, nil, err
+	}
+	pr.mu.Lock()
+	pr.mlog = mlog
+	pr.mu.Unlock()
+	return mlog, snk, nil
 }
 
 // OpenBadger uses a pretty memory hungry but battle-tested backend
-func (pr Private) OpenBadger(r repo.Interface) (multilog.MultiLog, librarian.SinkIndex, error) {
-	return repo.OpenBadgerMultiLog(r, IndexNamePrivates, pr.update)
+func (pr *Private) OpenBadger(r repo.Interface) (multilog.MultiLog, librarian.SinkIndex, error) {
+	mlog, snk, err := repo.OpenBadgerMultiLog(r, IndexNamePrivates, pr.update)
+	if err != nil {
+		return nil, nil, err
+	}
+	pr.mu.Lock()
+	pr.mlog = mlog
+	pr.mu.Unlock()
+	return mlog, snk, nil
 }
 
-func (pr Private) update(ctx context.Context, seq margaret.Seq, val interface{}, mlog multilog.MultiLog) error {
+func (pr *Private) update(ctx context.Context, seq margaret.Seq, val interface{}, mlog multilog.MultiLog) error {
+	boxedContent, err := pr.boxedContent(val)
+	if err != nil || boxedContent == nil {
+		return err
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	for _, kp := range pr.keyPairs {
+		if _, err := private.Unbox(kp, boxedContent); err != nil {
+			continue
+		}
+		if err := appendPrivate(mlog, kp, seq.Seq()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendPrivate adds seq to the private messages of kp, unless AddKeyPair already did.
+// The sublogs are in ascending order and have all the messages up to their last one, so only that one needs to be checked.
+func appendPrivate(mlog multilog.MultiLog, kp *ssb.KeyPair, seq int64) error {
+	userPrivs, err := mlog.Get(kp.Id.StoredAddr())
+	if err != nil {
+		return errors.Wrapf(err, "private/readidx: error opening priv sublog for %s", kp.Id.Ref())
+	}
+	v, err := userPrivs.Seq().Value()
+	if err != nil {
+		return errors.Wrapf(err, "private/readidx: error getting priv sublog seq for %s", kp.Id.Ref())
+	}
+	if cur, ok := v.(margaret.Seq); ok && cur.Seq() >= 0 {
+		last, err := userPrivs.Get(cur)
+		if err != nil {
+			return errors.Wrapf(err, "private/readidx: error getting last PM for %s", kp.Id.Ref())
+		}
+		if lastSeq, ok := last.(margaret.Seq); ok && lastSeq.Seq() >= seq {
+			return nil
+		}
+	}
+	_, err = userPrivs.Append(seq)
+	if err != nil {
+		return errors.Wrapf(err, "private/readidx: error appending PM for %s", kp.Id.Ref())
+	}
+	return nil
+}
+
+// boxedContent returns the boxed content of val or nil if it isn't a private message
+func (pr *Private) boxedContent(val interface{}) ([]byte, error) {
 	if nulled, ok := val.(error); ok {
 		if margaret.IsErrNulled(nulled) {
-			return nil
+			return nil, nil
 		}
-		return nulled
+		return nil, nulled
 	}
 
 	msg, ok := val.(ssb.Message)
 	if !ok {
 		err := errors.Errorf("private/readidx: error casting message. got type %T", val)
-		return err
+		return nil, err
 	}
 
-	var boxedContent []byte
 	switch msg.Author().Algo {
 	case ssb.RefAlgoFeedSSB1:
 		input := msg.ContentBytes()
 		if !(input[0] == '"' && input[len(input)-1] == '"') {
-			return nil // not a json string
+			return nil, nil // not a json string
 		}
 		b64data := bytes.TrimSuffix(input[1:], []byte(".box\""))
 		boxedData := make([]byte, len(b64data))
@@ -74,9 +180,9 @@ func (pr Private) update(ctx context.Context, seq margaret.Seq, val interface{},
 		if err != nil {
 			err = errors.Wrap(err, "private/readidx: invalid b64 encoding")
 			level.Debug(pr.logger).Log("msg", "unboxLog b64 decode failed", "err", err)
-			return nil
+			return nil, nil
 		}
-		boxedContent = boxedData[:n]
+		return boxedData[:n], nil
 
 	case ssb.RefAlgoFeedGabby:
 		mm, ok := val.(multimsg.MultiMessage)
@@ -84,42 +190,27 @@ func (pr Private) update(ctx context.Context, seq margaret.Seq, val interface{},
 			mmPtr, ok := val.(*multimsg.MultiMessage)
 			if !ok {
 				err := errors.Errorf("private/readidx: error casting message. got type %T", val)
-				return err
+				return nil, err
 			}
 			mm = *mmPtr
 		}
 		tr, ok := mm.AsGabby()
 		if !ok {
 			err := errors.Errorf("private/readidx: error getting gabby msg")
-			return err
+			return nil, err
 		}
 		evt, err := tr.UnmarshaledEvent()
 		if err != nil {
-			return errors.Wrap(err, "private/readidx: error unpacking event from stored message")
+			return nil, errors.Wrap(err, "private/readidx: error unpacking event from stored message")
 		}
 		if evt.Content.Type != gabbygrove.ContentTypeArbitrary {
-			return nil
+			return nil, nil
 		}
-		boxedContent = bytes.TrimPrefix(tr.Content, []byte("box1:"))
+		return bytes.TrimPrefix(tr.Content, []byte("box1:")), nil
 
 	default:
 		err := errors.Errorf("private/readidx: unknown feed type: %s", msg.Author().Algo)
 		level.Warn(pr.logger).Log("msg", "unahndled type", "err", err)
-		return err
+		return nil, err
 	}
-
-	for _, kp := range pr.keyPairs {
-		if _, err := private.Unbox(kp, boxedContent); err != nil {
-			continue
-		}
-		userPrivs, err := mlog.Get(kp.Id.StoredAddr())
-		if err != nil {
-			return errors.Wrapf(err, "private/readidx: error opening priv sublog for %s", kp.Id.Ref())
-		}
-		_, err = userPrivs.Append(seq.Seq())
-		if err != nil {
-			return errors.Wrapf(err, "private/readidx: error appending PM for %s", kp.Id.Ref())
-		}
-	}
-	return nil
 }
@@ -49,3 +49,38 @@ func UserFeedsUpdate(ctx context.Context, seq margaret.Seq, value interface{}, m
 	_, err = authorLog.Append(seq)
 	return errors.Wrap(err, "error appending new author message")
 }
+
+// FeedOffset returns how many messages from the start of a feed are not in its sublog of userFeeds.
+// The quota nulls the oldest messages of a feed and compacting the root log drops them, after that the sublog starts later in the feed.
+// The offset is read from the newest message that is still there, the quota never evicts the newest one.
+func FeedOffset(root margaret.Log, userLog margaret.Log) (int64, error) {
+	sv, err := userLog.Seq().Value()
+	if err != nil {
+		return 0, errors.Wrap(err, "feed offset: failed to get sequence of sublog")
+	}
+	latest, ok := sv.(margaret.Seq)
+	if !ok {
+		return 0, nil // empty sublog
+	}
+	for i := latest.Seq(); i >= 0; i-- {
+		rootSeq, err := userLog.Get(margaret.BaseSeq(i))
+		if err != nil {
+			return 0, errors.Wrapf(err, "feed offset: failed to get entry %d of sublog", i)
+		}
+		v, err := root.Get(rootSeq.(margaret.Seq))
+		if margaret.IsErrNulled(err) {
+			continue
+		} else if err != nil {
+			return 0, errors.Wrapf(err, "feed offset: failed to get message of entry %d", i)
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			return 0, errors.Errorf("feed offset: unexpected message type %T", v)
+		}
+		if off := msg.Seq() - 1 - i; off > 0 {
+			return off, nil
+		}
+		return 0, nil
+	}
+	return 0, nil
+}
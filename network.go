@@ -8,6 +8,7 @@ import (
 	"net"
 	"time"
 
+	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
 )
 
@@ -32,6 +33,11 @@ type Network interface {
 	io.Closer
 }
 
+// MultiserverAddresser is implemented by networks that can list the addresses they can be reached at (like net:host:port~shs:key)
+type MultiserverAddresser interface {
+	MultiserverAddresses() []string
+}
+
 // ConnTracker decides if connections should be established and keeps track of them
 type ConnTracker interface {
 	// Active returns true and since when a peer connection is active
@@ -51,3 +57,50 @@ type ConnTracker interface {
 	// CloseAll closes all tracked connections
 	CloseAll()
 }
+
+// ConnDecision records why a ConnTracker accepted or denied a connection
+type ConnDecision struct {
+	Time     time.Time
+	Peer     string
+	Addr     string
+	Accepted bool
+	Reason   string
+}
+
+// ConnDecider is implemented by ConnTrackers that keep a record of their recent decisions
+type ConnDecider interface {
+	Decisions() []ConnDecision
+}
+
+// Types of ConnEvents
+const (
+	ConnEventConnect          = "connect"
+	ConnEventDisconnect       = "disconnect"
+	ConnEventHandshakeFailure = "handshake-failure"
+	ConnEventAuthDenied       = "auth-denied"
+)
+
+// Directions of ConnEvents
+const (
+	ConnIncoming = "incoming"
+	ConnOutgoing = "outgoing"
+)
+
+// ConnEvent is a step in the lifecycle of a peer connection
+type ConnEvent struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Peer      string    `json:"peer,omitempty"` // unknown for incoming handshake failures
+	Addr      string    `json:"addr,omitempty"`
+	Direction string    `json:"direction"`
+
+	// Duration is the time the peer was connected in milliseconds (only for disconnect)
+	Duration int64 `json:"duration,omitempty"`
+
+	Reason string `json:"reason,omitempty"`
+}
+
+// ConnEventer is implemented by networks that stream ConnEvents to the registered sinks
+type ConnEventer interface {
+	ConnEvents() luigi.Broadcast
+}
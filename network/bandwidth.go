@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.cryptoscope.co/netwrap"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// BandwidthLimits are rates in bytes per second, counting both directions.
+// Zero means unlimited. Bursts default to one second worth of the rate.
+type BandwidthLimits struct {
+	// Global is shared by all connections
+	Global, GlobalBurst int64
+
+	// Peer applies to every peer (all connections to the same feed) on its own
+	Peer, PeerBurst int64
+
+	// Blobs is for sending blobs (blobs.get), so that they can't take all of Global
+	Blobs, BlobsBurst int64
+}
+
+// Bandwidth counts the bytes of all connections and enforces the limits.
+// PreSecureWrapper counts the bytes on the wire, PostSecureWrapper counts and limits per peer.
+type Bandwidth struct {
+	limits BandwidthLimits
+
+	wireRX, wireTX uint64 // atomic
+
+	global *TokenBucket
+	blobs  *TokenBucket
+
+	mu    sync.Mutex
+	peers map[string]*peerBandwidth
+}
+
+type peerBandwidth struct {
+	rx, tx uint64 // atomic
+	bucket *TokenBucket
+
+	conns int // open connections, guarded by Bandwidth.mu
+}
+
+// NewBandwidth returns a counter with the passed limits
+func NewBandwidth(l BandwidthLimits) *Bandwidth {
+	return &Bandwidth{
+		limits: l,
+		global: NewTokenBucket(l.Global, l.GlobalBurst),
+		blobs:  NewTokenBucket(l.Blobs, l.BlobsBurst),
+		peers:  make(map[string]*peerBandwidth),
+	}
+}
+
+// BlobLimiter returns the budget for sending blobs, or nil if it's unlimited
+func (bw *Bandwidth) BlobLimiter() *TokenBucket {
+	return bw.blobs
+}
+
+// PreSecureWrapper counts the bytes on the wire, including handshake and boxstream overhead
+func (bw *Bandwidth) PreSecureWrapper() netwrap.ConnWrapper {
+	return func(c net.Conn) (net.Conn, error) {
+		return newMeteredConn(c, &bw.wireRX, &bw.wireTX, nil, nil), nil
+	}
+}
+
+// PostSecureWrapper counts the muxrpc bytes of each peer and applies the global and per peer limits
+func (bw *Bandwidth) PostSecureWrapper() netwrap.ConnWrapper {
+	return func(c net.Conn) (net.Conn, error) {
+		ref, err := ssb.GetFeedRefFromAddr(c.RemoteAddr())
+		if err != nil {
+			return nil, err
+		}
+		pb := bw.openPeer(ref.Ref())
+		buckets := []*TokenBucket{bw.global, pb.bucket}
+		return newMeteredConn(c, &pb.rx, &pb.tx, buckets, func() { bw.closePeer(ref.Ref()) }), nil
+	}
+}
+
+// openPeer returns the counters of ref for one more connection
+func (bw *Bandwidth) openPeer(ref string) *peerBandwidth {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	pb, has := bw.peers[ref]
+	if !has {
+		pb = &peerBandwidth{bucket: NewTokenBucket(bw.limits.Peer, bw.limits.PeerBurst)}
+		bw.peers[ref] = pb
+	}
+	pb.conns++
+	return pb
+}
+
+// closePeer drops the counters of ref once its last connection is closed
+func (bw *Bandwidth) closePeer(ref string) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	pb, has := bw.peers[ref]
+	if !has {
+		return
+	}
+	pb.conns--
+	if pb.conns <= 0 {
+		delete(bw.peers, ref)
+	}
+}
+
+// Stats returns the counters since the bot started, peers with the most traffic first.
+// Peers are listed while they are connected, their counters start again when they reconnect.
+func (bw *Bandwidth) Stats() ssb.BandwidthStats {
+	stats := ssb.BandwidthStats{
+		RX: atomic.LoadUint64(&bw.wireRX),
+		TX: atomic.LoadUint64(&bw.wireTX),
+	}
+
+	bw.mu.Lock()
+	for ref, pb := range bw.peers {
+		stats.Peers = append(stats.Peers, ssb.PeerBandwidth{
+			Peer: ref,
+			RX:   atomic.LoadUint64(&pb.rx),
+			TX:   atomic.LoadUint64(&pb.tx),
+		})
+	}
+	bw.mu.Unlock()
+
+	sort.Slice(stats.Peers, func(i, j int) bool {
+		return stats.Peers[i].RX+stats.Peers[i].TX > stats.Peers[j].RX+stats.Peers[j].TX
+	})
+	return stats
+}
+
+type meteredConn struct {
+	net.Conn
+
+	rx, tx *uint64
+
+	buckets []*TokenBucket
+
+	// ctx is canceled on Close, so that waiting reads and writes return
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func newMeteredConn(c net.Conn, rx, tx *uint64, buckets []*TokenBucket, onClose func()) *meteredConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &meteredConn{
+		Conn:    c,
+		rx:      rx,
+		tx:      tx,
+		buckets: buckets,
+		ctx:     ctx,
+		cancel:  cancel,
+		onClose: onClose,
+	}
+}
+
+func (mc *meteredConn) Read(b []byte) (int, error) {
+	n, err := mc.Conn.Read(b)
+	if n > 0 {
+		atomic.AddUint64(mc.rx, uint64(n))
+		// we can't know the size before reading, so the next read waits instead
+		if werr := mc.wait(n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (mc *meteredConn) Write(b []byte) (int, error) {
+	if err := mc.wait(len(b)); err != nil {
+		return 0, err
+	}
+	n, err := mc.Conn.Write(b)
+	atomic.AddUint64(mc.tx, uint64(n))
+	return n, err
+}
+
+func (mc *meteredConn) Close() error {
+	mc.closeOnce.Do(func() {
+		mc.cancel()
+		if mc.onClose != nil {
+			mc.onClose()
+		}
+	})
+	return mc.Conn.Close()
+}
+
+func (mc *meteredConn) wait(n int) error {
+	for _, tb := range mc.buckets {
+		if err := tb.WaitN(mc.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TokenBucket limits a rate of bytes per second, allowing bursts.
+// A nil *TokenBucket doesn't limit anything.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns nil (unlimited) if rate is zero. If burst is zero, it's the same as rate.
+func NewTokenBucket(rate, burst int64) *TokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &TokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens and returns how long to wait until they are paid for.
+// Reservations beyond the burst put the bucket into debt, which later callers wait for.
+func (tb *TokenBucket) reserve(now time.Time, n int) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	tb.tokens -= float64(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// WaitN blocks until n bytes are allowed or the context is canceled
+func (tb *TokenBucket) WaitN(ctx context.Context, n int) error {
+	if tb == nil || n <= 0 {
+		return nil
+	}
+	d := tb.reserve(time.Now(), n)
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
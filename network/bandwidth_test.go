@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+)
+
+func TestTokenBucket(t *testing.T) {
+	r := require.New(t)
+
+	r.Nil(NewTokenBucket(0, 100), "zero rate should be unlimited")
+	var unlimited *TokenBucket
+	r.NoError(unlimited.WaitN(context.TODO(), 1<<30))
+
+	tb := NewTokenBucket(1000, 500)
+	now := tb.last
+
+	// the burst is free
+	r.Equal(time.Duration(0), tb.reserve(now, 500))
+	// then it's one millisecond per byte
+	r.Equal(100*time.Millisecond, tb.reserve(now, 100))
+	// debt adds up
+	r.Equal(200*time.Millisecond, tb.reserve(now, 100))
+
+	// after a while it's refilled, but never beyond the burst
+	later := now.Add(10 * time.Second)
+	r.Equal(time.Duration(0), tb.reserve(later, 500))
+	r.Equal(time.Millisecond, tb.reserve(later, 1))
+
+	// canceled waits return
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Error(tb.WaitN(ctx, 10000))
+}
+
+func TestBandwidthCounters(t *testing.T) {
+	r := require.New(t)
+
+	bw := NewBandwidth(BandwidthLimits{})
+
+	kp := makeRandPubkey(t)
+	a, b := net.Pipe()
+	shsA := shsAddrConn{Conn: a, remote: netwrap.WrapAddr(a.RemoteAddr(), secretstream.Addr{PubKey: kp.Id.PubKey()})}
+
+	wire, err := bw.PreSecureWrapper()(shsA)
+	r.NoError(err)
+	peer, err := bw.PostSecureWrapper()(wire)
+	r.NoError(err)
+
+	go func() {
+		buf := make([]byte, 10)
+		io.ReadFull(b, buf)
+		b.Write([]byte("pong"))
+	}()
+
+	_, err = peer.Write([]byte("ping-ping!"))
+	r.NoError(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(peer, buf)
+	r.NoError(err)
+
+	st := bw.Stats()
+	r.EqualValues(4, st.RX)
+	r.EqualValues(10, st.TX)
+	r.Len(st.Peers, 1)
+	r.Equal(kp.Id.Ref(), st.Peers[0].Peer)
+	r.EqualValues(4, st.Peers[0].RX)
+	r.EqualValues(10, st.Peers[0].TX)
+}
+
+func TestBandwidthPeerLimit(t *testing.T) {
+	r := require.New(t)
+
+	// 1000 bytes per second with a burst of 100
+	bw := NewBandwidth(BandwidthLimits{Peer: 1000, PeerBurst: 100})
+
+	kp := makeRandPubkey(t)
+	a, b := net.Pipe()
+	go io.Copy(ioutil.Discard, b)
+
+	conn, err := bw.PostSecureWrapper()(shsAddrConn{Conn: a, remote: netwrap.WrapAddr(a.RemoteAddr(), secretstream.Addr{PubKey: kp.Id.PubKey()})})
+	r.NoError(err)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err = conn.Write(make([]byte, 100))
+		r.NoError(err)
+	}
+	// the first 100 are free, the next 200 take 200ms
+	r.True(time.Since(start) >= 150*time.Millisecond, "too fast: %s", time.Since(start))
+}
+
+type shsAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c shsAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestBandwidthClose(t *testing.T) {
+	r := require.New(t)
+
+	// 10 bytes per second, the second write would wait for minutes
+	bw := NewBandwidth(BandwidthLimits{Peer: 10})
+
+	kp := makeRandPubkey(t)
+	a, b := net.Pipe()
+	go io.Copy(ioutil.Discard, b)
+	shsA := shsAddrConn{Conn: a, remote: netwrap.WrapAddr(a.RemoteAddr(), secretstream.Addr{PubKey: kp.Id.PubKey()})}
+
+	conn, err := bw.PostSecureWrapper()(shsA)
+	r.NoError(err)
+	second, err := bw.PostSecureWrapper()(shsA)
+	r.NoError(err)
+	r.Len(bw.Stats().Peers, 1, "connections of the same peer share the counters")
+
+	_, err = conn.Write(make([]byte, 10))
+	r.NoError(err)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 1000))
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	r.NoError(conn.Close())
+	select {
+	case err := <-errc:
+		r.Error(err)
+	case <-time.After(time.Second):
+		t.Fatal("close didn't stop the waiting write")
+	}
+
+	// the peer is dropped with its last connection
+	r.Len(bw.Stats().Peers, 1)
+	r.NoError(conn.Close())
+	r.Len(bw.Stats().Peers, 1, "closing twice counts once")
+	second.Close()
+	r.Len(bw.Stats().Peers, 0)
+}
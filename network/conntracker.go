@@ -49,6 +49,20 @@ func (ict instrumentedConnTracker) OnAccept(ctx context.Context, conn net.Conn)
 	return ok, ctx
 }
 
+func (ict instrumentedConnTracker) watch(c net.Conn) net.Conn {
+	if w, ok := ict.root.(connWatcher); ok {
+		return w.watch(c)
+	}
+	return c
+}
+
+func (ict instrumentedConnTracker) Decisions() []ssb.ConnDecision {
+	if d, ok := ict.root.(ssb.ConnDecider); ok {
+		return d.Decisions()
+	}
+	return nil
+}
+
 func (ict instrumentedConnTracker) OnClose(conn net.Conn) time.Duration {
 	durr := ict.root.OnClose(conn)
 	if durr > 0 {
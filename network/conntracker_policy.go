@@ -0,0 +1,322 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.cryptoscope.co/netwrap"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// ConnPolicy configures the admission rules of NewPolicyTracker.
+// Zero values mean no limit.
+type ConnPolicy struct {
+	// MaxConns is the maximum number of connections in total
+	MaxConns uint
+
+	// MaxPerIP is the maximum number of connections from the same IP address (tcp connections only)
+	MaxPerIP uint
+
+	// Wanted reports if a peer is one we want to replicate.
+	// If a wanted peer connects while we are full, the unwanted peer that was idle the longest is dropped to make room for it.
+	// Without it, no peers are evicted.
+	Wanted func(*ssb.FeedRef) bool
+
+	// KeepDecisions is the number of recent decisions that are kept for Decisions(). Defaults to 50.
+	KeepDecisions int
+
+	Logger kitlog.Logger
+}
+
+// reasons for the decisions of the policy tracker
+const (
+	ReasonAccepted    = "accepted"
+	ReasonSameFeed    = "feed already connected"
+	ReasonMaxConns    = "too many connections"
+	ReasonMaxPerIP    = "too many connections from this address"
+	ReasonEvicted     = "evicted for a wanted peer"
+	ReasonEvictedFull = "accepted by evicting an unwanted peer"
+)
+
+// NewPolicyTracker returns a ConnTracker that only allows one session per feed and enforces the limits of the policy.
+// Every decision is logged with a reason and the recent ones are available through Decisions().
+func NewPolicyTracker(p ConnPolicy) ssb.ConnTracker {
+	if p.KeepDecisions == 0 {
+		p.KeepDecisions = 50
+	}
+	if p.Logger == nil {
+		p.Logger = kitlog.NewNopLogger()
+	}
+	return &policyTracker{
+		policy: p,
+		active: make(map[[32]byte]*policyEntry),
+	}
+}
+
+type policyEntry struct {
+	connEntry
+
+	feed *ssb.FeedRef
+	ip   string
+
+	// set if the connection was passed through watch
+	activity *activityConn
+
+	// evicted entries don't count against the limits but stay until OnClose
+	evicted bool
+}
+
+type policyTracker struct {
+	policy ConnPolicy
+
+	mu     sync.Mutex
+	active map[[32]byte]*policyEntry
+
+	decisions []ssb.ConnDecision
+}
+
+var _ ssb.ConnDecider = (*policyTracker)(nil)
+
+func (pt *policyTracker) CloseAll() {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	for _, e := range pt.active {
+		e.c.Close()
+		e.cancel()
+	}
+	// the entries are removed by OnClose
+}
+
+// Count returns the number of connections that are not evicted, evicted ones are only waiting for OnClose
+func (pt *policyTracker) Count() uint {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	return pt.countLocked()
+}
+
+// watch makes the tracker notice when data goes over the connection, to find the idle ones
+func (pt *policyTracker) watch(c net.Conn) net.Conn {
+	return newActivityConn(c)
+}
+
+func (pt *policyTracker) Active(a net.Addr) (bool, time.Duration) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	e, ok := pt.active[toActive(a)]
+	if !ok || e.evicted {
+		return false, 0
+	}
+	return true, time.Since(e.started)
+}
+
+func (pt *policyTracker) OnAccept(ctx context.Context, conn net.Conn) (bool, context.Context) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	remote := conn.RemoteAddr()
+	k := toActive(remote)
+	feed, err := ssb.GetFeedRefFromAddr(remote)
+	if err != nil {
+		pt.decideLocked(nil, remote, false, err.Error())
+		return false, nil
+	}
+	ip := ipOf(remote)
+
+	if _, has := pt.active[k]; has {
+		pt.decideLocked(feed, remote, false, ReasonSameFeed)
+		return false, nil
+	}
+
+	if max := pt.policy.MaxPerIP; max > 0 && ip != "" {
+		var sameIP uint
+		for _, e := range pt.active {
+			if !e.evicted && e.ip == ip {
+				sameIP++
+			}
+		}
+		if sameIP >= max {
+			pt.decideLocked(feed, remote, false, ReasonMaxPerIP)
+			return false, nil
+		}
+	}
+
+	reason := ReasonAccepted
+	if max := pt.policy.MaxConns; max > 0 && pt.countLocked() >= max {
+		victim := pt.evictionCandidateLocked(feed)
+		if victim == nil {
+			pt.decideLocked(feed, remote, false, ReasonMaxConns)
+			return false, nil
+		}
+
+		victim.evicted = true
+		victim.c.Close()
+		victim.cancel()
+		pt.decideLocked(victim.feed, victim.c.RemoteAddr(), false, ReasonEvicted)
+		reason = ReasonEvictedFull
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	activity, _ := conn.(*activityConn)
+	pt.active[k] = &policyEntry{
+		connEntry: connEntry{
+			c:       conn,
+			started: time.Now(),
+			done:    make(chan struct{}),
+			cancel:  cancel,
+		},
+		feed:     feed,
+		ip:       ip,
+		activity: activity,
+	}
+	pt.decideLocked(feed, remote, true, reason)
+	return true, ctx
+}
+
+// countLocked returns the number of connections that are not evicted
+func (pt *policyTracker) countLocked() uint {
+	var n uint
+	for _, e := range pt.active {
+		if !e.evicted {
+			n++
+		}
+	}
+	return n
+}
+
+// evictionCandidateLocked returns the unwanted peer that was idle the longest, if the new peer is wanted.
+// Of peers that were idle equally long, the one that is connected the longest goes.
+func (pt *policyTracker) evictionCandidateLocked(newPeer *ssb.FeedRef) *policyEntry {
+	wanted := pt.policy.Wanted
+	if wanted == nil || !wanted(newPeer) {
+		return nil
+	}
+
+	var (
+		victim     *policyEntry
+		victimIdle time.Time
+	)
+	for _, e := range pt.active {
+		if e.evicted || wanted(e.feed) {
+			continue
+		}
+		idle := e.lastActive()
+		if victim == nil || idle.Before(victimIdle) || (idle.Equal(victimIdle) && e.started.Before(victim.started)) {
+			victim, victimIdle = e, idle
+		}
+	}
+	return victim
+}
+
+// lastActive returns when data last went over the connection, or when it started if it isn't watched
+func (e *policyEntry) lastActive() time.Time {
+	if e.activity == nil {
+		return e.started
+	}
+	return e.activity.lastActive()
+}
+
+// connWatcher is implemented by trackers that want to see the traffic of a connection.
+// The returned connection has to be used in place of the passed one, including the calls to OnAccept and OnClose.
+type connWatcher interface {
+	watch(net.Conn) net.Conn
+}
+
+// activityConn remembers when data last went over a connection
+type activityConn struct {
+	last int64 // unix nanoseconds, first for the alignment of the atomic access
+
+	net.Conn
+}
+
+func newActivityConn(c net.Conn) *activityConn {
+	ac := &activityConn{Conn: c}
+	ac.touch()
+	return ac
+}
+
+func (ac *activityConn) touch() {
+	atomic.StoreInt64(&ac.last, time.Now().UnixNano())
+}
+
+func (ac *activityConn) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&ac.last))
+}
+
+func (ac *activityConn) Read(b []byte) (int, error) {
+	n, err := ac.Conn.Read(b)
+	if n > 0 {
+		ac.touch()
+	}
+	return n, err
+}
+
+func (ac *activityConn) Write(b []byte) (int, error) {
+	n, err := ac.Conn.Write(b)
+	if n > 0 {
+		ac.touch()
+	}
+	return n, err
+}
+
+func (pt *policyTracker) OnClose(conn net.Conn) time.Duration {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	k := toActive(conn.RemoteAddr())
+	e, ok := pt.active[k]
+	if !ok || e.c != conn {
+		// never accepted
+		return 0
+	}
+	close(e.done)
+	delete(pt.active, k)
+	return time.Since(e.started)
+}
+
+// Decisions returns the recent decisions, the latest last
+func (pt *policyTracker) Decisions() []ssb.ConnDecision {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	ds := make([]ssb.ConnDecision, len(pt.decisions))
+	copy(ds, pt.decisions)
+	return ds
+}
+
+func (pt *policyTracker) decideLocked(feed *ssb.FeedRef, addr net.Addr, accepted bool, reason string) {
+	d := ssb.ConnDecision{
+		Time:     time.Now(),
+		Addr:     addr.String(),
+		Accepted: accepted,
+		Reason:   reason,
+	}
+	if feed != nil {
+		d.Peer = feed.Ref()
+	}
+
+	lvl := level.Info
+	if accepted {
+		lvl = level.Debug
+	}
+	lvl(pt.policy.Logger).Log("event", "conn decision", "peer", d.Peer, "addr", d.Addr, "accepted", accepted, "reason", reason, "count", pt.countLocked())
+
+	pt.decisions = append(pt.decisions, d)
+	if n := len(pt.decisions) - pt.policy.KeepDecisions; n > 0 {
+		pt.decisions = pt.decisions[n:]
+	}
+}
+
+func ipOf(a net.Addr) string {
+	tcpAddr, ok := netwrap.GetAddr(a, "tcp").(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	return tcpAddr.IP.String()
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb"
+)
+
+type policyTestConn struct {
+	net.Conn // nil, only RemoteAddr, Write and Close are used
+
+	remote net.Addr
+	closed bool
+}
+
+func (c *policyTestConn) RemoteAddr() net.Addr        { return c.remote }
+func (c *policyTestConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c *policyTestConn) Close() error                { c.closed = true; return nil }
+
+func newPolicyTestConn(t *testing.T, ip string) (*policyTestConn, *ssb.FeedRef) {
+	kp := makeRandPubkey(t)
+	tcpAddr := &net.TCPAddr{IP: net.ParseIP(ip), Port: 8008}
+	return &policyTestConn{
+		remote: netwrap.WrapAddr(tcpAddr, secretstream.Addr{PubKey: kp.Id.PubKey()}),
+	}, kp.Id
+}
+
+func TestPolicyTracker(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	wanted := make(map[string]bool)
+	ct := NewPolicyTracker(ConnPolicy{
+		MaxConns: 3,
+		MaxPerIP: 2,
+		Wanted: func(ref *ssb.FeedRef) bool {
+			return wanted[ref.Ref()]
+		},
+	})
+
+	// one session per feed
+	a, _ := newPolicyTestConn(t, "10.0.0.1")
+	ok, _ := ct.OnAccept(ctx, a)
+	r.True(ok)
+	ok, _ = ct.OnAccept(ctx, &policyTestConn{remote: a.remote})
+	r.False(ok, "same feed twice")
+
+	// per ip
+	b, _ := newPolicyTestConn(t, "10.0.0.1")
+	ok, _ = ct.OnAccept(ctx, b)
+	r.True(ok)
+	c, _ := newPolicyTestConn(t, "10.0.0.1")
+	ok, _ = ct.OnAccept(ctx, c)
+	r.False(ok, "third from the same ip")
+
+	// total
+	d, _ := newPolicyTestConn(t, "10.0.0.2")
+	ok, _ = ct.OnAccept(ctx, d)
+	r.True(ok)
+	r.EqualValues(3, ct.Count())
+
+	e, _ := newPolicyTestConn(t, "10.0.0.3")
+	ok, _ = ct.OnAccept(ctx, e)
+	r.False(ok, "full and not wanted")
+
+	// wanted peers evict the unwanted one that is connected the longest (a)
+	f, fRef := newPolicyTestConn(t, "10.0.0.4")
+	wanted[fRef.Ref()] = true
+	ok, fCtx := ct.OnAccept(ctx, f)
+	r.True(ok, "wanted peer should evict")
+	r.NotNil(fCtx)
+	r.True(a.closed, "a should be evicted")
+	r.False(b.closed)
+	r.False(d.closed)
+
+	active, _ := ct.Active(a.remote)
+	r.False(active)
+	r.EqualValues(3, ct.Count(), "evicted connections don't count")
+
+	// the evicted connection cleans up
+	r.NotZero(ct.OnClose(a))
+	r.EqualValues(3, ct.Count())
+
+	dec, ok := ct.(ssb.ConnDecider)
+	r.True(ok)
+	var reasons []string
+	for _, d := range dec.Decisions() {
+		reasons = append(reasons, d.Reason)
+	}
+	r.Equal([]string{
+		ReasonAccepted,
+		ReasonSameFeed,
+		ReasonAccepted,
+		ReasonMaxPerIP,
+		ReasonAccepted,
+		ReasonMaxConns,
+		ReasonEvicted,
+		ReasonEvictedFull,
+	}, reasons)
+
+	ct.CloseAll()
+	r.True(b.closed)
+	r.True(d.closed)
+	r.True(f.closed)
+}
+
+func TestPolicyTrackerEvictsIdle(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	wanted := make(map[string]bool)
+	ct := NewPolicyTracker(ConnPolicy{
+		MaxConns: 2,
+		Wanted: func(ref *ssb.FeedRef) bool {
+			return wanted[ref.Ref()]
+		},
+	})
+	w, ok := ct.(connWatcher)
+	r.True(ok, "policy tracker should watch connections")
+
+	a, _ := newPolicyTestConn(t, "10.0.0.1")
+	aConn := w.watch(a)
+	ok, _ = ct.OnAccept(ctx, aConn)
+	r.True(ok)
+
+	b, _ := newPolicyTestConn(t, "10.0.0.2")
+	bConn := w.watch(b)
+	ok, _ = ct.OnAccept(ctx, bConn)
+	r.True(ok)
+
+	// a is connected longer but b was idle longer
+	time.Sleep(10 * time.Millisecond)
+	_, err := aConn.Write([]byte("hello"))
+	r.NoError(err)
+
+	c, cRef := newPolicyTestConn(t, "10.0.0.3")
+	wanted[cRef.Ref()] = true
+	ok, _ = ct.OnAccept(ctx, w.watch(c))
+	r.True(ok, "wanted peer should evict")
+	r.True(b.closed, "the idle peer should be evicted")
+	r.False(a.closed)
+	r.EqualValues(2, ct.Count())
+
+	r.NotZero(ct.OnClose(bConn))
+	ct.CloseAll()
+}
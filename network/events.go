@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream/secrethandshake"
+
+	"go.cryptoscope.co/ssb"
+)
+
+var _ ssb.ConnEventer = (*node)(nil)
+
+// ConnEvents streams connects, disconnects, handshake failures and denied peers
+func (n *node) ConnEvents() luigi.Broadcast {
+	return n.connEvents
+}
+
+func (n *node) emit(evt ssb.ConnEvent) {
+	evt.Time = time.Now()
+	n.connEvents.send(evt)
+}
+
+// eventBufferSize is how many events a listener can fall behind before it misses some
+const eventBufferSize = 64
+
+// eventFanout sends the events to every registered sink through a buffer of its own.
+// A slow listener misses events instead of holding up the connections or the other listeners.
+type eventFanout struct {
+	log log.Logger
+
+	mu   sync.Mutex
+	subs map[*eventSub]struct{}
+}
+
+type eventSub struct {
+	ch      chan ssb.ConnEvent
+	done    chan struct{}
+	dropped int
+}
+
+func newEventFanout(log log.Logger) *eventFanout {
+	return &eventFanout{
+		log:  log,
+		subs: make(map[*eventSub]struct{}),
+	}
+}
+
+var _ luigi.Broadcast = (*eventFanout)(nil)
+
+// Register starts sending the events to snk until the returned function is called or Pour fails
+func (ef *eventFanout) Register(snk luigi.Sink) func() {
+	sub := &eventSub{
+		ch:   make(chan ssb.ConnEvent, eventBufferSize),
+		done: make(chan struct{}),
+	}
+	ef.mu.Lock()
+	ef.subs[sub] = struct{}{}
+	ef.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			ef.mu.Lock()
+			delete(ef.subs, sub)
+			ef.mu.Unlock()
+			close(sub.done)
+		})
+	}
+
+	go func() {
+		for {
+			select {
+			case <-sub.done:
+				return
+			case evt := <-sub.ch:
+				if err := snk.Pour(context.TODO(), evt); err != nil {
+					level.Debug(ef.log).Log("event", "conn event", "type", evt.Type, "err", err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+func (ef *eventFanout) send(evt ssb.ConnEvent) {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	for sub := range ef.subs {
+		select {
+		case sub.ch <- evt:
+		default:
+			sub.dropped++
+			level.Debug(ef.log).Log("event", "conn event dropped", "type", evt.Type, "dropped", sub.dropped)
+		}
+	}
+}
+
+// acceptError keeps the remote address of an incoming connection whose handshake failed,
+// since the listener only returns the error.
+type acceptError struct {
+	err    error
+	remote net.Addr
+}
+
+func (ae acceptError) Error() string { return ae.err.Error() }
+func (ae acceptError) Cause() error  { return ae.err }
+
+// keepRemote wraps the errors of cw with the remote address of the connection
+func keepRemote(cw netwrap.ConnWrapper) netwrap.ConnWrapper {
+	return func(c net.Conn) (net.Conn, error) {
+		wrapped, err := cw(c)
+		if err != nil {
+			return nil, acceptError{err: err, remote: c.RemoteAddr()}
+		}
+		return wrapped, nil
+	}
+}
+
+// remoteOf returns the remote address of the connection that err is about, if it is (or wraps) an acceptError
+func remoteOf(err error) net.Addr {
+	for err != nil {
+		if ae, ok := err.(acceptError); ok {
+			return ae.remote
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return nil
+		}
+		err = c.Cause()
+	}
+	return nil
+}
+
+// emitHandshakeFailure emits an event if err is caused by secret-handshake (and not something like a refused connection)
+func (n *node) emitHandshakeFailure(err error, dir string, peer, addr string) {
+	switch cause := errors.Cause(err).(type) {
+	case secrethandshake.ErrProcessing, secrethandshake.ErrProtocol:
+	default:
+		if cause != io.EOF {
+			return
+		}
+	}
+	n.emit(ssb.ConnEvent{
+		Type:      ssb.ConnEventHandshakeFailure,
+		Peer:      peer,
+		Addr:      addr,
+		Direction: dir,
+		Reason:    err.Error(),
+	})
+}
+
+// denyReason looks up why the tracker denied conn, if it keeps its decisions
+func (n *node) denyReason(conn net.Conn) string {
+	if cd, ok := n.connTracker.(ssb.ConnDecider); ok {
+		peer, addr := peerOf(conn.RemoteAddr()), conn.RemoteAddr().String()
+		decisions := cd.Decisions()
+		for i := len(decisions) - 1; i >= 0; i-- {
+			d := decisions[i]
+			if !d.Accepted && d.Peer == peer && d.Addr == addr {
+				return d.Reason
+			}
+		}
+	}
+	return "denied by connection tracker"
+}
+
+func peerOf(a net.Addr) string {
+	ref, err := ssb.GetFeedRefFromAddr(a)
+	if err != nil {
+		return ""
+	}
+	return ref.Ref()
+}
+
+// addrOf returns the transport part of a (shs wrapped) address
+func addrOf(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	for _, nw := range []string{"tcp", "ws", "onion", "tunnel"} {
+		if ta := netwrap.GetAddr(a, nw); ta != nil {
+			return ta.String()
+		}
+	}
+	return a.String()
+}
+
+func durationMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func pubKeyRef(pubKey []byte) string {
+	ref := ssb.FeedRef{ID: pubKey, Algo: ssb.RefAlgoFeedSSB1}
+	return ref.Ref()
+}
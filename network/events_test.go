@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func collectEvents(n ssb.Network) (<-chan ssb.ConnEvent, func()) {
+	ch := make(chan ssb.ConnEvent, 16)
+	done := n.(ssb.ConnEventer).ConnEvents().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return nil
+		}
+		ch <- v.(ssb.ConnEvent)
+		return nil
+	}))
+	return ch, done
+}
+
+func nextEvent(t *testing.T, ch <-chan ssb.ConnEvent) ssb.ConnEvent {
+	select {
+	case evt := <-ch:
+		return evt
+	case <-time.After(5 * time.Second):
+		t.Fatal("event timeout")
+		return ssb.ConnEvent{}
+	}
+}
+
+func TestConnEventsLifecycle(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, srvConns := newTestNode(ctx, t, Options{})
+	defer srv.Close()
+	cli, cliConns := newTestNode(ctx, t, Options{})
+	defer cli.Close()
+
+	srvEvts, done := collectEvents(srv)
+	defer done()
+	cliEvts, done := collectEvents(cli)
+	defer done()
+
+	r.NoError(cli.Connect(ctx, srv.GetListenAddr()))
+	cliRef := waitForConn(t, srvConns)
+	srvRef := waitForConn(t, cliConns)
+
+	evt := nextEvent(t, srvEvts)
+	r.Equal(ssb.ConnEventConnect, evt.Type)
+	r.Equal(ssb.ConnIncoming, evt.Direction)
+	r.Equal(cliRef.Ref(), evt.Peer)
+	r.NotEmpty(evt.Addr)
+
+	evt = nextEvent(t, cliEvts)
+	r.Equal(ssb.ConnEventConnect, evt.Type)
+	r.Equal(ssb.ConnOutgoing, evt.Direction)
+	r.Equal(srvRef.Ref(), evt.Peer)
+
+	cli.GetConnTracker().CloseAll()
+
+	evt = nextEvent(t, cliEvts)
+	r.Equal(ssb.ConnEventDisconnect, evt.Type)
+	r.Equal(srvRef.Ref(), evt.Peer)
+
+	evt = nextEvent(t, srvEvts)
+	r.Equal(ssb.ConnEventDisconnect, evt.Type)
+	r.Equal(cliRef.Ref(), evt.Peer)
+}
+
+func TestConnEventsDenied(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _ := newTestNode(ctx, t, Options{
+		MakeHandler: func(conn net.Conn) (muxrpc.Handler, error) {
+			return nil, fmt.Errorf("not authorized")
+		},
+	})
+	defer srv.Close()
+	cli, _ := newTestNode(ctx, t, Options{})
+	defer cli.Close()
+
+	srvEvts, done := collectEvents(srv)
+	defer done()
+
+	r.NoError(cli.Connect(ctx, srv.GetListenAddr()))
+
+	evt := nextEvent(t, srvEvts)
+	r.Equal(ssb.ConnEventAuthDenied, evt.Type)
+	r.Equal(ssb.ConnIncoming, evt.Direction)
+	r.Equal("not authorized", evt.Reason)
+}
+
+func TestConnEventsHandshakeFailure(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _ := newTestNode(ctx, t, Options{})
+	defer srv.Close()
+
+	srvEvts, done := collectEvents(srv)
+	defer done()
+
+	tcpAddr := netwrap.GetAddr(srv.GetListenAddr(), "tcp")
+	r.NotNil(tcpAddr)
+	conn, err := net.Dial("tcp", tcpAddr.String())
+	r.NoError(err)
+	localAddr := conn.LocalAddr().String()
+	r.NoError(conn.Close())
+
+	evt := nextEvent(t, srvEvts)
+	r.Equal(ssb.ConnEventHandshakeFailure, evt.Type)
+	r.Equal(ssb.ConnIncoming, evt.Direction)
+	r.Equal(localAddr, evt.Addr)
+}
+
+func TestEventFanoutSlowListener(t *testing.T) {
+	r := require.New(t)
+
+	ef := newEventFanout(log.NewNopLogger())
+
+	block := make(chan struct{})
+	defer close(block)
+	doneSlow := ef.Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		<-block
+		return nil
+	}))
+	defer doneSlow()
+
+	got := make(chan ssb.ConnEvent, 2*eventBufferSize)
+	doneFast := ef.Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		got <- v.(ssb.ConnEvent)
+		return nil
+	}))
+	defer doneFast()
+
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*eventBufferSize; i++ {
+			ef.send(ssb.ConnEvent{Type: ssb.ConnEventConnect, Peer: fmt.Sprint(i)})
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow listener held up the events")
+	}
+
+	// at least a buffer full arrives in order
+	for i := 0; i < eventBufferSize; i++ {
+		r.Equal(fmt.Sprint(i), nextEvent(t, got).Peer)
+	}
+}
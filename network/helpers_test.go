@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+var testAppKey = make([]byte, 32)
+
+// newTestNode starts serving a node with a fresh keypair on localhost.
+// Unless opts has a MakeHandler, the returned channel gets the remote of every connection that reaches it.
+func newTestNode(ctx context.Context, t *testing.T, opts Options) (ssb.Network, <-chan *ssb.FeedRef) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	connected := make(chan *ssb.FeedRef, 4)
+	opts.Logger = log.NewNopLogger()
+	opts.ListenAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	opts.KeyPair = kp
+	opts.AppKey = testAppKey
+	if opts.MakeHandler == nil {
+		opts.MakeHandler = func(conn net.Conn) (muxrpc.Handler, error) {
+			remote, err := ssb.GetFeedRefFromAddr(conn.RemoteAddr())
+			if err != nil {
+				return nil, err
+			}
+			connected <- remote
+			return &muxrpc.HandlerMux{}, nil
+		}
+	}
+
+	n, err := New(opts)
+	r.NoError(err)
+	go n.Serve(ctx)
+	return n, connected
+}
+
+func waitForConn(t *testing.T, ch <-chan *ssb.FeedRef) *ssb.FeedRef {
+	select {
+	case ref := <-ch:
+		return ref
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection timeout")
+		return nil
+	}
+}
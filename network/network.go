@@ -43,133 +43,112 @@ func newIPFromNetworkAddress(arg net.Addr) (net.IP, error) {
 	}
 }
 
-// associatedIPAddresses returns addresses which listen on that of the
-// argument.
-func associatedIPAddresses(arg net.Addr) ([]net.Addr, error) {
-	ipAddr, err := newIPFromNetworkAddress(arg)
+func isNetworkAddressSiteLocal(addr net.Addr) (bool, error) {
+	ipAddr, err := newIPFromNetworkAddress(addr)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	if ipAddr != nil && !ipAddr.IsUnspecified() {
-		return []net.Addr{arg}, nil
+
+	var found bool
+	for _, block := range privateIPBlocks {
+		if block.Contains(ipAddr) {
+			found = true
+			break
+		}
 	}
+	return found, nil
+}
 
-	// iterate over all interfaces to get link-local / broadcast addresses
-	var ret []net.Addr
+// lanInterface is a network interface that is up and its site-local addresses
+type lanInterface struct {
+	iface net.Interface
+	addrs []*net.IPNet
+}
+
+// lanInterfaces lists the interfaces with site-local addresses.
+// If only is a specific IP, just the interface with that address is returned (and just that address).
+// Loopback interfaces are only considered in that case.
+func lanInterfaces(only net.IP) ([]lanInterface, error) {
 	netIfs, err := net.Interfaces()
 	if err != nil {
 		return nil, err
 	}
+
+	specific := only != nil && !only.IsUnspecified()
+
+	var ret []lanInterface
 	for _, netIf := range netIfs {
-		if netIf.Flags&net.FlagLoopback != 0 {
+		if netIf.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if !specific && netIf.Flags&net.FlagLoopback != 0 {
 			continue
 		}
 
 		addrs, err := netIf.Addrs()
 		if err != nil {
-			return nil, err
+			// the interface might have just disappeared
+			continue
 		}
+
+		li := lanInterface{iface: netIf}
 		for _, addr := range addrs {
 			ipNet, ok := addr.(*net.IPNet)
 			if !ok {
-				// log.Print("DBG: ignoring non-net", ipNet.String())
 				continue
 			}
-			ipAddr := &net.IPAddr{
-				IP: ipNet.IP,
+			if specific && !ipNet.IP.Equal(only) {
+				continue
 			}
-			if !isIPv4(ipAddr.IP) {
-				// assume IPv6
-				ipAddr.Zone = netIf.Name
+			if siteLocal, _ := isNetworkAddressSiteLocal(ipNet); !siteLocal {
+				continue
 			}
-			ret = append(ret, ipAddr)
-		}
-	}
-
-	return ret, nil
-}
-
-func isNetworkAddressSiteLocal(addr net.Addr) (bool, error) {
-	ipAddr, err := newIPFromNetworkAddress(addr)
-	if err != nil {
-		return false, err
-	}
-
-	var found bool
-	for _, block := range privateIPBlocks {
-		if block.Contains(ipAddr) {
-			found = true
-			break
+			li.addrs = append(li.addrs, ipNet)
 		}
-	}
-	return found, nil
-}
-
-func findSiteLocalNetworkAddresses(arg net.Addr) ([]net.Addr, error) {
-	var ret []net.Addr
-
-	associated, err := associatedIPAddresses(arg)
-	if err != nil {
-		associated = []net.Addr{arg}
-	}
-	for _, addr := range associated {
-		isSiteLocal, err := isNetworkAddressSiteLocal(addr)
-		if err != nil {
-			return nil, err
-		}
-		if isSiteLocal {
-			ret = append(ret, addr)
+		if len(li.addrs) > 0 {
+			ret = append(ret, li)
 		}
 	}
-
 	return ret, nil
 }
 
-func findNetworkInterfaceByIP(arg net.Addr) (net.Interface, error) {
-	ifcs, err := net.Interfaces()
-	if err != nil {
-		return net.Interface{}, err
+// reachableIPs returns ip or, if it is unspecified, the global unicast addresses of the interfaces.
+// For the IPv4 unspecified address only IPv4 addresses are returned.
+func reachableIPs(ip net.IP) []net.IP {
+	if !ip.IsUnspecified() {
+		return []net.IP{ip}
 	}
-
-	argIP, err := newIPFromNetworkAddress(arg)
+	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		return net.Interface{}, err
+		return nil
 	}
-
-	for _, ifc := range ifcs {
-		addrs, err := ifc.Addrs()
-		if err != nil {
-			return net.Interface{}, err
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
 		}
-		for _, addr := range addrs {
-			ifcIP, err := newIPFromNetworkAddress(addr)
-			if err != nil {
-				continue
-			}
-			if ifcIP.Equal(argIP) {
-				return ifc, nil
-			}
+		if isIPv4(ip) && !isIPv4(ipNet.IP) {
+			continue
 		}
+		ips = append(ips, ipNet.IP)
 	}
-	return net.Interface{}, fmt.Errorf("interface for %s not found", arg)
+	return ips
 }
 
-func localBroadcastAddress(arg net.Addr) (string, error) {
-	ip, err := newIPFromNetworkAddress(arg)
-	if err != nil {
-		return "", err
+// directedBroadcast returns the broadcast address of an IPv4 network (like 192.168.1.255 for 192.168.1.0/24)
+func directedBroadcast(n *net.IPNet) net.IP {
+	ip := n.IP.To4()
+	mask := n.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
 	}
-
-	if isIPv4(ip) {
-		return net.IPv4bcast.String(), nil
-	} else {
-		if ip.IsLoopback() {
-			return net.IPv6loopback.String(), nil
-		}
-		ifc, err := findNetworkInterfaceByIP(arg)
-		if err != nil {
-			return "", err
-		}
-		return net.IPv6linklocalallnodes.String() + "%" + ifc.Name, nil
+	if ip == nil || len(mask) != net.IPv4len {
+		return net.IPv4bcast
+	}
+	bcast := make(net.IP, net.IPv4len)
+	for i := range ip {
+		bcast[i] = ip[i] | ^mask[i]
 	}
+	return bcast
 }
@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/libp2p/go-reuseport"
@@ -20,8 +21,11 @@ import (
 type Advertiser struct {
 	keyPair *ssb.KeyPair
 
-	local  *net.UDPAddr // Local listening address, may not be needed (auto-detect?).
-	remote *net.UDPAddr // Address being broadcasted to, this should be deduced form 'local'.
+	// local is the listening address. If its IP is unspecified, all LAN interfaces are used.
+	local *net.UDPAddr
+
+	// wsPort is set by the node if it also listens for websockets
+	wsPort int
 
 	waitTime time.Duration
 	ticker   *time.Ticker
@@ -46,6 +50,12 @@ func newAdvertisement(local *net.UDPAddr, keyPair *ssb.KeyPair) (string, error)
 	return msg, err
 }
 
+// newWebsocketAdvertisement returns the ws:// part that is appended to the net: address, separated by a semicolon
+func newWebsocketAdvertisement(ip net.IP, port int, keyPair *ssb.KeyPair) string {
+	host := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	return fmt.Sprintf("ws://%s~shs:%s", host, newPublicKeyString(keyPair))
+}
+
 func NewAdvertiser(local net.Addr, keyPair *ssb.KeyPair) (*Advertiser, error) {
 
 	var udpAddr *net.UDPAddr
@@ -54,9 +64,7 @@ func NewAdvertiser(local net.Addr, keyPair *ssb.KeyPair) (*Advertiser, error) {
 		udpAddr = new(net.UDPAddr)
 		udpAddr.IP = nv.IP
 		udpAddr.Port = nv.Port
-		if !isIPv4(nv.IP) {
-			udpAddr.Zone = nv.Zone
-		}
+		udpAddr.Zone = nv.Zone
 	case *net.UDPAddr:
 		udpAddr = nv
 	default:
@@ -64,93 +72,95 @@ func NewAdvertiser(local net.Addr, keyPair *ssb.KeyPair) (*Advertiser, error) {
 	}
 	log.Printf("adverstiser using local address %s", udpAddr)
 
-	remote, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", net.IPv4bcast, DefaultPort))
-	if err != nil {
-		return nil, errors.Wrap(err, "ssb/NewAdvertiser: failed to resolve v4 broadcast addr")
-	}
-
 	return &Advertiser{
 		local:    udpAddr,
-		remote:   remote,
 		waitTime: time.Second * 45,
 		keyPair:  keyPair,
 	}, nil
 }
 
+// advertisement returns the multiserver string that is sent from src.
+// It lists every address of the interface, starting with src since older receivers only look at the first one.
+func (b *Advertiser) advertisement(src *net.UDPAddr, li lanInterface) (string, error) {
+	first, err := newAdvertisement(&net.UDPAddr{IP: src.IP, Port: b.local.Port}, b.keyPair)
+	if err != nil {
+		return "", err
+	}
+	parts := []string{first}
+	for _, a := range li.addrs {
+		if a.IP.Equal(src.IP) {
+			continue
+		}
+		part, err := newAdvertisement(&net.UDPAddr{IP: a.IP, Port: b.local.Port}, b.keyPair)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+	}
+	if b.wsPort != 0 {
+		parts = append(parts, newWebsocketAdvertisement(src.IP, b.wsPort, b.keyPair))
+	}
+	return strings.Join(parts, ";"), nil
+}
+
+// advertise sends one packet per IPv4 address (to the directed broadcast address of its network)
+// and one IPv6 packet per interface (to the link-local all-nodes group).
+// The interfaces are enumerated every time, so that ones that come and go are picked up.
 func (b *Advertiser) advertise() error {
-	localAddresses, err := findSiteLocalNetworkAddresses(b.local)
+	ifaces, err := lanInterfaces(b.local.IP)
 	if err != nil {
 		return errors.Wrap(err, "ssb: failed to make new advertisment")
 	}
 
-	for _, localAddress := range localAddresses {
-		// log.Print("DBG23: using", localAddress)
-		var localUDP = new(net.UDPAddr)
-		// carry port from address or use default one
-		switch v := localAddress.(type) {
-		case *net.IPAddr:
-			localUDP.IP = v.IP
-			if !isIPv4(v.IP) {
-				localUDP.Zone = v.Zone
+	for _, li := range ifaces {
+		var sentV6 bool
+		for _, a := range li.addrs {
+			var src, dst net.UDPAddr
+			src.IP = a.IP
+			src.Port = b.local.Port
+			dst.Port = DefaultPort
+
+			if isIPv4(a.IP) {
+				if li.iface.Flags&(net.FlagBroadcast|net.FlagLoopback) == 0 {
+					continue
+				}
+				dst.IP = directedBroadcast(a)
+			} else {
+				if sentV6 || li.iface.Flags&(net.FlagMulticast|net.FlagLoopback) == 0 {
+					continue
+				}
+				src.Zone = li.iface.Name
+				if a.IP.IsLoopback() {
+					dst.IP = net.IPv6loopback
+				} else {
+					dst.IP = net.IPv6linklocalallnodes
+					dst.Zone = li.iface.Name
+				}
+				sentV6 = true
 			}
-			localUDP.Port = DefaultPort
-		case *net.IPNet:
-			localUDP.IP = v.IP
-			localUDP.Port = DefaultPort
-		case *net.TCPAddr:
-			localUDP.IP = v.IP
-			localUDP.Port = v.Port
-		case *net.UDPAddr:
-			localUDP.IP = v.IP
-			localUDP.Port = v.Port
-		default:
-			return errors.Errorf("cannot get Port for network type %s", localAddress.Network())
-		}
 
-		broadcastAddress, err := localBroadcastAddress(localAddress)
-		if err != nil {
-			return errors.Wrap(err, "ssb: failed to find site local address broadcast address")
-		}
-		dstStr := net.JoinHostPort(broadcastAddress, strconv.Itoa(DefaultPort))
-		remoteUDP, err := net.ResolveUDPAddr("udp", dstStr)
-		if err != nil {
-			return errors.Wrapf(err, "ssb: failed to resolve broadcast dest addr for advertiser: %s", dstStr)
-		}
+			msg, err := b.advertisement(&src, li)
+			if err != nil {
+				return err
+			}
 
-		msg, err := newAdvertisement(
-			localUDP,
-			b.keyPair,
-		)
-		if err != nil {
-			return err
-		}
-		broadcastConn, err := reuseport.Dial("udp", localUDP.String(), remoteUDP.String())
-		if err != nil {
-			// err = errors.Wrap(err, "adv dial failed")
-			// log.Println("debug,cont:", err)
-			continue
-		}
-		_, err = fmt.Fprint(broadcastConn, msg)
-		if err != nil {
-			// err = errors.Wrapf(err, "adv send of msg failed")
-			// log.Println("debug,cont:", err)
-			continue
-		}
-		_ = broadcastConn.Close()
-		if err != nil {
-			// err = errors.Wrapf(err, "close of con failed")
-			// log.Println("debug,cont:", err)
-			continue
+			broadcastConn, err := reuseport.Dial("udp", src.String(), dst.String())
+			if err != nil {
+				// interfaces can disappear between listing and sending
+				continue
+			}
+			_, err = fmt.Fprint(broadcastConn, msg)
+			_ = broadcastConn.Close()
+			if err != nil {
+				continue
+			}
 		}
-		// log.Println("adv pkt sent:", msg)
 	}
 	return nil
 }
 
 func (b *Advertiser) Start() {
 	b.ticker = time.NewTicker(b.waitTime)
-	// TODO: notice interface changes
-	// net.IPv6linklocalallnodes
 
 	go func() {
 		for range b.ticker.C {
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,27 +39,28 @@ func NewDiscoverer(local *ssb.KeyPair) (*Discoverer, error) {
 
 func (d *Discoverer) start() error {
 
-	var err error
-	d.rx4, err = makePktConn("udp4")
-	if err != nil {
-		return err
+	// one of them might not be available (like IPv6 being disabled) but we need at least one
+	var err4, err6 error
+	d.rx4, err4 = makePktConn("udp4")
+	if err4 == nil {
+		go d.work(d.rx4)
 	}
 
-	d.rx6, err = makePktConn("udp6")
-	if err != nil {
-		return err
+	d.rx6, err6 = makePktConn("udp6")
+	if err6 == nil {
+		go d.work(d.rx6)
 	}
 
-	go d.work(d.rx4)
-	go d.work(d.rx6)
-
+	if err4 != nil && err6 != nil {
+		return errors.Wrapf(err4, "ssb: discovery failed to listen (udp6: %s)", err6)
+	}
 	return nil
 }
 
 func makePktConn(n string) (net.PacketConn, error) {
 	lis, err := reuseport.ListenPacket(n, fmt.Sprintf(":%d", DefaultPort))
 	if err != nil {
-		return nil, errors.Wrap(err, "ssb: adv start failed to listen on v4 broadcast")
+		return nil, errors.Wrapf(err, "ssb: adv start failed to listen on %s", n)
 	}
 	switch v := lis.(type) {
 	case *net.UDPConn:
@@ -71,7 +74,7 @@ func (d *Discoverer) work(rx net.PacketConn) {
 
 	for {
 		rx.SetReadDeadline(time.Now().Add(time.Second * 1))
-		buf := make([]byte, 128)
+		buf := make([]byte, 1024)
 		n, addr, err := rx.ReadFrom(buf)
 		if err != nil {
 			if !os.IsTimeout(err) {
@@ -83,29 +86,18 @@ func (d *Discoverer) work(rx net.PacketConn) {
 
 		buf = buf[:n] // strip of zero bytes
 		// log.Printf("dbg adv raw: %q", string(buf))
-		na, err := multiserver.ParseNetAddress(buf)
-		if err != nil {
-			// log.Println("rx adv err", err.Error())
-			// TODO: _could_ try to get key out if just ws://[::]~shs:... and dial pkt origin
-			continue
-		}
 
-		if na.Ref.Equal(d.local.Id) {
+		ref, tcpAddr, ok := matchAdvertisement(buf, addr.(*net.UDPAddr))
+		if !ok {
+			// not from the source it claims or no net: address we understand
 			continue
 		}
 
-		ua := addr.(*net.UDPAddr)
-
-		// skip advertisments not from source
-		if !ua.IP.Equal(na.Addr.IP) {
+		if ref.Equal(d.local.Id) {
 			continue
 		}
 
-		na.Addr.Zone = ua.Zone
-
-		// fmt.Printf("[localadv debug] %s (claimed:%s) %s\n", addr.String(), na.Addr.String(), na.Ref.Ref())
-
-		wrappedAddr := netwrap.WrapAddr(&na.Addr, secretstream.Addr{PubKey: na.Ref.PubKey()})
+		wrappedAddr := netwrap.WrapAddr(tcpAddr, secretstream.Addr{PubKey: ref.PubKey()})
 
 		d.brLock.Lock()
 		for _, ch := range d.brodcasts {
@@ -115,6 +107,57 @@ func (d *Discoverer) work(rx net.PacketConn) {
 	}
 }
 
+// matchAdvertisement picks the net: address from a (; separated) multiserver advertisement that matches the IP the packet came from.
+// The zone of the sender is carried over, to be able to dial IPv6 link-local addresses.
+func matchAdvertisement(msg []byte, src *net.UDPAddr) (*ssb.FeedRef, *net.TCPAddr, bool) {
+	for _, part := range strings.Split(string(msg), ";") {
+		ref, tcpAddr, err := parseNetAdvertisement(part)
+		if err != nil {
+			// ws:// and other transports
+			continue
+		}
+		if !tcpAddr.IP.Equal(src.IP) {
+			continue
+		}
+		tcpAddr.Zone = src.Zone
+		return ref, tcpAddr, true
+	}
+	return nil, nil, false
+}
+
+// parseNetAdvertisement is like multiserver.ParseNetAddress but also takes IPv6 addresses without brackets (net:fe80::1:8008~shs:...), like the javascript implementation sends them.
+func parseNetAdvertisement(input string) (*ssb.FeedRef, *net.TCPAddr, error) {
+	if na, err := multiserver.ParseNetAddress([]byte(input)); err == nil {
+		addr := na.Addr
+		return na.Ref, &addr, nil
+	}
+
+	parts := strings.Split(input, "~")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "net:") || !strings.HasPrefix(parts[1], "shs:") {
+		return nil, nil, errors.Errorf("not a net address: %q", input)
+	}
+
+	hostPort := strings.TrimPrefix(parts[0], "net:")
+	idx := strings.LastIndex(hostPort, ":")
+	if idx < 0 {
+		return nil, nil, errors.Errorf("missing port: %q", input)
+	}
+	ip := net.ParseIP(strings.Trim(hostPort[:idx], "[]"))
+	if ip == nil {
+		return nil, nil, errors.Errorf("invalid ip: %q", input)
+	}
+	port, err := strconv.ParseUint(hostPort[idx+1:], 10, 16)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid port: %q", input)
+	}
+
+	ref, err := ssb.ParseFeedRef("@" + strings.TrimPrefix(parts[1], "shs:") + ".ed25519")
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid key: %q", input)
+	}
+	return ref, &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
 func (d *Discoverer) Stop() {
 	d.brLock.Lock()
 	for i, ch := range d.brodcasts {
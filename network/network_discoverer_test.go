@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchAdvertisement(t *testing.T) {
+	kp := makeTestPubKey(t)
+	key := newPublicKeyString(kp)
+
+	type tcase struct {
+		msg  string
+		src  *net.UDPAddr
+		ok   bool
+		addr string
+	}
+
+	v4 := "net:192.168.1.5:8008~shs:" + key
+	v6 := "net:[fe80::1]:8008~shs:" + key
+	v6js := "net:fe80::1:8008~shs:" + key
+	ws := "ws://192.168.1.5:8989~shs:" + key
+
+	tcases := []tcase{
+		{msg: v4, src: &net.UDPAddr{IP: net.ParseIP("192.168.1.5")}, ok: true, addr: "192.168.1.5:8008"},
+		{msg: v4, src: &net.UDPAddr{IP: net.ParseIP("192.168.1.6")}, ok: false},
+		{msg: v6, src: &net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "eth0"}, ok: true, addr: "[fe80::1%eth0]:8008"},
+		{msg: v6js, src: &net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "eth0"}, ok: true, addr: "[fe80::1%eth0]:8008"},
+
+		// multiple addresses, the one that matches the sender is picked
+		{msg: v4 + ";" + v6, src: &net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "wlan0"}, ok: true, addr: "[fe80::1%wlan0]:8008"},
+		{msg: ws + ";" + v4, src: &net.UDPAddr{IP: net.ParseIP("192.168.1.5")}, ok: true, addr: "192.168.1.5:8008"},
+		{msg: ws, src: &net.UDPAddr{IP: net.ParseIP("192.168.1.5")}, ok: false},
+
+		{msg: "garbage", src: &net.UDPAddr{IP: net.ParseIP("192.168.1.5")}, ok: false},
+	}
+
+	for i, tc := range tcases {
+		ref, addr, ok := matchAdvertisement([]byte(tc.msg), tc.src)
+		require.Equal(t, tc.ok, ok, "case %d", i)
+		if !tc.ok {
+			continue
+		}
+		require.True(t, ref.Equal(kp.Id), "case %d: wrong key", i)
+		require.Equal(t, tc.addr, addr.String(), "case %d", i)
+	}
+}
+
+func TestDirectedBroadcast(t *testing.T) {
+	for _, tc := range []struct{ cidr, bcast string }{
+		{"192.168.1.5/24", "192.168.1.255"},
+		{"10.1.2.3/8", "10.255.255.255"},
+		{"172.16.5.4/20", "172.16.15.255"},
+	} {
+		ip, ipNet, err := net.ParseCIDR(tc.cidr)
+		require.NoError(t, err)
+		ipNet.IP = ip
+		require.Equal(t, tc.bcast, directedBroadcast(ipNet).String(), tc.cidr)
+	}
+}
+
+func TestAdvertisementListsInterfaceAddresses(t *testing.T) {
+	r := require.New(t)
+	kp := makeTestPubKey(t)
+	key := newPublicKeyString(kp)
+
+	adv, err := NewAdvertiser(&net.TCPAddr{Port: 8008}, kp)
+	r.NoError(err)
+	adv.wsPort = 8989
+
+	li := lanInterface{
+		iface: net.Interface{Name: "eth0"},
+		addrs: []*net.IPNet{
+			{IP: net.ParseIP("192.168.1.5"), Mask: net.CIDRMask(24, 32)},
+			{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+		},
+	}
+
+	// the sending address comes first
+	msg, err := adv.advertisement(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Zone: "eth0"}, li)
+	r.NoError(err)
+	r.Equal("net:[fe80::1]:8008~shs:"+key+";net:192.168.1.5:8008~shs:"+key+";ws://[fe80::1]:8989~shs:"+key, msg)
+
+	msg, err = adv.advertisement(&net.UDPAddr{IP: net.ParseIP("192.168.1.5")}, li)
+	r.NoError(err)
+	r.Equal("net:192.168.1.5:8008~shs:"+key+";net:[fe80::1]:8008~shs:"+key+";ws://192.168.1.5:8989~shs:"+key, msg)
+}
@@ -5,6 +5,7 @@ package network
 import (
 	"context"
 	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
@@ -22,6 +23,8 @@ import (
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/internal/neterr"
+	"go.cryptoscope.co/ssb/internal/socks5"
+	"go.cryptoscope.co/ssb/internal/websock"
 )
 
 // DefaultPort is the default listening port for ScuttleButt.
@@ -33,6 +36,18 @@ type Options struct {
 	Dialer     netwrap.Dialer
 	ListenAddr net.Addr
 
+	// WebsocketAddr opens a second listener that accepts secret-handshake and muxrpc over websockets, if set
+	WebsocketAddr net.Addr
+
+	// SOCKS5Addr is the address of a SOCKS5 proxy (like tor on localhost:9050) that is used to dial onion: addresses
+	SOCKS5Addr string
+	// SOCKS5All routes all outgoing connections through the proxy, not just the onion ones
+	SOCKS5All bool
+
+	// OnionAddr is announced as an additional address of this node.
+	// The onion service itself has to be configured (in torrc) to forward to ListenAddr.
+	OnionAddr *OnionAddr
+
 	AdvertsSend      bool
 	AdvertsConnectTo bool
 
@@ -63,7 +78,10 @@ type node struct {
 	lisClose sync.Once
 
 	dialer        netwrap.Dialer
+	socks         *socks5.Dialer
 	l             net.Listener
+	wsl           net.Listener
+	wsAddr        net.Addr
 	localDiscovRx *Discoverer
 	localDiscovTx *Advertiser
 	secretServer  *secretstream.Server
@@ -75,6 +93,8 @@ type node struct {
 
 	listening chan struct{}
 
+	connEvents *eventFanout
+
 	remotesLock sync.Mutex
 	remotes     map[string]muxrpc.Endpoint
 
@@ -103,6 +123,12 @@ func New(opts Options) (ssb.Network, error) {
 		n.dialer = netwrap.Dial
 	}
 
+	if opts.SOCKS5Addr != "" {
+		n.socks = &socks5.Dialer{ProxyAddr: opts.SOCKS5Addr}
+	} else if opts.SOCKS5All {
+		return nil, errors.New("SOCKS5All needs a SOCKS5Addr")
+	}
+
 	n.secretClient, err = secretstream.NewClient(opts.KeyPair.Pair, opts.AppKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating secretstream.Client")
@@ -144,6 +170,7 @@ func New(opts Options) (ssb.Network, error) {
 		n.connTracker = NewInstrumentedConnTracker(n.connTracker, n.sysGauge, n.latency)
 	}
 	n.log = opts.Logger
+	n.connEvents = newEventFanout(n.log)
 
 	return n, nil
 }
@@ -223,7 +250,9 @@ func (n *node) removeRemote(edp muxrpc.Endpoint) {
 	delete(n.remotes, r.Ref())
 }
 
-func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...muxrpc.HandlerWrapper) {
+// handleConnection serves muxrpc on an established (shs) connection until it is closed.
+// dir is either ssb.ConnIncoming or ssb.ConnOutgoing and only used for the events.
+func (n *node) handleConnection(ctx context.Context, origConn net.Conn, dir string, hws ...muxrpc.HandlerWrapper) {
 	// TODO: overhaul events and logging levels
 	conn, err := n.applyConnWrappers(origConn)
 	if err != nil {
@@ -232,18 +261,45 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		return
 	}
 
+	if w, ok := n.connTracker.(connWatcher); ok {
+		conn = w.watch(conn)
+	}
+
+	evt := ssb.ConnEvent{
+		Peer:      peerOf(conn.RemoteAddr()),
+		Addr:      addrOf(conn.RemoteAddr()),
+		Direction: dir,
+	}
+
 	ok, ctx := n.connTracker.OnAccept(ctx, conn)
 	if !ok {
+		evt.Type = ssb.ConnEventAuthDenied
+		evt.Reason = n.denyReason(conn)
+		n.emit(evt)
+
 		err := conn.Close()
 		// err := origConn.Close()
 		n.log.Log("conn", "ignored", "remote", conn.RemoteAddr(), "err", err)
 		return
 	}
 
+	var (
+		connected bool
+		serveErr  error
+	)
 	defer func() {
-		n.connTracker.OnClose(conn)
+		dur := n.connTracker.OnClose(conn)
 		conn.Close()
 		origConn.Close()
+
+		if connected {
+			evt.Type = ssb.ConnEventDisconnect
+			evt.Duration = durationMillis(dur)
+			if serveErr != nil {
+				evt.Reason = serveErr.Error()
+			}
+			n.emit(evt)
+		}
 	}()
 
 	if n.evtCtr != nil {
@@ -252,6 +308,10 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 
 	h, err := n.opts.MakeHandler(conn)
 	if err != nil {
+		evt.Type = ssb.ConnEventAuthDenied
+		evt.Reason = err.Error()
+		n.emit(evt)
+
 		if _, ok := errors.Cause(err).(*ssb.ErrOutOfReach); ok {
 			return // ignore silently
 		}
@@ -272,6 +332,10 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 	}
 	n.addRemote(edp)
 
+	connected = true
+	evt.Type = ssb.ConnEventConnect
+	n.emit(evt)
+
 	defer edp.Terminate()
 	srv := edp.(muxrpc.Server)
 
@@ -280,6 +344,7 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		causeErr := errors.Cause(err)
 		if !neterr.IsConnBrokenErr(causeErr) && causeErr != context.Canceled {
 			level.Debug(n.log).Log("conn", "serve", "err", err)
+			serveErr = err
 		}
 	}
 	n.removeRemote(edp)
@@ -290,7 +355,13 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) error {
 	evtLog := log.With(n.log, "event", "network.Serve")
 	// TODO: make multiple listeners (localhost:8008 should not restrict or kill connections)
-	lisWrap := netwrap.NewListenerWrapper(n.secretServer.Addr(), append(n.opts.BefreCryptoWrappers, n.secretServer.ConnWrapper())...)
+	// the failed handshakes keep the remote address for the events
+	var connWrappers []netwrap.ConnWrapper
+	for _, cw := range n.opts.BefreCryptoWrappers {
+		connWrappers = append(connWrappers, keepRemote(cw))
+	}
+	connWrappers = append(connWrappers, keepRemote(n.secretServer.ConnWrapper()))
+	lisWrap := netwrap.NewListenerWrapper(n.secretServer.Addr(), connWrappers...)
 	var err error
 
 	n.l, err = netwrap.Listen(n.opts.ListenAddr, lisWrap)
@@ -298,13 +369,36 @@ func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 
 		return errors.Wrap(err, "error creating listener")
 	}
+
+	if n.opts.WebsocketAddr != nil {
+		wsl, err := websock.Listen(n.opts.WebsocketAddr)
+		if err != nil {
+			n.l.Close()
+			return errors.Wrap(err, "error creating websocket listener")
+		}
+		n.wsl, err = lisWrap(wsl)
+		if err != nil {
+			wsl.Close()
+			n.l.Close()
+			return errors.Wrap(err, "error wrapping websocket listener")
+		}
+		wsTCP, ok := wsl.Addr().(*net.TCPAddr)
+		if !ok {
+			n.wsl.Close()
+			n.l.Close()
+			return errors.Errorf("websocket listener: unexpected address type %T", wsl.Addr())
+		}
+		n.wsAddr = netwrap.WrapAddr(WebsocketAddr{Addr: wsTCP}, n.secretServer.Addr())
+		if n.localDiscovTx != nil {
+			n.localDiscovTx.wsPort = wsTCP.Port
+		}
+	}
+
 	n.lisClose = sync.Once{} // reset once
 	close(n.listening)
 
 	defer func() {
-		n.lisClose.Do(func() {
-			n.l.Close()
-		})
+		n.lisClose.Do(n.closeListeners)
 		n.listening = make(chan struct{})
 	}()
 
@@ -341,35 +435,22 @@ func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 		}()
 	}
 
-	// accept in a goroutine so that we can react to context cancel and close the listener
+	// accept in goroutines so that we can react to context cancel and close the listeners
 	newConn := make(chan net.Conn)
-	go func() {
-		defer close(newConn)
-		for {
-			conn, err := n.l.Accept()
-			if err != nil {
-				if strings.Contains(err.Error(), "use of closed network connection") {
-					// yikes way of handling this
-					// but means this needs to be restarted anyway
-					return
-				}
-
-				switch cause := errors.Cause(err).(type) {
-				case secrethandshake.ErrProcessing:
-					// ignore
-				case secrethandshake.ErrProtocol:
-					// ignore
-				default:
-					if cause != io.EOF { // handshake ended early
-						level.Warn(evtLog).Log("msg", "failed to accept connection", "err", err,
-							"cause", cause, "causeT", fmt.Sprintf("%T", cause))
-					}
-				}
-				continue
-			}
-
-			newConn <- conn
+	var accepting sync.WaitGroup
+	for _, l := range []net.Listener{n.l, n.wsl} {
+		if l == nil {
+			continue
 		}
+		accepting.Add(1)
+		go func(l net.Listener) {
+			defer accepting.Done()
+			n.acceptLoop(l, newConn, evtLog)
+		}(l)
+	}
+	go func() {
+		accepting.Wait()
+		close(newConn)
 	}()
 
 	defer level.Debug(n.log).Log("event", "network listen loop exited")
@@ -381,8 +462,46 @@ func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 			if conn == nil {
 				return nil
 			}
-			go n.handleConnection(ctx, conn, wrappers...)
+			go n.handleConnection(ctx, conn, ssb.ConnIncoming, wrappers...)
+		}
+	}
+}
+
+// acceptLoop feeds the connections of l into newConn until the listener is closed
+func (n *node) acceptLoop(l net.Listener, newConn chan<- net.Conn, evtLog log.Logger) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				// yikes way of handling this
+				// but means this needs to be restarted anyway
+				return
+			}
+
+			switch cause := errors.Cause(err).(type) {
+			case secrethandshake.ErrProcessing:
+				// ignore
+			case secrethandshake.ErrProtocol:
+				// ignore
+			default:
+				if cause != io.EOF { // handshake ended early
+					level.Warn(evtLog).Log("msg", "failed to accept connection", "err", err,
+						"cause", cause, "causeT", fmt.Sprintf("%T", cause))
+				}
+			}
+			// the peer is only known once the handshake succeeded
+			n.emitHandshakeFailure(err, ssb.ConnIncoming, "", addrOf(remoteOf(err)))
+			continue
 		}
+
+		newConn <- conn
+	}
+}
+
+func (n *node) closeListeners() {
+	n.l.Close()
+	if n.wsl != nil {
+		n.wsl.Close()
 	}
 }
 
@@ -404,21 +523,80 @@ func (n *node) Connect(ctx context.Context, addr net.Addr) error {
 		return errors.New("node/connect: expected shs-bs address to be of type secretstream.Addr")
 	}
 
-	conn, err := n.dialer(netwrap.GetAddr(addr, "tcp"), append(n.beforeCryptoConnWrappers,
-		n.secretClient.ConnWrapper(pubKey))...)
+	if ta, ok := netwrap.GetAddr(addr, "tunnel").(TunnelAddr); ok {
+		return n.connectTunnel(ctx, ta, pubKey)
+	}
+
+	var (
+		dialAddr = netwrap.GetAddr(addr, "tcp")
+		wrappers []netwrap.ConnWrapper
+	)
+	wrappers = append(wrappers, n.beforeCryptoConnWrappers...)
+	if wa, ok := netwrap.GetAddr(addr, "ws").(WebsocketAddr); ok {
+		dialAddr = wa.Addr
+		wrappers = append(wrappers, websock.ClientWrapper(wa.URL()))
+	}
+	wrappers = append(wrappers, n.secretClient.ConnWrapper(pubKey))
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if oa, ok := netwrap.GetAddr(addr, "onion").(OnionAddr); ok {
+		if n.socks == nil {
+			return errors.New("node/connect: can't dial onion address without a SOCKS5 proxy")
+		}
+		conn, err = n.dialSOCKS(ctx, oa.HostPort(), oa, wrappers...)
+	} else if n.socks != nil && n.opts.SOCKS5All {
+		if dialAddr == nil {
+			return errors.New("node/connect: expected an address containing a tcp addr")
+		}
+		conn, err = n.dialSOCKS(ctx, dialAddr.String(), dialAddr, wrappers...)
+	} else {
+		conn, err = n.dialer(dialAddr, wrappers...)
+	}
 	if err != nil {
 		if conn != nil {
 			conn.Close()
 		}
+		n.emitHandshakeFailure(err, ssb.ConnOutgoing, pubKeyRef(pubKey), addrOf(addr))
 		return errors.Wrap(err, "node/connect: error dialing")
 	}
 
 	go func(c net.Conn) {
-		n.handleConnection(ctx, c)
+		n.handleConnection(ctx, c, ssb.ConnOutgoing)
 	}(conn)
 	return nil
 }
 
+// dialSOCKS connects to hostport through the proxy and applies the wrappers like netwrap.Dial does.
+// The remote address of the returned connection is remote, not the one of the proxy.
+func (n *node) dialSOCKS(ctx context.Context, hostport string, remote net.Addr, wrappers ...netwrap.ConnWrapper) (net.Conn, error) {
+	pc, err := n.socks.DialContext(ctx, "tcp", hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn = proxiedConn{Conn: pc, remote: remote}
+	for i, cw := range wrappers {
+		wrapped, err := cw(conn)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "error applying connection wrapper #%d", i)
+		}
+		conn = wrapped
+	}
+	return conn, nil
+}
+
+type proxiedConn struct {
+	net.Conn
+
+	remote net.Addr
+}
+
+func (pc proxiedConn) RemoteAddr() net.Addr { return pc.remote }
+
 // GetListenAddr waits for Serve() to be called!
 func (n *node) GetListenAddr() net.Addr {
 	_, ok := <-n.listening
@@ -429,6 +607,47 @@ func (n *node) GetListenAddr() net.Addr {
 	return nil
 }
 
+// GetWebsocketAddr returns the (shs wrapped) address of the websocket listener or nil if there is none.
+// Like GetListenAddr, it waits for Serve() to be called.
+func (n *node) GetWebsocketAddr() net.Addr {
+	_, ok := <-n.listening
+	if !ok {
+		return n.wsAddr
+	}
+	return nil
+}
+
+// MultiserverAddresses returns the addresses this node can be reached at, in multiserver notation.
+// The listeners are only known once Serve() was called, before that only the onion address is returned.
+// Listeners on an unspecified IP are listed with the addresses of the interfaces, or not at all if there are none.
+func (n *node) MultiserverAddresses() []string {
+	key := base64.StdEncoding.EncodeToString(n.opts.KeyPair.Pair.Public[:])
+
+	var addrs []string
+	select {
+	case <-n.listening: // closed once Serve() set up the listeners
+		if tcpAddr, ok := netwrap.GetAddr(n.l.Addr(), "tcp").(*net.TCPAddr); ok {
+			for _, ip := range reachableIPs(tcpAddr.IP) {
+				addrs = append(addrs, fmt.Sprintf("net:%s~shs:%s", &net.TCPAddr{IP: ip, Port: tcpAddr.Port}, key))
+			}
+		}
+		if n.wsAddr != nil {
+			if wa, ok := netwrap.GetAddr(n.wsAddr, "ws").(WebsocketAddr); ok {
+				for _, ip := range reachableIPs(wa.Addr.IP) {
+					reachable := WebsocketAddr{Addr: &net.TCPAddr{IP: ip, Port: wa.Addr.Port}, Path: wa.Path}
+					addrs = append(addrs, fmt.Sprintf("%s~shs:%s", reachable, key))
+				}
+			}
+		}
+	default:
+	}
+
+	if n.opts.OnionAddr != nil {
+		addrs = append(addrs, fmt.Sprintf("%s~shs:%s", n.opts.OnionAddr, key))
+	}
+	return addrs
+}
+
 func (n *node) applyConnWrappers(conn net.Conn) (net.Conn, error) {
 	for i, cw := range n.afterSecureConnWrappers {
 		var err error
@@ -449,6 +668,9 @@ func (n *node) Close() error {
 		var closeErr error
 		n.lisClose.Do(func() {
 			closeErr = n.l.Close()
+			if n.wsl != nil {
+				n.wsl.Close()
+			}
 		})
 		if closeErr != nil && !strings.Contains(errors.Cause(closeErr).Error(), "use of closed network connection") {
 			return errors.Wrap(closeErr, "ssb: network node failed to close it's listener")
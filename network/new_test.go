@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+	multiserver "go.mindeco.de/ssb-multiserver"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestMultiserverAddressesUnspecified(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	n, err := New(Options{
+		Logger:     log.NewNopLogger(),
+		ListenAddr: &net.TCPAddr{IP: net.IPv4zero},
+		KeyPair:    kp,
+		AppKey:     testAppKey,
+		MakeHandler: func(net.Conn) (muxrpc.Handler, error) {
+			return &muxrpc.HandlerMux{}, nil
+		},
+	})
+	r.NoError(err)
+	go n.Serve(ctx)
+	defer n.Close()
+	<-n.(*node).listening
+
+	// the interface addresses are announced instead of 0.0.0.0
+	addrs := n.(*node).MultiserverAddresses()
+	r.Len(addrs, len(reachableIPs(net.IPv4zero)))
+	for _, a := range addrs {
+		r.False(strings.Contains(a, "0.0.0.0"), "unspecified address announced: %s", a)
+		na, err := multiserver.ParseNetAddress([]byte(a))
+		r.NoError(err, "invalid address: %s", a)
+		r.True(na.Addr.IP.IsGlobalUnicast(), "not an interface address: %s", a)
+		r.True(na.Ref.Equal(kp.Id))
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+)
+
+// OnionAddr is the address of a tor onion service.
+// The multiserver format is onion:xyz.onion:port~shs:key
+type OnionAddr struct {
+	Host string
+	Port int
+}
+
+var _ net.Addr = OnionAddr{}
+
+func (oa OnionAddr) Network() string { return "onion" }
+
+func (oa OnionAddr) String() string { return fmt.Sprintf("onion:%s:%d", oa.Host, oa.Port) }
+
+// HostPort returns the host:port pair that is passed to the SOCKS5 proxy
+func (oa OnionAddr) HostPort() string { return net.JoinHostPort(oa.Host, strconv.Itoa(oa.Port)) }
+
+// ParseOnionHostPort parses xyz.onion:port into an OnionAddr
+func ParseOnionHostPort(hostport string) (*OnionAddr, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, errors.Wrap(err, "onion address: expected host:port")
+	}
+	if !isOnionHost(host) {
+		return nil, errors.Errorf("onion address: not an onion host: %q", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, errors.Errorf("onion address: invalid port: %q", portStr)
+	}
+	return &OnionAddr{Host: host, Port: int(port)}, nil
+}
+
+// v2 names are 16 and v3 names are 56 base32 characters long
+func isOnionHost(host string) bool {
+	name := strings.TrimSuffix(strings.ToLower(host), ".onion")
+	if name == host || (len(name) != 16 && len(name) != 56) {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= '2' && c <= '7') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseOnionAddress returns an onion address that also holds the shs key of the remote, ready to be passed to Connect
+func ParseOnionAddress(input string) (net.Addr, error) {
+	parts := strings.Split(input, "~")
+	if len(parts) != 2 {
+		return nil, errors.Errorf("onion address: expected onion:host:port~shs:key")
+	}
+	if !strings.HasPrefix(parts[0], "onion:") {
+		return nil, errors.Errorf("onion address: expected onion: prefix")
+	}
+	oa, err := ParseOnionHostPort(strings.TrimPrefix(parts[0], "onion:"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(parts[1], "shs:") {
+		return nil, errors.Errorf("onion address: unsupported transform: %q", parts[1])
+	}
+	pubKey, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(parts[1], "shs:"))
+	if err != nil {
+		return nil, errors.Wrap(err, "onion address: invalid shs key")
+	}
+	if n := len(pubKey); n != 32 {
+		return nil, errors.Errorf("onion address: invalid shs key length: %d", n)
+	}
+	return netwrap.WrapAddr(*oa, secretstream.Addr{PubKey: pubKey}), nil
+}
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb/internal/socks5"
+)
+
+var testOnionHost = strings.Repeat("a", 56) + ".onion"
+
+func TestParseOnionAddress(t *testing.T) {
+	r := require.New(t)
+
+	kp := makeTestPubKey(t)
+	key := newPublicKeyString(kp)
+
+	addr, err := ParseOnionAddress("onion:" + testOnionHost + ":8008~shs:" + key)
+	r.NoError(err)
+
+	oa, ok := netwrap.GetAddr(addr, "onion").(OnionAddr)
+	r.True(ok, "not an onion address: %T", addr)
+	r.Equal(testOnionHost, oa.Host)
+	r.Equal(8008, oa.Port)
+	r.Equal(testOnionHost+":8008", oa.HostPort())
+
+	shs, ok := netwrap.GetAddr(addr, "shs-bs").(secretstream.Addr)
+	r.True(ok, "no shs address")
+	r.Equal(kp.Id.PubKey(), shs.PubKey)
+
+	// v2 names are shorter
+	_, err = ParseOnionAddress("onion:abcdefghijklmnop.onion:8008~shs:" + key)
+	r.NoError(err)
+
+	for i, bad := range []string{
+		"onion:" + testOnionHost + ":8008",
+		"net:" + testOnionHost + ":8008~shs:" + key,
+		"onion:example.com:8008~shs:" + key,
+		"onion:abc.onion:8008~shs:" + key,
+		"onion:" + testOnionHost + ":nope~shs:" + key,
+		"onion:" + testOnionHost + ":8008~noise:" + key,
+	} {
+		_, err = ParseOnionAddress(bad)
+		r.Error(err, "case %d should fail", i)
+	}
+}
+
+func TestOnionConnectThroughSOCKS5(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, srvConns := newTestNode(ctx, t, Options{
+		OnionAddr: &OnionAddr{Host: testOnionHost, Port: 8008},
+	})
+	defer srv.Close()
+	srvAddr := srv.GetListenAddr()
+
+	// stand-in for tor that knows where the onion service lives
+	proxy := &socks5.Server{
+		Resolve: func(hostport string) (string, error) {
+			if hostport == testOnionHost+":8008" {
+				return netwrap.GetAddr(srvAddr, "tcp").String(), nil
+			}
+			return "", fmt.Errorf("unknown destination: %s", hostport)
+		},
+	}
+	proxyL, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer proxyL.Close()
+	go proxy.Serve(proxyL)
+
+	// the server announces the onion address
+	var onionMS string
+	for _, a := range srv.(*node).MultiserverAddresses() {
+		if strings.HasPrefix(a, "onion:") {
+			onionMS = a
+		}
+	}
+	r.NotEmpty(onionMS, "onion address not announced")
+	onionAddr, err := ParseOnionAddress(onionMS)
+	r.NoError(err)
+
+	// without a proxy, onion addresses can't be dialed
+	direct, _ := newTestNode(ctx, t, Options{})
+	defer direct.Close()
+	r.Error(direct.Connect(ctx, onionAddr))
+
+	cli, cliConns := newTestNode(ctx, t, Options{
+		SOCKS5Addr: proxyL.Addr().String(),
+	})
+	defer cli.Close()
+
+	r.NoError(cli.Connect(ctx, onionAddr))
+	waitForConn(t, srvConns)
+	waitForConn(t, cliConns)
+
+	r.Equal([]string{testOnionHost + ":8008"}, proxy.Requested())
+}
@@ -0,0 +1,362 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/muxrpc/codec"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// TunnelAddr is the address of a peer that is reached through a room (the portal).
+// The multiserver format is tunnel:@portal.ed25519:@target.ed25519~shs:targetKey
+type TunnelAddr struct {
+	Portal *ssb.FeedRef
+	Target *ssb.FeedRef
+}
+
+var _ net.Addr = TunnelAddr{}
+
+func (ta TunnelAddr) Network() string { return "tunnel" }
+
+func (ta TunnelAddr) String() string {
+	return fmt.Sprintf("tunnel:%s:%s", ta.Portal.Ref(), ta.Target.Ref())
+}
+
+// ParseTunnelAddress returns a tunnel address that also holds the shs key of the target, ready to be passed to Connect
+func ParseTunnelAddress(input string) (net.Addr, error) {
+	parts := strings.Split(input, "~")
+	if len(parts) > 2 {
+		return nil, errors.Errorf("tunnel address: too many transforms: %q", input)
+	}
+
+	if !strings.HasPrefix(parts[0], "tunnel:") {
+		return nil, errors.Errorf("tunnel address: expected tunnel: prefix")
+	}
+	refs := strings.Split(strings.TrimPrefix(parts[0], "tunnel:"), ":")
+	if len(refs) != 2 {
+		return nil, errors.Errorf("tunnel address: expected portal and target")
+	}
+
+	var (
+		ta  TunnelAddr
+		err error
+	)
+	ta.Portal, err = ssb.ParseFeedRef(refs[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "tunnel address: invalid portal")
+	}
+	ta.Target, err = ssb.ParseFeedRef(refs[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "tunnel address: invalid target")
+	}
+
+	if len(parts) == 2 && !strings.HasPrefix(parts[1], "shs:") {
+		return nil, errors.Errorf("tunnel address: unsupported transform: %q", parts[1])
+	}
+	// the key of the target is part of the address anyhow
+	return netwrap.WrapAddr(ta, secretstream.Addr{PubKey: ta.Target.PubKey()}), nil
+}
+
+// TunnelConnectArgs are the arguments of tunnel.connect.
+// The client calls the room with portal and target. The room calls the target with origin added.
+type TunnelConnectArgs struct {
+	Origin *ssb.FeedRef `json:"origin,omitempty"`
+	Portal *ssb.FeedRef `json:"portal"`
+	Target *ssb.FeedRef `json:"target"`
+}
+
+// connectTunnel asks the room to relay a stream to the target and does secret-handshake over it
+func (n *node) connectTunnel(ctx context.Context, ta TunnelAddr, pubKey []byte) error {
+	edp, has := n.GetEndpointFor(ta.Portal)
+	if !has {
+		return errors.Errorf("node/connect: not connected to room %s", ta.Portal.ShortRef())
+	}
+
+	args := TunnelConnectArgs{
+		Portal: ta.Portal,
+		Target: ta.Target,
+	}
+	src, snk, err := edp.Duplex(ctx, codec.Body{}, muxrpc.Method{"tunnel", "connect"}, args)
+	if err != nil {
+		return errors.Wrap(err, "node/connect: tunnel.connect failed")
+	}
+
+	conn := NewTunnelConn(src, snk, TunnelAddr{Portal: ta.Portal, Target: n.opts.KeyPair.Id}, ta)
+	shsConn, err := n.secretClient.ConnWrapper(pubKey)(conn)
+	if err != nil {
+		conn.Close()
+		n.emitHandshakeFailure(err, ssb.ConnOutgoing, ta.Target.Ref(), ta.String())
+		return errors.Wrap(err, "node/connect: handshake through tunnel failed")
+	}
+
+	go n.handleConnection(ctx, shsConn, ssb.ConnOutgoing)
+	return nil
+}
+
+// AcceptTunnel does the server side of secret-handshake on a stream that was relayed by a room and serves it like any other incoming connection.
+// The connection is refused if the peer isn't the origin the room named.
+// It returns once the connection is closed.
+func (n *node) AcceptTunnel(ctx context.Context, origin *ssb.FeedRef, conn net.Conn) error {
+	shsConn, err := n.secretServer.ConnWrapper()(conn)
+	if err != nil {
+		conn.Close()
+		var claimed string
+		if origin != nil {
+			claimed = origin.Ref()
+		}
+		n.emitHandshakeFailure(err, ssb.ConnIncoming, claimed, addrOf(conn.RemoteAddr()))
+		return errors.Wrap(err, "node/tunnel: handshake failed")
+	}
+
+	remote, err := ssb.GetFeedRefFromAddr(shsConn.RemoteAddr())
+	if err != nil {
+		shsConn.Close()
+		return errors.Wrap(err, "node/tunnel: expected an address containing an shs-bs addr")
+	}
+	if origin == nil || !remote.Equal(origin) {
+		shsConn.Close()
+		var claimed string
+		if origin != nil {
+			claimed = origin.ShortRef()
+		}
+		level.Warn(n.log).Log("event", "tunnel origin mismatch", "claimed", claimed, "remote", remote.ShortRef())
+		return errors.Errorf("node/tunnel: the room claimed %q but %s connected", claimed, remote.ShortRef())
+	}
+
+	n.handleConnection(ctx, shsConn, ssb.ConnIncoming)
+	return nil
+}
+
+// NewTunnelConn turns the two ends of a duplex muxrpc call into a net.Conn.
+// A write that runs into its deadline closes the connection, since the stream can't be continued in the middle of a write.
+func NewTunnelConn(src luigi.Source, snk luigi.Sink, local, remote net.Addr) net.Conn {
+	return newTunnelConn(muxrpc.NewSourceReader(src), muxrpc.NewSinkWriter(snk), local, remote)
+}
+
+func newTunnelConn(r io.Reader, w io.WriteCloser, local, remote net.Addr) *tunnelConn {
+	c := &tunnelConn{
+		r:      r,
+		w:      w,
+		local:  local,
+		remote: remote,
+
+		chunks: make(chan []byte),
+		closed: make(chan struct{}),
+
+		readDeadline:  newConnDeadline(),
+		writeDeadline: newConnDeadline(),
+	}
+	go c.readLoop()
+	return c
+}
+
+type tunnelConn struct {
+	r io.Reader
+	w io.WriteCloser
+
+	local, remote net.Addr
+
+	// readLoop hands what it reads to Read, so that Read can give up when the deadline passes
+	chunks  chan []byte
+	readErr error // set before chunks is closed
+
+	readMu  sync.Mutex
+	pending []byte // the rest of the last chunk
+
+	closeOnce sync.Once
+	closeErr  error
+	closed    chan struct{}
+
+	readDeadline, writeDeadline *connDeadline
+}
+
+func (c *tunnelConn) readLoop() {
+	defer close(c.chunks)
+	for {
+		buf := make([]byte, 32*1024)
+		n, err := c.r.Read(buf)
+		if n > 0 {
+			select {
+			case c.chunks <- buf[:n]:
+			case <-c.closed:
+				c.readErr = io.ErrClosedPipe
+				return
+			}
+		}
+		if err != nil {
+			c.readErr = err
+			return
+		}
+	}
+}
+
+func (c *tunnelConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	expired, _ := c.readDeadline.wait()
+	select {
+	case <-expired:
+		return 0, errTunnelTimeout
+	default:
+	}
+
+	if len(c.pending) == 0 {
+		select {
+		case chunk, ok := <-c.chunks:
+			if !ok {
+				return 0, c.readErr
+			}
+			c.pending = chunk
+		case <-c.closed:
+			return 0, io.ErrClosedPipe
+		case <-expired:
+			return 0, errTunnelTimeout
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *tunnelConn) Write(b []byte) (int, error) {
+	expired, has := c.writeDeadline.wait()
+	if !has {
+		// writes without a deadline go straight to the stream
+		return c.w.Write(b)
+	}
+	select {
+	case <-expired:
+		return 0, errTunnelTimeout
+	default:
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	// b belongs to the caller again once Write returns
+	data := append([]byte(nil), b...)
+	go func() {
+		n, err := c.w.Write(data)
+		done <- result{n, err}
+	}()
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-expired:
+		c.Close()
+		return 0, errTunnelTimeout
+	}
+}
+
+func (c *tunnelConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.closeErr = c.w.Close()
+	})
+	return c.closeErr
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr  { return c.local }
+func (c *tunnelConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// errTunnelTimeout is returned by reads and writes of a tunnelConn after their deadline passed
+var errTunnelTimeout net.Error = tunnelTimeoutError{}
+
+type tunnelTimeoutError struct{}
+
+func (tunnelTimeoutError) Error() string   { return "network/tunnel: i/o timeout" }
+func (tunnelTimeoutError) Timeout() bool   { return true }
+func (tunnelTimeoutError) Temporary() bool { return true }
+
+// connDeadline is a channel that is closed when the deadline passes.
+// Setting a new deadline keeps the channel if it wasn't closed yet, so that waiting reads and writes follow the new one.
+type connDeadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired chan struct{}
+	has     bool
+}
+
+func newConnDeadline() *connDeadline {
+	return &connDeadline{expired: make(chan struct{})}
+}
+
+func (d *connDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer fired already, wait until it closed the channel
+		<-d.expired
+	}
+	d.timer = nil
+
+	closed := false
+	select {
+	case <-d.expired:
+		closed = true
+	default:
+	}
+
+	d.has = !t.IsZero()
+	if !d.has {
+		if closed {
+			d.expired = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.expired = make(chan struct{})
+		}
+		expired := d.expired
+		d.timer = time.AfterFunc(dur, func() { close(expired) })
+		return
+	}
+
+	if !closed {
+		close(d.expired)
+	}
+}
+
+// wait returns a channel that is closed when the deadline passed and if a deadline is set at all
+func (d *connDeadline) wait() (chan struct{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired, d.has
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestParseTunnelAddress(t *testing.T) {
+	r := require.New(t)
+
+	portal := &ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	target := &ssb.FeedRef{ID: bytes.Repeat([]byte{2}, 32), Algo: ssb.RefAlgoFeedSSB1}
+
+	addr, err := ParseTunnelAddress("tunnel:" + portal.Ref() + ":" + target.Ref() + "~shs:" + target.Ref()[1:45])
+	r.NoError(err)
+
+	ta, ok := netwrap.GetAddr(addr, "tunnel").(TunnelAddr)
+	r.True(ok, "not a tunnel address: %T", addr)
+	r.True(ta.Portal.Equal(portal))
+	r.True(ta.Target.Equal(target))
+
+	shs, ok := netwrap.GetAddr(addr, "shs-bs").(secretstream.Addr)
+	r.True(ok, "no shs address")
+	r.Equal(target.PubKey(), shs.PubKey)
+
+	// the transform is optional
+	_, err = ParseTunnelAddress("tunnel:" + portal.Ref() + ":" + target.Ref())
+	r.NoError(err)
+
+	for i, bad := range []string{
+		"net:localhost:8008~shs:" + target.Ref()[1:45],
+		"tunnel:" + portal.Ref(),
+		"tunnel:" + portal.Ref() + ":nope",
+		"tunnel:" + portal.Ref() + ":" + target.Ref() + "~noise:foo",
+	} {
+		_, err = ParseTunnelAddress(bad)
+		r.Error(err, "case %d should fail", i)
+	}
+}
+
+func TestTunnelConnDeadlines(t *testing.T) {
+	r := require.New(t)
+
+	inR, inW := io.Pipe()
+	_, outW := io.Pipe()
+	c := newTunnelConn(inR, outW, nil, nil)
+
+	isTimeout := func(err error) {
+		ne, ok := err.(net.Error)
+		r.True(ok && ne.Timeout(), "expected a timeout: %v", err)
+	}
+
+	// nothing to read, the read gives up at the deadline
+	r.NoError(c.SetReadDeadline(time.Now().Add(50 * time.Millisecond)))
+	buf := make([]byte, 8)
+	_, err := c.Read(buf)
+	isTimeout(err)
+
+	// the connection can still be used once the deadline is lifted
+	r.NoError(c.SetReadDeadline(time.Time{}))
+	go inW.Write([]byte("hello"))
+	n, err := c.Read(buf)
+	r.NoError(err)
+	r.Equal("hello", string(buf[:n]))
+
+	// a read that is already waiting follows a new deadline
+	r.NoError(c.SetReadDeadline(time.Now().Add(time.Hour)))
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := c.Read(buf)
+		readErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	r.NoError(c.SetReadDeadline(time.Now()))
+	select {
+	case err = <-readErr:
+		isTimeout(err)
+	case <-time.After(time.Second):
+		t.Fatal("the read didn't follow the new deadline")
+	}
+
+	// nobody reads the other end, the write runs into its deadline and closes the connection
+	r.NoError(c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond)))
+	_, err = c.Write([]byte("stuck"))
+	isTimeout(err)
+	select {
+	case <-c.closed:
+	default:
+		t.Fatal("the connection should be closed")
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+)
+
+// WebsocketAddr is the address of a peer that accepts secret-handshake over websockets.
+// The multiserver format is ws://host:port~shs:key
+type WebsocketAddr struct {
+	Addr *net.TCPAddr
+	Path string
+}
+
+var _ net.Addr = WebsocketAddr{}
+
+func (wa WebsocketAddr) Network() string { return "ws" }
+
+func (wa WebsocketAddr) String() string { return wa.URL().String() }
+
+// URL returns the url that is requested during the opening handshake
+func (wa WebsocketAddr) URL() *url.URL {
+	return &url.URL{
+		Scheme: "ws",
+		Host:   wa.Addr.String(),
+		Path:   wa.Path,
+	}
+}
+
+// ParseWebsocketAddress returns a websocket address that also holds the shs key of the remote, ready to be passed to Connect
+func ParseWebsocketAddress(input string) (net.Addr, error) {
+	parts := strings.Split(input, "~")
+	if len(parts) != 2 {
+		return nil, errors.Errorf("websocket address: expected ws://host:port~shs:key")
+	}
+
+	u, err := url.Parse(parts[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "websocket address: invalid url")
+	}
+	if u.Scheme != "ws" {
+		return nil, errors.Errorf("websocket address: unsupported scheme: %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", u.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "websocket address: failed to resolve host")
+	}
+
+	if !strings.HasPrefix(parts[1], "shs:") {
+		return nil, errors.Errorf("websocket address: unsupported transform: %q", parts[1])
+	}
+	pubKey, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(parts[1], "shs:"))
+	if err != nil {
+		return nil, errors.Wrap(err, "websocket address: invalid shs key")
+	}
+	if n := len(pubKey); n != ed25519.PublicKeySize {
+		return nil, errors.Errorf("websocket address: invalid shs key length: %d", n)
+	}
+
+	wa := WebsocketAddr{
+		Addr: tcpAddr,
+		Path: u.Path,
+	}
+	return netwrap.WrapAddr(wa, secretstream.Addr{PubKey: pubKey}), nil
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestParseWebsocketAddress(t *testing.T) {
+	r := require.New(t)
+
+	kp := makeTestPubKey(t)
+	key := newPublicKeyString(kp)
+
+	addr, err := ParseWebsocketAddress("ws://127.0.0.1:8989~shs:" + key)
+	r.NoError(err)
+
+	wa, ok := netwrap.GetAddr(addr, "ws").(WebsocketAddr)
+	r.True(ok, "not a websocket address: %T", addr)
+	r.Equal(8989, wa.Addr.Port)
+	r.Equal("ws://127.0.0.1:8989", wa.String())
+
+	shs, ok := netwrap.GetAddr(addr, "shs-bs").(secretstream.Addr)
+	r.True(ok, "no shs address")
+	r.Equal(kp.Id.PubKey(), shs.PubKey)
+
+	// port defaults to 80
+	addr, err = ParseWebsocketAddress("ws://127.0.0.1/path~shs:" + key)
+	r.NoError(err)
+	wa = netwrap.GetAddr(addr, "ws").(WebsocketAddr)
+	r.Equal(80, wa.Addr.Port)
+	r.Equal("/path", wa.Path)
+
+	for i, bad := range []string{
+		"ws://127.0.0.1:8989",
+		"net:127.0.0.1:8989~shs:" + key,
+		"wss://127.0.0.1:8989~shs:" + key,
+		"ws://127.0.0.1:8989~noise:" + key,
+		"ws://127.0.0.1:8989~shs:dG9vc2hvcnQ=",
+	} {
+		_, err = ParseWebsocketAddress(bad)
+		r.Error(err, "case %d should fail", i)
+	}
+}
+
+func TestWebsocketAdvertisement(t *testing.T) {
+	kp := makeTestPubKey(t)
+	got := newWebsocketAdvertisement(net.IPv4(1, 2, 3, 4), 8989, kp)
+	require.Equal(t, "ws://1.2.3.4:8989~shs:LtQ3tOuLoeQFi5s/ic7U6wDBxWS3t2yxauc4/AwqfWc=", got)
+}
+
+func TestWebsocketConnect(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	appKey := make([]byte, 32)
+	mkNode := func(wsAddr net.Addr, connected chan<- *ssb.FeedRef) ssb.Network {
+		kp, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		n, err := New(Options{
+			Logger:        log.NewNopLogger(),
+			ListenAddr:    &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			WebsocketAddr: wsAddr,
+			KeyPair:       kp,
+			AppKey:        appKey,
+			MakeHandler: func(conn net.Conn) (muxrpc.Handler, error) {
+				remote, err := ssb.GetFeedRefFromAddr(conn.RemoteAddr())
+				if err != nil {
+					return nil, err
+				}
+				connected <- remote
+				return &muxrpc.HandlerMux{}, nil
+			},
+		})
+		r.NoError(err)
+		go n.Serve(ctx)
+		return n
+	}
+
+	srvConns := make(chan *ssb.FeedRef, 1)
+	srv := mkNode(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, srvConns)
+	defer srv.Close()
+
+	cliConns := make(chan *ssb.FeedRef, 1)
+	cli := mkNode(nil, cliConns)
+	defer cli.Close()
+
+	wsAddr := srv.(*node).GetWebsocketAddr()
+	r.NotNil(wsAddr)
+	r.NotNil(netwrap.GetAddr(wsAddr, "ws"))
+
+	r.NoError(cli.Connect(ctx, wsAddr))
+
+	for _, ch := range []chan *ssb.FeedRef{srvConns, cliConns} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			r.Fail("connection timeout")
+		}
+	}
+}
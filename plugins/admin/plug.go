@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: MIT
+
+// Package admin offers maintenance calls for the operator of the bot, like admin.backup.
+// The offline tools use them to do their work through the running bot, since only one process may open the repo.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cryptix/go/logging"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/muxmux"
+	"go.cryptoscope.co/ssb/plugins/feedgc"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Backuper makes a backup of the running bot into the folder dst
+type Backuper interface {
+	Backup(ctx context.Context, dst string, opts repo.BackupOptions) (*repo.BackupManifest, error)
+}
+
+// FeedNuller deletes all the messages of a feed
+type FeedNuller interface {
+	NullFeed(ref *ssb.FeedRef) error
+}
+
+// Compacter removes nulled messages from the root log.
+// progressFn is only called until Compact returns.
+type Compacter interface {
+	Compact(ctx context.Context, progressFn func(percentage float64, timeLeft time.Duration)) (*repo.CompactStats, error)
+}
+
+// FeedCollector drops the stored feeds that are out of range for longer than the grace period
+type FeedCollector interface {
+	CollectFeeds(ctx context.Context, dryRun bool) (*feedgc.Report, error)
+}
+
+// Bot is what the admin calls need from the bot
+type Bot interface {
+	Backuper
+	FeedNuller
+	Compacter
+	FeedCollector
+}
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+// New returns the admin plugin. It should only be registered on the master (local) handler.
+func New(log logging.Interface, b Bot) ssb.Plugin {
+	mux := muxmux.New(log)
+	mux.RegisterAsync(muxrpc.Method{"admin", "backup"}, backupH{b: b})
+	mux.RegisterAsync(muxrpc.Method{"admin", "nullFeed"}, nullFeedH{n: b})
+	mux.RegisterSource(muxrpc.Method{"admin", "compact"}, compactSrc{c: b})
+	mux.RegisterAsync(muxrpc.Method{"admin", "gc"}, gcH{c: b})
+	return plugin{h: &mux}
+}
+
+func (plugin) Name() string              { return "admin" }
+func (plugin) Method() muxrpc.Method     { return muxrpc.Method{"admin"} }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// BackupArgs are the arguments of admin.backup
+type BackupArgs struct {
+	// Path is the folder on the machine of the bot that the backup is written to. It needs to be empty or not exist.
+	Path string `json:"path"`
+
+	// Blobs is none, list (default) or all
+	Blobs repo.BackupBlobs `json:"blobs"`
+}
+
+type backupH struct {
+	b Backuper
+}
+
+func (h backupH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []BackupArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("admin.backup: invalid arguments: %w", err)
+	}
+	if len(args) != 1 || args[0].Path == "" {
+		return nil, fmt.Errorf("admin.backup: expected one argument with a path")
+	}
+	a := args[0]
+
+	return h.b.Backup(ctx, a.Path, repo.BackupOptions{
+		Blobs: a.Blobs,
+	})
+}
+
+type nullFeedH struct {
+	n FeedNuller
+}
+
+// HandleAsync of admin.nullFeed expects the feed reference as the only argument.
+// The messages are overwritten, there is no undo.
+func (h nullFeedH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []string
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("admin.nullFeed: invalid arguments: %w", err)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("admin.nullFeed: expected one feed reference")
+	}
+	ref, err := ssb.ParseFeedRef(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("admin.nullFeed: invalid feed reference: %w", err)
+	}
+	if err := h.n.NullFeed(ref); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// CompactProgress is sent by admin.compact while it copies the log
+type CompactProgress struct {
+	Percent  float64 `json:"percent"`
+	TimeLeft string  `json:"timeLeft"`
+}
+
+type compactSrc struct {
+	c Compacter
+}
+
+// HandleSource of admin.compact sends CompactProgress updates and the repo.CompactStats at the end.
+// The compacted log is used once the bot is restarted.
+func (h compactSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk luigi.Sink) error {
+	stats, err := h.c.Compact(ctx, func(percentage float64, timeLeft time.Duration) {
+		snk.Pour(ctx, CompactProgress{
+			Percent:  percentage,
+			TimeLeft: timeLeft.String(),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("admin.compact: %w", err)
+	}
+	if err := snk.Pour(ctx, stats); err != nil {
+		return err
+	}
+	return snk.Close()
+}
+
+// GCArgs are the optional arguments of admin.gc
+type GCArgs struct {
+	DryRun bool `json:"dryRun"`
+}
+
+type gcH struct {
+	c FeedCollector
+}
+
+// HandleAsync of admin.gc sweeps the stored feeds and returns the feedgc.Report.
+// Without arguments the feeds that are due are dropped, with dryRun they are only reported.
+func (h gcH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []GCArgs
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			return nil, fmt.Errorf("admin.gc: invalid arguments: %w", err)
+		}
+	}
+	var a GCArgs
+	if len(args) > 0 {
+		a = args[0]
+	}
+	report, err := h.c.CollectFeeds(ctx, a.DryRun)
+	if err != nil {
+		return nil, fmt.Errorf("admin.gc: %w", err)
+	}
+	return report, nil
+}
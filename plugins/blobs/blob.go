@@ -4,6 +4,7 @@ package blobs
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
@@ -43,9 +44,24 @@ func checkAndLog(log logging.Interface, err error) {
 	}
 }
 
-func New(log logging.Interface, self ssb.FeedRef, bs ssb.BlobStore, wm ssb.WantManager) ssb.Plugin {
+// RateLimiter can be passed to New to limit how fast blobs are sent to peers
+type RateLimiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+func New(log logging.Interface, self ssb.FeedRef, bs ssb.BlobStore, wm ssb.WantManager, opts ...interface{}) ssb.Plugin {
 	rootHdlr := muxrpc.HandlerMux{}
 
+	var limiter RateLimiter
+	for i, o := range opts {
+		switch v := o.(type) {
+		case RateLimiter:
+			limiter = v
+		default:
+			level.Warn(log).Log("event", "unhandled blobs option", "i", i, "type", fmt.Sprintf("%T", o))
+		}
+	}
+
 	// TODO: needs priv checks
 	// rootHdlr.Register(muxrpc.Method{"blobs", "add"}, addHandler{
 	// 	log: log,
@@ -62,8 +78,9 @@ func New(log logging.Interface, self ssb.FeedRef, bs ssb.BlobStore, wm ssb.WantM
 
 	var hs = []muxrpc.NamedHandler{
 		{muxrpc.Method{"blobs", "get"}, getHandler{
-			log: log,
-			bs:  bs,
+			log:     log,
+			bs:      bs,
+			limiter: limiter,
 		}},
 		{muxrpc.Method{"blobs", "has"}, hasHandler{
 			log: log,
@@ -23,10 +23,26 @@ import (
 type getHandler struct {
 	bs  ssb.BlobStore
 	log logging.Interface
+
+	limiter RateLimiter
 }
 
 func (getHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
 
+// limitedReader waits for the limiter before each read, so that nothing is read before it may be sent
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   RateLimiter
+}
+
+func (lr limitedReader) Read(b []byte) (int, error) {
+	if err := lr.l.WaitN(lr.ctx, len(b)); err != nil {
+		return 0, err
+	}
+	return lr.r.Read(b)
+}
+
 func (h getHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
 	logger := log.With(h.log, "handler", "get")
 	errLog := level.Error(logger)
@@ -84,6 +100,10 @@ func (h getHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mux
 	}
 	start := time.Now()
 
+	if h.limiter != nil {
+		r = limitedReader{ctx: ctx, r: r, l: h.limiter}
+	}
+
 	w := muxrpc.NewSinkWriter(req.Stream)
 	_, err = io.Copy(w, r)
 	checkAndLog(errLog, errors.Wrap(err, "error sending blob"))
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+
+// Package conn offers conn.events, a live stream of peers connecting and disconnecting.
+package conn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/cryptix/go/logging"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/muxmux"
+)
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+// New returns the plugin that streams the events of the passed network
+func New(log logging.Interface, events ssb.ConnEventer) ssb.Plugin {
+	mux := muxmux.New(log)
+	mux.RegisterSource(muxrpc.Method{"conn", "events"}, eventsSrc{events: events})
+	return plugin{h: &mux}
+}
+
+func (plugin) Name() string              { return "conn" }
+func (plugin) Method() muxrpc.Method     { return muxrpc.Method{"conn"} }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// EventsArgs optionally restrict the stream to some types of events
+type EventsArgs struct {
+	Types []string `json:"types"`
+}
+
+type eventsSrc struct {
+	events ssb.ConnEventer
+}
+
+func (es eventsSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk luigi.Sink) error {
+	var args []EventsArgs
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			return fmt.Errorf("conn.events: invalid arguments: %w", err)
+		}
+	}
+
+	var wanted map[string]bool
+	if len(args) == 1 && len(args[0].Types) > 0 {
+		wanted = make(map[string]bool, len(args[0].Types))
+		for _, t := range args[0].Types {
+			wanted[t] = true
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		errc = make(chan error, 1)
+	)
+	done := es.events.ConnEvents().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return nil
+		}
+		evt, ok := v.(ssb.ConnEvent)
+		if !ok || (wanted != nil && !wanted[evt.Type]) {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err := snk.Pour(ctx, evt); err != nil {
+			select {
+			case errc <- err:
+			default:
+			}
+		}
+		return nil
+	}))
+	defer done()
+
+	select {
+	case <-ctx.Done():
+	case err := <-errc:
+		return err
+	}
+	return snk.Close()
+}
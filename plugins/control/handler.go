@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
@@ -17,6 +18,7 @@ import (
 	multiserver "go.mindeco.de/ssb-multiserver"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/network"
 )
 
 type handler struct {
@@ -121,6 +123,36 @@ func (h *handler) connect(ctx context.Context, req *muxrpc.Request) (interface{}
 	if !ok {
 		return nil, errors.Errorf("ctrl.connect call: expected argument to be string, got %T", req.Args()[0])
 	}
+	if strings.HasPrefix(dest, "tunnel:") {
+		tunnelAddr, err := network.ParseTunnelAddress(dest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ctrl.connect call: failed to parse input: %s", dest)
+		}
+		level.Info(h.info).Log("event", "doing gossip.connect", "remote", tunnelAddr.String())
+		err = h.node.Connect(context.Background(), tunnelAddr)
+		return nil, errors.Wrapf(err, "ctrl.connect call: error connecting to %q", dest)
+	}
+
+	if strings.HasPrefix(dest, "onion:") {
+		onionAddr, err := network.ParseOnionAddress(dest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ctrl.connect call: failed to parse input: %s", dest)
+		}
+		level.Info(h.info).Log("event", "doing gossip.connect", "remote", onionAddr.String())
+		err = h.node.Connect(context.Background(), onionAddr)
+		return nil, errors.Wrapf(err, "ctrl.connect call: error connecting to %q", dest)
+	}
+
+	if strings.HasPrefix(dest, "ws:") {
+		wsAddr, err := network.ParseWebsocketAddress(dest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ctrl.connect call: failed to parse input: %s", dest)
+		}
+		level.Info(h.info).Log("event", "doing gossip.connect", "remote", wsAddr.String())
+		err = h.node.Connect(context.Background(), wsAddr)
+		return nil, errors.Wrapf(err, "ctrl.connect call: error connecting to %q", dest)
+	}
+
 	msaddr, err := multiserver.ParseNetAddress([]byte(dest))
 	if err != nil {
 		return nil, errors.Wrapf(err, "ctrl.connect call: failed to parse input: %s", dest)
@@ -0,0 +1,347 @@
+// SPDX-License-Identifier: MIT
+
+// Package feedgc drops stored feeds that are no longer replicated.
+// A feed is out of range if it is neither on the replication list nor within the hops of a local identity.
+// It is only dropped once it stayed out of range for the grace period, so that unfollowing and following again doesn't cost a full download.
+package feedgc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Options of the garbage collection
+type Options struct {
+	// Grace is how long a feed needs to be out of range before it is dropped
+	Grace time.Duration
+
+	// Interval is how often the feeds are checked, defaults to an hour
+	Interval time.Duration
+
+	// Exempt feeds are never dropped
+	Exempt []*ssb.FeedRef
+
+	// DryRun only reports what would be dropped
+	DryRun bool
+}
+
+// ParseExempt reads a comma separated list of feed references
+func ParseExempt(s string) ([]*ssb.FeedRef, error) {
+	var refs []*ssb.FeedRef
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		ref, err := ssb.ParseFeedRef(part)
+		if err != nil {
+			return nil, fmt.Errorf("feedgc: invalid exempt feed %q: %w", part, err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// Candidate is a stored feed that is out of range
+type Candidate struct {
+	Feed *ssb.FeedRef `json:"feed"`
+
+	// Since is when the feed was first seen out of range
+	Since time.Time `json:"since"`
+
+	// Messages is the number of messages of the feed
+	Messages int64 `json:"messages"`
+
+	// Due is true once the grace period is over
+	Due bool `json:"due"`
+
+	// Dropped is true if the feed was nulled by this sweep
+	Dropped bool `json:"dropped"`
+}
+
+// Report is what a sweep found
+type Report struct {
+	Checked time.Time `json:"checked"`
+	DryRun  bool      `json:"dryRun"`
+
+	// Feeds is the number of stored feeds and InRange the ones of them that are kept
+	Feeds   int `json:"feeds"`
+	InRange int `json:"inRange"`
+
+	Candidates []Candidate `json:"candidates"`
+	Dropped    int         `json:"dropped"`
+}
+
+// FeedNuller deletes all the messages of a feed, like sbot.NullFeed
+type FeedNuller interface {
+	NullFeed(*ssb.FeedRef) error
+}
+
+// Identities are the feeds of the bot itself, their hops are in range
+type Identities interface {
+	Identities() []*ssb.KeyPair
+	IsIdentity(*ssb.FeedRef) bool
+}
+
+// Service sweeps the stored feeds and remembers since when each of them is out of range
+type Service struct {
+	logger kitlog.Logger
+
+	hops   int
+	ids    Identities
+	lister ssb.ReplicationLister
+	graph  graph.Builder
+	feeds  multilog.MultiLog
+	nuller FeedNuller
+
+	opts   Options
+	exempt *ssb.StrFeedSet
+
+	mu   sync.Mutex
+	kv   *kv.DB
+	last *Report
+}
+
+// New opens the state of the garbage collection in the repo.
+// hops is the hop count of the bot, lister its replication lists and feeds the userFeeds multilog.
+func New(
+	logger kitlog.Logger,
+	r repo.Interface,
+	hops int,
+	ids Identities,
+	lister ssb.ReplicationLister,
+	gb graph.Builder,
+	feeds multilog.MultiLog,
+	nuller FeedNuller,
+	opts Options,
+) (*Service, error) {
+	if opts.Grace < 0 {
+		return nil, fmt.Errorf("feedgc: invalid grace period %s", opts.Grace)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = time.Hour
+	}
+	exempt := ssb.NewFeedSet(len(opts.Exempt))
+	for _, ref := range opts.Exempt {
+		exempt.AddRef(ref)
+	}
+
+	db, err := repo.OpenMKV(r.GetPath("plugin", "feedgc"))
+	if err != nil {
+		return nil, fmt.Errorf("feedgc: failed to open key-value database: %w", err)
+	}
+
+	return &Service{
+		logger: logger,
+
+		hops:   hops,
+		ids:    ids,
+		lister: lister,
+		graph:  gb,
+		feeds:  feeds,
+		nuller: nuller,
+
+		opts:   opts,
+		exempt: exempt,
+
+		kv: db,
+	}, nil
+}
+
+// Close closes the underlying key-value database
+func (s *Service) Close() error { return s.kv.Close() }
+
+// Serve sweeps once per interval until ctx is canceled
+func (s *Service) Serve(ctx context.Context) error {
+	tick := time.NewTicker(s.opts.Interval)
+	defer tick.Stop()
+	for {
+		report, err := s.Sweep(ctx, time.Now(), s.opts.DryRun)
+		if err != nil {
+			level.Warn(s.logger).Log("event", "sweep failed", "err", err)
+		} else if report.DryRun {
+			for _, c := range report.Candidates {
+				if c.Due {
+					level.Info(s.logger).Log("event", "would drop", "feed", c.Feed.Ref(), "since", c.Since, "messages", c.Messages)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+	}
+}
+
+// Sweep checks all the stored feeds and drops the ones that are out of range for longer than the grace period.
+// With dryRun nothing is dropped but it still notes which feeds went out of range.
+func (s *Service) Sweep(ctx context.Context, now time.Time, dryRun bool) (*Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs, err := s.feeds.List()
+	if err != nil {
+		return nil, fmt.Errorf("feedgc: failed to list feeds: %w", err)
+	}
+
+	inRange := s.inRange()
+	report := &Report{
+		Checked:    now,
+		DryRun:     dryRun,
+		Feeds:      len(addrs),
+		Candidates: []Candidate{},
+	}
+	for _, addr := range addrs {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var sr ssb.StorageRef
+		if err := sr.Unmarshal([]byte(addr)); err != nil {
+			continue
+		}
+		ref, err := sr.FeedRef()
+		if err != nil {
+			continue
+		}
+
+		if inRange(ref) {
+			report.InRange++
+			if err := s.reset(ref); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		c, err := s.candidate(ref, now)
+		if err != nil {
+			return nil, err
+		}
+		if c.Due && !dryRun {
+			if err := s.nuller.NullFeed(ref); err != nil {
+				return nil, fmt.Errorf("feedgc: failed to drop %s: %w", ref.ShortRef(), err)
+			}
+			if err := s.reset(ref); err != nil {
+				return nil, err
+			}
+			c.Dropped = true
+			report.Dropped++
+			level.Info(s.logger).Log("event", "dropped feed", "feed", ref.Ref(), "since", c.Since, "messages", c.Messages)
+		}
+		report.Candidates = append(report.Candidates, *c)
+	}
+	sort.Slice(report.Candidates, func(i, j int) bool {
+		return report.Candidates[i].Feed.Ref() < report.Candidates[j].Feed.Ref()
+	})
+
+	s.last = report
+	return report, nil
+}
+
+// ErrNoReport is returned by LastReport before the first sweep
+var ErrNoReport = errors.New("feedgc: no sweep yet")
+
+// LastReport returns the report of the latest sweep
+func (s *Service) LastReport() (*Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		return nil, ErrNoReport
+	}
+	return s.last, nil
+}
+
+// inRange returns a check for the feeds that are kept.
+// The hops of each identity are fetched once, since the graph builder memoizes them that is cheap.
+func (s *Service) inRange() func(*ssb.FeedRef) bool {
+	var (
+		wants   = s.lister.ReplicationList()
+		hopSets []*ssb.StrFeedSet
+	)
+	for _, kp := range s.ids.Identities() {
+		if set := s.graph.Hops(kp.Id, s.hops); set != nil {
+			hopSets = append(hopSets, set)
+		}
+	}
+	return func(ref *ssb.FeedRef) bool {
+		if s.ids.IsIdentity(ref) || s.exempt.Has(ref) || wants.Has(ref) {
+			return true
+		}
+		for _, set := range hopSets {
+			if set.Has(ref) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// candidate needs to be called with s.mu locked
+func (s *Service) candidate(ref *ssb.FeedRef, now time.Time) (*Candidate, error) {
+	c := &Candidate{Feed: ref, Since: now}
+
+	data, err := s.kv.Get(nil, sinceKey(ref))
+	if err != nil {
+		return nil, fmt.Errorf("feedgc: failed to get state of %s: %w", ref.ShortRef(), err)
+	}
+	if data != nil {
+		if err := c.Since.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("feedgc: failed to decode state of %s: %w", ref.ShortRef(), err)
+		}
+	} else {
+		data, err := now.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.kv.Set(sinceKey(ref), data); err != nil {
+			return nil, fmt.Errorf("feedgc: failed to store state of %s: %w", ref.ShortRef(), err)
+		}
+	}
+	c.Due = now.Sub(c.Since) >= s.opts.Grace
+
+	userLog, err := s.feeds.Get(ref.StoredAddr())
+	if err != nil {
+		return nil, fmt.Errorf("feedgc: failed to open feed: %w", err)
+	}
+	sv, err := userLog.Seq().Value()
+	if err != nil {
+		return nil, fmt.Errorf("feedgc: failed to get feed sequence: %w", err)
+	}
+	c.Messages = sv.(margaret.Seq).Seq() + 1
+	return c, nil
+}
+
+// reset forgets that ref was out of range, it needs to be called with s.mu locked
+func (s *Service) reset(ref *ssb.FeedRef) error {
+	k := sinceKey(ref)
+	data, err := s.kv.Get(nil, k)
+	if err != nil {
+		return fmt.Errorf("feedgc: failed to get state of %s: %w", ref.ShortRef(), err)
+	}
+	if data == nil {
+		return nil
+	}
+	if err := s.kv.Delete(k); err != nil {
+		return fmt.Errorf("feedgc: failed to reset %s: %w", ref.ShortRef(), err)
+	}
+	return nil
+}
+
+func sinceKey(ref *ssb.FeedRef) []byte {
+	return append([]byte("since:"), ref.StoredAddr()...)
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: MIT
+
+package feedgc_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/plugins/feedgc"
+	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+type testLister struct {
+	wants *ssb.StrFeedSet
+}
+
+func (l testLister) Authorize(remote *ssb.FeedRef) error {
+	if l.wants.Has(remote) {
+		return nil
+	}
+	return errors.New("not wanted")
+}
+
+func (l testLister) ReplicationList() *ssb.StrFeedSet { return l.wants }
+func (l testLister) BlockList() *ssb.StrFeedSet       { return ssb.NewFeedSet(0) }
+
+func TestSweep(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	bot, err := sbot.New(
+		sbot.WithInfo(log.NewNopLogger()),
+		sbot.WithRepoPath(tRepoPath),
+		sbot.DisableNetworkNode(),
+	)
+	r.NoError(err)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+
+	uf, ok := bot.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+
+	// the keys of the other feeds are not in the repo, those would be identities of the bot
+	newFeed := func(n int) *ssb.FeedRef {
+		kp, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		pub, err := message.OpenPublishLog(bot.RootLog, uf, kp)
+		r.NoError(err)
+		for i := 0; i < n; i++ {
+			_, err := pub.Publish(map[string]interface{}{"type": "test", "i": i})
+			r.NoError(err)
+		}
+		return kp.Id
+	}
+	var (
+		followed = newFeed(2)
+		wanted   = newFeed(2)
+		exempt   = newFeed(2)
+		stray    = newFeed(3)
+		flaky    = newFeed(1)
+	)
+
+	_, err = bot.PublishLog.Publish(ssb.NewContactFollow(followed))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+
+	lister := testLister{wants: ssb.NewFeedSet(0)}
+	lister.wants.AddRef(wanted)
+
+	svc, err := feedgc.New(log.NewNopLogger(), repo.New(tRepoPath), 1, bot, lister, bot.GraphBuilder, uf, bot, feedgc.Options{
+		Grace:  time.Hour,
+		Exempt: []*ssb.FeedRef{exempt},
+	})
+	r.NoError(err)
+	defer svc.Close()
+
+	_, err = svc.LastReport()
+	r.Equal(feedgc.ErrNoReport, err)
+
+	ctx := context.TODO()
+	now := time.Now()
+	report, err := svc.Sweep(ctx, now, false)
+	r.NoError(err)
+	r.Equal(6, report.Feeds)
+	r.Equal(4, report.InRange)
+	r.Len(report.Candidates, 2)
+	r.Equal(0, report.Dropped)
+	for _, c := range report.Candidates {
+		r.True(c.Feed.Equal(stray) || c.Feed.Equal(flaky), "unexpected candidate %s", c.Feed.Ref())
+		r.False(c.Due)
+		if c.Feed.Equal(stray) {
+			r.EqualValues(3, c.Messages)
+		}
+	}
+
+	// coming back into range starts the grace period over
+	lister.wants.AddRef(flaky)
+	report, err = svc.Sweep(ctx, now.Add(time.Minute), false)
+	r.NoError(err)
+	r.Len(report.Candidates, 1)
+	lister.wants.Delete(flaky)
+
+	later := now.Add(2 * time.Hour)
+	report, err = svc.Sweep(ctx, later, true)
+	r.NoError(err)
+	r.Len(report.Candidates, 2)
+	r.Equal(0, report.Dropped)
+	for _, c := range report.Candidates {
+		if c.Feed.Equal(stray) {
+			r.True(c.Due)
+			r.True(c.Since.Equal(now), "since: %s", c.Since)
+		} else {
+			r.False(c.Due)
+			r.True(c.Since.Equal(later), "since: %s", c.Since)
+		}
+	}
+	has, err := multilog.Has(uf, stray.StoredAddr())
+	r.NoError(err)
+	r.True(has, "dry run dropped the feed")
+
+	report, err = svc.Sweep(ctx, later, false)
+	r.NoError(err)
+	r.Equal(1, report.Dropped)
+	has, err = multilog.Has(uf, stray.StoredAddr())
+	r.NoError(err)
+	r.False(has)
+
+	last, err := svc.LastReport()
+	r.NoError(err)
+	r.Equal(report, last)
+
+	report, err = svc.Sweep(ctx, later, false)
+	r.NoError(err)
+	r.Equal(5, report.Feeds)
+	r.Len(report.Candidates, 1)
+	r.True(report.Candidates[0].Feed.Equal(flaky))
+}
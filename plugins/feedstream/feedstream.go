@@ -0,0 +1,290 @@
+// SPDX-License-Identifier: MIT
+
+// Package feedstream implements createFeedStream, which returns messages from all feeds ordered by the timestamp their authors claimed.
+package feedstream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/indexes"
+	"go.cryptoscope.co/ssb/internal/transform"
+	"go.cryptoscope.co/ssb/message"
+)
+
+// ~> sbot createFeedStream --help
+// (feed) Fetch messages ordered by their claimed timestamps.
+// feed [--live] [--gt ts] [--lt ts] [--reverse] [--keys] [--limit n] [--hops n]
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func New(log log.Logger, self *ssb.FeedRef, rootLog margaret.Log, ts *indexes.Timestamps, b graph.Builder) ssb.Plugin {
+	return plugin{
+		h: handler{
+			log:     log,
+			self:    self,
+			root:    rootLog,
+			ts:      ts,
+			builder: b,
+		},
+	}
+}
+
+func (plugin) Name() string { return "createFeedStream" }
+
+func (plugin) Method() muxrpc.Method {
+	return muxrpc.Method{"createFeedStream"}
+}
+
+func (p plugin) Handler() muxrpc.Handler {
+	return p.h
+}
+
+// Args are the query parameters of createFeedStream
+type Args struct {
+	message.CommonArgs
+	message.StreamArgs
+
+	// Gt and Lt are claimed timestamps in milliseconds, zero means unbounded
+	Gt int64 `json:"gt,omitempty"`
+	Lt int64 `json:"lt,omitempty"`
+
+	// Hops restricts the stream to feeds that are this many hops away from us (and our own)
+	Hops *int `json:"hops,omitempty"`
+}
+
+// how many entries are read from the index in one transaction
+const pageSize = 256
+
+type handler struct {
+	log log.Logger
+
+	self *ssb.FeedRef
+
+	root    margaret.Log
+	ts      *indexes.Timestamps
+	builder graph.Builder
+}
+
+func (h handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
+
+func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	var args []Args
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		req.CloseWithError(errors.Wrap(err, "feedStream: bad request data"))
+		return
+	}
+	var qry Args
+	if len(args) == 1 {
+		qry = args[0]
+	} else {
+		qry.Keys = true
+	}
+
+	if qry.Live && qry.Reverse {
+		req.CloseWithError(errors.Errorf("feedStream: can't do live in reverse"))
+		return
+	}
+
+	if qry.Limit == 0 {
+		qry.Limit = -1
+	}
+
+	err := h.stream(ctx, qry, transform.NewKeyValueWrapper(req.Stream, qry.Keys))
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+
+	req.Stream.Close()
+}
+
+func (h handler) stream(ctx context.Context, qry Args, snk luigi.Sink) error {
+	var (
+		feedsMu sync.Mutex
+		feeds   *ssb.StrFeedSet
+	)
+	if qry.Hops != nil {
+		// live streams follow the changes to the range, the backlog uses it as it is now
+		if n, ok := h.builder.(graph.Notifier); ok && qry.Live {
+			done := n.HopChanges().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+				if err != nil {
+					return err
+				}
+				hc, ok := v.(graph.HopChange)
+				if !ok || hc.Max != *qry.Hops || !hc.From.Equal(h.self) || hc.Feed.Equal(h.self) {
+					return nil
+				}
+				feedsMu.Lock()
+				defer feedsMu.Unlock()
+				if feeds == nil {
+					// not set yet, Hops below already has the change
+					return nil
+				}
+				if hc.Added {
+					feeds.AddRef(hc.Feed)
+				} else {
+					feeds.Delete(hc.Feed)
+				}
+				return nil
+			}))
+			defer done()
+			defer n.WatchHops(h.self, *qry.Hops)()
+		}
+
+		hops := h.builder.Hops(h.self, *qry.Hops)
+		if hops == nil {
+			return errors.Errorf("feedStream: failed to get hops")
+		}
+		hops.AddRef(h.self)
+		feedsMu.Lock()
+		feeds = hops
+		feedsMu.Unlock()
+	}
+
+	// the backlog comes from the index, everything it didn't get to yet is sent by the live part
+	currSeqV, err := h.ts.GetSeq()
+	if err != nil {
+		return errors.Wrap(err, "feedStream: failed to get current index sequence")
+	}
+	currSeq := currSeqV.Seq()
+
+	inRange := func(claimed int64) bool {
+		if qry.Gt > 0 && claimed <= qry.Gt {
+			return false
+		}
+		if qry.Lt > 0 && claimed >= qry.Lt {
+			return false
+		}
+		return true
+	}
+
+	send := func(msg ssb.Message) error {
+		feedsMu.Lock()
+		skip := qry.Hops != nil && !feeds.Has(msg.Author())
+		feedsMu.Unlock()
+		if skip {
+			return nil
+		}
+		if err := snk.Pour(ctx, msg); err != nil {
+			return errors.Wrap(err, "feedStream: failed to send message")
+		}
+		if qry.Limit > 0 {
+			qry.Limit--
+		}
+		return nil
+	}
+
+	from := indexes.FirstEntry
+	if qry.Gt > 0 {
+		from = indexes.TimestampEntry{Claimed: qry.Gt + 1}
+	}
+	if qry.Reverse {
+		from = indexes.LastEntry
+		if qry.Lt > 0 {
+			// lt is at least 1, there is always an entry before it
+			from, _ = indexes.TimestampEntry{Claimed: qry.Lt}.Prev()
+		}
+	}
+
+backlog:
+	for qry.Limit != 0 {
+		page, err := h.ts.Range(from, qry.Reverse, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, entry := range page {
+			if qry.Limit == 0 || !inRange(entry.Claimed) {
+				// the pages are sorted, so we are past the range
+				break backlog
+			}
+
+			if entry.Seq.Seq() > currSeq {
+				continue
+			}
+
+			v, err := h.root.Get(entry.Seq)
+			if err != nil {
+				if margaret.IsErrNulled(err) {
+					continue
+				}
+				return errors.Wrapf(err, "feedStream: failed to get message %d", entry.Seq)
+			}
+			if errv, ok := v.(error); ok {
+				if margaret.IsErrNulled(errv) {
+					continue
+				}
+				return errv
+			}
+			msg, ok := v.(ssb.Message)
+			if !ok {
+				return errors.Errorf("feedStream: unexpected message type: %T", v)
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+
+		last := page[len(page)-1]
+		if qry.Reverse {
+			var ok bool
+			if from, ok = last.Prev(); !ok {
+				// that was the lowest possible entry
+				break
+			}
+		} else {
+			from = last.Next()
+		}
+	}
+
+	if !qry.Live || qry.Limit == 0 {
+		return nil
+	}
+
+	// new messages are sent in the order we receive them
+	src, err := h.root.Query(margaret.Live(true), margaret.Gt(margaret.BaseSeq(currSeq)))
+	if err != nil {
+		return errors.Wrap(err, "feedStream: failed to query live messages")
+	}
+
+	for qry.Limit != 0 {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) || errors.Cause(err) == context.Canceled {
+				return nil
+			}
+			return err
+		}
+		if errv, ok := v.(error); ok {
+			if margaret.IsErrNulled(errv) {
+				continue
+			}
+			return errv
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			return errors.Errorf("feedStream: unexpected message type: %T", v)
+		}
+		if !inRange(indexes.ClampedTimestamp(msg)) {
+			continue
+		}
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: MIT
+
+package feedstream
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/indexes"
+	"go.cryptoscope.co/ssb/internal/asynctesting"
+	"go.cryptoscope.co/ssb/internal/ctxutils"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// chanSink collects the messages of a stream
+type chanSink chan ssb.Message
+
+func (cs chanSink) Pour(ctx context.Context, v interface{}) error {
+	cs <- v.(ssb.Message)
+	return nil
+}
+
+func (cs chanSink) Close() error { return nil }
+
+func (cs chanSink) next(t testing.TB, wait time.Duration) ssb.Message {
+	select {
+	case msg := <-cs:
+		return msg
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+func TestStreamLive(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath, err := ioutil.TempDir("", "feedstream")
+	r.NoError(err)
+	defer os.RemoveAll(tRepoPath)
+
+	ctx, cancel := ctxutils.WithError(context.Background(), ssb.ErrShuttingDown)
+	logger := testutils.NewRelativeTimeLogger(nil)
+
+	tRepo := repo.New(tRepoPath)
+	tRootLog, err := repo.OpenLog(tRepo)
+	r.NoError(err)
+
+	uf, serveUF, err := multilogs.OpenUserFeeds(tRepo)
+	r.NoError(err)
+	ufErrc := asynctesting.ServeLog(ctx, "user feeds", tRootLog, serveUF, true)
+
+	tsIdx, serveTS, err := indexes.OpenTimestamps(tRepo)
+	r.NoError(err)
+	tsErrc := asynctesting.ServeLog(ctx, "timestamps", tRootLog, serveTS, true)
+
+	builder, _, serveContacts, err := indexes.OpenContacts(logger, tRepo)
+	r.NoError(err)
+	contactsErrc := asynctesting.ServeLog(ctx, "contacts", tRootLog, serveContacts, true)
+
+	newPublisher := func() (*ssb.KeyPair, ssb.Publisher) {
+		kp, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		pub, err := message.OpenPublishLog(tRootLog, uf, kp, message.UseNowTimestamps(true))
+		r.NoError(err)
+		return kp, pub
+	}
+	self, selfPublish := newPublisher()
+	alice, alicePublish := newPublisher()
+	bob, bobPublish := newPublisher()
+
+	h := handler{
+		log:     logger,
+		self:    self.Id,
+		root:    tRootLog,
+		ts:      tsIdx.(*indexes.Timestamps),
+		builder: builder,
+	}
+
+	post := func(pub ssb.Publisher, text string) *ssb.MessageRef {
+		ref, err := pub.Publish(map[string]interface{}{"type": "post", "text": text})
+		r.NoError(err)
+		return ref
+	}
+
+	var backlog []*ssb.MessageRef
+	for i := 0; i < 3; i++ {
+		backlog = append(backlog, post(alicePublish, "backlog"))
+	}
+
+	// the limit also ends live streams, after the backlog and what came in since
+	snk := make(chanSink, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- h.stream(ctx, Args{StreamArgs: message.StreamArgs{Live: true, Limit: 5}}, snk)
+	}()
+	for i, ref := range backlog {
+		msg := snk.next(t, 5*time.Second)
+		r.NotNil(msg, "backlog message %d missing", i)
+		r.True(ref.Equal(*msg.Key()), "backlog message %d", i)
+	}
+	live := []*ssb.MessageRef{post(bobPublish, "live"), post(alicePublish, "live")}
+	for i, ref := range live {
+		msg := snk.next(t, 5*time.Second)
+		r.NotNil(msg, "live message %d missing", i)
+		r.True(ref.Equal(*msg.Key()), "live message %d", i)
+	}
+	select {
+	case err := <-done:
+		r.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("live stream didn't stop at the limit")
+	}
+
+	// feeds that come into range while the stream is running are sent, too
+	_, err = selfPublish.Publish(ssb.NewContactFollow(alice.Id))
+	r.NoError(err)
+	for i := 0; !builder.Hops(self.Id, 0).Has(alice.Id); i++ {
+		r.True(i < 100, "follow of alice wasn't indexed")
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	hops := 0
+	snk = make(chanSink, 100)
+	streamCtx, stopStream := context.WithCancel(ctx)
+	go func() {
+		done <- h.stream(streamCtx, Args{StreamArgs: message.StreamArgs{Live: true, Limit: -1}, Hops: &hops}, snk)
+	}()
+
+	// alice's messages and the follow
+	for i := 0; i < len(backlog)+2; i++ {
+		msg := snk.next(t, 5*time.Second)
+		r.NotNil(msg, "backlog message %d missing", i)
+		r.False(msg.Author().Equal(bob.Id), "bob isn't in range yet")
+	}
+
+	notYet := post(bobPublish, "not in range")
+	r.Nil(snk.next(t, time.Second), "bob isn't in range yet")
+
+	_, err = selfPublish.Publish(ssb.NewContactFollow(bob.Id))
+	r.NoError(err)
+	selfFollow := snk.next(t, 5*time.Second)
+	r.NotNil(selfFollow)
+	r.True(selfFollow.Author().Equal(self.Id))
+
+	// the range is updated right after the follow is indexed
+	var got ssb.Message
+	for i := 0; got == nil; i++ {
+		r.True(i < 25, "no message from bob")
+		post(bobPublish, "in range")
+		got = snk.next(t, 200*time.Millisecond)
+	}
+	r.True(got.Author().Equal(bob.Id))
+	r.False(notYet.Equal(*got.Key()))
+
+	stopStream()
+	r.NoError(<-done)
+
+	cancel()
+	for err := range asynctesting.MergedErrors(ufErrc, tsErrc, contactsErrc) {
+		r.NoError(err, "from chan")
+	}
+}
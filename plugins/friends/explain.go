@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: MIT
+
+package friends
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/go-kit/kit/log"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+)
+
+// HopCount tells explain which hop limit the bot uses for replication
+type HopCount int
+
+// ManualLister is implemented by replicators that keep track of the feeds that were replicated or blocked by hand, not because of the graph.
+type ManualLister interface {
+	ManualReplicationList() *ssb.StrFeedSet
+	ManualBlockList() *ssb.StrFeedSet
+}
+
+type ExplainArgs struct {
+	From *ssb.FeedRef `json:"from,omitempty"`
+	To   *ssb.FeedRef `json:"to"`
+}
+
+// Explanation is the answer of friends.explain
+type Explanation struct {
+	From *ssb.FeedRef `json:"from"`
+	To   *ssb.FeedRef `json:"to"`
+
+	// Path is the shortest follow path from From to To, including both of them. Empty if there is none.
+	Path []*ssb.FeedRef `json:"path"`
+
+	// Dist is the number of feeds between From and To on Path, -1 if there is no path
+	Dist int `json:"dist"`
+
+	// MaxHops is the hop limit the bot uses for replication and InHops tells if To is inside of it
+	MaxHops int  `json:"maxHops"`
+	InHops  bool `json:"inHops"`
+
+	// Blocks are the blocks of From and the feeds on the path on To or each other
+	Blocks []graph.Edge `json:"blocks"`
+
+	// the state of the replicator
+	Replicated         bool `json:"replicated"`
+	ManuallyReplicated bool `json:"manuallyReplicated"`
+	Blocked            bool `json:"blocked"`
+	ManuallyBlocked    bool `json:"manuallyBlocked"`
+}
+
+type explainH struct {
+	self ssb.FeedRef
+
+	log log.Logger
+
+	builder graph.Builder
+
+	hopCount   int
+	replicator ssb.Replicator
+}
+
+func (h explainH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []ExplainArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("invalid argument on explain call: %w", err)
+	}
+	if len(args) != 1 || args[0].To == nil {
+		return nil, fmt.Errorf("expected one arg {from, to}")
+	}
+	a := args[0]
+	if a.From == nil {
+		a.From = &h.self
+	}
+
+	return h.explain(a.From, a.To)
+}
+
+func (h explainH) explain(from, to *ssb.FeedRef) (*Explanation, error) {
+	g, err := h.builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	ex := Explanation{
+		From: from,
+		To:   to,
+
+		Path: []*ssb.FeedRef{},
+		Dist: -1,
+
+		MaxHops: h.hopCount,
+
+		Blocks: []graph.Edge{},
+	}
+
+	lookup, err := g.MakeDijkstra(from)
+	var nsf graph.ErrNoSuchFrom
+	if err != nil && !errors.As(err, &nsf) {
+		return nil, err
+	}
+	if lookup != nil {
+		// like the authorizer, dist counts the feeds between from and to
+		path, d := lookup.Path(to)
+		if len(path) > 0 && !math.IsInf(d, 0) {
+			ex.Path = path
+			ex.Dist = len(path) - 2
+		}
+	}
+
+	// blocks from us or along the way
+	deciders := []*ssb.FeedRef{from}
+	if len(ex.Path) > 2 {
+		deciders = append(deciders, ex.Path[1:len(ex.Path)-1]...)
+	}
+	for _, who := range deciders {
+		if g.Blocks(who, to) {
+			ex.Blocks = append(ex.Blocks, graph.Edge{From: who, To: to, Blocking: true})
+		}
+		for _, other := range ex.Path {
+			if !other.Equal(who) && !other.Equal(to) && g.Blocks(who, other) {
+				ex.Blocks = append(ex.Blocks, graph.Edge{From: who, To: other, Blocking: true})
+			}
+		}
+	}
+
+	if hops := h.builder.Hops(from, h.hopCount); hops != nil {
+		ex.InHops = hops.Has(to)
+	}
+
+	if h.replicator != nil {
+		lister := h.replicator.Lister()
+		ex.Replicated = lister.ReplicationList().Has(to)
+		ex.Blocked = lister.BlockList().Has(to)
+
+		if ml, ok := h.replicator.(ManualLister); ok {
+			ex.ManuallyReplicated = ml.ManualReplicationList().Has(to)
+			ex.ManuallyBlocked = ml.ManualBlockList().Has(to)
+		}
+	}
+
+	return &ex, nil
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: MIT
+
+package friends
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+// testReplicator is a replicator with fixed lists
+type testReplicator struct {
+	wants, blocks             *ssb.StrFeedSet
+	manualWants, manualBlocks *ssb.StrFeedSet
+}
+
+func newTestReplicator() *testReplicator {
+	return &testReplicator{
+		wants:        ssb.NewFeedSet(0),
+		blocks:       ssb.NewFeedSet(0),
+		manualWants:  ssb.NewFeedSet(0),
+		manualBlocks: ssb.NewFeedSet(0),
+	}
+}
+
+func (tr *testReplicator) Replicate(ref *ssb.FeedRef) {
+	tr.wants.AddRef(ref)
+	tr.manualWants.AddRef(ref)
+}
+
+func (tr *testReplicator) DontReplicate(ref *ssb.FeedRef) {
+	tr.wants.Delete(ref)
+	tr.manualWants.Delete(ref)
+}
+
+func (tr *testReplicator) Block(ref *ssb.FeedRef) {
+	tr.blocks.AddRef(ref)
+	tr.manualBlocks.AddRef(ref)
+}
+
+func (tr *testReplicator) Unblock(ref *ssb.FeedRef) {
+	tr.blocks.Delete(ref)
+	tr.manualBlocks.Delete(ref)
+}
+
+func (tr *testReplicator) Lister() ssb.ReplicationLister { return tr }
+
+func (tr *testReplicator) Authorize(*ssb.FeedRef) error { return nil }
+
+func (tr *testReplicator) ReplicationList() *ssb.StrFeedSet       { return tr.wants }
+func (tr *testReplicator) BlockList() *ssb.StrFeedSet             { return tr.blocks }
+func (tr *testReplicator) ManualReplicationList() *ssb.StrFeedSet { return tr.manualWants }
+func (tr *testReplicator) ManualBlockList() *ssb.StrFeedSet       { return tr.manualBlocks }
+
+func TestExplainPath(t *testing.T) {
+	r := require.New(t)
+
+	tg, done := newTestGraph(t)
+	defer done()
+
+	var (
+		self  = randomFeed(t)
+		alice = randomFeed(t)
+		bob   = randomFeed(t)
+		carol = randomFeed(t)
+	)
+	// self and alice are friends, alice follows bob
+	tg.contact(self, alice, true, false)
+	tg.contact(alice, self, true, false)
+	tg.contact(alice, bob, true, false)
+
+	repl := newTestReplicator()
+	repl.wants.AddRef(bob)
+	h := explainH{
+		self:       *self,
+		log:        testutils.NewRelativeTimeLogger(nil),
+		builder:    tg.builder,
+		hopCount:   1,
+		replicator: repl,
+	}
+
+	v, err := h.HandleAsync(context.TODO(), testRequest(t, ExplainArgs{To: bob}))
+	r.NoError(err)
+	ex := v.(*Explanation)
+	r.True(ex.From.Equal(self), "from defaults to self")
+	r.True(ex.To.Equal(bob))
+	r.Len(ex.Path, 3)
+	r.True(ex.Path[0].Equal(self))
+	r.True(ex.Path[1].Equal(alice))
+	r.True(ex.Path[2].Equal(bob))
+	r.Equal(1, ex.Dist)
+	r.Equal(1, ex.MaxHops)
+	r.True(ex.InHops)
+	r.Empty(ex.Blocks)
+	r.True(ex.Replicated)
+	r.False(ex.ManuallyReplicated)
+
+	// from alice's view bob is a direct follow
+	v, err = h.HandleAsync(context.TODO(), testRequest(t, ExplainArgs{From: alice, To: bob}))
+	r.NoError(err)
+	ex = v.(*Explanation)
+	r.Len(ex.Path, 2)
+	r.Equal(0, ex.Dist)
+	r.True(ex.InHops)
+
+	// nobody follows carol
+	v, err = h.HandleAsync(context.TODO(), testRequest(t, ExplainArgs{To: carol}))
+	r.NoError(err)
+	ex = v.(*Explanation)
+	r.Empty(ex.Path)
+	r.Equal(-1, ex.Dist)
+	r.False(ex.InHops)
+	r.False(ex.Replicated)
+
+	// without a contact, from isn't in the graph
+	v, err = h.HandleAsync(context.TODO(), testRequest(t, ExplainArgs{From: carol, To: bob}))
+	r.NoError(err)
+	ex = v.(*Explanation)
+	r.Empty(ex.Path)
+	r.Equal(-1, ex.Dist)
+
+	_, err = h.HandleAsync(context.TODO(), testRequest(t, ExplainArgs{From: alice}))
+	r.Error(err, "to is needed")
+}
+
+func TestExplainBlocks(t *testing.T) {
+	r := require.New(t)
+
+	tg, done := newTestGraph(t)
+	defer done()
+
+	var (
+		self  = randomFeed(t)
+		alice = randomFeed(t)
+		bob   = randomFeed(t)
+	)
+	tg.contact(self, alice, true, false)
+	tg.contact(alice, self, true, false)
+	tg.contact(alice, bob, true, false)
+
+	// self blocks bob, the follow path through alice is still there
+	tg.contact(self, bob, false, true)
+
+	repl := newTestReplicator()
+	repl.Block(bob)
+	h := explainH{
+		self:       *self,
+		log:        testutils.NewRelativeTimeLogger(nil),
+		builder:    tg.builder,
+		hopCount:   1,
+		replicator: repl,
+	}
+
+	ex, err := h.explain(self, bob)
+	r.NoError(err)
+	r.Len(ex.Path, 3)
+	r.Len(ex.Blocks, 1)
+	r.Equal(graph.Edge{From: self, To: bob, Blocking: true}, ex.Blocks[0])
+	r.True(ex.Blocked)
+	r.True(ex.ManuallyBlocked)
+
+	// alice blocking self breaks the friendship, bob is out of the hop range
+	tg.contact(alice, self, false, true)
+
+	ex, err = h.explain(self, bob)
+	r.NoError(err)
+	r.Len(ex.Path, 3, "self still follows alice")
+	r.False(ex.InHops)
+	r.Len(ex.Blocks, 2)
+	r.Equal(graph.Edge{From: self, To: bob, Blocking: true}, ex.Blocks[0])
+	r.Equal(graph.Edge{From: alice, To: self, Blocking: true}, ex.Blocks[1])
+
+	// blocks of others that are not on the path don't matter
+	carol := randomFeed(t)
+	tg.contact(carol, bob, false, true)
+	ex, err = h.explain(self, bob)
+	r.NoError(err)
+	r.Len(ex.Blocks, 2)
+}
@@ -3,6 +3,8 @@
 package friends
 
 import (
+	"fmt"
+
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
 	"go.cryptoscope.co/muxrpc"
@@ -17,11 +19,14 @@ import (
   isFollowing: 'async',
   isBlocking: 'async',
   hops: 'async',
+  explain: 'async',
 
 extra:
 
   follows: 'source',
   blocks: 'source',
+  stream: 'source',
+  hopsStream: 'source',
 
 */
 
@@ -36,9 +41,27 @@ func checkAndLog(log logging.Interface, err error) {
 	}
 }
 
-func New(log logging.Interface, self ssb.FeedRef, b graph.Builder) ssb.Plugin {
+// New creates the friends plugin. The options can be a HopCount and the ssb.Replicator of the bot, which are used by explain.
+func New(log logging.Interface, self ssb.FeedRef, b graph.Builder, opts ...interface{}) ssb.Plugin {
 	rootHdlr := muxmux.New(log)
 
+	explain := explainH{
+		log:      log,
+		builder:  b,
+		self:     self,
+		hopCount: 1,
+	}
+	for i, o := range opts {
+		switch v := o.(type) {
+		case HopCount:
+			explain.hopCount = int(v)
+		case ssb.Replicator:
+			explain.replicator = v
+		default:
+			log.Log("warning", "unhandled option", "i", i, "type", fmt.Sprintf("%T", o))
+		}
+	}
+
 	rootHdlr.RegisterAsync(muxrpc.Method{"friends", "isFollowing"}, isFollowingH{
 		log:     log,
 		builder: b,
@@ -63,6 +86,25 @@ func New(log logging.Interface, self ssb.FeedRef, b graph.Builder) ssb.Plugin {
 		self:    self,
 	})
 
+	// the log based graph can't tell about changes
+	notifier, _ := b.(graph.Notifier)
+
+	rootHdlr.RegisterSource(muxrpc.Method{"friends", "stream"}, streamSrc{
+		log:      log,
+		builder:  b,
+		notifier: notifier,
+		self:     self,
+	})
+
+	rootHdlr.RegisterSource(muxrpc.Method{"friends", "hopsStream"}, hopsStreamSrc{
+		log:      log,
+		builder:  b,
+		notifier: notifier,
+		self:     self,
+	})
+
+	rootHdlr.RegisterAsync(muxrpc.Method{"friends", "explain"}, explain)
+
 	rootHdlr.RegisterAsync(muxrpc.Method{"friends", "plotsvg"}, plotSVGHandler{
 		log:     log,
 		builder: b,
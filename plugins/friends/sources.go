@@ -3,6 +3,7 @@ package friends
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/go-kit/kit/log"
@@ -101,3 +102,194 @@ func (h hopsSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk luig
 
 	return snk.Close()
 }
+
+type StreamArgs struct {
+	Live bool `json:"live"`
+}
+
+// streamSrc sends all the follow and block edges, and the changes to them if live is set
+type streamSrc struct {
+	self ssb.FeedRef
+
+	log log.Logger
+
+	builder  graph.Builder
+	notifier graph.Notifier
+}
+
+func (h streamSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk luigi.Sink) error {
+	var args []StreamArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return fmt.Errorf("invalid argument on stream call: %w", err)
+	}
+	var live bool
+	if len(args) == 1 {
+		live = args[0].Live
+	}
+
+	var ls *liveSink
+	if live {
+		if h.notifier == nil {
+			return fmt.Errorf("stream: live updates not supported by this graph")
+		}
+		// register before looking at the graph so that nothing gets lost in between.
+		// the changes are queued until the current edges are sent.
+		ls = newLiveSink(nil)
+		done := h.notifier.Changes().Register(ls)
+		defer done()
+	}
+
+	g, err := h.builder.Build()
+	if err != nil {
+		return err
+	}
+
+	edges := g.EdgeList()
+	for i, e := range edges {
+		if err := snk.Pour(ctx, e); err != nil {
+			return fmt.Errorf("stream: failed to send edge %d: %w", i, err)
+		}
+	}
+
+	if ls == nil {
+		return snk.Close()
+	}
+	return ls.forward(ctx, snk)
+}
+
+// hopsStreamSrc sends the current hops range as additions, followed by feeds leaving or entering it
+type hopsStreamSrc struct {
+	self ssb.FeedRef
+
+	log log.Logger
+
+	builder  graph.Builder
+	notifier graph.Notifier
+}
+
+func (h hopsStreamSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk luigi.Sink) error {
+	if h.notifier == nil {
+		return fmt.Errorf("hopsStream: live updates not supported by this graph")
+	}
+
+	var args []HopsArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return fmt.Errorf("invalid argument on hopsStream call: %w", err)
+	}
+
+	var (
+		start *ssb.FeedRef
+		dist  uint
+	)
+	if len(args) == 1 {
+		start = args[0].Start
+		dist = args[0].Max
+	}
+
+	if start == nil {
+		start = &h.self
+	}
+
+	ls := newLiveSink(func(v interface{}) bool {
+		hc, ok := v.(graph.HopChange)
+		return ok && hc.From.Equal(start) && hc.Max == int(dist)
+	})
+	done := h.notifier.HopChanges().Register(ls)
+	defer done()
+	defer h.notifier.WatchHops(start, int(dist))()
+
+	set := h.builder.Hops(start, int(dist))
+	if set == nil {
+		return fmt.Errorf("hopsStream: failed to get hops")
+	}
+	lst, err := set.List()
+	if err != nil {
+		return err
+	}
+	for i, v := range lst {
+		err := snk.Pour(ctx, graph.HopChange{From: start, Max: int(dist), Feed: v, Added: true})
+		if err != nil {
+			return fmt.Errorf("hopsStream: failed to send item %d: %w", i, err)
+		}
+	}
+
+	return ls.forward(ctx, snk)
+}
+
+// liveQueueSize is how many changes a live call can fall behind before it is ended
+const liveQueueSize = 512
+
+var errLiveTooSlow = errors.New("friends: live stream fell too far behind")
+
+// liveSink queues broadcasted values for the stream of one call.
+// The broadcasts come from the indexing, so Pour never blocks. A call that can't keep up is ended instead.
+// Changes that happen while the current state is sent are queued and sent after it,
+// they might already be part of that state but applying them in order still gives the current one.
+type liveSink struct {
+	accept func(interface{}) bool
+
+	queue chan interface{}
+	errc  chan error
+}
+
+// newLiveSink returns a liveSink that only queues the values accept returns true for, or all of them if it is nil
+func newLiveSink(accept func(interface{}) bool) *liveSink {
+	return &liveSink{
+		accept: accept,
+		queue:  make(chan interface{}, liveQueueSize),
+		errc:   make(chan error, 1),
+	}
+}
+
+func (ls *liveSink) Pour(ctx context.Context, v interface{}) error {
+	if ls.accept != nil && !ls.accept(v) {
+		return nil
+	}
+	select {
+	case ls.queue <- v:
+	default:
+		ls.fail(errLiveTooSlow)
+	}
+	return nil
+}
+
+func (ls *liveSink) Close() error {
+	ls.fail(luigi.EOS{})
+	return nil
+}
+
+func (ls *liveSink) fail(err error) {
+	select {
+	case ls.errc <- err:
+	default:
+	}
+}
+
+// forward sends the queued values to snk until the call is done and closes it
+func (ls *liveSink) forward(ctx context.Context, snk luigi.Sink) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return snk.Close()
+		case err := <-ls.errc:
+			if !luigi.IsEOS(err) {
+				return err
+			}
+			// the broadcast ended, send what is left
+			for {
+				select {
+				case v := <-ls.queue:
+					if err := snk.Pour(ctx, v); err != nil {
+						return err
+					}
+				default:
+					return snk.Close()
+				}
+			}
+		case v := <-ls.queue:
+			if err := snk.Pour(ctx, v); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: MIT
+
+package friends
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+// testGraph is a graph builder that is fed contact messages directly
+type testGraph struct {
+	t testing.TB
+
+	builder  graph.Builder
+	notifier graph.Notifier
+	sink     librarian.SinkIndex
+	seq      int64
+}
+
+func newTestGraph(t testing.TB) (*testGraph, func()) {
+	tmp, err := ioutil.TempDir("", "friends")
+	require.NoError(t, err)
+
+	opts := badger.DefaultOptions(tmp)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	require.NoError(t, err)
+
+	b := graph.NewBuilder(testutils.NewRelativeTimeLogger(nil), db)
+	_, sink := b.OpenIndex()
+	tg := &testGraph{
+		t:        t,
+		builder:  b,
+		notifier: b,
+		sink:     sink,
+	}
+	return tg, func() {
+		db.Close()
+		os.RemoveAll(tmp)
+	}
+}
+
+func (tg *testGraph) contact(author, contact *ssb.FeedRef, following, blocking bool) {
+	var kv ssb.KeyValueRaw
+	kv.Value.Author = *author
+	kv.Value.Content = []byte(fmt.Sprintf(`{"type":"contact","contact":%q,"following":%v,"blocking":%v}`, contact.Ref(), following, blocking))
+	err := tg.sink.Pour(context.TODO(), margaret.WrapWithSeq(kv, margaret.BaseSeq(tg.seq)))
+	require.NoError(tg.t, err)
+	tg.seq++
+}
+
+func randomFeed(t testing.TB) *ssb.FeedRef {
+	id := make([]byte, 32)
+	_, err := rand.Read(id)
+	require.NoError(t, err)
+	return &ssb.FeedRef{ID: id, Algo: ssb.RefAlgoFeedSSB1}
+}
+
+func testRequest(t testing.TB, args ...interface{}) *muxrpc.Request {
+	raw, err := json.Marshal(args)
+	require.NoError(t, err)
+	return &muxrpc.Request{RawArgs: raw}
+}
+
+// chanSink collects what a source handler sends
+type chanSink struct {
+	ch     chan interface{}
+	closed chan struct{}
+}
+
+func newChanSink() *chanSink {
+	return &chanSink{
+		ch:     make(chan interface{}, 2*liveQueueSize),
+		closed: make(chan struct{}),
+	}
+}
+
+func (cs *chanSink) Pour(ctx context.Context, v interface{}) error {
+	cs.ch <- v
+	return nil
+}
+
+func (cs *chanSink) Close() error {
+	close(cs.closed)
+	return nil
+}
+
+func (cs *chanSink) next(t testing.TB) interface{} {
+	select {
+	case v := <-cs.ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the next value")
+		return nil
+	}
+}
+
+func TestStream(t *testing.T) {
+	r := require.New(t)
+
+	tg, done := newTestGraph(t)
+	defer done()
+
+	var (
+		self  = randomFeed(t)
+		alice = randomFeed(t)
+		bob   = randomFeed(t)
+	)
+	tg.contact(self, alice, true, false)
+
+	h := streamSrc{self: *self, builder: tg.builder, notifier: tg.notifier}
+
+	// without live the call ends after the current edges
+	snk := newChanSink()
+	r.NoError(h.HandleSource(context.TODO(), testRequest(t), snk))
+	e := snk.next(t).(graph.Edge)
+	r.True(e.From.Equal(self))
+	r.True(e.To.Equal(alice))
+	r.True(e.Following)
+	<-snk.closed
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	snk = newChanSink()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.HandleSource(ctx, testRequest(t, StreamArgs{Live: true}), snk)
+	}()
+	e = snk.next(t).(graph.Edge)
+	r.True(e.To.Equal(alice))
+
+	tg.contact(alice, bob, false, true)
+	e = snk.next(t).(graph.Edge)
+	r.True(e.From.Equal(alice))
+	r.True(e.To.Equal(bob))
+	r.True(e.Blocking)
+
+	cancel()
+	r.NoError(<-errc)
+	<-snk.closed
+	r.Len(snk.ch, 0, "sent more than expected")
+}
+
+func TestHopsStream(t *testing.T) {
+	r := require.New(t)
+
+	tg, done := newTestGraph(t)
+	defer done()
+
+	var (
+		self  = randomFeed(t)
+		alice = randomFeed(t)
+		bob   = randomFeed(t)
+	)
+	tg.contact(self, alice, true, false)
+
+	h := hopsStreamSrc{self: *self, builder: tg.builder, notifier: tg.notifier}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	snk := newChanSink()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.HandleSource(ctx, testRequest(t, HopsArgs{Max: 0}), snk)
+	}()
+	hc := snk.next(t).(graph.HopChange)
+	r.True(hc.Feed.Equal(alice))
+	r.True(hc.Added)
+
+	// changes of other hop counts are not sent
+	tg.builder.Hops(self, 1)
+	tg.contact(self, bob, true, false)
+	hc = snk.next(t).(graph.HopChange)
+	r.True(hc.Feed.Equal(bob))
+	r.True(hc.Added)
+	r.Equal(0, hc.Max)
+
+	tg.contact(self, alice, false, false)
+	hc = snk.next(t).(graph.HopChange)
+	r.True(hc.Feed.Equal(alice))
+	r.False(hc.Added)
+
+	cancel()
+	r.NoError(<-errc)
+	<-snk.closed
+	r.Len(snk.ch, 0, "sent more than expected")
+}
+
+func TestLiveSinkTooSlow(t *testing.T) {
+	r := require.New(t)
+
+	ls := newLiveSink(nil)
+	// the indexing must never block on a slow call
+	for i := 0; i < liveQueueSize+1; i++ {
+		r.NoError(ls.Pour(context.TODO(), i))
+	}
+
+	snk := newChanSink()
+	err := ls.forward(context.TODO(), snk)
+	r.Equal(errLiveTooSlow, err)
+}
+
+func TestLiveSinkDrainsOnClose(t *testing.T) {
+	r := require.New(t)
+
+	ls := newLiveSink(func(v interface{}) bool { return v.(int)%2 == 0 })
+	for i := 0; i < 10; i++ {
+		r.NoError(ls.Pour(context.TODO(), i))
+	}
+	r.NoError(ls.Close())
+
+	snk := newChanSink()
+	r.NoError(ls.forward(context.TODO(), snk))
+	<-snk.closed
+	var got []int
+	for len(snk.ch) > 0 {
+		got = append(got, (<-snk.ch).(int))
+	}
+	r.Equal([]int{0, 2, 4, 6, 8}, got)
+}
@@ -22,6 +22,7 @@ import (
 	"go.cryptoscope.co/ssb/internal/mutil"
 	"go.cryptoscope.co/ssb/internal/transform"
 	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
 )
 
 // FeedManager handles serving gossip about User Feeds.
@@ -213,7 +214,15 @@ func (m *FeedManager) CreateStreamHistory(
 	}
 
 	if arg.Seq != 0 {
-		arg.Seq--             // our idx is 0 ed
+		// feeds that were cut down to their storage limits might not start at the first message anymore
+		offset, err := multilogs.FeedOffset(m.RootLog, userLog)
+		if err != nil {
+			return errors.Wrap(err, "userLog offset")
+		}
+		arg.Seq -= 1 + offset // our idx is 0 ed
+		if arg.Seq < 0 {
+			arg.Seq = 0
+		}
 		if arg.Seq > latest { // more than we got
 			return errors.Wrap(sink.Close(), "pour: failed to close")
 		}
@@ -233,6 +242,8 @@ func (m *FeedManager) CreateStreamHistory(
 	if err != nil {
 		return errors.Wrapf(err, "invalid user log query")
 	}
+	// feeds that were cut down to their storage limits are served from where they start now
+	src = skipNulled{src}
 
 	switch arg.ID.Algo {
 	case ssb.RefAlgoFeedSSB1:
@@ -168,10 +168,12 @@ func (g *handler) fetchFeed(
 				return errors.Errorf("fetch: wrong message type. expected %T - got %T", latestMsg, msgV)
 			}
 
-			// make sure our house is in order
-			if hasSeq := latestMsg.Seq(); hasSeq != latestSeq.Seq() {
+			// make sure our house is in order.
+			// feeds that were cut down to their storage limits start later once the root log is compacted, see multilogs.FeedOffset
+			if hasSeq := latestMsg.Seq(); hasSeq < latestSeq.Seq() {
 				return ssb.ErrWrongSequence{Ref: fr, Stored: latestMsg, Logical: latestSeq}
 			}
+			latestSeq = margaret.BaseSeq(latestMsg.Seq())
 		}
 	}
 
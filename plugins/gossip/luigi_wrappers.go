@@ -7,6 +7,7 @@ import (
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/muxrpc/codec"
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/message/multimsg"
@@ -62,3 +63,18 @@ func newSinkCounter(counter *int, sink luigi.Sink) luigi.FuncSink {
 		return sink.Pour(ctx, v)
 	}
 }
+
+// skipNulled drops the entries of the source that were nulled
+type skipNulled struct {
+	luigi.Source
+}
+
+func (sn skipNulled) Next(ctx context.Context) (interface{}, error) {
+	for {
+		v, err := sn.Source.Next(ctx)
+		if err != nil && margaret.IsErrNulled(errors.Cause(err)) {
+			continue
+		}
+		return v, err
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: MIT
+
+// Package identities offers identities.list and identities.create, to manage the identities the bot publishes and reads private messages for.
+// The publish and private calls take the identity to use in their as argument.
+// identities.migrate moves one of them to another, see ssb.FeedMigration.
+package identities
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cryptix/go/logging"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/muxmux"
+)
+
+// Manager lists and creates the identities of the bot
+type Manager interface {
+	Identities() []*ssb.KeyPair
+	CreateIdentity(name, algo string) (*ssb.KeyPair, error)
+	MigrateFeed(old, new *ssb.FeedRef) (announced, countersigned *ssb.MessageRef, err error)
+}
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+// New returns the identities plugin. It should only be registered on the master (local) handler.
+func New(log logging.Interface, m Manager) ssb.Plugin {
+	mux := muxmux.New(log)
+	mux.RegisterAsync(muxrpc.Method{"identities", "list"}, listH{m: m})
+	mux.RegisterAsync(muxrpc.Method{"identities", "create"}, createH{m: m})
+	mux.RegisterAsync(muxrpc.Method{"identities", "migrate"}, migrateH{m: m})
+	return plugin{h: &mux}
+}
+
+func (plugin) Name() string              { return "identities" }
+func (plugin) Method() muxrpc.Method     { return muxrpc.Method{"identities"} }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+type listH struct {
+	m Manager
+}
+
+// HandleAsync of identities.list returns the feed references of the identities, the one of the bot first
+func (h listH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	kps := h.m.Identities()
+	refs := make([]string, len(kps))
+	for i, kp := range kps {
+		refs[i] = kp.Id.Ref()
+	}
+	return refs, nil
+}
+
+// CreateArgs are the optional arguments of identities.create
+type CreateArgs struct {
+	// Name is the file name of the secret in the repo, a free one is picked if it's empty
+	Name string `json:"name"`
+
+	// Algo is ed25519 (default) or ggfeed-v1
+	Algo string `json:"algo"`
+}
+
+type createH struct {
+	m Manager
+}
+
+// HandleAsync of identities.create returns the feed reference of the new identity
+func (h createH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []CreateArgs
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			return nil, fmt.Errorf("identities.create: invalid arguments: %w", err)
+		}
+	}
+	var a CreateArgs
+	if len(args) > 0 {
+		a = args[0]
+	}
+	if a.Algo == "" {
+		a.Algo = ssb.RefAlgoFeedSSB1
+	}
+
+	kp, err := h.m.CreateIdentity(a.Name, a.Algo)
+	if err != nil {
+		return nil, fmt.Errorf("identities.create: %w", err)
+	}
+	return kp.Id.Ref(), nil
+}
+
+// MigrateArgs are the arguments of identities.migrate, both need to be local identities
+type MigrateArgs struct {
+	Old *ssb.FeedRef `json:"old"`
+	New *ssb.FeedRef `json:"new"`
+}
+
+// MigrateResult are the two messages of a migration
+type MigrateResult struct {
+	Announced     *ssb.MessageRef `json:"announced"`
+	Countersigned *ssb.MessageRef `json:"countersigned"`
+}
+
+type migrateH struct {
+	m Manager
+}
+
+// HandleAsync of identities.migrate publishes the feed/migrate messages on both feeds
+func (h migrateH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []MigrateArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("identities.migrate: invalid arguments: %w", err)
+	}
+	if len(args) != 1 || args[0].Old == nil || args[0].New == nil {
+		return nil, fmt.Errorf("identities.migrate: expected one argument with old and new")
+	}
+
+	announced, countersigned, err := h.m.MigrateFeed(args[0].Old, args[0].New)
+	if err != nil {
+		return nil, fmt.Errorf("identities.migrate: %w", err)
+	}
+	return MigrateResult{Announced: announced, Countersigned: countersigned}, nil
+}
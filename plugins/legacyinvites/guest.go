@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/muxrpc"
@@ -37,14 +38,9 @@ func (h acceptHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp
 
 	if len(args) != 1 {
 		req.CloseWithError(fmt.Errorf("invalid argument count"))
-	}
-	arg := args[0]
-
-	// lookup guest key
-	if err := h.service.kv.BeginTransaction(); err != nil {
-		req.CloseWithError(err)
 		return
 	}
+	arg := args[0]
 
 	guestRef, err := ssb.GetFeedRefFromAddr(edp.Remote())
 	if err != nil {
@@ -52,59 +48,8 @@ func (h acceptHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp
 		return
 	}
 
-	kvKey := []byte(guestRef.StoredAddr())
-
-	has, err := h.service.kv.Get(nil, kvKey)
+	st, err := h.service.use(guestRef, arg.Feed)
 	if err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed get guest remote from KV (%w)", err)
-		req.CloseWithError(err)
-		return
-	}
-	if has == nil {
-		h.service.kv.Rollback()
-		err = errors.New("not for us")
-		req.CloseWithError(err)
-		return
-	}
-
-	var st inviteState
-	if err := json.Unmarshal(has, &st); err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed to probe new key (%w)", err)
-		req.CloseWithError(err)
-		return
-	}
-
-	if st.Used >= st.Uses {
-		h.service.kv.Delete(kvKey)
-		h.service.kv.Commit()
-		err = fmt.Errorf("invite/kv: invite depleeted")
-		req.CloseWithError(err)
-		return
-	}
-
-	// count uses
-	st.Used++
-
-	updatedState, err := json.Marshal(st)
-	if err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed marshal updated state data (%w)", err)
-		req.CloseWithError(err)
-		return
-	}
-	err = h.service.kv.Set(kvKey, updatedState)
-	if err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed save updated state data (%w)", err)
-		req.CloseWithError(err)
-		return
-	}
-	err = h.service.kv.Commit()
-	if err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed to commit kv transaction (%w)", err)
 		req.CloseWithError(err)
 		return
 	}
@@ -133,4 +78,11 @@ func (h acceptHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp
 	req.Return(ctx, msgv)
 
 	h.service.logger.Log("invite", "used")
+
+	now := time.Now()
+	for _, fn := range h.service.onRedeem {
+		if err := fn(arg.Feed, now); err != nil {
+			h.service.logger.Log("invite", "redeem hook failed", "feed", arg.Feed.Ref(), "err", err)
+		}
+	}
 }
@@ -6,9 +6,12 @@ import (
 	"fmt"
 
 	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/invite"
 )
 
-// supplies create, use and other managment calls (maybe list and delete?)
+// supplies create, list and revoke
 type masterPlug struct {
 	service *Service
 }
@@ -31,39 +34,113 @@ type createHandler struct {
 	service *Service
 }
 
-type createArguments struct {
+// CreateArguments are the options for invite.create
+type CreateArguments struct {
 	// how many times this invite should be useable
 	Uses uint `json:"uses"`
 
 	// a note to organize invites (also posted when used)
 	Note string `json:"note,omitempty"`
+
+	// unix timestamp (in milliseconds) after which the invite can't be used anymore (optional)
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// RevokeArguments are the options for invite.revoke
+// Either the ID (from invite.list) or the full invite code can be used.
+type RevokeArguments struct {
+	ID     *ssb.FeedRef `json:"id,omitempty"`
+	Invite string       `json:"invite,omitempty"`
 }
 
 func (h createHandler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
 
 func (h createHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
-	if req.Method.String() != "invite.create" {
+	switch req.Method.String() {
+	case "invite.create":
+		h.create(ctx, req)
+	case "invite.list":
+		h.list(ctx, req)
+	case "invite.revoke":
+		h.revoke(ctx, req)
+	default:
 		req.CloseWithError(fmt.Errorf("unknown method"))
-		return
 	}
+}
 
+func (h createHandler) create(ctx context.Context, req *muxrpc.Request) {
 	// parse passed arguments
-	var args createArguments
-	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
-		args.Uses = 1
+	var args []CreateArguments
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil || len(args) != 1 {
+		// ssb-server also accepts just the number of uses
+		var uses []uint
+		if err := json.Unmarshal(req.RawArgs, &uses); err == nil && len(uses) == 1 {
+			args = []CreateArguments{{Uses: uses[0]}}
+		} else {
+			args = []CreateArguments{{Uses: 1}}
+		}
 	}
+	arg := args[0]
 
-	if args.Uses == 0 {
+	if arg.Uses == 0 {
 		req.CloseWithError(fmt.Errorf("cant create invite with zero uses"))
 		return
 	}
 
-	inv, err := h.service.Create(args.Uses, args.Note)
+	inv, err := h.service.Create(arg)
 	if err != nil {
 		req.CloseWithError(fmt.Errorf("failed to create invite"))
 		return
 	}
 
 	req.Return(ctx, inv.String())
-	h.service.logger.Log("invite", "created", "uses", args.Uses)
+	h.service.logger.Log("invite", "created", "uses", arg.Uses)
+}
+
+func (h createHandler) list(ctx context.Context, req *muxrpc.Request) {
+	lst, err := h.service.List()
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	req.Return(ctx, lst)
+}
+
+func (h createHandler) revoke(ctx context.Context, req *muxrpc.Request) {
+	var args []RevokeArguments
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		req.CloseWithError(fmt.Errorf("invalid arguments (%w)", err))
+		return
+	}
+	if len(args) != 1 {
+		req.CloseWithError(fmt.Errorf("invalid argument count"))
+		return
+	}
+	arg := args[0]
+
+	id := arg.ID
+	if arg.Invite != "" {
+		tok, err := invite.ParseLegacyToken(arg.Invite)
+		if err != nil {
+			req.CloseWithError(fmt.Errorf("invalid invite (%w)", err))
+			return
+		}
+		id, err = tok.GuestID()
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+	}
+	if id == nil {
+		req.CloseWithError(fmt.Errorf("need either id or invite to revoke"))
+		return
+	}
+
+	if err := h.service.Revoke(id); err != nil {
+		req.CloseWithError(err)
+		return
+	}
+
+	req.Return(ctx, fmt.Sprintf("revoked %s", id.Ref()))
+	h.service.logger.Log("invite", "revoked", "id", id.Ref())
 }
@@ -5,7 +5,9 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
@@ -29,6 +31,18 @@ type Service struct {
 
 	mu sync.Mutex
 	kv *kv.DB
+
+	onRedeem []RedeemFunc
+}
+
+// RedeemFunc is called with the feed that was followed for a redeemed invite
+type RedeemFunc func(feed *ssb.FeedRef, when time.Time) error
+
+// OnRedeem registers fn to be called after the follow for a redeemed invite was published
+func (s *Service) OnRedeem(fn RedeemFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRedeem = append(s.onRedeem, fn)
 }
 
 func (s *Service) GuestHandler() muxrpc.Handler {
@@ -47,32 +61,19 @@ func (s *Service) Authorize(to *ssb.FeedRef) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	kvKey := []byte(to.StoredAddr())
-
 	if err := s.kv.BeginTransaction(); err != nil {
 		return err
 	}
 
-	has, err := s.kv.Get(nil, kvKey)
+	st, err := s.getState(to)
 	if err != nil {
 		s.kv.Rollback()
-		return fmt.Errorf("invite/auth: failed get guest remote from KV (%w)", err)
-	}
-	if has == nil {
-		s.kv.Rollback()
-		return errors.New("not for us")
+		return fmt.Errorf("invite/auth: %w", err)
 	}
 
-	var st inviteState
-	if err := json.Unmarshal(has, &st); err != nil {
+	if err := st.usable(time.Now()); err != nil {
 		s.kv.Rollback()
-		return fmt.Errorf("invite/auth: failed to probe new key (%w)", err)
-	}
-
-	if st.Used >= st.Uses {
-		s.kv.Delete(kvKey)
-		s.kv.Commit()
-		return fmt.Errorf("invite/auth: invite depleeted")
+		return fmt.Errorf("invite/auth: %w", err)
 	}
 
 	return s.kv.Commit()
@@ -107,9 +108,11 @@ func New(
 }
 
 // Close closes the underlying key-value database
-func (s Service) Close() error { return s.kv.Close() }
+func (s *Service) Close() error { return s.kv.Close() }
 
-func (s Service) Create(uses uint, note string) (*invite.Token, error) {
+// Create makes a new invite with the passed arguments and returns the token for it.
+// Expires is optional. If it's set the invite can't be used after that point in time.
+func (s *Service) Create(args CreateArguments) (*invite.Token, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if err := s.kv.BeginTransaction(); err != nil {
@@ -139,32 +142,217 @@ func (s Service) Create(uses uint, note string) (*invite.Token, error) {
 		}
 	}
 
-	// store pub key with params (ties, note)
+	// store pub key with params (ties, note, expiry)
 	st := inviteState{Used: 0}
-	st.Uses = uses
-	st.Note = note
+	st.CreateArguments = args
+	st.Created = time.Now().Unix() * 1000
 
-	data, err := json.Marshal(st)
+	if err := s.setState(seedRef, st); err != nil {
+		s.kv.Rollback()
+		return nil, fmt.Errorf("invite/create: %w", err)
+	}
+
+	inv.Peer = *s.self
+	// TODO: external host configuration?
+	inv.Address = s.network.GetListenAddr()
+
+	return &inv, s.kv.Commit()
+}
+
+// List returns all the invites that were created and not revoked yet, including depleted and expired ones.
+func (s *Service) List() ([]Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enum, err := s.kv.SeekFirst()
+	if err == io.EOF {
+		return []Invite{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invite/list: failed to start iterating (%w)", err)
+	}
+
+	var lst []Invite
+	for {
+		k, v, err := enum.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invite/list: failed to get next entry (%w)", err)
+		}
+
+		var sr ssb.StorageRef
+		if err := sr.Unmarshal(k); err != nil {
+			return nil, fmt.Errorf("invite/list: invalid key (%w)", err)
+		}
+		id, err := sr.FeedRef()
+		if err != nil {
+			return nil, fmt.Errorf("invite/list: invalid key (%w)", err)
+		}
+
+		var st inviteState
+		if err := json.Unmarshal(v, &st); err != nil {
+			return nil, fmt.Errorf("invite/list: failed to decode state of %s (%w)", id.Ref(), err)
+		}
+
+		lst = append(lst, Invite{
+			ID:              id,
+			CreateArguments: st.CreateArguments,
+			Used:            st.Used,
+			Created:         st.Created,
+			RedeemedBy:      st.RedeemedBy,
+		})
+	}
+	if lst == nil {
+		lst = []Invite{}
+	}
+	return lst, nil
+}
+
+// Revoke removes the invite with the passed ID. It can't be used afterwards.
+func (s *Service) Revoke(id *ssb.FeedRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.kv.BeginTransaction(); err != nil {
+		return err
+	}
+
+	kvKey := []byte(id.StoredAddr())
+
+	has, err := s.kv.Get(nil, kvKey)
 	if err != nil {
 		s.kv.Rollback()
-		return nil, fmt.Errorf("invite/create: failed to marshal state data (%w)", err)
+		return fmt.Errorf("invite/revoke: failed get invite from KV (%w)", err)
 	}
+	if has == nil {
+		s.kv.Rollback()
+		return ErrNoSuchInvite
+	}
+
+	if err := s.kv.Delete(kvKey); err != nil {
+		s.kv.Rollback()
+		return fmt.Errorf("invite/revoke: failed to delete invite (%w)", err)
+	}
+	return s.kv.Commit()
+}
 
-	err = s.kv.Set([]byte(seedRef.StoredAddr()), data)
+// use counts a use of the invite by guest and notes who redeemed it.
+// The caller needs to hold the lock.
+func (s *Service) use(guest, redeemer *ssb.FeedRef) (*inviteState, error) {
+	if err := s.kv.BeginTransaction(); err != nil {
+		return nil, err
+	}
+
+	st, err := s.getState(guest)
 	if err != nil {
 		s.kv.Rollback()
-		return nil, fmt.Errorf("invite/create: failed to store state data (%w)", err)
+		return nil, fmt.Errorf("invite/kv: %w", err)
 	}
 
-	inv.Peer = *s.self
-	// TODO: external host configuration?
-	inv.Address = s.network.GetListenAddr()
+	now := time.Now()
+	if err := st.usable(now); err != nil {
+		s.kv.Rollback()
+		return nil, fmt.Errorf("invite/kv: %w", err)
+	}
 
-	return &inv, s.kv.Commit()
+	// count uses
+	st.Used++
+	st.RedeemedBy = append(st.RedeemedBy, Redemption{
+		Feed:      redeemer,
+		Timestamp: now.Unix() * 1000,
+	})
+
+	if err := s.setState(guest, *st); err != nil {
+		s.kv.Rollback()
+		return nil, fmt.Errorf("invite/kv: %w", err)
+	}
+
+	if err := s.kv.Commit(); err != nil {
+		s.kv.Rollback()
+		return nil, fmt.Errorf("invite/kv: failed to commit kv transaction (%w)", err)
+	}
+	return st, nil
 }
 
+// getState needs to be called inside a transaction
+func (s *Service) getState(guest *ssb.FeedRef) (*inviteState, error) {
+	has, err := s.kv.Get(nil, []byte(guest.StoredAddr()))
+	if err != nil {
+		return nil, fmt.Errorf("failed get guest remote from KV (%w)", err)
+	}
+	if has == nil {
+		return nil, errors.New("not for us")
+	}
+
+	var st inviteState
+	if err := json.Unmarshal(has, &st); err != nil {
+		return nil, fmt.Errorf("failed to decode state data (%w)", err)
+	}
+	return &st, nil
+}
+
+// setState needs to be called inside a transaction
+func (s *Service) setState(guest *ssb.FeedRef, st inviteState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state data (%w)", err)
+	}
+
+	err = s.kv.Set([]byte(guest.StoredAddr()), data)
+	if err != nil {
+		return fmt.Errorf("failed to store state data (%w)", err)
+	}
+	return nil
+}
+
+// ErrNoSuchInvite is returned by Revoke if the invite doesn't exist (anymore)
+var ErrNoSuchInvite = errors.New("invite: no such invite")
+
+var (
+	errDepleted = errors.New("invite depleeted")
+	errExpired  = errors.New("invite expired")
+)
+
 type inviteState struct {
-	createArguments
+	CreateArguments
 
 	Used uint // how many times this invite was used already
+
+	Created    int64        `json:"created,omitempty"`
+	RedeemedBy []Redemption `json:"redeemedBy,omitempty"`
+}
+
+func (st inviteState) usable(now time.Time) error {
+	if st.Used >= st.Uses {
+		return errDepleted
+	}
+	if st.Expires != 0 && now.Unix()*1000 > st.Expires {
+		return errExpired
+	}
+	return nil
+}
+
+// Invite is the state of an invite as returned by invite.list
+type Invite struct {
+	// ID is the public key of the invite, which the guest uses to connect
+	ID *ssb.FeedRef `json:"id"`
+
+	CreateArguments
+
+	// how many times this invite was used already
+	Used uint `json:"used"`
+
+	// when the invite was created (unix timestamp in milliseconds)
+	Created int64 `json:"created,omitempty"`
+
+	// who used this invite
+	RedeemedBy []Redemption `json:"redeemedBy"`
+}
+
+// Redemption notes who used an invite and when
+type Redemption struct {
+	Feed      *ssb.FeedRef `json:"feed"`
+	Timestamp int64        `json:"timestamp"`
 }
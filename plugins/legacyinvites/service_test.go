@@ -0,0 +1,97 @@
+package legacyinvites
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+type listenAddrNetwork struct {
+	ssb.Network
+	addr net.Addr
+}
+
+func (n listenAddrNetwork) GetListenAddr() net.Addr { return n.addr }
+
+func TestManageInvites(t *testing.T) {
+	r := require.New(t)
+
+	testRepo := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testRepo)
+
+	self := &ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	nw := listenAddrNetwork{
+		addr: netwrap.WrapAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008}, secretstream.Addr{PubKey: self.PubKey()}),
+	}
+
+	s, err := New(log.NewNopLogger(), repo.New(testRepo), self, nw, nil, nil)
+	r.NoError(err)
+	defer s.Close()
+
+	lst, err := s.List()
+	r.NoError(err)
+	r.Len(lst, 0)
+
+	tok, err := s.Create(CreateArguments{Uses: 2, Note: "two friends"})
+	r.NoError(err)
+	guest, err := tok.GuestID()
+	r.NoError(err)
+
+	r.NoError(s.Authorize(guest))
+
+	friend := &ssb.FeedRef{ID: bytes.Repeat([]byte{2}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	_, err = s.use(guest, friend)
+	r.NoError(err)
+
+	lst, err = s.List()
+	r.NoError(err)
+	r.Len(lst, 1)
+	r.True(lst[0].ID.Equal(guest))
+	r.Equal("two friends", lst[0].Note)
+	r.EqualValues(2, lst[0].Uses)
+	r.EqualValues(1, lst[0].Used)
+	r.NotZero(lst[0].Created)
+	r.Len(lst[0].RedeemedBy, 1)
+	r.True(lst[0].RedeemedBy[0].Feed.Equal(friend))
+
+	// second use depletes it but keeps the history
+	_, err = s.use(guest, friend)
+	r.NoError(err)
+	r.Error(s.Authorize(guest))
+	_, err = s.use(guest, friend)
+	r.Error(err)
+
+	lst, err = s.List()
+	r.NoError(err)
+	r.Len(lst, 1)
+	r.Len(lst[0].RedeemedBy, 2)
+
+	// expired invites can't be used
+	expiredTok, err := s.Create(CreateArguments{Uses: 1, Expires: time.Now().Add(-time.Minute).Unix() * 1000})
+	r.NoError(err)
+	expired, err := expiredTok.GuestID()
+	r.NoError(err)
+	r.Error(s.Authorize(expired))
+	_, err = s.use(expired, friend)
+	r.Error(err)
+
+	// revoke
+	r.NoError(s.Revoke(guest))
+	r.NoError(s.Revoke(expired))
+	r.Equal(ErrNoSuchInvite, s.Revoke(expired))
+
+	lst, err = s.List()
+	r.NoError(err)
+	r.Len(lst, 0)
+}
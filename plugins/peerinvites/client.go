@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: MIT
+
+package peerinvites
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+	"go.cryptoscope.co/ssb/message/legacy"
+	multiserver "go.mindeco.de/ssb-multiserver"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/client"
+)
+
+// AppKey is the secret-handshake capability that is used to connect to pubs
+type AppKey []byte
+
+// HMACSecret is used to verify the invite message if the network uses hmac signing
+type HMACSecret *[32]byte
+
+// CreateArgs are the options for peerInvites.create
+type CreateArgs struct {
+	// Pubs are multiserver addresses of peers that replicate us and will confirm the invite.
+	// If none are passed, the own address is used.
+	Pubs []string `json:"pubs,omitempty"`
+
+	// AllowWithoutPubs creates the invite even if no pub is known
+	AllowWithoutPubs bool `json:"allowWithoutPubs,omitempty"`
+}
+
+// InviteContent is the content of a peer-invite message
+type InviteContent struct {
+	Type   string       `json:"type"`
+	Invite *ssb.FeedRef `json:"invite"`
+	Host   *ssb.FeedRef `json:"host"`
+}
+
+// OpenedInvite is returned by openInvite
+type OpenedInvite struct {
+	Message json.RawMessage `json:"msg"`
+	Content InviteContent   `json:"content"`
+}
+
+type masterPlug struct {
+	p Plugin
+}
+
+func (mp masterPlug) Name() string            { return mp.p.Name() }
+func (mp masterPlug) Method() muxrpc.Method   { return mp.p.Method() }
+func (mp masterPlug) Handler() muxrpc.Handler { return mp.p.c }
+
+type clientHandler struct {
+	*handler
+
+	self *ssb.FeedRef
+
+	appKey     AppKey
+	hmacSecret HMACSecret
+	network    ssb.Network
+}
+
+func (c *clientHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	var (
+		v   interface{}
+		err error
+	)
+	switch req.Method.String() {
+	case "peerInvites.create":
+		var args []CreateArgs
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil || len(args) != 1 {
+			args = []CreateArgs{{}}
+		}
+		var tok *Token
+		tok, err = c.Create(args[0])
+		if err == nil {
+			v = tok.String()
+		}
+
+	case "peerInvites.openInvite":
+		var tok Token
+		tok, err = tokenFromArgs(req)
+		if err == nil {
+			v, err = c.Open(ctx, tok)
+		}
+
+	case "peerInvites.acceptInvite":
+		var tok Token
+		tok, err = tokenFromArgs(req)
+		if err == nil {
+			v, err = c.Accept(ctx, tok)
+		}
+
+	default:
+		c.handler.HandleCall(ctx, req, edp)
+		return
+	}
+
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	req.Return(ctx, v)
+}
+
+func tokenFromArgs(req *muxrpc.Request) (Token, error) {
+	var args []string
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return Token{}, errors.Wrap(err, "peerInvites: expected invite as argument")
+	}
+	if len(args) != 1 {
+		return Token{}, errors.Errorf("peerInvites: expected one argument, got %d", len(args))
+	}
+	return ParseToken(args[0])
+}
+
+// Create publishes a new peer-invite message and returns the invite for the guest
+func (c *clientHandler) Create(args CreateArgs) (*Token, error) {
+	var tok Token
+
+	tok.Pubs = args.Pubs
+	if len(tok.Pubs) == 0 && c.network != nil {
+		if addr := c.ownAddress(); addr != "" {
+			tok.Pubs = []string{addr}
+		}
+	}
+	if len(tok.Pubs) == 0 && !args.AllowWithoutPubs {
+		return nil, errors.Errorf("peerInvites/create: no pubs to confirm the invite")
+	}
+
+	rand.Read(tok.Seed[:])
+	guest, err := tok.GuestKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := signContent(guest, InviteContent{
+		Type:   "peer-invite",
+		Invite: guest.Id,
+		Host:   c.self,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tok.Invite, err = c.pub.Publish(signed)
+	if err != nil {
+		return nil, errors.Wrap(err, "peerInvites/create: failed to publish invite")
+	}
+	return &tok, nil
+}
+
+func (c *clientHandler) ownAddress() string {
+	tcpAddr := netwrap.GetAddr(c.network.GetListenAddr(), "tcp")
+	if tcpAddr == nil {
+		return ""
+	}
+	return fmt.Sprintf("net:%s~shs:%s", tcpAddr.String(), base64.StdEncoding.EncodeToString(c.self.PubKey()))
+}
+
+// Open fetches the invite message from one of the pubs and checks it
+func (c *clientHandler) Open(ctx context.Context, tok Token) (*OpenedInvite, error) {
+	cl, opened, err := c.dialPub(ctx, tok)
+	if err != nil {
+		return nil, err
+	}
+	cl.Close()
+	return opened, nil
+}
+
+// Accept publishes an accept message for the invite and asks the pub to confirm it
+func (c *clientHandler) Accept(ctx context.Context, tok Token) (json.RawMessage, error) {
+	cl, _, err := c.dialPub(ctx, tok)
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
+
+	guest, err := tok.GuestKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := signContent(guest, acceptContent{
+		Type:    "peer-invite/accept",
+		Receipt: tok.Invite,
+		ID:      c.self,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := c.pub.Append(signed)
+	if err != nil {
+		return nil, errors.Wrap(err, "peerInvites/accept: failed to publish accept message")
+	}
+
+	v, err := c.rl.Get(seq)
+	if err != nil {
+		return nil, errors.Wrap(err, "peerInvites/accept: failed to get accept message")
+	}
+	acceptMsg, ok := v.(ssb.Message)
+	if !ok {
+		return nil, errors.Errorf("peerInvites/accept: wrong message type in storage: %T", v)
+	}
+
+	reply, err := cl.Async(ctx, json.RawMessage{}, muxrpc.Method{"peerInvites", "confirm"}, acceptMsg.ValueContentJSON())
+	if err != nil {
+		return nil, errors.Wrap(err, "peerInvites/accept: pub didn't confirm")
+	}
+	confirm, ok := reply.(json.RawMessage)
+	if !ok {
+		return nil, errors.Errorf("peerInvites/accept: unexpected confirm reply: %T", reply)
+	}
+	return confirm, nil
+}
+
+// dialPub tries the pubs of the token in order, using the guest key, and returns the first one that has the invite
+func (c *clientHandler) dialPub(ctx context.Context, tok Token) (*client.Client, *OpenedInvite, error) {
+	guest, err := tok.GuestKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(tok.Pubs) == 0 {
+		return nil, nil, errors.Errorf("peerInvites: invite has no pubs")
+	}
+
+	opts := []client.Option{client.WithContext(ctx)}
+	if c.appKey != nil {
+		opts = append(opts, client.WithSHSAppKey(base64.StdEncoding.EncodeToString(c.appKey)))
+	}
+
+	var lastErr error
+	for _, pub := range tok.Pubs {
+		msaddr, err := multiserver.ParseNetAddress([]byte(pub))
+		if err != nil {
+			lastErr = errors.Wrapf(err, "peerInvites: invalid pub address %q", pub)
+			continue
+		}
+		addr := netwrap.WrapAddr(&msaddr.Addr, secretstream.Addr{PubKey: msaddr.Ref.PubKey()})
+
+		cl, err := client.NewTCP(guest, addr, opts...)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "peerInvites: failed to connect to pub %s", msaddr.Ref.ShortRef())
+			continue
+		}
+
+		opened, err := c.getInvite(ctx, cl, tok, guest.Id)
+		if err != nil {
+			cl.Close()
+			lastErr = err
+			continue
+		}
+		return cl, opened, nil
+	}
+	return nil, nil, lastErr
+}
+
+func (c *clientHandler) getInvite(ctx context.Context, cl *client.Client, tok Token, guest *ssb.FeedRef) (*OpenedInvite, error) {
+	reply, err := cl.Async(ctx, json.RawMessage{}, muxrpc.Method{"peerInvites", "getInvite"}, tok.Invite.Ref())
+	if err != nil {
+		return nil, errors.Wrap(err, "peerInvites: failed to get invite")
+	}
+	raw, ok := reply.(json.RawMessage)
+	if !ok {
+		return nil, errors.Errorf("peerInvites: unexpected getInvite reply: %T", reply)
+	}
+
+	ref, dmsg, err := legacy.Verify(raw, c.hmacSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "peerInvites: invalid invite message")
+	}
+	if !ref.Equal(*tok.Invite) {
+		return nil, errors.Errorf("peerInvites: pub returned the wrong message")
+	}
+
+	var opened OpenedInvite
+	opened.Message = raw
+	if err := json.Unmarshal(dmsg.Content, &opened.Content); err != nil {
+		return nil, errors.Wrap(err, "peerInvites: failed to decode invite")
+	}
+	if opened.Content.Type != "peer-invite" || opened.Content.Invite == nil || !opened.Content.Invite.Equal(guest) {
+		return nil, errors.Errorf("peerInvites: not a valid invite for this seed")
+	}
+	if err := verifyContent(dmsg.Content, guest); err != nil {
+		return nil, errors.Wrap(err, "peerInvites: invite not signed by the guest key")
+	}
+	return &opened, nil
+}
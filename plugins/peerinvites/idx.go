@@ -7,7 +7,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 
 	"github.com/cryptix/go/logging"
 	"github.com/dgraph-io/badger"
@@ -17,57 +16,59 @@ import (
 	"go.cryptoscope.co/librarian"
 	libbadger "go.cryptoscope.co/librarian/badger"
 	"go.cryptoscope.co/margaret"
-	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
-	"go.cryptoscope.co/ssb/message/legacy"
-	"golang.org/x/crypto/nacl/auth"
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/repo"
 )
 
 type Plugin struct {
-	tl multilog.MultiLog
-	rl margaret.Log
-
 	logger logging.Interface
 
-	h handler
+	h *handler
+	c *clientHandler
 }
 
 func (p Plugin) Name() string {
-	return "get"
+	return "peerInvites"
 }
 
 func (p Plugin) Method() muxrpc.Method {
 	return muxrpc.Method{"peerInvites"}
 }
 
+// Handler supplies the calls that peers and guests can make: willReplicate, getInvite and confirm
 func (p Plugin) Handler() muxrpc.Handler {
 	return p.h
 }
 
+// MasterPlugin supplies create, openInvite and acceptInvite on top of the calls of Handler
+func (p Plugin) MasterPlugin() ssb.Plugin {
+	return masterPlug{p}
+}
+
 const FolderNameInvites = "peerInvites"
 
-func (p *Plugin) OpenIndex(r repo.Interface) (librarian.Index, repo.ServeFunc, error) {
-	db, sinkIdx, serve, err := repo.OpenBadgerIndex(r, FolderNameInvites, p.updateIndex)
+// OpenIndex opens the index of published invites and their confirmations.
+// It needs to be fed the root log for Authorize to work.
+func (p *Plugin) OpenIndex(r repo.Interface) (librarian.SeqSetterIndex, librarian.SinkIndex, error) {
+	_, idx, sinkIdx, err := repo.OpenBadgerIndex(r, FolderNameInvites, p.updateIndex)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "error getting index")
 	}
-	nextServe := func(ctx context.Context, log margaret.Log, live bool) error {
-		err := serve(ctx, log, live)
-		if err != nil {
-			return err
-		}
-		return db.Close()
-	}
-	return sinkIdx, nextServe, nil
+	return idx, sinkIdx, nil
 }
 
-func (p *Plugin) updateIndex(db *badger.DB) librarian.SinkIndex {
+func (p *Plugin) updateIndex(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
 	p.h.state = libbadger.NewIndex(db, true)
 
 	idxSink := librarian.NewSinkIndex(func(ctx context.Context, seq margaret.Seq, val interface{}, idx librarian.SetterIndex) error {
+		if nulled, ok := val.(error); ok {
+			if margaret.IsErrNulled(nulled) {
+				return nil
+			}
+			return nulled
+		}
 		msg, ok := val.(ssb.Message)
 		if !ok {
 			return fmt.Errorf("unexpeced stored message type: %T", val)
@@ -85,18 +86,18 @@ func (p *Plugin) updateIndex(db *badger.DB) librarian.SinkIndex {
 		switch msgType.Type {
 		case "peer-invite":
 			err = p.indexNewInvite(ctx, msg)
-			p.logger.Log("newInvite", err, "msg", msg.Key().Ref())
-			return err
 		case "peer-invite/confirm":
-			err := p.indexConfirm(ctx, msg)
-			p.logger.Log("confirmed", err)
-			return err
+			err = p.indexConfirm(ctx, msg)
 		default:
-			// p.logger.Log("skipped", msg.Key().Ref(), "why", "wrong type", "type", msgType.Type)
 			return nil // skip
 		}
+		if err != nil {
+			// invalid invites from other peers shouldn't stop the index
+			level.Warn(p.logger).Log("event", "skipped invite message", "type", msgType.Type, "msg", msg.Key().Ref(), "err", err)
+		}
+		return nil
 	}, p.h.state)
-	return idxSink
+	return p.h.state, idxSink
 }
 
 func (p *Plugin) indexNewInvite(ctx context.Context, msg ssb.Message) error {
@@ -195,28 +196,45 @@ func (p *Plugin) Authorize(to *ssb.FeedRef) error {
 
 var (
 	_ ssb.Plugin     = (*Plugin)(nil)
+	_ ssb.Plugin     = masterPlug{}
 	_ ssb.Authorizer = (*Plugin)(nil)
 )
 
-func New(logger logging.Interface, g ssb.Getter, typeLog multilog.MultiLog, rootLog margaret.Log, publish ssb.Publisher) *Plugin {
-
-	p := Plugin{
+// New creates the peer-invites plugin.
+// Options can be an AppKey and HMACSecret (to reach pubs of other networks) and an ssb.Network to use the own address as the pub if none are specified on create.
+func New(logger logging.Interface, self *ssb.FeedRef, g ssb.Getter, rootLog margaret.Log, publish ssb.Publisher, opts ...interface{}) *Plugin {
+	h := &handler{
 		logger: logger,
 
-		tl: typeLog,
-		rl: rootLog,
+		g:   g,
+		rl:  rootLog,
+		pub: publish,
+	}
 
-		h: handler{
-			logger: logger,
+	c := &clientHandler{
+		handler: h,
+		self:    self,
+	}
 
-			g:   g,
-			tl:  typeLog,
-			rl:  rootLog,
-			pub: publish,
-		},
+	for i, o := range opts {
+		switch v := o.(type) {
+		case AppKey:
+			c.appKey = v
+		case HMACSecret:
+			c.hmacSecret = v
+		case ssb.Network:
+			c.network = v
+		default:
+			level.Warn(logger).Log("event", "unhandled peerInvites option", "i", i, "type", fmt.Sprintf("%T", o))
+		}
 	}
 
-	return &p
+	return &Plugin{
+		logger: logger,
+
+		h: h,
+		c: c,
+	}
 }
 
 type handler struct {
@@ -226,15 +244,14 @@ type handler struct {
 
 	g ssb.Getter
 
-	tl multilog.MultiLog
 	rl margaret.Log
 
 	pub ssb.Publisher
 }
 
-func (h handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
+func (h *handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
 
-func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+func (h *handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
 	if len(req.Args()) < 1 {
 		req.CloseWithError(errors.Errorf("invalid arguments"))
 		return
@@ -311,14 +328,12 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 			req.CloseWithError(err)
 			return
 		}
-		fmt.Fprintln(os.Stderr, string(msgArg))
-		fmt.Fprintf(os.Stderr, "%+v\n", accept)
 		ref, err := h.pub.Publish(struct {
 			Type  string          `json:"type"`
 			Embed json.RawMessage `json:"embed"`
 		}{"peer-invite/confirm", msgArg})
 		if err != nil {
-			errors.Wrap(err, "failed to publish confirm message")
+			err = errors.Wrap(err, "failed to publish confirm message")
 			errLog.Log("err", err)
 			req.CloseWithError(err)
 			return
@@ -326,7 +341,7 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 
 		msg, err := h.g.Get(*ref)
 		if err != nil {
-			errors.Wrap(err, "failed to load published confirm message")
+			err = errors.Wrap(err, "failed to load published confirm message")
 			errLog.Log("err", err)
 			req.CloseWithError(err)
 			return
@@ -347,15 +362,16 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 		}
 
 		err = req.Return(ctx, json.RawMessage(msg.ContentBytes()))
+		if err != nil {
+			errLog.Log("msg", "failed to return message", "err", err)
+			return
+		}
 	default:
 		req.CloseWithError(fmt.Errorf("unknown method"))
 	}
 	hlog.Log("peerInvites", "done")
 }
 
-//  from 2.0: hash("peer-invites")
-var peerCap = [32]byte{29, 61, 48, 33, 139, 164, 220, 229, 156, 216, 91, 90, 9, 241, 205, 157, 169, 21, 235, 200, 210, 25, 26, 227, 68, 195, 253, 42, 139, 59, 33, 7}
-
 func verifyAcceptMessage(raw []byte, guestID *ssb.FeedRef) (*acceptContent, error) {
 	var rawContent struct {
 		Content json.RawMessage
@@ -365,21 +381,8 @@ func verifyAcceptMessage(raw []byte, guestID *ssb.FeedRef) (*acceptContent, erro
 		return nil, errors.Wrap(err, "unwrap content for verify failed")
 	}
 
-	// fmt.Fprintln(os.Stderr, "msg:", string(rawContent.Content))
-
 	// can verify the invite message
-	enc, err := legacy.EncodePreserveOrder(rawContent.Content)
-	if err != nil {
-		return nil, err
-	}
-	invmsgWoSig, sig, err := legacy.ExtractSignature(enc)
-	if err != nil {
-		return nil, err
-	}
-
-	mac := auth.Sum(invmsgWoSig, &peerCap)
-	err = sig.Verify(mac[:], guestID)
-	if err != nil {
+	if err := verifyContent(rawContent.Content, guestID); err != nil {
 		return nil, err
 	}
 
@@ -407,5 +410,5 @@ type acceptContent struct {
 	Type    string          `json:"type"`
 	Receipt *ssb.MessageRef `json:"receipt"`
 	ID      *ssb.FeedRef    `json:"id"`
-	// Key     string          `json:"key"` only needed for reveal
+	Key     string          `json:"key,omitempty"` // only needed for reveal
 }
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package peerinvites
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/auth"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// from 2.0: hash("peer-invites")
+var peerCap = [32]byte{29, 61, 48, 33, 139, 164, 220, 229, 156, 216, 91, 90, 9, 241, 205, 157, 169, 21, 235, 200, 210, 25, 26, 227, 68, 195, 253, 42, 139, 59, 33, 7}
+
+// signContent signs the content with the guest key like ssb-keys signObj does.
+// The signature is over the hmac (using the peer-invites cap) of the v8-like encoding and is added as the last field.
+func signContent(kp *ssb.KeyPair, content interface{}) (json.RawMessage, error) {
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "peerInvites/sign: failed to encode content")
+	}
+
+	pp, err := legacy.EncodePreserveOrder(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "peerInvites/sign: failed to prepare content")
+	}
+
+	mac := auth.Sum(pp, &peerCap)
+	sig, err := json.Marshal(legacy.EncodeSignature(ed25519.Sign(kp.Pair.Secret[:], mac[:])))
+	if err != nil {
+		return nil, err
+	}
+
+	var signed bytes.Buffer
+	signed.Write(bytes.TrimSuffix(raw, []byte("}")))
+	signed.WriteString(`,"signature":`)
+	signed.Write(sig)
+	signed.WriteString("}")
+	return signed.Bytes(), nil
+}
+
+// verifyContent checks that the signature on the raw content was made by signer
+func verifyContent(raw []byte, signer *ssb.FeedRef) error {
+	enc, err := legacy.EncodePreserveOrder(raw)
+	if err != nil {
+		return err
+	}
+	woSig, sig, err := legacy.ExtractSignature(enc)
+	if err != nil {
+		return err
+	}
+
+	mac := auth.Sum(woSig, &peerCap)
+	return sig.Verify(mac[:], signer)
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: MIT
+
+package peerinvites
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// Token is the invite code that is handed to the guest.
+// The format is inv:base64Seed,%inviteMsg.sha256,pubAddr1,pubAddr2,...
+type Token struct {
+	Seed [32]byte
+
+	// Invite is the peer-invite message that was published by the host
+	Invite *ssb.MessageRef
+
+	// Pubs are multiserver addresses of peers that replicate the host and can confirm the invite
+	Pubs []string
+}
+
+func (t Token) String() string {
+	var s strings.Builder
+	s.WriteString("inv:")
+	s.WriteString(base64.StdEncoding.EncodeToString(t.Seed[:]))
+	s.WriteString(",")
+	s.WriteString(t.Invite.Ref())
+	for _, p := range t.Pubs {
+		s.WriteString(",")
+		s.WriteString(p)
+	}
+	return s.String()
+}
+
+// GuestKeyPair returns the keypair that is derived from the seed.
+// It is used to sign the accept message and to connect to the pubs.
+func (t Token) GuestKeyPair() (*ssb.KeyPair, error) {
+	kp, err := ssb.NewKeyPair(bytes.NewReader(t.Seed[:]))
+	return kp, errors.Wrap(err, "peerInvites: couldn't make keypair from seed")
+}
+
+// ParseToken parses an invite code as produced by Token.String or ssb-peer-invites
+func ParseToken(input string) (Token, error) {
+	var t Token
+
+	if !strings.HasPrefix(input, "inv:") {
+		return t, errors.Errorf("peerInvites: expected inv: prefix")
+	}
+	parts := strings.Split(strings.TrimPrefix(input, "inv:"), ",")
+	if len(parts) < 2 {
+		return t, errors.Errorf("peerInvites: expected at least seed and invite message")
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return t, errors.Wrap(err, "peerInvites: invalid seed")
+	}
+	if n := len(seed); n != 32 {
+		return t, errors.Errorf("peerInvites: invalid seed length: %d", n)
+	}
+	copy(t.Seed[:], seed)
+
+	t.Invite, err = ssb.ParseMessageRef(parts[1])
+	if err != nil {
+		return t, errors.Wrap(err, "peerInvites: invalid invite message reference")
+	}
+
+	for _, p := range parts[2:] {
+		if p != "" {
+			t.Pubs = append(t.Pubs, p)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MIT
+
+package peerinvites
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestTokenRoundtrip(t *testing.T) {
+	r := require.New(t)
+
+	var tok Token
+	copy(tok.Seed[:], bytes.Repeat([]byte{7}, 32))
+	tok.Invite = &ssb.MessageRef{Hash: bytes.Repeat([]byte{3}, 32), Algo: ssb.RefAlgoMessageSSB1}
+	tok.Pubs = []string{"net:localhost:8008~shs:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}
+
+	parsed, err := ParseToken(tok.String())
+	r.NoError(err)
+	r.Equal(tok.Seed, parsed.Seed)
+	r.True(tok.Invite.Equal(*parsed.Invite))
+	r.Equal(tok.Pubs, parsed.Pubs)
+
+	for i, bad := range []string{
+		"",
+		"inv:",
+		"invite:foo,bar",
+		"inv:AAAA," + tok.Invite.Ref(),
+		tok.String()[:10],
+	} {
+		_, err := ParseToken(bad)
+		r.Error(err, "case %d should fail", i)
+	}
+}
+
+func TestSignContent(t *testing.T) {
+	r := require.New(t)
+
+	var tok Token
+	copy(tok.Seed[:], bytes.Repeat([]byte{7}, 32))
+	guest, err := tok.GuestKeyPair()
+	r.NoError(err)
+
+	host := &ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	signed, err := signContent(guest, InviteContent{
+		Type:   "peer-invite",
+		Invite: guest.Id,
+		Host:   host,
+	})
+	r.NoError(err)
+
+	var content InviteContent
+	r.NoError(json.Unmarshal(signed, &content))
+	r.True(content.Host.Equal(host))
+
+	r.NoError(verifyContent(signed, guest.Id))
+	r.Error(verifyContent(signed, host), "verified with the wrong key")
+
+	tampered := bytes.Replace(signed, []byte("peer-invite"), []byte("peer-invitf"), 1)
+	r.Error(verifyContent(tampered, guest.Id))
+}
@@ -24,6 +24,44 @@ type handler struct {
 
 	publish ssb.Publisher
 	read    margaret.Log
+	ids     Identities
+}
+
+// asArg reads the as field of an options argument, which selects the local identity for the call
+func asArg(v interface{}) (*ssb.FeedRef, error) {
+	opts, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("private: expected an options object but got %T", v)
+	}
+	as, has := opts["as"]
+	if !has || as == nil {
+		return nil, nil
+	}
+	str, ok := as.(string)
+	if !ok {
+		return nil, errors.Errorf("private: expected a feed reference for as but got %T", as)
+	}
+	return ssb.ParseFeedRef(str)
+}
+
+func (h handler) publisherAs(as *ssb.FeedRef) (ssb.Publisher, error) {
+	if as == nil {
+		return h.publish, nil
+	}
+	if h.ids == nil {
+		return nil, errors.Errorf("private: this bot only has one identity")
+	}
+	return h.ids.PublisherAs(as)
+}
+
+func (h handler) readAs(as *ssb.FeedRef) (margaret.Log, error) {
+	if as == nil {
+		return h.read, nil
+	}
+	if h.ids == nil {
+		return nil, errors.Errorf("private: this bot only has one identity")
+	}
+	return h.ids.PrivateReadAs(as)
 }
 
 func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
@@ -57,11 +95,21 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 		if req.Type == "" {
 			req.Type = "async"
 		}
-		if n := len(req.Args()); n != 2 {
-			req.CloseWithError(errors.Errorf("private/publish: bad request. expected 2 argument got %d", n))
+		if n := len(req.Args()); n != 2 && n != 3 {
+			req.CloseWithError(errors.Errorf("private/publish: bad request. expected 2 or 3 arguments got %d", n))
 			return
 		}
 
+		var as *ssb.FeedRef
+		if len(req.Args()) == 3 {
+			var err error
+			as, err = asArg(req.Args()[2])
+			if err != nil {
+				req.CloseWithError(errors.Wrap(err, "private/publish: bad options argument"))
+				return
+			}
+		}
+
 		msg, err := json.Marshal(req.Args()[0])
 		if err != nil {
 			req.CloseWithError(errors.Wrap(err, "failed to encode message"))
@@ -88,7 +136,7 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 			}
 		}
 
-		ref, err := h.privatePublish(msg, rcpsRefs)
+		ref, err := h.privatePublish(as, msg, rcpsRefs)
 		if err != nil {
 			req.CloseWithError(err)
 			return
@@ -118,7 +166,10 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 func (h handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
 
 func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
-	var qry message.CreateHistArgs
+	var (
+		qry message.CreateHistArgs
+		as  *ssb.FeedRef
+	)
 
 	args := req.Args()
 	if len(args) > 0 {
@@ -131,6 +182,12 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 				return
 			}
 			qry = *q
+
+			as, err = asArg(v)
+			if err != nil {
+				req.CloseWithError(errors.Wrap(err, "privateRead: bad request"))
+				return
+			}
 		default:
 			req.CloseWithError(errors.Errorf("privateRead: invalid argument type %T", args[0]))
 			return
@@ -146,7 +203,13 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 	// well, sorry - the client lib needs better handling of receiving types
 	qry.Keys = true
 
-	src, err := h.read.Query(
+	read, err := h.readAs(as)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "private/read: no private messages for identity"))
+		return
+	}
+
+	src, err := read.Query(
 		margaret.Gte(margaret.BaseSeq(qry.Seq)),
 		margaret.Limit(int(qry.Limit)),
 		margaret.Live(qry.Live))
@@ -163,14 +226,19 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 	req.Close()
 }
 
-func (h handler) privatePublish(msg []byte, recps []*ssb.FeedRef) (*ssb.MessageRef, error) {
+func (h handler) privatePublish(as *ssb.FeedRef, msg []byte, recps []*ssb.FeedRef) (*ssb.MessageRef, error) {
+	publish, err := h.publisherAs(as)
+	if err != nil {
+		return nil, err
+	}
+
 	boxedMsg, err := private.Box(msg, recps...)
 	if err != nil {
 		return nil, errors.Wrap(err, "private/publish: failed to box message")
 
 	}
 
-	ref, err := h.publish.Publish(boxedMsg)
+	ref, err := publish.Publish(boxedMsg)
 	if err != nil {
 		return nil, errors.Wrap(err, "private/publish: pour failed")
 
@@ -13,8 +13,14 @@ type privatePlug struct {
 	h muxrpc.Handler
 }
 
-func NewPlug(i logging.Interface, publish ssb.Publisher, readIdx margaret.Log) ssb.Plugin {
-	return &privatePlug{h: handler{publish: publish, read: readIdx, info: i}}
+// Identities gives the publishers and the private messages for the as argument, it can be nil if the bot only has one identity
+type Identities interface {
+	PublisherAs(*ssb.FeedRef) (ssb.Publisher, error)
+	PrivateReadAs(*ssb.FeedRef) (margaret.Log, error)
+}
+
+func NewPlug(i logging.Interface, publish ssb.Publisher, readIdx margaret.Log, ids Identities) ssb.Plugin {
+	return &privatePlug{h: handler{publish: publish, read: readIdx, ids: ids, info: i}}
 }
 
 func (p privatePlug) Name() string {
@@ -4,6 +4,7 @@ package publish
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
@@ -16,10 +17,17 @@ import (
 
 type handler struct {
 	publish ssb.Publisher
+	ids     Identities
 	rootLog margaret.Log // to get the key back
 	info    logging.Interface
 }
 
+// PublishArgs is the optional second argument of publish
+type PublishArgs struct {
+	// As is the local identity to publish with, instead of the one of the bot
+	As *ssb.FeedRef `json:"as"`
+}
+
 func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
 	if n := req.Method.String(); n != "publish" {
 		req.CloseWithError(errors.Errorf("publish: bad request name: %s", n))
@@ -27,12 +35,22 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 	}
 
 	args := req.Args()
-	if n := len(args); n != 1 {
-		req.CloseWithError(errors.Errorf("publish: bad request. expected 1 argument got %d", n))
+	if n := len(args); n != 1 && n != 2 {
+		req.CloseWithError(errors.Errorf("publish: bad request. expected 1 or 2 arguments got %d", n))
 		return
 	}
 
-	ref, err := h.publish.Publish(args[0])
+	publish := h.publish
+	if len(args) == 2 {
+		var err error
+		publish, err = h.publisherFor(req.RawArgs)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+	}
+
+	ref, err := publish.Publish(args[0])
 	if err != nil {
 		req.CloseWithError(errors.Wrap(err, "publish: pour failed"))
 		return
@@ -48,3 +66,21 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 }
 
 func (h handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
+
+func (h handler) publisherFor(rawArgs json.RawMessage) (ssb.Publisher, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return nil, errors.Wrap(err, "publish: bad request")
+	}
+	var opts PublishArgs
+	if err := json.Unmarshal(args[1], &opts); err != nil {
+		return nil, errors.Wrap(err, "publish: bad options argument")
+	}
+	if opts.As == nil {
+		return h.publish, nil
+	}
+	if h.ids == nil {
+		return nil, errors.Errorf("publish: this bot only has one identity")
+	}
+	return h.ids.PublisherAs(opts.As)
+}
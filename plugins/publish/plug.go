@@ -13,9 +13,15 @@ type publishPlug struct {
 	h muxrpc.Handler
 }
 
-func NewPlug(i logging.Interface, publish ssb.Publisher, rootLog margaret.Log) ssb.Plugin {
+// Identities gives the publishers for the as argument, it can be nil if the bot only has one identity
+type Identities interface {
+	PublisherAs(*ssb.FeedRef) (ssb.Publisher, error)
+}
+
+func NewPlug(i logging.Interface, publish ssb.Publisher, rootLog margaret.Log, ids Identities) ssb.Plugin {
 	return &publishPlug{h: handler{
 		publish: publish,
+		ids:     ids,
 		rootLog: rootLog,
 		info:    i,
 	}}
@@ -0,0 +1,606 @@
+// SPDX-License-Identifier: MIT
+
+// Package pubmode turns a bot into a pub.
+// It announces the address of the pub in a pub message, follows feeds back according to a policy
+// and optionally unfollows feeds that didn't connect for a while.
+package pubmode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/netwrap"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/invite"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// FollowBack decides which feeds the pub follows on its own
+type FollowBack string
+
+const (
+	// FollowBackInvites only follows the feeds that redeem an invite (which invite.use always does)
+	FollowBackInvites FollowBack = "invites"
+
+	// FollowBackFollowers also follows every feed that follows the pub
+	FollowBackFollowers FollowBack = "followers"
+
+	// FollowBackPeers also follows every peer that connects to the pub
+	FollowBackPeers FollowBack = "peers"
+)
+
+// ParseFollowBack checks that s is one of the policies
+func ParseFollowBack(s string) (FollowBack, error) {
+	switch fb := FollowBack(s); fb {
+	case FollowBackInvites, FollowBackFollowers, FollowBackPeers:
+		return fb, nil
+	case "":
+		return FollowBackInvites, nil
+	}
+	return "", fmt.Errorf("pubmode: unknown follow-back policy %q (want invites, followers or peers)", s)
+}
+
+// Options configure the pub
+type Options struct {
+	// Address is the host:port that is announced, host can be a domain name.
+	// Defaults to the listen address of the network, unless that is unspecified (like 0.0.0.0).
+	Address string
+
+	// AnnounceInterval is how often the address is published again, even if it didn't change.
+	// Defaults to a week.
+	AnnounceInterval time.Duration
+
+	FollowBack FollowBack
+
+	// PruneAfter unfollows feeds that didn't connect for that long. Zero disables pruning.
+	// This only applies to the feeds the pub followed back or through an invite (see Invited), follows that were published otherwise are kept.
+	PruneAfter time.Duration
+}
+
+// sweepInterval is how often the pub checks its announcement, followers and followed feeds
+const sweepInterval = time.Hour
+
+// ErrNoAddress is returned by Announce if there is no address that others could reach
+var ErrNoAddress = errors.New("pubmode: no reachable address to announce (listening on an unspecified address?)")
+
+// Service keeps the state of the pub
+type Service struct {
+	logger kitlog.Logger
+
+	self    *ssb.FeedRef
+	network ssb.Network
+	publish ssb.Publisher
+	graph   graph.Builder
+
+	opts Options
+
+	// connections that weren't written to the kv yet
+	pendingMu sync.Mutex
+	pending   map[string]connected
+	wake      chan struct{}
+
+	mu sync.Mutex
+	kv *kv.DB
+
+	// feeds that were followed but aren't in the graph yet
+	followed map[string]struct{}
+}
+
+type connected struct {
+	ref  *ssb.FeedRef
+	when time.Time
+}
+
+// New opens the state of the pub in the repo
+func New(
+	logger kitlog.Logger,
+	r repo.Interface,
+	self *ssb.FeedRef,
+	nw ssb.Network,
+	publish ssb.Publisher,
+	gb graph.Builder,
+	opts Options,
+) (*Service, error) {
+	var err error
+	opts.FollowBack, err = ParseFollowBack(string(opts.FollowBack))
+	if err != nil {
+		return nil, err
+	}
+	if opts.AnnounceInterval == 0 {
+		opts.AnnounceInterval = 7 * 24 * time.Hour
+	}
+	if opts.Address != "" {
+		if _, _, err := splitHostPort(opts.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := repo.OpenMKV(r.GetPath("plugin", "pubmode"))
+	if err != nil {
+		return nil, fmt.Errorf("pubmode: failed to open key-value database: %w", err)
+	}
+
+	return &Service{
+		logger: logger,
+
+		self:    self,
+		network: nw,
+		publish: publish,
+		graph:   gb,
+
+		opts: opts,
+
+		pending: make(map[string]connected),
+		wake:    make(chan struct{}, 1),
+
+		kv: db,
+
+		followed: make(map[string]struct{}),
+	}, nil
+}
+
+// Close closes the underlying key-value database
+func (s *Service) Close() error { return s.kv.Close() }
+
+// Serve listens for connections (if the network emits ssb.ConnEvents) and sweeps once an hour until ctx is canceled
+func (s *Service) Serve(ctx context.Context) error {
+	if ce, ok := s.network.(ssb.ConnEventer); ok {
+		done := ce.ConnEvents().Register(luigi.FuncSink(func(_ context.Context, v interface{}, err error) error {
+			if err != nil {
+				return nil
+			}
+			evt, ok := v.(ssb.ConnEvent)
+			if !ok || (evt.Type != ssb.ConnEventConnect && evt.Type != ssb.ConnEventDisconnect) {
+				return nil
+			}
+			ref, err := ssb.ParseFeedRef(evt.Peer)
+			if err != nil {
+				return nil
+			}
+			s.noteConnection(ref, evt.Time)
+			return nil
+		}))
+		defer done()
+	}
+
+	s.Sweep(time.Now())
+
+	tick := time.NewTicker(sweepInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.wake:
+			s.flushConnections()
+		case now := <-tick.C:
+			s.Sweep(now)
+		}
+	}
+}
+
+// noteConnection is called from the event stream of the network, so it only remembers the connection for the serve loop
+func (s *Service) noteConnection(ref *ssb.FeedRef, when time.Time) {
+	s.pendingMu.Lock()
+	s.pending[ref.Ref()] = connected{ref: ref, when: when}
+	s.pendingMu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Service) flushConnections() {
+	s.pendingMu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]connected)
+	s.pendingMu.Unlock()
+
+	for _, c := range pending {
+		if err := s.Seen(c.ref, c.when); err != nil {
+			level.Warn(s.logger).Log("event", "seen failed", "peer", c.ref.Ref(), "err", err)
+			continue
+		}
+		if s.opts.FollowBack != FollowBackPeers {
+			continue
+		}
+		if err := s.followBack(c.ref); err != nil {
+			level.Warn(s.logger).Log("event", "follow back failed", "peer", c.ref.Ref(), "err", err)
+		}
+	}
+}
+
+// Sweep announces the address (if necessary), follows back and prunes.
+// Errors are logged since the steps don't depend on each other.
+func (s *Service) Sweep(now time.Time) {
+	s.flushConnections()
+
+	// peers that are still connected don't produce events
+	for _, es := range s.network.GetAllEndpoints() {
+		if es.ID == nil {
+			continue
+		}
+		if err := s.Seen(es.ID, now); err != nil {
+			level.Warn(s.logger).Log("event", "seen failed", "peer", es.ID.Ref(), "err", err)
+		}
+	}
+
+	if err := s.Announce(now); err != nil {
+		level.Warn(s.logger).Log("event", "announce failed", "err", err)
+	}
+
+	if s.opts.FollowBack == FollowBackFollowers {
+		if err := s.FollowBackFollowers(); err != nil {
+			level.Warn(s.logger).Log("event", "follow back failed", "err", err)
+		}
+	}
+
+	if err := s.Prune(now); err != nil {
+		level.Warn(s.logger).Log("event", "prune failed", "err", err)
+	}
+}
+
+type announcement struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	Time int64  `json:"time"` // unix milliseconds
+}
+
+var announcedKey = []byte("announced")
+
+// Announce publishes a pub message with the reachable address,
+// unless the same address was published less than AnnounceInterval ago.
+func (s *Service) Announce(now time.Time) error {
+	host, port, err := s.address()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last announcement
+	data, err := s.kv.Get(nil, announcedKey)
+	if err != nil {
+		return fmt.Errorf("pubmode: failed to get last announcement: %w", err)
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, &last); err != nil {
+			return fmt.Errorf("pubmode: failed to decode last announcement: %w", err)
+		}
+		age := now.Sub(time.Unix(0, last.Time*int64(time.Millisecond)))
+		if last.Host == host && last.Port == port && age < s.opts.AnnounceInterval {
+			return nil
+		}
+	}
+
+	if _, err := s.publish.Append(invite.NewPubMessage(*s.self, host, port)); err != nil {
+		return fmt.Errorf("pubmode: failed to publish pub message: %w", err)
+	}
+	level.Info(s.logger).Log("event", "announced", "host", host, "port", port)
+
+	data, err = json.Marshal(announcement{Host: host, Port: port, Time: now.UnixNano() / int64(time.Millisecond)})
+	if err != nil {
+		return err
+	}
+	return s.kv.Set(announcedKey, data)
+}
+
+func (s *Service) address() (string, int, error) {
+	if s.opts.Address != "" {
+		return splitHostPort(s.opts.Address)
+	}
+
+	tcpAddr, ok := netwrap.GetAddr(s.network.GetListenAddr(), "tcp").(*net.TCPAddr)
+	if !ok || tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified() {
+		return "", 0, ErrNoAddress
+	}
+	return tcpAddr.IP.String(), tcpAddr.Port, nil
+}
+
+func splitHostPort(hostport string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0, fmt.Errorf("pubmode: invalid address %q: %w", hostport, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || host == "" || port == 0 {
+		return "", 0, fmt.Errorf("pubmode: invalid address %q", hostport)
+	}
+	return host, int(port), nil
+}
+
+// FollowBackFollowers follows all the feeds that follow the pub but aren't followed (or blocked) by it.
+// Pruned feeds are only followed again after they connected again.
+func (s *Service) FollowBackFollowers() error {
+	g, err := s.graph.Build()
+	if err != nil {
+		return fmt.Errorf("pubmode: failed to build graph: %w", err)
+	}
+
+	for _, e := range g.EdgeList() {
+		if !e.Following || !e.To.Equal(s.self) || e.From.Equal(s.self) {
+			continue
+		}
+		if err := s.followBackIn(g, e.From); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) followBack(ref *ssb.FeedRef) error {
+	if ref.Equal(s.self) {
+		return nil
+	}
+	g, err := s.graph.Build()
+	if err != nil {
+		return fmt.Errorf("pubmode: failed to build graph: %w", err)
+	}
+	return s.followBackIn(g, ref)
+}
+
+func (s *Service) followBackIn(g *graph.Graph, ref *ssb.FeedRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g.Follows(s.self, ref) || g.Blocks(s.self, ref) {
+		// the graph caught up with our follow (or was changed by hand)
+		delete(s.followed, ref.Ref())
+		return nil
+	}
+
+	if _, has := s.followed[ref.Ref()]; has {
+		return nil
+	}
+
+	pruned, err := s.kv.Get(nil, prunedKey(ref))
+	if err != nil {
+		return fmt.Errorf("pubmode: failed to check prune state: %w", err)
+	}
+	if pruned != nil {
+		return nil
+	}
+
+	err = s.publishWith(ssb.NewContactFollow(ref), func() error {
+		return s.kv.Set(followedKey(ref), []byte{1})
+	})
+	if err != nil {
+		return fmt.Errorf("pubmode: failed to follow back: %w", err)
+	}
+	s.followed[ref.Ref()] = struct{}{}
+	level.Info(s.logger).Log("event", "followed back", "feed", ref.Ref())
+	return nil
+}
+
+// Invited records that the pub followed ref because it redeemed an invite at when, so that Prune can unfollow it like the feeds that were followed back.
+// The time it has to connect again starts with the redemption.
+func (s *Service) Invited(ref *ssb.FeedRef, when time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.kv.BeginTransaction(); err != nil {
+		return err
+	}
+	if err := s.kv.Set(followedKey(ref), []byte{1}); err != nil {
+		s.kv.Rollback()
+		return fmt.Errorf("pubmode: failed to store follow of invited feed: %w", err)
+	}
+	if err := s.setSeen(ref, when); err != nil {
+		s.kv.Rollback()
+		return err
+	}
+	if err := s.kv.Delete(prunedKey(ref)); err != nil {
+		s.kv.Rollback()
+		return fmt.Errorf("pubmode: failed to clear prune state: %w", err)
+	}
+	return s.kv.Commit()
+}
+
+// Seen records that ref was connected at when, which also makes pruned feeds eligible for follow-back again
+func (s *Service) Seen(ref *ssb.FeedRef, when time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.kv.BeginTransaction(); err != nil {
+		return err
+	}
+	if err := s.setSeen(ref, when); err != nil {
+		s.kv.Rollback()
+		return err
+	}
+	if err := s.kv.Delete(prunedKey(ref)); err != nil {
+		s.kv.Rollback()
+		return fmt.Errorf("pubmode: failed to clear prune state: %w", err)
+	}
+	return s.kv.Commit()
+}
+
+// LastSeen returns when ref was connected for the last time, or false if it wasn't seen yet
+func (s *Service) LastSeen(ref *ssb.FeedRef) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getSeen(ref)
+}
+
+// Prune unfollows the followed back feeds that didn't connect for PruneAfter.
+// Feeds that were never seen get the full duration from the first time Prune sees them.
+func (s *Service) Prune(now time.Time) error {
+	if s.opts.PruneAfter == 0 {
+		return nil
+	}
+
+	follows, err := s.graph.Follows(s.self)
+	if err != nil {
+		return fmt.Errorf("pubmode: failed to get followed feeds: %w", err)
+	}
+	refs, err := follows.List()
+	if err != nil {
+		return fmt.Errorf("pubmode: failed to list followed feeds: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ref := range refs {
+		if ref.Equal(s.self) {
+			continue
+		}
+
+		ours, err := s.kv.Get(nil, followedKey(ref))
+		if err != nil {
+			return fmt.Errorf("pubmode: failed to check follow-back state: %w", err)
+		}
+		if ours == nil {
+			// followed by hand, not ours to undo
+			continue
+		}
+
+		seen, has, err := s.getSeen(ref)
+		if err != nil {
+			return err
+		}
+		if !has {
+			if err := s.setSeen(ref, now); err != nil {
+				return err
+			}
+			continue
+		}
+		if now.Sub(seen) < s.opts.PruneAfter {
+			continue
+		}
+
+		err = s.publishWith(ssb.NewContactUnfollow(ref), func() error {
+			if err := s.kv.Set(prunedKey(ref), []byte{1}); err != nil {
+				return err
+			}
+			if err := s.kv.Delete(followedKey(ref)); err != nil {
+				return err
+			}
+			return s.kv.Delete(seenKey(ref))
+		})
+		if err != nil {
+			return fmt.Errorf("pubmode: failed to prune %s: %w", ref.Ref(), err)
+		}
+		delete(s.followed, ref.Ref())
+		level.Info(s.logger).Log("event", "pruned", "feed", ref.Ref(), "last-seen", seen)
+	}
+	return nil
+}
+
+// publishWith publishes content and makes the changes of update to the kv, needs to be called with s.mu locked.
+// The log and the kv can't be changed in one transaction, so the changes are only committed once publishing worked.
+// If the commit fails after that, the kv is missing the change, which means a followed back feed isn't pruned
+// or a pruned one might be followed again, but never that a feed is unfollowed that the pub didn't follow itself.
+func (s *Service) publishWith(content interface{}, update func() error) error {
+	if err := s.kv.BeginTransaction(); err != nil {
+		return err
+	}
+	if err := update(); err != nil {
+		s.kv.Rollback()
+		return err
+	}
+	if _, err := s.publish.Append(content); err != nil {
+		s.kv.Rollback()
+		return err
+	}
+	return s.kv.Commit()
+}
+
+// Pruned returns the feeds that were unfollowed by Prune and didn't connect since
+func (s *Service) Pruned() ([]*ssb.FeedRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enum, _, err := s.kv.Seek(prunedPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("pubmode: failed to seek pruned feeds: %w", err)
+	}
+
+	var lst []*ssb.FeedRef
+	for {
+		k, _, err := enum.Next()
+		if err == io.EOF || (err == nil && !bytes.HasPrefix(k, prunedPrefix)) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pubmode: failed to get next pruned feed: %w", err)
+		}
+
+		var sr ssb.StorageRef
+		if err := sr.Unmarshal(k[len(prunedPrefix):]); err != nil {
+			return nil, fmt.Errorf("pubmode: invalid key: %w", err)
+		}
+		ref, err := sr.FeedRef()
+		if err != nil {
+			return nil, fmt.Errorf("pubmode: invalid key: %w", err)
+		}
+		lst = append(lst, ref)
+	}
+	return lst, nil
+}
+
+var (
+	seenPrefix     = []byte("seen:")
+	prunedPrefix   = []byte("pruned:")
+	followedPrefix = []byte("followed:")
+)
+
+func seenKey(ref *ssb.FeedRef) []byte {
+	return append(append([]byte{}, seenPrefix...), ref.StoredAddr()...)
+}
+
+func prunedKey(ref *ssb.FeedRef) []byte {
+	return append(append([]byte{}, prunedPrefix...), ref.StoredAddr()...)
+}
+
+func followedKey(ref *ssb.FeedRef) []byte {
+	return append(append([]byte{}, followedPrefix...), ref.StoredAddr()...)
+}
+
+// getSeen needs to be called with s.mu locked
+func (s *Service) getSeen(ref *ssb.FeedRef) (time.Time, bool, error) {
+	data, err := s.kv.Get(nil, seenKey(ref))
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("pubmode: failed to get last seen: %w", err)
+	}
+	if data == nil {
+		return time.Time{}, false, nil
+	}
+	ms, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("pubmode: invalid last seen of %s: %w", ref.Ref(), err)
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true, nil
+}
+
+// setSeen needs to be called with s.mu locked. It never moves the time backwards.
+func (s *Service) setSeen(ref *ssb.FeedRef, when time.Time) error {
+	prev, has, err := s.getSeen(ref)
+	if err != nil {
+		return err
+	}
+	if has && prev.After(when) {
+		return nil
+	}
+	ms := when.UnixNano() / int64(time.Millisecond)
+	if err := s.kv.Set(seenKey(ref), []byte(strconv.FormatInt(ms, 10))); err != nil {
+		return fmt.Errorf("pubmode: failed to store last seen: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+// SPDX-License-Identifier: MIT
+
+package pubmode_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/plugins/pubmode"
+	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+type fakeNetwork struct {
+	ssb.Network
+	addr net.Addr
+}
+
+func (n fakeNetwork) GetListenAddr() net.Addr             { return n.addr }
+func (n fakeNetwork) GetAllEndpoints() []ssb.EndpointStat { return nil }
+
+// contentOf returns the content of all the messages that were published by author
+func contentOf(t *testing.T, bot *sbot.Sbot, author *ssb.FeedRef) []map[string]interface{} {
+	r := require.New(t)
+	src, err := bot.RootLog.Query()
+	r.NoError(err)
+
+	var contents []map[string]interface{}
+	for {
+		v, err := src.Next(context.TODO())
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		msg, ok := v.(ssb.Message)
+		r.True(ok, "wrong type: %T", v)
+		if !msg.Author().Equal(author) {
+			continue
+		}
+		var c map[string]interface{}
+		r.NoError(json.Unmarshal(msg.ContentBytes(), &c))
+		contents = append(contents, c)
+	}
+	return contents
+}
+
+func countType(contents []map[string]interface{}, typ string) int {
+	var n int
+	for _, c := range contents {
+		if c["type"] == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func TestAnnounce(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	bot, err := sbot.New(
+		sbot.WithInfo(log.NewNopLogger()),
+		sbot.WithRepoPath(tRepoPath),
+		sbot.DisableNetworkNode(),
+	)
+	r.NoError(err)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+
+	// listening on all interfaces isn't something others can dial
+	unspecified := fakeNetwork{addr: &net.TCPAddr{IP: net.IPv4zero, Port: 8008}}
+	svc, err := pubmode.New(log.NewNopLogger(), repo.New(tRepoPath), bot.KeyPair.Id, unspecified, bot.PublishLog, bot.GraphBuilder, pubmode.Options{})
+	r.NoError(err)
+	r.Equal(pubmode.ErrNoAddress, svc.Announce(time.Now()))
+	r.NoError(svc.Close())
+
+	_, err = pubmode.New(log.NewNopLogger(), repo.New(tRepoPath), bot.KeyPair.Id, unspecified, bot.PublishLog, bot.GraphBuilder, pubmode.Options{Address: "no-port"})
+	r.Error(err)
+
+	svc, err = pubmode.New(log.NewNopLogger(), repo.New(tRepoPath), bot.KeyPair.Id, unspecified, bot.PublishLog, bot.GraphBuilder, pubmode.Options{
+		Address:          "pub.example:8008",
+		AnnounceInterval: time.Hour,
+	})
+	r.NoError(err)
+	defer svc.Close()
+
+	now := time.Now()
+	r.NoError(svc.Announce(now))
+	r.NoError(svc.Announce(now.Add(time.Minute)))
+
+	own := contentOf(t, bot, bot.KeyPair.Id)
+	r.Len(own, 1, "unchanged address should only be announced once")
+	r.Equal("pub", own[0]["type"])
+	addr, ok := own[0]["address"].(map[string]interface{})
+	r.True(ok, "wrong address: %v", own[0]["address"])
+	r.Equal("pub.example", addr["host"])
+	r.EqualValues(8008, addr["port"])
+	r.Equal(bot.KeyPair.Id.Ref(), addr["key"])
+
+	// once the interval passed it's published again
+	r.NoError(svc.Announce(now.Add(2 * time.Hour)))
+	r.Equal(2, countType(contentOf(t, bot, bot.KeyPair.Id), "pub"))
+}
+
+func TestFollowBackAndPrune(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	kpAli, err := repo.NewKeyPair(tRepo, "ali", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+	kpBob, err := repo.NewKeyPair(tRepo, "bob", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	bot, err := sbot.New(
+		sbot.WithInfo(log.NewNopLogger()),
+		sbot.WithRepoPath(tRepoPath),
+		sbot.DisableNetworkNode(),
+	)
+	r.NoError(err)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+	self := bot.KeyPair.Id
+
+	follows := func(from, to *ssb.FeedRef) func() bool {
+		return func() bool {
+			g, err := bot.GraphBuilder.Build()
+			return err == nil && g.Follows(from, to)
+		}
+	}
+
+	// ali follows the pub, bob only follows ali
+	_, err = bot.PublishAs("ali", ssb.NewContactFollow(self))
+	r.NoError(err)
+	_, err = bot.PublishAs("bob", ssb.NewContactFollow(kpAli.Id))
+	r.NoError(err)
+	r.Eventually(follows(kpAli.Id, self), 5*time.Second, 50*time.Millisecond)
+
+	nw := fakeNetwork{addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008}}
+	svc, err := pubmode.New(log.NewNopLogger(), tRepo, self, nw, bot.PublishLog, bot.GraphBuilder, pubmode.Options{
+		FollowBack: pubmode.FollowBackFollowers,
+		PruneAfter: 24 * time.Hour,
+	})
+	r.NoError(err)
+	defer svc.Close()
+
+	r.NoError(svc.FollowBackFollowers())
+	r.Eventually(follows(self, kpAli.Id), 5*time.Second, 50*time.Millisecond)
+	r.False(follows(self, kpBob.Id)())
+
+	// following again before the graph changes or after it doesn't publish twice
+	r.NoError(svc.FollowBackFollowers())
+	r.Equal(1, countType(contentOf(t, bot, self), "contact"))
+
+	// the first prune starts the clock for feeds that weren't seen yet
+	now := time.Now()
+	r.NoError(svc.Prune(now))
+	seen, has, err := svc.LastSeen(kpAli.Id)
+	r.NoError(err)
+	r.True(has)
+	r.Equal(now.Unix(), seen.Unix())
+
+	// connecting moves it forward
+	r.NoError(svc.Seen(kpAli.Id, now.Add(time.Hour)))
+	r.NoError(svc.Prune(now.Add(24 * time.Hour)))
+	r.Equal(1, countType(contentOf(t, bot, self), "contact"))
+
+	r.NoError(svc.Prune(now.Add(26 * time.Hour)))
+	r.Eventually(func() bool { return !follows(self, kpAli.Id)() }, 5*time.Second, 50*time.Millisecond)
+	pruned, err := svc.Pruned()
+	r.NoError(err)
+	r.Len(pruned, 1)
+	r.True(pruned[0].Equal(kpAli.Id))
+
+	// ali still follows the pub but isn't followed back until it connects again
+	r.NoError(svc.FollowBackFollowers())
+	r.Equal(2, countType(contentOf(t, bot, self), "contact"))
+
+	r.NoError(svc.Seen(kpAli.Id, now.Add(30*time.Hour)))
+	pruned, err = svc.Pruned()
+	r.NoError(err)
+	r.Len(pruned, 0)
+
+	r.NoError(svc.FollowBackFollowers())
+	r.Eventually(follows(self, kpAli.Id), 5*time.Second, 50*time.Millisecond)
+	r.Equal(3, countType(contentOf(t, bot, self), "contact"))
+}
+
+func TestParseFollowBack(t *testing.T) {
+	r := require.New(t)
+
+	fb, err := pubmode.ParseFollowBack("")
+	r.NoError(err)
+	r.Equal(pubmode.FollowBackInvites, fb)
+
+	fb, err = pubmode.ParseFollowBack("peers")
+	r.NoError(err)
+	r.Equal(pubmode.FollowBackPeers, fb)
+
+	_, err = pubmode.ParseFollowBack("everyone")
+	r.Error(err)
+}
+
+// togglePublisher fails to publish while fail is set
+type togglePublisher struct {
+	ssb.Publisher
+	fail bool
+}
+
+func (tp *togglePublisher) Append(val interface{}) (margaret.Seq, error) {
+	if tp.fail {
+		return nil, errors.New("publish failed")
+	}
+	return tp.Publisher.Append(val)
+}
+
+func TestPruneOnlyFollowedBack(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	kpAli, err := repo.NewKeyPair(tRepo, "ali", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+	kpBob, err := repo.NewKeyPair(tRepo, "bob", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	bot, err := sbot.New(
+		sbot.WithInfo(log.NewNopLogger()),
+		sbot.WithRepoPath(tRepoPath),
+		sbot.DisableNetworkNode(),
+	)
+	r.NoError(err)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+	self := bot.KeyPair.Id
+
+	follows := func(from, to *ssb.FeedRef) func() bool {
+		return func() bool {
+			g, err := bot.GraphBuilder.Build()
+			return err == nil && g.Follows(from, to)
+		}
+	}
+
+	// ali follows the pub and is followed back, bob is followed by hand
+	_, err = bot.PublishAs("ali", ssb.NewContactFollow(self))
+	r.NoError(err)
+	_, err = bot.PublishLog.Publish(ssb.NewContactFollow(kpBob.Id))
+	r.NoError(err)
+	r.Eventually(follows(kpAli.Id, self), 5*time.Second, 50*time.Millisecond)
+	r.Eventually(follows(self, kpBob.Id), 5*time.Second, 50*time.Millisecond)
+
+	pub := &togglePublisher{Publisher: bot.PublishLog}
+	nw := fakeNetwork{addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008}}
+	svc, err := pubmode.New(log.NewNopLogger(), tRepo, self, nw, pub, bot.GraphBuilder, pubmode.Options{
+		FollowBack: pubmode.FollowBackFollowers,
+		PruneAfter: 24 * time.Hour,
+	})
+	r.NoError(err)
+	defer svc.Close()
+
+	r.NoError(svc.FollowBackFollowers())
+	r.Eventually(follows(self, kpAli.Id), 5*time.Second, 50*time.Millisecond)
+
+	now := time.Now()
+	r.NoError(svc.Prune(now))
+
+	// nothing is recorded if the unfollow can't be published
+	pub.fail = true
+	r.Error(svc.Prune(now.Add(25 * time.Hour)))
+	pruned, err := svc.Pruned()
+	r.NoError(err)
+	r.Len(pruned, 0)
+	_, has, err := svc.LastSeen(kpAli.Id)
+	r.NoError(err)
+	r.True(has)
+
+	pub.fail = false
+	r.NoError(svc.Prune(now.Add(25 * time.Hour)))
+	r.Eventually(func() bool { return !follows(self, kpAli.Id)() }, 5*time.Second, 50*time.Millisecond)
+	pruned, err = svc.Pruned()
+	r.NoError(err)
+	r.Len(pruned, 1)
+	r.True(pruned[0].Equal(kpAli.Id))
+
+	// bob was never seen either but wasn't followed by the pub
+	r.True(follows(self, kpBob.Id)())
+	r.Equal(3, countType(contentOf(t, bot, self), "contact"), "the follow of bob, the follow-back of ali and its unfollow")
+}
+
+func TestPruneInvited(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	kpAli, err := repo.NewKeyPair(tRepo, "ali", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	bot, err := sbot.New(
+		sbot.WithInfo(log.NewNopLogger()),
+		sbot.WithRepoPath(tRepoPath),
+		sbot.DisableNetworkNode(),
+	)
+	r.NoError(err)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+	self := bot.KeyPair.Id
+
+	follows := func(from, to *ssb.FeedRef) func() bool {
+		return func() bool {
+			g, err := bot.GraphBuilder.Build()
+			return err == nil && g.Follows(from, to)
+		}
+	}
+
+	nw := fakeNetwork{addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8008}}
+	svc, err := pubmode.New(log.NewNopLogger(), tRepo, self, nw, bot.PublishLog, bot.GraphBuilder, pubmode.Options{
+		FollowBack: pubmode.FollowBackInvites,
+		PruneAfter: 24 * time.Hour,
+	})
+	r.NoError(err)
+	defer svc.Close()
+
+	// ali redeems an invite, invite.use publishes the follow
+	now := time.Now()
+	_, err = bot.PublishLog.Publish(ssb.NewContactFollow(kpAli.Id))
+	r.NoError(err)
+	r.NoError(svc.Invited(kpAli.Id, now))
+	r.Eventually(follows(self, kpAli.Id), 5*time.Second, 50*time.Millisecond)
+
+	seen, has, err := svc.LastSeen(kpAli.Id)
+	r.NoError(err)
+	r.True(has)
+	r.Equal(now.Unix(), seen.Unix())
+
+	r.NoError(svc.Prune(now.Add(time.Hour)))
+	r.Equal(1, countType(contentOf(t, bot, self), "contact"))
+
+	r.NoError(svc.Prune(now.Add(25 * time.Hour)))
+	r.Eventually(func() bool { return !follows(self, kpAli.Id)() }, 5*time.Second, 50*time.Millisecond)
+	pruned, err := svc.Pruned()
+	r.NoError(err)
+	r.Len(pruned, 1)
+	r.True(pruned[0].Equal(kpAli.Id))
+}
@@ -0,0 +1,507 @@
+// SPDX-License-Identifier: MIT
+
+// Package quota keeps replicated feeds within storage limits.
+// The limits are set per hop or per feed. When a feed goes over them, its oldest messages are nulled
+// and the rest of it is served as a partial feed.
+package quota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/indexes"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Limits a feed can use, zero means no limit.
+// The newest message of a feed is kept even if it breaks them, otherwise the feed couldn't be fetched any further.
+type Limits struct {
+	// MaxMessages is the number of messages that are kept
+	MaxMessages int64 `json:"maxMessages,omitempty"`
+
+	// MaxAge drops messages that were claimed to be published longer ago than that
+	MaxAge time.Duration `json:"maxAge,omitempty"`
+
+	// MaxBytes is the size of the kept messages, as JSON
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// IsZero returns true if there are no limits
+func (l Limits) IsZero() bool { return l == Limits{} }
+
+// ParseLimits reads limits like messages=1000,age=720h,bytes=1048576
+func ParseLimits(s string) (Limits, error) {
+	var l Limits
+	for _, part := range strings.Split(s, ",") {
+		nameValue := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(nameValue) != 2 {
+			return l, fmt.Errorf("quota: invalid limit %q (want name=value)", part)
+		}
+		var err error
+		switch nameValue[0] {
+		case "messages":
+			l.MaxMessages, err = strconv.ParseInt(nameValue[1], 10, 64)
+		case "age":
+			l.MaxAge, err = time.ParseDuration(nameValue[1])
+		case "bytes":
+			l.MaxBytes, err = strconv.ParseInt(nameValue[1], 10, 64)
+		default:
+			return l, fmt.Errorf("quota: unknown limit %q (want messages, age or bytes)", nameValue[0])
+		}
+		if err != nil {
+			return l, fmt.Errorf("quota: invalid value for %s: %w", nameValue[0], err)
+		}
+	}
+	return l, nil
+}
+
+// Options are the storage policies
+type Options struct {
+	// Hops has the limits by the distance from the bot, as in graph.Builder.Hops:
+	// 0 are the feeds the bot follows, 1 the ones its friends follow and so on.
+	Hops map[int]Limits
+
+	// Feeds has limits for single feeds (by their reference), they take precedence over the hops
+	Feeds map[string]Limits
+
+	// Interval is how often all feeds are checked, defaults to an hour
+	Interval time.Duration
+}
+
+// ParseOptions reads policies like 2:messages=1000;@feed.ed25519:bytes=1048576,age=720h.
+// Each policy is a hop count or a feed reference, followed by its limits.
+func ParseOptions(s string) (Options, error) {
+	opts := Options{
+		Hops:  make(map[int]Limits),
+		Feeds: make(map[string]Limits),
+	}
+	for _, policy := range strings.Split(s, ";") {
+		policy = strings.TrimSpace(policy)
+		if policy == "" {
+			continue
+		}
+		idx := strings.LastIndex(policy, ":")
+		if idx < 0 {
+			return opts, fmt.Errorf("quota: invalid policy %q (want hops:limits or feed:limits)", policy)
+		}
+		l, err := ParseLimits(policy[idx+1:])
+		if err != nil {
+			return opts, err
+		}
+		which := policy[:idx]
+		if hops, err := strconv.Atoi(which); err == nil {
+			opts.Hops[hops] = l
+			continue
+		}
+		ref, err := ssb.ParseFeedRef(which)
+		if err != nil {
+			return opts, fmt.Errorf("quota: %q is neither a hop count nor a feed: %w", which, err)
+		}
+		opts.Feeds[ref.Ref()] = l
+	}
+	return opts, nil
+}
+
+// FeedState is what the last check of a feed found
+type FeedState struct {
+	Feed *ssb.FeedRef `json:"feed"`
+
+	// Hops is the distance from the bot or -1 if the feed is further away
+	Hops   int    `json:"hops"`
+	Limits Limits `json:"limits"`
+
+	// Latest is the sequence of the newest message of the feed
+	Latest int64 `json:"latest"`
+
+	// Evicted is the sequence up to which messages were nulled, the feed is partial if it's not zero
+	Evicted int64 `json:"evicted"`
+	Partial bool  `json:"partial"`
+
+	// Messages and Bytes are what is kept of the feed
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+
+	Checked time.Time `json:"checked"`
+}
+
+// Nuller can null entries of the root log
+type Nuller interface {
+	Null(margaret.Seq) error
+}
+
+// Identities are the feeds of the bot itself, they are never evicted
+type Identities interface {
+	IsIdentity(*ssb.FeedRef) bool
+}
+
+// Service checks the feeds against the limits and keeps the state of each feed
+type Service struct {
+	logger kitlog.Logger
+
+	self    *ssb.FeedRef
+	ids     Identities
+	root    margaret.Log
+	nuller  Nuller
+	content ssb.ContentNuller
+	feeds   multilog.MultiLog
+	graph   graph.Builder
+
+	opts   Options
+	maxHop int
+
+	mu sync.Mutex
+	kv *kv.DB
+}
+
+// New opens the state of the policies in the repo.
+// root needs to be the root log and nuller is used to null its entries, except for gabby grove feeds where only the content is nulled.
+func New(
+	logger kitlog.Logger,
+	r repo.Interface,
+	self *ssb.FeedRef,
+	ids Identities,
+	root margaret.Log,
+	nuller Nuller,
+	content ssb.ContentNuller,
+	feeds multilog.MultiLog,
+	gb graph.Builder,
+	opts Options,
+) (*Service, error) {
+	if opts.Interval == 0 {
+		opts.Interval = time.Hour
+	}
+	maxHop := -1
+	for h := range opts.Hops {
+		if h < 0 {
+			return nil, fmt.Errorf("quota: invalid hop count %d", h)
+		}
+		if h > maxHop {
+			maxHop = h
+		}
+	}
+
+	db, err := repo.OpenMKV(r.GetPath("plugin", "quota"))
+	if err != nil {
+		return nil, fmt.Errorf("quota: failed to open key-value database: %w", err)
+	}
+
+	return &Service{
+		logger: logger,
+
+		self:    self,
+		ids:     ids,
+		root:    root,
+		nuller:  nuller,
+		content: content,
+		feeds:   feeds,
+		graph:   gb,
+
+		opts:   opts,
+		maxHop: maxHop,
+
+		kv: db,
+	}, nil
+}
+
+// Close closes the underlying key-value database
+func (s *Service) Close() error { return s.kv.Close() }
+
+// Serve sweeps once per interval until ctx is canceled
+func (s *Service) Serve(ctx context.Context) error {
+	tick := time.NewTicker(s.opts.Interval)
+	defer tick.Stop()
+	for {
+		if err := s.Sweep(ctx, time.Now()); err != nil {
+			level.Warn(s.logger).Log("event", "sweep failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+	}
+}
+
+// Sweep checks all the feeds. A feed that fails is logged and skipped.
+func (s *Service) Sweep(ctx context.Context, now time.Time) error {
+	addrs, err := s.feeds.List()
+	if err != nil {
+		return fmt.Errorf("quota: failed to list feeds: %w", err)
+	}
+
+	hops := s.hopSets()
+	for _, addr := range addrs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var sr ssb.StorageRef
+		if err := sr.Unmarshal([]byte(addr)); err != nil {
+			continue
+		}
+		ref, err := sr.FeedRef()
+		if err != nil {
+			continue
+		}
+		if _, err := s.check(ref, hops, now); err != nil {
+			level.Warn(s.logger).Log("event", "check failed", "feed", ref.Ref(), "err", err)
+		}
+	}
+	return nil
+}
+
+// Check applies the policy to a single feed and returns its new state, or nil if there is no policy for it
+func (s *Service) Check(ref *ssb.FeedRef, now time.Time) (*FeedState, error) {
+	return s.check(ref, s.hopSets(), now)
+}
+
+// hopSets returns the feeds in range for each hop count up to the largest one with limits
+func (s *Service) hopSets() []*ssb.StrFeedSet {
+	sets := make([]*ssb.StrFeedSet, s.maxHop+1)
+	for h := range sets {
+		sets[h] = s.graph.Hops(s.self, h)
+	}
+	return sets
+}
+
+func (s *Service) check(ref *ssb.FeedRef, hops []*ssb.StrFeedSet, now time.Time) (*FeedState, error) {
+	if s.ids.IsIdentity(ref) {
+		return nil, nil
+	}
+
+	dist := -1
+	for h, set := range hops {
+		if set != nil && set.Has(ref) {
+			dist = h
+			break
+		}
+	}
+	limits, has := s.opts.Feeds[ref.Ref()]
+	if !has && dist >= 0 {
+		limits, has = s.opts.Hops[dist]
+	}
+	if !has || limits.IsZero() {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.getState(ref)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &FeedState{Feed: ref}
+	}
+	state.Hops = dist
+	state.Limits = limits
+	state.Checked = now
+
+	userLog, err := s.feeds.Get(ref.StoredAddr())
+	if err != nil {
+		return nil, fmt.Errorf("quota: failed to open feed: %w", err)
+	}
+	sv, err := userLog.Seq().Value()
+	if err != nil {
+		return nil, fmt.Errorf("quota: failed to get feed sequence: %w", err)
+	}
+	latest := int64(-1) // for an empty feed
+	if seq, ok := sv.(margaret.Seq); ok {
+		latest = seq.Seq()
+	}
+	// index i of the sublog is message i+1+offset of the feed, see multilogs.FeedOffset
+	offset, err := multilogs.FeedOffset(s.root, userLog)
+	if err != nil {
+		return nil, fmt.Errorf("quota: failed to get feed offset: %w", err)
+	}
+	state.Latest = latest + 1 + offset
+
+	// the feed was dropped and is fetched again from the start
+	if latest < 0 || state.Latest < state.Evicted {
+		state.Evicted = 0
+	}
+
+	// from the newest message back to the first one that breaks a limit.
+	// the newest one is always kept, fetching continues from it and it needs to be there to check the next message against.
+	state.Messages, state.Bytes = 0, 0
+	cut := int64(-1)
+	first := state.Evicted - offset // the first sublog index that wasn't evicted
+	if first < 0 {
+		first = 0
+	}
+	for i := latest; i >= first; i-- {
+		rootSeq, err := userLog.Get(margaret.BaseSeq(i))
+		if err != nil {
+			return nil, fmt.Errorf("quota: failed to get entry %d of feed: %w", i, err)
+		}
+		v, err := s.root.Get(rootSeq.(margaret.Seq))
+		if margaret.IsErrNulled(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("quota: failed to get message %d of feed: %w", i+1+offset, err)
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			return nil, fmt.Errorf("quota: unexpected message type %T", v)
+		}
+
+		size := int64(len(msg.ValueContentJSON()))
+		claimed := time.Unix(0, indexes.ClampedTimestamp(msg)*int64(time.Millisecond))
+		overLimit := (limits.MaxMessages > 0 && state.Messages+1 > limits.MaxMessages) ||
+			(limits.MaxBytes > 0 && state.Bytes+size > limits.MaxBytes) ||
+			(limits.MaxAge > 0 && now.Sub(claimed) > limits.MaxAge)
+		if overLimit && i < latest {
+			cut = i
+			break
+		}
+		state.Messages++
+		state.Bytes += size
+	}
+
+	for i := first; i <= cut; i++ {
+		if err := s.evict(ref, userLog, i, offset); err != nil {
+			return nil, err
+		}
+		state.Evicted = i + 1 + offset
+	}
+	if cut >= 0 {
+		level.Info(s.logger).Log("event", "evicted", "feed", ref.Ref(), "upto", state.Evicted, "kept", state.Messages)
+	}
+	state.Partial = state.Evicted > 0
+
+	if err := s.setState(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// evict nulls the message at the (zero based) index i of the sublog, which is message i+1+offset of the feed
+func (s *Service) evict(ref *ssb.FeedRef, userLog margaret.Log, i, offset int64) error {
+	if ref.Algo == ssb.RefAlgoFeedGabby {
+		// the signature can still be checked without the content
+		err := s.content.NullContent(ref, uint(i+1+offset))
+		if err != nil {
+			return fmt.Errorf("quota: failed to null content of message %d: %w", i+1+offset, err)
+		}
+		return nil
+	}
+
+	rootSeq, err := userLog.Get(margaret.BaseSeq(i))
+	if err != nil {
+		return fmt.Errorf("quota: failed to get entry %d of feed: %w", i, err)
+	}
+	err = s.nuller.Null(rootSeq.(margaret.Seq))
+	if err != nil && !margaret.IsErrNulled(err) {
+		return fmt.Errorf("quota: failed to null message %d: %w", i+1+offset, err)
+	}
+	return nil
+}
+
+var statePrefix = []byte("state:")
+
+func stateKey(ref *ssb.FeedRef) []byte {
+	return append(append([]byte{}, statePrefix...), ref.StoredAddr()...)
+}
+
+// getState needs to be called with s.mu locked
+func (s *Service) getState(ref *ssb.FeedRef) (*FeedState, error) {
+	data, err := s.kv.Get(nil, stateKey(ref))
+	if err != nil {
+		return nil, fmt.Errorf("quota: failed to get state: %w", err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var state FeedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("quota: failed to decode state: %w", err)
+	}
+	return &state, nil
+}
+
+// setState needs to be called with s.mu locked
+func (s *Service) setState(state *FeedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := s.kv.Set(stateKey(state.Feed), data); err != nil {
+		return fmt.Errorf("quota: failed to store state: %w", err)
+	}
+	return nil
+}
+
+// Forget drops the state of ref, NullFeed calls it when the feed is deleted from the repo.
+// A copy that is fetched again starts from the first message and isn't limited by what was evicted before.
+func (s *Service) Forget(ref *ssb.FeedRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.kv.Delete(stateKey(ref)); err != nil {
+		return fmt.Errorf("quota: failed to delete state: %w", err)
+	}
+	return nil
+}
+
+// ErrNoState is returned by State for feeds that weren't checked yet or have no policy
+var ErrNoState = errors.New("quota: no policy state for this feed")
+
+// State returns what the last check of ref found
+func (s *Service) State(ref *ssb.FeedRef) (*FeedState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.getState(ref)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, ErrNoState
+	}
+	return state, nil
+}
+
+// States returns the state of all the feeds that were checked, sorted by their reference
+func (s *Service) States() ([]FeedState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enum, _, err := s.kv.Seek(statePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("quota: failed to seek states: %w", err)
+	}
+
+	states := []FeedState{}
+	for {
+		k, v, err := enum.Next()
+		if err == io.EOF || (err == nil && !bytes.HasPrefix(k, statePrefix)) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("quota: failed to get next state: %w", err)
+		}
+		var state FeedState
+		if err := json.Unmarshal(v, &state); err != nil {
+			return nil, fmt.Errorf("quota: failed to decode state: %w", err)
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Feed.Ref() < states[j].Feed.Ref() })
+	return states, nil
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MIT
+
+package quota_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/plugins/quota"
+	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func TestParseOptions(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	opts, err := quota.ParseOptions("2:messages=1000,age=720h; " + kp.Id.Ref() + ":bytes=1048576")
+	r.NoError(err)
+	r.Equal(quota.Limits{MaxMessages: 1000, MaxAge: 720 * time.Hour}, opts.Hops[2])
+	r.Equal(quota.Limits{MaxBytes: 1048576}, opts.Feeds[kp.Id.Ref()])
+
+	_, err = quota.ParseOptions("2:size=10")
+	r.Error(err)
+	_, err = quota.ParseOptions("someone:messages=10")
+	r.Error(err)
+}
+
+func TestEviction(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	bot, err := sbot.New(
+		sbot.WithInfo(log.NewNopLogger()),
+		sbot.WithRepoPath(tRepoPath),
+		sbot.DisableNetworkNode(),
+	)
+	r.NoError(err)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+
+	uf, ok := bot.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+
+	// the keys of the other feeds are not in the repo, those would be identities of the bot
+	newFeed := func(n int) *ssb.FeedRef {
+		kp, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		pub, err := message.OpenPublishLog(bot.RootLog, uf, kp)
+		r.NoError(err)
+		for i := 0; i < n; i++ {
+			_, err := pub.Publish(map[string]interface{}{"type": "test", "i": i})
+			r.NoError(err)
+		}
+		return kp.Id
+	}
+	big := newFeed(10)
+	old := newFeed(2)
+
+	_, err = bot.PublishLog.Publish(ssb.NewContactFollow(big))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+
+	svc, err := quota.New(log.NewNopLogger(), repo.New(tRepoPath), bot.KeyPair.Id, bot, bot.RootLog, bot.RootLog, bot, uf, bot.GraphBuilder, quota.Options{
+		Hops:  map[int]quota.Limits{0: {MaxMessages: 3}},
+		Feeds: map[string]quota.Limits{old.Ref(): {MaxAge: time.Hour}},
+	})
+	r.NoError(err)
+	defer svc.Close()
+
+	now := time.Now()
+	r.NoError(svc.Sweep(context.TODO(), now))
+
+	state, err := svc.State(big)
+	r.NoError(err)
+	r.Equal(0, state.Hops)
+	r.EqualValues(10, state.Latest)
+	r.EqualValues(7, state.Evicted)
+	r.EqualValues(3, state.Messages)
+	r.True(state.Partial)
+
+	bigLog, err := uf.Get(big.StoredAddr())
+	r.NoError(err)
+	for i := 0; i < 10; i++ {
+		rootSeq, err := bigLog.Get(margaret.BaseSeq(i))
+		r.NoError(err)
+		_, err = bot.RootLog.Get(rootSeq.(margaret.Seq))
+		if i < 7 {
+			r.True(margaret.IsErrNulled(err), "expected %d to be nulled: %v", i, err)
+		} else {
+			r.NoError(err, "expected %d to be kept", i)
+		}
+	}
+
+	// nothing more to do on the next check
+	state, err = svc.Check(big, now)
+	r.NoError(err)
+	r.EqualValues(7, state.Evicted)
+	r.EqualValues(3, state.Messages)
+
+	// feeds with their own limits don't need to be in range
+	state, err = svc.State(old)
+	r.NoError(err)
+	r.Equal(-1, state.Hops)
+	r.False(state.Partial)
+	state, err = svc.Check(old, now.Add(2*time.Hour))
+	r.NoError(err)
+	r.EqualValues(1, state.Evicted, "the newest message is kept")
+	r.EqualValues(1, state.Messages)
+	r.True(state.Partial)
+
+	// the feed of the bot is never touched
+	_, err = svc.State(bot.KeyPair.Id)
+	r.Equal(quota.ErrNoState, err)
+
+	states, err := svc.States()
+	r.NoError(err)
+	r.Len(states, 2)
+}
@@ -4,6 +4,7 @@ package replicate
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
@@ -11,17 +12,26 @@ import (
 	"go.cryptoscope.co/muxrpc"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/plugins/quota"
 )
 
 type replicatePlug struct {
 	h muxrpc.Handler
 }
 
+// Policies has the state of the storage policies of the feeds, see the quota plugin
+type Policies interface {
+	State(*ssb.FeedRef) (*quota.FeedState, error)
+	States() ([]quota.FeedState, error)
+}
+
 // TODO: add replicate, block, changes
-func NewPlug(users multilog.MultiLog) ssb.Plugin {
+// policies can be nil if there are no storage policies.
+func NewPlug(users multilog.MultiLog, policies Policies) ssb.Plugin {
 	plug := &replicatePlug{}
 	plug.h = replicateHandler{
-		users: users,
+		users:    users,
+		policies: policies,
 	}
 	return plug
 }
@@ -36,12 +46,18 @@ func (lt replicatePlug) Handler() muxrpc.Handler {
 }
 
 type replicateHandler struct {
-	users multilog.MultiLog
+	users    multilog.MultiLog
+	policies Policies
 }
 
 func (g replicateHandler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
 
 func (g replicateHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if len(req.Method) == 2 && req.Method[1] == "policy" {
+		g.policy(ctx, req)
+		return
+	}
+
 	if len(req.Method) < 2 && req.Method[1] != "upto" {
 		req.CloseWithError(errors.Errorf("invalid method"))
 		return
@@ -62,3 +78,44 @@ func (g replicateHandler) HandleCall(ctx context.Context, req *muxrpc.Request, e
 
 	req.Stream.Close()
 }
+
+// PolicyArgs are the optional arguments of replicate.policy
+type PolicyArgs struct {
+	Feed *ssb.FeedRef `json:"feed"`
+}
+
+// policy returns the storage policy state of one feed or of all the feeds that have a policy
+func (g replicateHandler) policy(ctx context.Context, req *muxrpc.Request) {
+	if req.Type == "" {
+		req.Type = "async"
+	}
+	if g.policies == nil {
+		req.CloseWithError(errors.Errorf("replicate: no storage policies configured"))
+		return
+	}
+
+	var args []PolicyArgs
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			req.CloseWithError(errors.Wrap(err, "replicate: invalid policy arguments"))
+			return
+		}
+	}
+
+	var (
+		v   interface{}
+		err error
+	)
+	if len(args) > 0 && args[0].Feed != nil {
+		v, err = g.policies.State(args[0].Feed)
+	} else {
+		v, err = g.policies.States()
+	}
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "replicate: failed to get policy state"))
+		return
+	}
+	if err := req.Return(ctx, v); err != nil {
+		req.CloseWithError(err)
+	}
+}
@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: MIT
+
+// Package tunnel implements the tunnel.* calls of ssb-room.
+//
+// As a room it relays duplex streams between the peers that are connected to it (the attendants).
+// As an attendant it accepts the streams the room relays to it and hands them to the network node,
+// which does secret-handshake over them like on any other connection.
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/muxrpc/codec"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/network"
+)
+
+// Acceptor serves connections that came in through a room
+type Acceptor interface {
+	AcceptTunnel(ctx context.Context, origin *ssb.FeedRef, conn net.Conn) error
+}
+
+var method = muxrpc.Method{"tunnel"}
+
+type Plugin struct {
+	h *handler
+}
+
+// New creates the tunnel plugin. If isRoom is false, it only accepts tunneled connections for itself.
+func New(logger log.Logger, self *ssb.FeedRef, acceptor Acceptor, isRoom bool) *Plugin {
+	return &Plugin{
+		h: &handler{
+			logger:   logger,
+			self:     self,
+			acceptor: acceptor,
+			isRoom:   isRoom,
+
+			attendants: make(map[string]*attendant),
+			watchers:   make(map[*endpointsWatcher]struct{}),
+		},
+	}
+}
+
+func (Plugin) Name() string              { return "tunnel" }
+func (Plugin) Method() muxrpc.Method     { return method }
+func (p Plugin) Handler() muxrpc.Handler { return p.h }
+
+// Attendants returns the feeds that can currently be reached through this room
+func (p Plugin) Attendants() []*ssb.FeedRef {
+	return p.h.announced(nil)
+}
+
+type attendant struct {
+	feed *ssb.FeedRef
+	edp  muxrpc.Endpoint
+
+	// attendants can hide themselves with tunnel.leave
+	hidden bool
+}
+
+type handler struct {
+	logger log.Logger
+
+	self     *ssb.FeedRef
+	acceptor Acceptor
+	isRoom   bool
+
+	mu         sync.Mutex
+	attendants map[string]*attendant
+	watchers   map[*endpointsWatcher]struct{}
+}
+
+// HandleConnect makes every peer connected to the room an attendant until the connection is closed
+func (h *handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {
+	if !h.isRoom {
+		return
+	}
+
+	remote, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil || remote.Equal(h.self) {
+		return
+	}
+
+	h.mu.Lock()
+	at := &attendant{feed: remote, edp: edp}
+	h.attendants[remote.Ref()] = at
+	h.notifyLocked()
+	h.mu.Unlock()
+
+	<-ctx.Done()
+
+	h.mu.Lock()
+	// a newer connection of the same peer might have replaced it
+	if h.attendants[remote.Ref()] == at {
+		delete(h.attendants, remote.Ref())
+		h.notifyLocked()
+	}
+	h.mu.Unlock()
+}
+
+func (h *handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if len(req.Method) != 2 {
+		req.CloseWithError(errors.Errorf("tunnel: unknown command: %s", req.Method))
+		return
+	}
+
+	var err error
+	switch req.Method[1] {
+	case "isRoom":
+		err = req.Return(ctx, h.isRoom)
+
+	case "announce", "leave":
+		err = h.setHidden(edp, req.Method[1] == "leave")
+		if err == nil {
+			err = req.Return(ctx, true)
+		}
+
+	case "endpoints":
+		err = h.endpoints(ctx, req, edp)
+
+	case "connect":
+		err = h.connect(ctx, req, edp)
+
+	case "ping":
+		err = req.Return(ctx, time.Now().UnixNano()/int64(time.Millisecond))
+
+	default:
+		err = errors.Errorf("tunnel: unknown command: %s", req.Method)
+	}
+	if err != nil {
+		level.Debug(h.logger).Log("call", req.Method.String(), "err", err)
+		req.CloseWithError(err)
+	}
+}
+
+func (h *handler) setHidden(edp muxrpc.Endpoint, hidden bool) error {
+	if !h.isRoom {
+		return errors.New("tunnel: not a room")
+	}
+	remote, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	at, has := h.attendants[remote.Ref()]
+	if !has {
+		return errors.New("tunnel: not connected")
+	}
+	at.hidden = hidden
+	h.notifyLocked()
+	return nil
+}
+
+// announced returns the attendants that didn't leave, without the one passed
+func (h *handler) announced(without *ssb.FeedRef) []*ssb.FeedRef {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.announcedLocked(without)
+}
+
+func (h *handler) announcedLocked(without *ssb.FeedRef) []*ssb.FeedRef {
+	lst := []*ssb.FeedRef{}
+	for _, at := range h.attendants {
+		if at.hidden || (without != nil && at.feed.Equal(without)) {
+			continue
+		}
+		lst = append(lst, at.feed)
+	}
+	return lst
+}
+
+type endpointsWatcher struct {
+	caller *ssb.FeedRef
+	update chan []*ssb.FeedRef
+}
+
+// notifyLocked sends the current list to all the callers of tunnel.endpoints
+func (h *handler) notifyLocked() {
+	for w := range h.watchers {
+		lst := h.announcedLocked(w.caller)
+		// only the latest list matters
+		select {
+		case <-w.update:
+		default:
+		}
+		w.update <- lst
+	}
+}
+
+// endpoints sends the list of attendants every time it changes
+func (h *handler) endpoints(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) error {
+	if !h.isRoom {
+		return errors.New("tunnel: not a room")
+	}
+	caller, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		return err
+	}
+
+	w := &endpointsWatcher{
+		caller: caller,
+		update: make(chan []*ssb.FeedRef, 1),
+	}
+	h.mu.Lock()
+	h.watchers[w] = struct{}{}
+	w.update <- h.announcedLocked(caller)
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.watchers, w)
+		h.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return req.Stream.Close()
+		case lst := <-w.update:
+			if err := req.Stream.Pour(ctx, lst); err != nil {
+				return nil // the caller went away
+			}
+		}
+	}
+}
+
+func (h *handler) connect(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) error {
+	var args []network.TunnelConnectArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return errors.Wrap(err, "tunnel.connect: bad arguments")
+	}
+	if len(args) != 1 || args[0].Target == nil || args[0].Portal == nil {
+		return errors.New("tunnel.connect: expected one argument {portal, target}")
+	}
+	arg := args[0]
+
+	caller, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		return err
+	}
+
+	if arg.Target.Equal(h.self) {
+		// we are the target, only the room itself passes streams on to us.
+		// peers that want to reach us directly have to connect directly.
+		if arg.Portal.Equal(h.self) || !arg.Portal.Equal(caller) {
+			return errors.New("tunnel.connect: can't tunnel to the room itself")
+		}
+		if arg.Origin == nil {
+			return errors.New("tunnel.connect: the room didn't say who is connecting")
+		}
+		if h.acceptor == nil {
+			return errors.New("tunnel.connect: can't accept tunneled connections")
+		}
+		conn := network.NewTunnelConn(req.Stream, req.Stream,
+			network.TunnelAddr{Portal: arg.Portal, Target: h.self},
+			network.TunnelAddr{Portal: arg.Portal, Target: arg.Origin})
+		return h.acceptor.AcceptTunnel(ctx, arg.Origin, conn)
+	}
+
+	if !h.isRoom || !arg.Portal.Equal(h.self) {
+		return errors.Errorf("tunnel.connect: not a portal for %s", arg.Target.ShortRef())
+	}
+
+	origin := caller
+
+	h.mu.Lock()
+	target, has := h.attendants[arg.Target.Ref()]
+	h.mu.Unlock()
+	if !has {
+		return errors.Errorf("tunnel.connect: %s is not connected", arg.Target.ShortRef())
+	}
+
+	arg.Origin = origin
+	targetSrc, targetSnk, err := target.edp.Duplex(ctx, codec.Body{}, muxrpc.Method{"tunnel", "connect"}, arg)
+	if err != nil {
+		return errors.Wrap(err, "tunnel.connect: failed to call target")
+	}
+	level.Debug(h.logger).Log("event", "relaying", "origin", origin.ShortRef(), "target", arg.Target.ShortRef())
+
+	return relay(ctx, req.Stream, targetSrc, targetSnk)
+}
+
+// relay copies the bytes between the origin and the target until one of them closes their side
+func relay(ctx context.Context, origin muxrpc.Stream, targetSrc luigi.Source, targetSnk luigi.Sink) error {
+	toTarget := muxrpc.NewSinkWriter(targetSnk)
+	toOrigin := muxrpc.NewSinkWriter(origin)
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(toTarget, muxrpc.NewSourceReader(origin))
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(toOrigin, muxrpc.NewSourceReader(targetSrc))
+		errc <- err
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+	}
+	toTarget.Close()
+	toOrigin.Close()
+	if err != nil && !luigi.IsEOS(err) {
+		return fmt.Errorf("tunnel: relay failed: %w", err)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ package plugins2
 import (
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
 )
 
 type AuthMode uint
@@ -25,3 +26,8 @@ type NeedsRootLog interface {
 type NeedsMultiLog interface {
 	WantMultiLog(ssb.MultiLogGetter) error
 }
+
+// NeedsFeedMigrations is implemented by plugins that carry data of a feed over to the one it moved to
+type NeedsFeedMigrations interface {
+	WantFeedMigrations(graph.FeedMigrations) error
+}
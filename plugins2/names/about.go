@@ -19,11 +19,23 @@ import (
 	"go.cryptoscope.co/margaret"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
 	"go.cryptoscope.co/ssb/repo"
 )
 
 type aboutStore struct {
 	kv *badger.DB
+
+	// about info of feeds that moved is carried over to the new feed
+	migrations graph.FeedMigrations
+}
+
+// predecessors returns the feeds that moved to ref
+func (ab aboutStore) predecessors(ref *ssb.FeedRef) ([]*ssb.FeedRef, error) {
+	if ab.migrations == nil {
+		return nil, nil
+	}
+	return graph.AllPredecessors(ab.migrations, ref)
 }
 
 type AboutInfo struct {
@@ -36,6 +48,26 @@ type AboutAttribute struct {
 }
 
 func (ab aboutStore) ImageFor(ref *ssb.FeedRef) (*ssb.BlobRef, error) {
+	br, err := ab.imageFor(ref)
+	if err != badger.ErrKeyNotFound {
+		return br, err
+	}
+
+	// the image of an old feed if there is none yet
+	preds, predErr := ab.predecessors(ref)
+	if predErr != nil {
+		return nil, predErr
+	}
+	for _, p := range preds {
+		br, err = ab.imageFor(p)
+		if err != badger.ErrKeyNotFound {
+			return br, err
+		}
+	}
+	return br, err
+}
+
+func (ab aboutStore) imageFor(ref *ssb.FeedRef) (*ssb.BlobRef, error) {
 	var br ssb.BlobRef
 
 	err := ab.kv.View(func(txn *badger.Txn) error {
@@ -114,31 +146,73 @@ func (ab aboutStore) All() (client.NamesGetResult, error) {
 		}
 		return nil
 	})
-	return ngr, err
+	if err != nil || ab.migrations == nil {
+		return ngr, err
+	}
+
+	// names for feeds that moved count for the new feed, unless it has its own
+	for about, abouts := range ngr {
+		ref, err := ssb.ParseFeedRef(about)
+		if err != nil {
+			continue
+		}
+		current, err := graph.ResolveFeed(ab.migrations, ref)
+		if err != nil {
+			return nil, errors.Wrap(err, "about.All: failed to resolve feed migrations")
+		}
+		if current.Equal(ref) {
+			continue
+		}
+
+		carried, ok := ngr[current.Ref()]
+		if !ok {
+			carried = make(map[string]string)
+			ngr[current.Ref()] = carried
+		}
+		for author, name := range abouts {
+			if author == about {
+				author = current.Ref()
+			}
+			if _, has := carried[author]; !has {
+				carried[author] = name
+			}
+		}
+	}
+	return ngr, nil
 }
 
 func (ab aboutStore) CollectedFor(ref *ssb.FeedRef) (*AboutInfo, error) {
-	addr := []byte(ref.Ref() + ":")
-	// from self
-	// addr = append(addr, ref.ID...)
-
-	// explicit lookup
-	// addr = append(addr, []byte(":name")...)
-	// if obs, err := ab.idx.Get(context.TODO(), librarian.Addr(addr)); err == nil {
-	// 	val, err := obs.Value()
-	// 	if err == nil {
-	// 		return val.(string)
-	// 	}
-	// }
-
-	// direct badger magic
-	// most of this feels like to direct k:v magic to be honest
 	var reduced AboutInfo
 	reduced.Name.Prescribed = make(map[string]int)
 	reduced.Description.Prescribed = make(map[string]int)
 	reduced.Image.Prescribed = make(map[string]int)
 
-	err := ab.kv.View(func(txn *badger.Txn) error {
+	// the abouts of feeds that moved to ref are carried over, the closer ones first
+	preds, err := ab.predecessors(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "about: failed to get feed migrations")
+	}
+	selves := map[string]struct{}{ref.Ref(): {}}
+	for _, p := range preds {
+		selves[p.Ref()] = struct{}{}
+	}
+
+	for _, about := range append([]*ssb.FeedRef{ref}, preds...) {
+		if err := ab.collect(about, selves, &reduced); err != nil {
+			return nil, errors.Wrap(err, "name db lookup failed")
+		}
+	}
+	return &reduced, nil
+}
+
+// collect adds what was said about the feed about to reduced.
+// What one of the selves said is chosen, if nothing was chosen before.
+func (ab aboutStore) collect(about *ssb.FeedRef, selves map[string]struct{}, reduced *AboutInfo) error {
+	addr := []byte(about.Ref() + ":")
+
+	// direct badger magic
+	// most of this feels like to direct k:v magic to be honest
+	return ab.kv.View(func(txn *badger.Txn) error {
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
 
@@ -147,7 +221,7 @@ func (ab aboutStore) CollectedFor(ref *ssb.FeedRef) (*AboutInfo, error) {
 			k := it.Key()
 			splitted := bytes.Split(k, []byte(":"))
 
-			c, err := ssb.ParseFeedRef(string(splitted[0]))
+			c, err := ssb.ParseFeedRef(string(splitted[1]))
 			if err != nil {
 				return errors.Wrapf(err, "about: couldnt make author ref from db key: %s", splitted)
 			}
@@ -170,16 +244,12 @@ func (ab aboutStore) CollectedFor(ref *ssb.FeedRef) (*AboutInfo, error) {
 					log.Printf("no field for: %q", string(k))
 					return nil
 				}
-				if c.Equal(ref) {
-					fieldPtr.Chosen = foundVal
-				} else {
-					cnt, has := fieldPtr.Prescribed[foundVal]
-					if has {
-						cnt++
-					} else {
-						cnt = 1
+				if _, self := selves[c.Ref()]; self {
+					if fieldPtr.Chosen == "" {
+						fieldPtr.Chosen = foundVal
 					}
-					fieldPtr.Prescribed[foundVal] = cnt
+				} else {
+					fieldPtr.Prescribed[foundVal]++
 				}
 
 				return nil
@@ -191,8 +261,6 @@ func (ab aboutStore) CollectedFor(ref *ssb.FeedRef) (*AboutInfo, error) {
 		}
 		return nil
 	})
-
-	return &reduced, errors.Wrap(err, "name db lookup failed")
 }
 
 const FolderNameAbout = "about"
@@ -211,11 +279,17 @@ func (plug *Plugin) MakeSimpleIndex(r repo.Interface) (librarian.Index, libraria
 
 	// TODO: hook serve to close db
 
-	plug.about = aboutStore{db}
+	plug.about.kv = db
 
 	return idx, update, err
 }
 
+// WantFeedMigrations carries the about info of feeds that moved over to the new ones
+func (plug *Plugin) WantFeedMigrations(fm graph.FeedMigrations) error {
+	plug.about.migrations = fm
+	return nil
+}
+
 func updateAboutMessage(ctx context.Context, seq margaret.Seq, msgv interface{}, idx librarian.SetterIndex) error {
 	msg, ok := msgv.(ssb.Message)
 	if !ok {
@@ -0,0 +1,255 @@
+// SPDX-License-Identifier: MIT
+
+package query
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cryptix/go/encodedTime"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// supported operators. a plain value (not an object) in the filter is the same as $eq.
+const (
+	opEqual       = "$eq"
+	opIn          = "$in"
+	opGreaterThan = "$gt"
+	opLessThan    = "$lt"
+)
+
+// Filter is a compiled query.read filter.
+// It is constructed from a JSON object that mirrors the shape of a message (key, value, timestamp), like this:
+//
+//	{"value": {"author": "@...", "content": {"type": "post", "root": {"$in": ["%...", "%..."]}}, "timestamp": {"$gt": 1590000000000}}}
+//
+// Nested objects that don't start with a $ are treated as paths into the message.
+// Objects with $ keys are operators on the value at that path. All conditions need to match.
+type Filter struct {
+	conds []condition
+}
+
+type condition struct {
+	path []string
+	op   string
+
+	// $in has all of them, the others use only the first
+	vals []interface{}
+}
+
+// ParseFilter compiles the passed JSON object into a Filter.
+// An empty or null object matches all messages.
+func ParseFilter(raw json.RawMessage) (*Filter, error) {
+	var f Filter
+	if len(raw) == 0 || string(raw) == "null" {
+		return &f, nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, errors.Wrap(err, "query: filter is not a JSON object")
+	}
+
+	if err := f.compile(nil, obj); err != nil {
+		return nil, err
+	}
+
+	// make the order of the conditions stable (map iteration isn't)
+	sort.Slice(f.conds, func(i, j int) bool {
+		a, b := strings.Join(f.conds[i].path, "."), strings.Join(f.conds[j].path, ".")
+		if a == b {
+			return f.conds[i].op < f.conds[j].op
+		}
+		return a < b
+	})
+	return &f, nil
+}
+
+func (f *Filter) compile(path []string, obj map[string]interface{}) error {
+	for k, v := range obj {
+		if strings.HasPrefix(k, "$") {
+			if len(path) == 0 {
+				return errors.Errorf("query: operator %s needs a field", k)
+			}
+			cond := condition{
+				path: path,
+				op:   k,
+			}
+			switch k {
+			case opEqual, opGreaterThan, opLessThan:
+				cond.vals = []interface{}{v}
+			case opIn:
+				arr, ok := v.([]interface{})
+				if !ok {
+					return errors.Errorf("query: %s on %s needs an array (got %T)", k, strings.Join(path, "."), v)
+				}
+				cond.vals = arr
+			default:
+				return errors.Errorf("query: unsupported operator %s", k)
+			}
+			f.conds = append(f.conds, cond)
+			continue
+		}
+
+		// copy so that siblings don't share the backing array
+		fieldPath := make([]string, len(path)+1)
+		copy(fieldPath, path)
+		fieldPath[len(path)] = k
+
+		if nested, ok := v.(map[string]interface{}); ok {
+			if err := f.compile(fieldPath, nested); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f.conds = append(f.conds, condition{
+			path: fieldPath,
+			op:   opEqual,
+			vals: []interface{}{v},
+		})
+	}
+	return nil
+}
+
+// Match returns true if all conditions of the filter are met by the generic (JSON decoded) message
+func (f Filter) Match(msg map[string]interface{}) bool {
+	for _, c := range f.conds {
+		if !c.match(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchMessage decodes the message into the key/value/timestamp form and checks it against the filter
+func (f Filter) MatchMessage(msg ssb.Message) (bool, error) {
+	if len(f.conds) == 0 {
+		return true, nil
+	}
+	m, err := genericMessage(msg)
+	if err != nil {
+		return false, err
+	}
+	return f.Match(m), nil
+}
+
+// EqualString returns the value of an $eq (or plain) string condition on path, if there is one.
+// It is used to pick an index.
+func (f Filter) EqualString(path ...string) (string, bool) {
+	for _, c := range f.conds {
+		if c.op != opEqual || !reflect.DeepEqual(c.path, path) {
+			continue
+		}
+		s, ok := c.vals[0].(string)
+		if ok {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+func (c condition) match(msg map[string]interface{}) bool {
+	field, has := lookup(msg, c.path)
+	if !has {
+		return false
+	}
+
+	switch c.op {
+	case opEqual, opIn:
+		for _, want := range c.vals {
+			if equal(field, want) {
+				return true
+			}
+		}
+		return false
+
+	case opGreaterThan:
+		cmp, ok := compare(field, c.vals[0])
+		return ok && cmp > 0
+
+	case opLessThan:
+		cmp, ok := compare(field, c.vals[0])
+		return ok && cmp < 0
+	}
+	return false
+}
+
+func lookup(v interface{}, path []string) (interface{}, bool) {
+	for _, p := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = obj[p]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// equal also matches if the field is an array and one of its elements is equal (like branch or mentions)
+func equal(field, want interface{}) bool {
+	if reflect.DeepEqual(field, want) {
+		return true
+	}
+	arr, ok := field.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, elem := range arr {
+		if reflect.DeepEqual(elem, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare only works on two numbers or two strings
+func compare(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case float64:
+		bv, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case av < bv:
+			return -1, true
+		case av > bv:
+			return 1, true
+		}
+		return 0, true
+
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	}
+	return 0, false
+}
+
+func genericMessage(msg ssb.Message) (map[string]interface{}, error) {
+	var kv ssb.KeyValueRaw
+	kv.Key_ = msg.Key()
+	kv.Value = *msg.ValueContent()
+	kv.Timestamp = encodedTime.Millisecs(msg.Received())
+
+	b, err := json.Marshal(kv)
+	if err != nil {
+		return nil, errors.Wrap(err, "query: failed to encode message")
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrap(err, "query: failed to decode message")
+	}
+	return m, nil
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MIT
+
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterMatch(t *testing.T) {
+	r := require.New(t)
+
+	var msg map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"key": "%Mi2fnXl1nH2/sqaLLFrI7gbaGdJ0WXKV2RdJ3LBVQ1c=.sha256",
+		"value": {
+			"author": "@Fi5jtXpuqT9z3xDlQ4iQnNwuIMWPOBt8s7RCcZFZnJI=.ed25519",
+			"sequence": 3,
+			"timestamp": 1590000000000,
+			"content": {
+				"type": "post",
+				"text": "hello",
+				"branch": ["%AAAfnXl1nH2/sqaLLFrI7gbaGdJ0WXKV2RdJ3LBVQ1c=.sha256", "%BBBfnXl1nH2/sqaLLFrI7gbaGdJ0WXKV2RdJ3LBVQ1c=.sha256"]
+			}
+		},
+		"timestamp": 1590000001000
+	}`), &msg)
+	r.NoError(err)
+
+	type tcase struct {
+		filter string
+		match  bool
+	}
+	var tcases = []tcase{
+		{`{}`, true},
+		{`{"value": {"content": {"type": "post"}}}`, true},
+		{`{"value": {"content": {"type": "about"}}}`, false},
+		{`{"value": {"content": {"type": {"$in": ["about", "post"]}}}}`, true},
+		{`{"value": {"content": {"type": {"$in": ["about", "contact"]}}}}`, false},
+		{`{"value": {"author": "@Fi5jtXpuqT9z3xDlQ4iQnNwuIMWPOBt8s7RCcZFZnJI=.ed25519", "content": {"type": "post"}}}`, true},
+		{`{"value": {"timestamp": {"$gt": 1580000000000}}}`, true},
+		{`{"value": {"timestamp": {"$gt": 1580000000000, "$lt": 1590000000000}}}`, false},
+		{`{"value": {"sequence": {"$lt": 4}}}`, true},
+		{`{"timestamp": {"$gt": 1590000000000}}`, true},
+		{`{"value": {"content": {"branch": "%BBBfnXl1nH2/sqaLLFrI7gbaGdJ0WXKV2RdJ3LBVQ1c=.sha256"}}}`, true},
+		{`{"value": {"content": {"root": "%BBBfnXl1nH2/sqaLLFrI7gbaGdJ0WXKV2RdJ3LBVQ1c=.sha256"}}}`, false},
+		{`{"value": {"content": {"text": {"$gt": 1}}}}`, false}, // mixed types never match
+	}
+
+	for i, tc := range tcases {
+		f, err := ParseFilter(json.RawMessage(tc.filter))
+		r.NoError(err, "case %d", i)
+		r.Equal(tc.match, f.Match(msg), "case %d: %s", i, tc.filter)
+	}
+}
+
+func TestFilterParseErrors(t *testing.T) {
+	r := require.New(t)
+
+	var bad = []string{
+		`[]`,
+		`{"$gt": 1}`,
+		`{"value": {"sequence": {"$gte": 4}}}`,
+		`{"value": {"author": {"$in": "@Fi5jtXpuqT9z3xDlQ4iQnNwuIMWPOBt8s7RCcZFZnJI=.ed25519"}}}`,
+	}
+	for i, filter := range bad {
+		_, err := ParseFilter(json.RawMessage(filter))
+		r.Error(err, "case %d: %s", i, filter)
+	}
+}
+
+func TestFilterEqualString(t *testing.T) {
+	r := require.New(t)
+
+	f, err := ParseFilter(json.RawMessage(`{"value": {"author": {"$in": ["a", "b"]}, "content": {"type": "post"}}}`))
+	r.NoError(err)
+
+	typ, has := f.EqualString("value", "content", "type")
+	r.True(has)
+	r.Equal("post", typ)
+
+	_, has = f.EqualString("value", "author")
+	r.False(has, "$in can't be used for a single index lookup")
+}
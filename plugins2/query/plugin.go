@@ -0,0 +1,355 @@
+// SPDX-License-Identifier: MIT
+
+// Package query implements query.read, a generic way of filtering messages on the root log.
+// It picks one of the available indexes (tangles, userFeeds, msgTypes) to narrow down the candidates
+// and falls back to scanning the whole log if none of them are usable for the passed filter.
+package query
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/cryptix/go/encodedTime"
+	"github.com/cryptix/go/logging"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/muxmux"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/plugins2"
+)
+
+type Plugin struct {
+	h readHandler
+}
+
+var (
+	_ plugins2.NeedsRootLog  = (*Plugin)(nil)
+	_ plugins2.NeedsMultiLog = (*Plugin)(nil)
+)
+
+func (p *Plugin) WantRootLog(rl margaret.Log) error {
+	p.h.root = rl
+	return nil
+}
+
+// WantMultiLog keeps the getter around, the indexes are looked up when a query comes in
+// so that the order in which the plugins are mounted doesn't matter.
+func (p *Plugin) WantMultiLog(mlogs ssb.MultiLogGetter) error {
+	p.h.mlogs = mlogs
+	return nil
+}
+
+func (Plugin) Name() string          { return "query" }
+func (Plugin) Method() muxrpc.Method { return muxrpc.Method{"query"} }
+func (p Plugin) Handler() muxrpc.Handler {
+	log := logging.Logger("query")
+	p.h.log = log
+
+	mux := muxmux.New(log)
+	mux.RegisterSource(muxrpc.Method{"query", "read"}, p.h)
+	return &mux
+}
+
+// ReadArgs are the arguments of query.read
+type ReadArgs struct {
+	// Query is the filter, see Filter for the format
+	Query json.RawMessage `json:"query"`
+
+	Live    bool  `json:"live,omitempty"`
+	Reverse bool  `json:"reverse,omitempty"`
+	Limit   int64 `json:"limit,omitempty"`
+
+	// Gt and Lt are cursors on the root log. Pass the cursor of the last result to get the next page.
+	Gt *int64 `json:"gt,omitempty"`
+	Lt *int64 `json:"lt,omitempty"`
+}
+
+// Result is one matching message, together with its position on the root log
+type Result struct {
+	Key       *ssb.MessageRef       `json:"key"`
+	Value     ssb.Value             `json:"value"`
+	Timestamp encodedTime.Millisecs `json:"timestamp"`
+
+	Cursor int64 `json:"cursor"`
+}
+
+type readHandler struct {
+	log logging.Interface
+
+	root  margaret.Log
+	mlogs ssb.MultiLogGetter
+}
+
+func (h readHandler) HandleSource(ctx context.Context, req *muxrpc.Request, snk luigi.Sink) error {
+	var args []ReadArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return errors.Wrap(err, "query.read: invalid arguments")
+	}
+	if len(args) != 1 {
+		return errors.Errorf("query.read: expected one argument object")
+	}
+	qry := args[0]
+
+	if qry.Live && qry.Reverse {
+		return errors.Errorf("query.read: can't do live in reverse")
+	}
+
+	if qry.Limit == 0 {
+		qry.Limit = -1
+	}
+
+	filter, err := ParseFilter(qry.Query)
+	if err != nil {
+		return err
+	}
+
+	cs, err := h.candidates(filter, qry)
+	if err != nil {
+		return err
+	}
+
+	for qry.Limit != 0 {
+		rxSeq, msg, err := cs.next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				break
+			}
+			return err
+		}
+
+		if qry.Gt != nil && rxSeq <= *qry.Gt {
+			if qry.Reverse {
+				break // only getting smaller from here on
+			}
+			continue
+		}
+		if qry.Lt != nil && rxSeq >= *qry.Lt {
+			if !qry.Reverse {
+				break
+			}
+			continue
+		}
+
+		ok, err := filter.MatchMessage(msg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		res := Result{
+			Key:       msg.Key(),
+			Value:     *msg.ValueContent(),
+			Timestamp: encodedTime.Millisecs(msg.Received()),
+			Cursor:    rxSeq,
+		}
+		if err := snk.Pour(ctx, res); err != nil {
+			return errors.Wrap(err, "query.read: failed to send result")
+		}
+
+		if qry.Limit > 0 {
+			qry.Limit--
+		}
+	}
+
+	return snk.Close()
+}
+
+// candidates picks the most selective index that is usable for this filter.
+// the tangle of a thread is usually the smallest set, followed by the feed of an author.
+// message types can be huge (like post or vote) but are still better then everything.
+func (h readHandler) candidates(f *Filter, qry ReadArgs) (*candidateSource, error) {
+	type plan struct {
+		index string
+		path  []string
+		addr  func(string) (librarian.Addr, error)
+	}
+	var plans = []plan{
+		{"tangles", []string{"value", "content", "root"}, func(v string) (librarian.Addr, error) {
+			ref, err := ssb.ParseMessageRef(v)
+			if err != nil {
+				return "", err
+			}
+			return librarian.Addr(ref.Hash), nil
+		}},
+		{multilogs.IndexNameFeeds, []string{"value", "author"}, func(v string) (librarian.Addr, error) {
+			ref, err := ssb.ParseFeedRef(v)
+			if err != nil {
+				return "", err
+			}
+			return ref.StoredAddr(), nil
+		}},
+		{"msgTypes", []string{"value", "content", "type"}, func(v string) (librarian.Addr, error) {
+			return librarian.Addr(v), nil
+		}},
+	}
+
+	var specs = []margaret.QuerySpec{
+		margaret.Live(qry.Live),
+		margaret.Reverse(qry.Reverse),
+	}
+
+	for _, p := range plans {
+		val, has := f.EqualString(p.path...)
+		if !has || h.mlogs == nil {
+			continue
+		}
+		mlog, has := h.mlogs.GetMultiLog(p.index)
+		if !has {
+			continue
+		}
+
+		addr, err := p.addr(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "query.read: invalid value for %s", p.index)
+		}
+
+		sublog, err := mlog.Get(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "query.read: failed to open sublog of %s", p.index)
+		}
+
+		// the cursors are on the root log, they are translated to the sublog
+		if qry.Gt != nil {
+			first, err := sublogSeq(sublog, *qry.Gt)
+			if err != nil {
+				return nil, errors.Wrapf(err, "query.read: failed to find cursor in %s", p.index)
+			}
+			if first > 0 {
+				specs = append(specs, margaret.Gt(margaret.BaseSeq(first-1)))
+			}
+		}
+		if qry.Lt != nil {
+			end, err := sublogSeq(sublog, *qry.Lt-1)
+			if err != nil {
+				return nil, errors.Wrapf(err, "query.read: failed to find cursor in %s", p.index)
+			}
+			specs = append(specs, margaret.Lt(margaret.BaseSeq(end)))
+		}
+
+		src, err := sublog.Query(specs...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "query.read: failed to query %s", p.index)
+		}
+		level.Debug(h.log).Log("event", "query plan", "index", p.index)
+		return &candidateSource{src: src, root: h.root, indirect: true}, nil
+	}
+
+	// full scan, but we can at least let the log skip to the cursor
+	specs = append(specs, margaret.SeqWrap(true))
+	if qry.Gt != nil {
+		specs = append(specs, margaret.Gt(margaret.BaseSeq(*qry.Gt)))
+	}
+	if qry.Lt != nil {
+		specs = append(specs, margaret.Lt(margaret.BaseSeq(*qry.Lt)))
+	}
+
+	src, err := h.root.Query(specs...)
+	if err != nil {
+		return nil, errors.Wrap(err, "query.read: failed to query root log")
+	}
+	level.Debug(h.log).Log("event", "query plan", "index", "none")
+	return &candidateSource{src: src, root: h.root}, nil
+}
+
+// sublogSeq returns the first sequence on the sublog whose entry is greater than rxSeq, or the next one if there is none.
+// The entries of a sublog are sequences on the root log in ascending order, so it can search for them.
+func sublogSeq(sublog margaret.Log, rxSeq int64) (int64, error) {
+	v, err := sublog.Seq().Value()
+	if err != nil {
+		return 0, err
+	}
+	curr, ok := v.(margaret.Seq)
+	if !ok {
+		return 0, errors.Errorf("query: unexpected sublog sequence: %T", v)
+	}
+
+	lo, hi := int64(0), curr.Seq()+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		v, err := sublog.Get(margaret.BaseSeq(mid))
+		if err != nil {
+			return 0, err
+		}
+		seq, ok := v.(margaret.Seq)
+		if !ok {
+			return 0, errors.Errorf("query: unexpected sublog entry: %T", v)
+		}
+		if seq.Seq() > rxSeq {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// candidateSource unifies the two ways of getting messages:
+// resolving the sequences of a sublog (indirect) or getting them from the root log directly (sequence wrapped)
+type candidateSource struct {
+	src  luigi.Source
+	root margaret.Log
+
+	indirect bool
+}
+
+// next returns the next message and its sequence on the root log. Nulled entries are skipped.
+func (cs candidateSource) next(ctx context.Context) (int64, ssb.Message, error) {
+	for {
+		v, err := cs.src.Next(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if errv, ok := v.(error); ok {
+			if margaret.IsErrNulled(errv) {
+				continue
+			}
+			return 0, nil, errv
+		}
+
+		var rxSeq margaret.Seq
+		if cs.indirect {
+			seq, ok := v.(margaret.Seq)
+			if !ok {
+				return 0, nil, errors.Errorf("query: unexpected sublog entry: %T", v)
+			}
+			rxSeq = seq
+
+			v, err = cs.root.Get(seq)
+			if err != nil {
+				if margaret.IsErrNulled(err) {
+					continue
+				}
+				return 0, nil, errors.Wrap(err, "query: failed to resolve sublog entry")
+			}
+		} else {
+			sw, ok := v.(margaret.SeqWrapper)
+			if !ok {
+				return 0, nil, errors.Errorf("query: unexpected root log entry: %T", v)
+			}
+			rxSeq = sw.Seq()
+			v = sw.Value()
+		}
+
+		if errv, ok := v.(error); ok {
+			if margaret.IsErrNulled(errv) {
+				continue
+			}
+			return 0, nil, errv
+		}
+
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			return 0, nil, errors.Errorf("query: unexpected message type: %T", v)
+		}
+		return rxSeq.Seq(), msg, nil
+	}
+}
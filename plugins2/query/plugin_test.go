@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: MIT
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/asynctesting"
+	"go.cryptoscope.co/ssb/internal/ctxutils"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/plugins2/bytype"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+type testMultiLogs map[string]multilog.MultiLog
+
+func (tm testMultiLogs) GetMultiLog(name string) (multilog.MultiLog, bool) {
+	ml, has := tm[name]
+	return ml, has
+}
+
+// cursorSink collects the cursors of the results a query sends
+type cursorSink struct {
+	ch chan int64
+}
+
+func (cs cursorSink) Pour(ctx context.Context, v interface{}) error {
+	cs.ch <- v.(Result).Cursor
+	return nil
+}
+
+func (cs cursorSink) Close() error { return nil }
+
+func TestRead(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(tRepoPath)
+
+	ctx, cancel := ctxutils.WithError(context.Background(), ssb.ErrShuttingDown)
+
+	tRepo := repo.New(tRepoPath)
+	tRootLog, err := repo.OpenLog(tRepo)
+	r.NoError(err)
+
+	uf, serveUF, err := multilogs.OpenUserFeeds(tRepo)
+	r.NoError(err)
+	ufErrc := asynctesting.ServeLog(ctx, "user feeds", tRootLog, serveUF, true)
+
+	mt, serveMT, err := repo.OpenMultiLog(tRepo, "msgTypes", bytype.IndexUpdate)
+	r.NoError(err)
+	mtErrc := asynctesting.ServeLog(ctx, "message types", tRootLog, serveMT, true)
+
+	h := readHandler{
+		log:   testutils.NewRelativeTimeLogger(nil),
+		root:  tRootLog,
+		mlogs: testMultiLogs{multilogs.IndexNameFeeds: uf, "msgTypes": mt},
+	}
+
+	alice, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	alicePublish, err := message.OpenPublishLog(tRootLog, uf, alice)
+	r.NoError(err)
+
+	bob, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	bobPublish, err := message.OpenPublishLog(tRootLog, uf, bob)
+	r.NoError(err)
+
+	// waits until the sublog at addr has an entry at seq
+	waitIndexed := func(ml multilog.MultiLog, addr librarian.Addr, seq int64) {
+		sublog, err := ml.Get(addr)
+		r.NoError(err)
+		for i := 0; ; i++ {
+			r.True(i < 100, "index didn't catch up")
+			v, err := sublog.Seq().Value()
+			r.NoError(err)
+			if v.(margaret.Seq).Seq() >= seq {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	post := map[string]interface{}{"type": "post", "text": "hello"}
+	about := map[string]interface{}{"type": "about", "about": alice.Id.Ref(), "name": "alice"}
+
+	// alice: 0 post, 1 about, 2 post, 3 post; bob: 4 post, 5 post
+	var (
+		authored = make(map[librarian.Addr]int64)
+		typed    = make(map[librarian.Addr]int64)
+	)
+	for i, tc := range []struct {
+		author  *ssb.KeyPair
+		pub     ssb.Publisher
+		content map[string]interface{}
+	}{
+		{alice, alicePublish, post},
+		{alice, alicePublish, about},
+		{alice, alicePublish, post},
+		{alice, alicePublish, post},
+		{bob, bobPublish, post},
+		{bob, bobPublish, post},
+	} {
+		seq, err := tc.pub.Append(tc.content)
+		r.NoError(err)
+		r.EqualValues(i, seq.Seq())
+
+		// the publisher needs the previous message of the author in the index
+		authorAddr := tc.author.Id.StoredAddr()
+		waitIndexed(uf, authorAddr, authored[authorAddr])
+		authored[authorAddr]++
+		typeAddr := librarian.Addr(tc.content["type"].(string))
+		waitIndexed(mt, typeAddr, typed[typeAddr])
+		typed[typeAddr]++
+	}
+
+	read := func(args ReadArgs) ([]int64, error) {
+		raw, err := json.Marshal([]ReadArgs{args})
+		r.NoError(err)
+
+		snk := cursorSink{ch: make(chan int64, 100)}
+		err = h.HandleSource(ctx, &muxrpc.Request{RawArgs: raw}, snk)
+		close(snk.ch)
+
+		var cursors []int64
+		for c := range snk.ch {
+			cursors = append(cursors, c)
+		}
+		return cursors, err
+	}
+
+	cursor := func(v int64) *int64 { return &v }
+	byAuthor := func(ref *ssb.FeedRef) json.RawMessage {
+		return json.RawMessage(`{"value":{"author":"` + ref.Ref() + `"}}`)
+	}
+	byType := json.RawMessage(`{"value":{"content":{"type":"post"}}}`)
+	byText := json.RawMessage(`{"value":{"content":{"text":"hello"}}}`)
+
+	type tcase struct {
+		args ReadArgs
+		want []int64
+	}
+	var tcases = []tcase{
+		{ReadArgs{Query: byAuthor(alice.Id)}, []int64{0, 1, 2, 3}},
+		{ReadArgs{Query: byAuthor(bob.Id), Gt: cursor(4)}, []int64{5}},
+		{ReadArgs{Query: byAuthor(bob.Id), Lt: cursor(4)}, nil},
+
+		{ReadArgs{Query: byType}, []int64{0, 2, 3, 4, 5}},
+		{ReadArgs{Query: byType, Gt: cursor(2)}, []int64{3, 4, 5}},
+		{ReadArgs{Query: byType, Gt: cursor(1)}, []int64{2, 3, 4, 5}},
+		{ReadArgs{Query: byType, Lt: cursor(4)}, []int64{0, 2, 3}},
+		{ReadArgs{Query: byType, Gt: cursor(0), Lt: cursor(5)}, []int64{2, 3, 4}},
+		{ReadArgs{Query: byType, Gt: cursor(5)}, nil},
+		{ReadArgs{Query: byType, Reverse: true, Limit: 2}, []int64{5, 4}},
+		{ReadArgs{Query: byType, Reverse: true, Lt: cursor(4)}, []int64{3, 2, 0}},
+
+		// no index for this, the whole log is scanned
+		{ReadArgs{Query: byText, Gt: cursor(1), Limit: 2}, []int64{2, 3}},
+		{ReadArgs{Query: byText, Lt: cursor(3), Reverse: true}, []int64{2, 0}},
+	}
+	for i, tc := range tcases {
+		got, err := read(tc.args)
+		r.NoError(err, "case %d", i)
+		r.Equal(tc.want, got, "case %d", i)
+	}
+
+	// live queries stop at the limit, too
+	raw, err := json.Marshal([]ReadArgs{{Query: byAuthor(bob.Id), Live: true, Limit: 3}})
+	r.NoError(err)
+	snk := cursorSink{ch: make(chan int64, 100)}
+	done := make(chan error, 1)
+	go func() {
+		done <- h.HandleSource(ctx, &muxrpc.Request{RawArgs: raw}, snk)
+	}()
+	r.EqualValues(4, <-snk.ch)
+	r.EqualValues(5, <-snk.ch)
+
+	_, err = bobPublish.Append(post)
+	r.NoError(err)
+	r.EqualValues(6, <-snk.ch)
+
+	select {
+	case err := <-done:
+		r.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("live query didn't stop at the limit")
+	}
+
+	_, err = read(ReadArgs{Query: byType, Live: true, Reverse: true})
+	r.Error(err)
+
+	mt.Close()
+	uf.Close()
+	cancel()
+
+	for err := range asynctesting.MergedErrors(mtErrc, ufErrc) {
+		r.NoError(err, "from chan")
+	}
+}
@@ -0,0 +1,335 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo/atrest"
+)
+
+// AtRestConfigName is the file that holds the atrest.Params of a repo that is encrypted at rest
+const AtRestConfigName = "atrest.json"
+
+// ErrAtRestLocked is returned if the log, blobs or indexes of an encrypted repo are opened without a key
+var ErrAtRestLocked = errors.New("repo: the repo is encrypted at rest but no key was given")
+
+// ReadAtRestParams returns the params of the repo or nil if it isn't encrypted
+func ReadAtRestParams(r Interface) (*atrest.Params, error) {
+	data, err := ioutil.ReadFile(r.GetPath(AtRestConfigName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "repo: failed to read at-rest config")
+	}
+	var p atrest.Params
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, errors.Wrap(err, "repo: failed to decode at-rest config")
+	}
+	return &p, nil
+}
+
+// EnableAtRest makes the new repo r encrypted at rest and returns the key for it.
+// It fails if the repo already has a log, existing repos can be moved over with a backup and restore.
+func EnableAtRest(r Interface, mode atrest.Mode, kp *ssb.KeyPair, passphrase []byte) (*atrest.Key, error) {
+	if _, err := os.Stat(r.GetPath("log")); err == nil {
+		return nil, errors.New("repo: at-rest encryption can only be enabled on new repos")
+	}
+	if p, err := ReadAtRestParams(r); err != nil {
+		return nil, err
+	} else if p != nil {
+		return nil, errors.New("repo: the repo is already encrypted at rest")
+	}
+
+	p, k, err := atrest.NewParams(mode, kp, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(r.GetPath(), 0700); err != nil {
+		return nil, errors.Wrap(err, "repo: failed to create repo folder")
+	}
+	if err := writeJSONFile(r.GetPath(AtRestConfigName), p); err != nil {
+		return nil, errors.Wrap(err, "repo: failed to write at-rest config")
+	}
+	return k, nil
+}
+
+// UnlockAtRest returns the key of the repo or nil if it isn't encrypted.
+// kp is used for atrest.ModeSecret and passphrase for atrest.ModePassphrase.
+func UnlockAtRest(r Interface, kp *ssb.KeyPair, passphrase ssb.PassphraseFunc) (*atrest.Key, error) {
+	p, err := ReadAtRestParams(r)
+	if err != nil || p == nil {
+		return nil, err
+	}
+
+	var pass []byte
+	if p.Mode == atrest.ModePassphrase {
+		if passphrase == nil {
+			return nil, ssb.ErrNoPassphrase
+		}
+		pass, err = passphrase(r.GetPath(AtRestConfigName))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.Key(kp, pass)
+}
+
+type keyedRepo struct {
+	Interface
+	k *atrest.Key
+}
+
+// WithAtRestKey returns a repo that opens the log and blob store of r with the key k.
+// If k is nil, r is returned as it is.
+func WithAtRestKey(r Interface, k *atrest.Key) Interface {
+	if k == nil {
+		return r
+	}
+	return keyedRepo{Interface: r, k: k}
+}
+
+// atRestKey returns the key of the repo, nil if it isn't encrypted or ErrAtRestLocked if there is no key for it
+func atRestKey(r Interface) (*atrest.Key, error) {
+	if kr, ok := r.(keyedRepo); ok {
+		return kr.k, nil
+	}
+	if _, err := os.Stat(r.GetPath(AtRestConfigName)); err == nil {
+		return nil, ErrAtRestLocked
+	}
+	return nil, nil
+}
+
+// Unlocked returns r with its key if it is encrypted at rest, for tools that open the log without a bot.
+// The key pair for atrest.ModeSecret is loaded from the default secret of the repo.
+func Unlocked(r Interface, passphrase ssb.PassphraseFunc) (Interface, error) {
+	p, err := ReadAtRestParams(r)
+	if err != nil || p == nil {
+		return r, err
+	}
+	var kp *ssb.KeyPair
+	if p.Mode == atrest.ModeSecret {
+		kp, err = ssb.LoadKeyPairWithPassphrase(r.GetPath("secret"), passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "repo: failed to load the secret for the at-rest key")
+		}
+	}
+	k, err := UnlockAtRest(r, kp, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return WithAtRestKey(r, k), nil
+}
+
+// ShareAtRest makes the new repo to encrypted like the unlocked repo from, by copying its config, and returns it with the key.
+// If from isn't encrypted, to is returned as it is.
+func ShareAtRest(from, to Interface) (Interface, error) {
+	k, err := atRestKey(from)
+	if err != nil || k == nil {
+		return to, err
+	}
+	if err := os.MkdirAll(to.GetPath(), 0700); err != nil {
+		return nil, errors.Wrap(err, "repo: failed to create repo folder")
+	}
+	if err := copyFile(from.GetPath(AtRestConfigName), to.GetPath(AtRestConfigName), 0600); err != nil && !os.IsExist(err) {
+		return nil, errors.Wrap(err, "repo: failed to copy at-rest config")
+	}
+	return WithAtRestKey(to, k), nil
+}
+
+// SealedIndexesName is the file that holds the index data of a repo that is encrypted at rest, while no bot is running
+const SealedIndexesName = "indexes.sealed"
+
+// the folders that are sealed, the plugin state is as telling as the indexes
+var sealedFolders = append(append([]string{}, indexFolders...), "plugin")
+
+// SealIndexes encrypts the index data of r into SealedIndexesName and removes the plain folders.
+// The databases can't encrypt themselves, so they are only plain while a bot is using them, which calls this when it's closed.
+// It does nothing if r isn't encrypted at rest.
+func SealIndexes(r Interface) error {
+	k, err := atRestKey(r)
+	if err != nil || k == nil {
+		return err
+	}
+
+	fname := r.GetPath(SealedIndexesName)
+	if err := writeSealedIndexes(r, k, fname); err != nil {
+		return err
+	}
+	for _, folder := range sealedFolders {
+		if err := os.RemoveAll(r.GetPath(folder)); err != nil {
+			return errors.Wrapf(err, "repo: failed to remove plain %s", folder)
+		}
+	}
+	return nil
+}
+
+// writeSealedIndexes writes the encrypted archive of the index data of r to fname, replacing it atomically
+func writeSealedIndexes(r Interface, k *atrest.Key, fname string) error {
+	f, err := ioutil.TempFile(filepath.Dir(fname), SealedIndexesName)
+	if err != nil {
+		return errors.Wrap(err, "repo: failed to create sealed indexes")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w, err := atrest.NewWriter(k, f)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, folder := range sealedFolders {
+		if err := tarFolder(tw, r.GetPath(), folder); err != nil {
+			return errors.Wrapf(err, "repo: failed to seal %s", folder)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "repo: failed to seal indexes")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "repo: failed to seal indexes")
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Wrap(err, "repo: failed to sync sealed indexes")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "repo: failed to close sealed indexes")
+	}
+	return errors.Wrap(os.Rename(f.Name(), fname), "repo: failed to replace sealed indexes")
+}
+
+func tarFolder(tw *tar.Writer, base, folder string) error {
+	err := filepath.Walk(filepath.Join(base, folder), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		// lock files belong to the process that had the databases open
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".lock") {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, info.Size())
+		return err
+	})
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil
+	}
+	return err
+}
+
+// UnsealIndexes restores the plain index data of r from SealedIndexesName, before the databases are opened.
+// It does nothing if there is no sealed index data.
+func UnsealIndexes(r Interface) error {
+	fname := r.GetPath(SealedIndexesName)
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "repo: failed to open sealed indexes")
+	}
+	defer f.Close()
+
+	k, err := atRestKey(r)
+	if err != nil {
+		return err
+	}
+	if k == nil {
+		return ErrAtRestLocked
+	}
+
+	// left over from a bot that didn't close, the sealed data is the one to use
+	for _, folder := range sealedFolders {
+		if err := os.RemoveAll(r.GetPath(folder)); err != nil {
+			return errors.Wrapf(err, "repo: failed to remove plain %s", folder)
+		}
+	}
+
+	tr := tar.NewReader(atrest.NewReader(k, f))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "repo: failed to unseal indexes")
+		}
+		if err := untarEntry(r, tr, hdr); err != nil {
+			return errors.Wrapf(err, "repo: failed to unseal %s", hdr.Name)
+		}
+	}
+
+	// only removed once everything is back, otherwise the next start tries again
+	f.Close()
+	return errors.Wrap(os.Remove(fname), "repo: failed to remove sealed indexes")
+}
+
+func untarEntry(r Interface, rd io.Reader, hdr *tar.Header) error {
+	rel := filepath.FromSlash(hdr.Name)
+	parts := strings.Split(rel, string(filepath.Separator))
+	if filepath.IsAbs(rel) || !isSealedFolder(parts[0]) {
+		return errors.New("unexpected path")
+	}
+	for _, p := range parts {
+		if p == ".." {
+			return errors.New("unexpected path")
+		}
+	}
+	target := r.GetPath(rel)
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0700)
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, rd); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	default:
+		return errors.Errorf("unexpected entry type %d", hdr.Typeflag)
+	}
+}
+
+func isSealedFolder(name string) bool {
+	for _, folder := range sealedFolders {
+		if name == folder {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,339 @@
+// SPDX-License-Identifier: MIT
+
+package atrest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/codec/msgpack"
+	"go.cryptoscope.co/margaret/offset2"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
+	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/repo/atrest"
+)
+
+func newKey(t testing.TB) *atrest.Key {
+	var k atrest.Key
+	_, err := rand.Read(k[:])
+	require.NoError(t, err)
+	return &k
+}
+
+func TestParams(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	other, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	p, k, err := atrest.NewParams(atrest.ModeSecret, kp, nil)
+	r.NoError(err)
+	got, err := p.Key(kp, nil)
+	r.NoError(err)
+	r.Equal(k, got)
+	_, err = p.Key(other, nil)
+	r.Equal(atrest.ErrWrongKey, err)
+
+	p, k, err = atrest.NewParams(atrest.ModePassphrase, nil, []byte("correct horse"))
+	r.NoError(err)
+	got, err = p.Key(nil, []byte("correct horse"))
+	r.NoError(err)
+	r.Equal(k, got)
+	_, err = p.Key(nil, []byte("battery staple"))
+	r.Equal(atrest.ErrWrongKey, err)
+	_, err = p.Key(nil, nil)
+	r.Equal(ssb.ErrNoPassphrase, err)
+}
+
+func TestCodec(t *testing.T) {
+	r := require.New(t)
+	k := newKey(t)
+
+	c := atrest.NewCodec(msgpack.New(margaret.BaseSeq(0)), k)
+
+	data, err := c.Marshal(margaret.BaseSeq(23))
+	r.NoError(err)
+	v, err := c.Unmarshal(data)
+	r.NoError(err)
+	r.EqualValues(23, v.(margaret.Seq).Seq())
+
+	// the decoder only reads its entry, even if the reader goes on
+	var buf bytes.Buffer
+	r.NoError(c.NewEncoder(&buf).Encode(margaret.BaseSeq(42)))
+	buf.Write([]byte("trailing garbage"))
+	v, err = c.NewDecoder(&buf).Decode()
+	r.NoError(err)
+	r.EqualValues(42, v.(margaret.Seq).Seq())
+
+	data[len(data)-1] ^= 1
+	_, err = c.Unmarshal(data)
+	r.Equal(atrest.ErrBroken, err)
+
+	_, err = atrest.NewCodec(msgpack.New(margaret.BaseSeq(0)), newKey(t)).Unmarshal(data)
+	r.Equal(atrest.ErrBroken, err)
+}
+
+func TestLog(t *testing.T) {
+	r := require.New(t)
+	k := newKey(t)
+
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+
+	l, err := offset2.Open(testPath, atrest.NewCodec(msgpack.New(margaret.BaseSeq(0)), k))
+	r.NoError(err)
+	for i := 0; i < 10; i++ {
+		_, err := l.Append(margaret.BaseSeq(100 + i))
+		r.NoError(err)
+	}
+	r.NoError(l.Null(margaret.BaseSeq(3)))
+	r.NoError(l.Close())
+
+	l, err = offset2.Open(testPath, atrest.NewCodec(msgpack.New(margaret.BaseSeq(0)), k))
+	r.NoError(err)
+	defer l.Close()
+	v, err := l.Get(margaret.BaseSeq(7))
+	r.NoError(err)
+	r.EqualValues(107, v.(margaret.Seq).Seq())
+	_, err = l.Get(margaret.BaseSeq(3))
+	r.True(margaret.IsErrNulled(err), "expected nulled entry: %v", err)
+}
+
+func openBlobs(t testing.TB, k *atrest.Key) (ssb.BlobStore, func()) {
+	r := require.New(t)
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+
+	inner, err := blobstore.New(filepath.Join(testPath, "blobs"))
+	r.NoError(err)
+	if k == nil {
+		return inner, func() {}
+	}
+	idx, err := repo.OpenMKV(filepath.Join(testPath, "idx"))
+	r.NoError(err)
+	bs := atrest.NewBlobStore(inner, k, idx)
+	return bs, func() { idx.Close() }
+}
+
+func TestBlobStore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.TODO()
+
+	bs, done := openBlobs(t, newKey(t))
+	defer done()
+
+	var notified []ssb.BlobStoreNotification
+	cancel := bs.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return nil
+		}
+		notified = append(notified, v.(ssb.BlobStoreNotification))
+		return nil
+	}))
+	defer cancel()
+
+	content := []byte("a secret picture")
+	ref, err := bs.Put(bytes.NewReader(content))
+	r.NoError(err)
+	hash := sha256.Sum256(content)
+	r.Equal(hash[:], ref.Hash, "the ref is the one of the plain blob")
+
+	again, err := bs.Put(bytes.NewReader(content))
+	r.NoError(err)
+	r.True(again.Equal(ref))
+
+	rd, err := bs.Get(ref)
+	r.NoError(err)
+	got, err := ioutil.ReadAll(rd)
+	r.NoError(err)
+	r.Equal(content, got)
+
+	sz, err := bs.Size(ref)
+	r.NoError(err)
+	r.EqualValues(len(content), sz)
+
+	v, err := bs.List().Next(ctx)
+	r.NoError(err)
+	r.True(v.(*ssb.BlobRef).Equal(ref))
+
+	// nothing in the folder is readable
+	r.NoError(filepath.Walk(filepath.Join("testrun", t.Name()), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		r.NoError(err)
+		r.False(bytes.Contains(data, content), "plain content in %s", path)
+		r.False(bytes.Contains(data, ref.Hash), "plain ref in %s", path)
+		return nil
+	}))
+
+	r.NoError(bs.Delete(ref))
+	_, err = bs.Get(ref)
+	r.Equal(blobstore.ErrNoSuchBlob, err)
+	_, err = bs.List().Next(ctx)
+	r.True(luigi.IsEOS(err))
+
+	r.Len(notified, 2)
+	r.Equal(ssb.BlobStoreOpPut, notified[0].Op)
+	r.Equal(ssb.BlobStoreOpRm, notified[1].Op)
+}
+
+func TestBlobStoreConcurrentPut(t *testing.T) {
+	r := require.New(t)
+
+	bs, done := openBlobs(t, newKey(t))
+	defer done()
+
+	content := []byte("put by many at once")
+	var wg sync.WaitGroup
+	errc := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := bs.Put(bytes.NewReader(content))
+			errc <- err
+		}()
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		r.NoError(err)
+	}
+
+	// only one sealed copy is stored
+	var files int
+	r.NoError(filepath.Walk(filepath.Join("testrun", t.Name(), "blobs", "sha256"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files++
+		}
+		return err
+	}))
+	r.Equal(1, files)
+}
+
+func TestStream(t *testing.T) {
+	r := require.New(t)
+	k := newKey(t)
+
+	seal := func(data []byte) []byte {
+		var buf bytes.Buffer
+		w, err := atrest.NewWriter(k, &buf)
+		r.NoError(err)
+		// odd writes, so that they don't line up with the boxes
+		for len(data) > 0 {
+			n := 1000
+			if n > len(data) {
+				n = len(data)
+			}
+			_, err := w.Write(data[:n])
+			r.NoError(err)
+			data = data[n:]
+		}
+		r.NoError(w.Close())
+		return buf.Bytes()
+	}
+
+	for _, size := range []int{0, 1, 64 * 1024, 200*1024 + 7} {
+		data := make([]byte, size)
+		rand.Read(data)
+		box := seal(data)
+		r.False(size > 0 && bytes.Contains(box, data), "plain data in the stream")
+
+		got, err := ioutil.ReadAll(atrest.NewReader(k, bytes.NewReader(box)))
+		r.NoError(err, "size %d", size)
+		r.Equal(data, got)
+	}
+
+	data := make([]byte, 150*1024)
+	rand.Read(data)
+	box := seal(data)
+
+	broken := map[string][]byte{
+		"changed":   append([]byte{}, box...),
+		"truncated": box[:len(box)-70*1024],
+		"appended":  append(append([]byte{}, box...), 0),
+		"empty":     nil,
+	}
+	broken["changed"][len(box)/2] ^= 1
+	for name, b := range broken {
+		_, err := ioutil.ReadAll(atrest.NewReader(k, bytes.NewReader(b)))
+		r.Equal(atrest.ErrBroken, err, name)
+	}
+
+	_, err := ioutil.ReadAll(atrest.NewReader(newKey(t), bytes.NewReader(box)))
+	r.Equal(atrest.ErrBroken, err, "wrong key")
+}
+
+func BenchmarkCodec(b *testing.B) {
+	inner := msgpack.New([]byte{})
+	entry := make([]byte, 1024)
+	rand.Read(entry)
+
+	run := func(c margaret.Codec) func(*testing.B) {
+		return func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(entry)))
+			for i := 0; i < b.N; i++ {
+				data, err := c.Marshal(entry)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := c.Unmarshal(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	b.Run("plain", run(inner))
+	b.Run("atrest", run(atrest.NewCodec(inner, newKey(b))))
+}
+
+func BenchmarkBlobStore(b *testing.B) {
+	blob := make([]byte, 1<<20)
+	rand.Read(blob)
+
+	run := func(k *atrest.Key) func(*testing.B) {
+		return func(b *testing.B) {
+			bs, done := openBlobs(b, k)
+			defer done()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(blob)))
+			for i := 0; i < b.N; i++ {
+				// a different blob every time, so that it's really stored
+				blob[i%len(blob)]++
+				ref, err := bs.Put(bytes.NewReader(blob))
+				if err != nil {
+					b.Fatal(err)
+				}
+				rd, err := bs.Get(ref)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := ioutil.ReadAll(rd); err != nil {
+					b.Fatal(err)
+				}
+				if c, ok := rd.(interface{ Close() error }); ok {
+					c.Close()
+				}
+			}
+		}
+	}
+	b.Run("plain", run(nil))
+	b.Run("atrest", run(newKey(b)))
+}
@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: MIT
+
+package atrest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
+)
+
+// NewBlobStore returns a blob store that keeps the blobs encrypted in inner.
+// The refs of the encrypted blobs differ from the ones of the plain blobs, idx maps between them.
+// Its keys are MACs of the plain refs and the plain refs in the values are encrypted, so it doesn't show which blobs are stored.
+//
+// Blobs are encrypted as a whole, which is fine for the size limit of blobs that are replicated.
+func NewBlobStore(inner ssb.BlobStore, k *Key, idx *kv.DB) ssb.BlobStore {
+	bs := &blobStore{
+		inner: inner,
+		k:     k,
+		idx:   idx,
+	}
+	bs.sink, bs.bcast = luigi.NewBroadcast()
+	return bs
+}
+
+type blobStore struct {
+	inner ssb.BlobStore
+	k     *Key
+
+	mu  sync.Mutex
+	idx *kv.DB
+
+	sink  luigi.Sink
+	bcast luigi.Broadcast
+}
+
+var _ io.Closer = (*blobStore)(nil)
+
+// Close closes the index
+func (bs *blobStore) Close() error { return bs.idx.Close() }
+
+func (bs *blobStore) idxKey(ref *ssb.BlobRef) []byte {
+	mac := hmac.New(sha256.New, bs.k[:])
+	mac.Write([]byte(ref.Algo))
+	mac.Write(ref.Hash)
+	return mac.Sum(nil)
+}
+
+// lookup returns the ref of the encrypted blob or nil if ref isn't stored
+func (bs *blobStore) lookup(ref *ssb.BlobRef) (*ssb.BlobRef, error) {
+	if err := ref.IsValid(); err != nil {
+		return nil, errors.Wrap(err, "atrest: invalid blob reference")
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.lookupLocked(ref)
+}
+
+// lookupLocked is lookup for callers that hold mu
+func (bs *blobStore) lookupLocked(ref *ssb.BlobRef) (*ssb.BlobRef, error) {
+	v, err := bs.idx.Get(nil, bs.idxKey(ref))
+	if err != nil {
+		return nil, errors.Wrap(err, "atrest: failed to look up blob")
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return decodeEntry(bs.k, v, false)
+}
+
+// the values of the index are the hash of the encrypted blob followed by the sealed plain ref
+func encodeEntry(k *Key, sealed, plain *ssb.BlobRef) ([]byte, error) {
+	box, err := Seal(k, plain.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, sealed.Hash...), box...), nil
+}
+
+func decodeEntry(k *Key, v []byte, wantPlain bool) (*ssb.BlobRef, error) {
+	if len(v) < sha256.Size+Overhead {
+		return nil, errors.New("atrest: broken blob index entry")
+	}
+	if !wantPlain {
+		return &ssb.BlobRef{Algo: ssb.RefAlgoBlobSSB1, Hash: v[:sha256.Size]}, nil
+	}
+	hash, err := Open(k, v[sha256.Size:])
+	if err != nil {
+		return nil, err
+	}
+	return &ssb.BlobRef{Algo: ssb.RefAlgoBlobSSB1, Hash: hash}, nil
+}
+
+func (bs *blobStore) Get(ref *ssb.BlobRef) (io.Reader, error) {
+	sealed, err := bs.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	if sealed == nil {
+		return nil, blobstore.ErrNoSuchBlob
+	}
+
+	rd, err := bs.inner.Get(sealed)
+	if err != nil {
+		return nil, err
+	}
+	box, err := ioutil.ReadAll(rd)
+	if c, ok := rd.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "atrest: failed to read blob")
+	}
+	data, err := Open(bs.k, box)
+	if err != nil {
+		return nil, errors.Wrapf(err, "atrest: blob %s", ref.Ref())
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (bs *blobStore) Put(blob io.Reader) (*ssb.BlobRef, error) {
+	data, err := ioutil.ReadAll(blob)
+	if err != nil && !luigi.IsEOS(err) {
+		return nil, errors.Wrap(err, "atrest: failed to read blob")
+	}
+	h := sha256.Sum256(data)
+	ref := &ssb.BlobRef{Algo: ssb.RefAlgoBlobSSB1, Hash: h[:]}
+
+	stored, err := bs.store(ref, data)
+	if err != nil {
+		return nil, err
+	}
+	if !stored {
+		return ref, nil
+	}
+
+	err = bs.sink.Pour(context.TODO(), ssb.BlobStoreNotification{
+		Op:  ssb.BlobStoreOpPut,
+		Ref: ref,
+	})
+	return ref, errors.Wrap(err, "atrest: error in notification handler")
+}
+
+// store seals data and adds it to the index, unless ref is already stored.
+// mu is held until the index is updated, otherwise concurrent puts of the same blob would leave sealed copies that nothing points to.
+func (bs *blobStore) store(ref *ssb.BlobRef, data []byte) (bool, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	// the same blob encrypts to a different box every time, only store it once
+	if sealed, err := bs.lookupLocked(ref); err != nil {
+		return false, err
+	} else if sealed != nil {
+		return false, nil
+	}
+
+	box, err := Seal(bs.k, data)
+	if err != nil {
+		return false, err
+	}
+	sealed, err := bs.inner.Put(bytes.NewReader(box))
+	if err != nil {
+		return false, err
+	}
+	entry, err := encodeEntry(bs.k, sealed, ref)
+	if err == nil {
+		err = bs.idx.Set(bs.idxKey(ref), entry)
+	}
+	if err != nil {
+		bs.inner.Delete(sealed)
+		return false, errors.Wrap(err, "atrest: failed to update blob index")
+	}
+	return true, nil
+}
+
+func (bs *blobStore) Delete(ref *ssb.BlobRef) error {
+	sealed, err := bs.lookup(ref)
+	if err != nil {
+		return err
+	}
+	if sealed == nil {
+		return blobstore.ErrNoSuchBlob
+	}
+
+	bs.mu.Lock()
+	err = bs.idx.Delete(bs.idxKey(ref))
+	bs.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "atrest: failed to update blob index")
+	}
+	if err := bs.inner.Delete(sealed); err != nil && err != blobstore.ErrNoSuchBlob {
+		return err
+	}
+
+	err = bs.sink.Pour(context.TODO(), ssb.BlobStoreNotification{
+		Op:  ssb.BlobStoreOpRm,
+		Ref: ref,
+	})
+	return errors.Wrap(err, "atrest: error in delete notification handlers")
+}
+
+// List returns the plain refs of the stored blobs
+func (bs *blobStore) List() luigi.Source {
+	return &listSource{bs: bs}
+}
+
+type listSource struct {
+	bs *blobStore
+
+	once sync.Once
+	refs []*ssb.BlobRef
+	err  error
+}
+
+func (src *listSource) Next(ctx context.Context) (interface{}, error) {
+	src.once.Do(func() { src.refs, src.err = src.bs.all() })
+	if src.err != nil {
+		return nil, src.err
+	}
+	if len(src.refs) == 0 {
+		return nil, luigi.EOS{}
+	}
+	var ref *ssb.BlobRef
+	ref, src.refs = src.refs[0], src.refs[1:]
+	return ref, nil
+}
+
+func (bs *blobStore) all() ([]*ssb.BlobRef, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	enum, err := bs.idx.SeekFirst()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "atrest: failed to list blobs")
+	}
+	var refs []*ssb.BlobRef
+	for {
+		_, v, err := enum.Next()
+		if err == io.EOF {
+			return refs, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "atrest: failed to list blobs")
+		}
+		ref, err := decodeEntry(bs.k, v, true)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+}
+
+// Size returns the size of the plain blob
+func (bs *blobStore) Size(ref *ssb.BlobRef) (int64, error) {
+	sealed, err := bs.lookup(ref)
+	if err != nil {
+		return 0, err
+	}
+	if sealed == nil {
+		return 0, blobstore.ErrNoSuchBlob
+	}
+	sz, err := bs.inner.Size(sealed)
+	if err != nil {
+		return 0, err
+	}
+	return sz - Overhead, nil
+}
+
+func (bs *blobStore) Changes() luigi.Broadcast {
+	return bs.bcast
+}
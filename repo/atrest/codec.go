@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+
+package atrest
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+)
+
+// NewCodec returns a codec that encrypts what inner encodes and decrypts it before inner decodes it.
+// The boxes start with their length, so that decoders don't depend on getting a reader that ends with the entry.
+func NewCodec(inner margaret.Codec, k *Key) margaret.Codec {
+	return codec{inner: inner, k: k}
+}
+
+type codec struct {
+	inner margaret.Codec
+	k     *Key
+}
+
+func (c codec) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	box, err := Seal(c.k, data)
+	if err != nil {
+		return nil, err
+	}
+	framed := make([]byte, 4+len(box))
+	binary.BigEndian.PutUint32(framed, uint32(len(box)))
+	copy(framed[4:], box)
+	return framed, nil
+}
+
+func (c codec) Unmarshal(framed []byte) (interface{}, error) {
+	if len(framed) < 4 {
+		return nil, ErrBroken
+	}
+	n := binary.BigEndian.Uint32(framed)
+	if uint64(n) > uint64(len(framed)-4) {
+		return nil, ErrBroken
+	}
+	data, err := Open(c.k, framed[4:4+n])
+	if err != nil {
+		return nil, err
+	}
+	return c.inner.Unmarshal(data)
+}
+
+func (c codec) NewEncoder(w io.Writer) margaret.Encoder { return encoder{c: c, w: w} }
+func (c codec) NewDecoder(r io.Reader) margaret.Decoder { return decoder{c: c, r: r} }
+
+type encoder struct {
+	c codec
+	w io.Writer
+}
+
+func (enc encoder) Encode(v interface{}) error {
+	box, err := enc.c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(box)
+	return errors.Wrap(err, "atrest: failed to write box")
+}
+
+type decoder struct {
+	c codec
+	r io.Reader
+}
+
+func (dec decoder) Decode() (interface{}, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(dec.r, hdr[:]); err != nil {
+		return nil, errors.Wrap(err, "atrest: failed to read box length")
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxEntrySize {
+		return nil, ErrBroken
+	}
+	framed := make([]byte, 4+n)
+	copy(framed, hdr[:])
+	if _, err := io.ReadFull(dec.r, framed[4:]); err != nil {
+		return nil, errors.Wrap(err, "atrest: failed to read box")
+	}
+	return dec.c.Unmarshal(framed)
+}
+
+// log entries are messages, which are far smaller. This guards against allocating a lot for broken lengths.
+const maxEntrySize = 16 << 20
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: MIT
+
+// Package atrest encrypts the data of a repo on disk.
+// NewCodec wraps the codec of a margaret log and NewBlobStore wraps a blob store, so that the code using them doesn't change.
+//
+// The key is derived from the secret of the repo or from a passphrase, see Params.
+// The index databases can't be wrapped like that, they are put into an archive that is encrypted with NewWriter while no bot is using them.
+package atrest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// Key is the symmetric key the data is encrypted with
+type Key [32]byte
+
+// Overhead is the number of bytes Seal adds to the data (the nonce and the authenticator)
+const Overhead = 24 + secretbox.Overhead
+
+var (
+	// ErrWrongKey is returned by Params.Key if the derived key doesn't match the one the repo was encrypted with
+	ErrWrongKey = errors.New("atrest: wrong key (or passphrase) for the repo")
+
+	// ErrBroken is returned if data can't be decrypted, either because it was changed or because the key is wrong
+	ErrBroken = errors.New("atrest: failed to decrypt data")
+)
+
+// Seal encrypts data with a random nonce, which is put in front of the box
+func Seal(k *Key, data []byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "atrest: failed to make nonce")
+	}
+	out := make([]byte, 24, Overhead+len(data))
+	copy(out, nonce[:])
+	return secretbox.Seal(out, data, &nonce, (*[32]byte)(k)), nil
+}
+
+// Open decrypts a box that was made with Seal
+func Open(k *Key, box []byte) ([]byte, error) {
+	if len(box) < Overhead {
+		return nil, ErrBroken
+	}
+	var nonce [24]byte
+	copy(nonce[:], box[:24])
+	data, ok := secretbox.Open(nil, box[24:], &nonce, (*[32]byte)(k))
+	if !ok {
+		return nil, ErrBroken
+	}
+	return data, nil
+}
+
+// Mode is what the key of a repo is derived from
+type Mode string
+
+const (
+	// ModeSecret derives the key from the default secret of the repo.
+	// It protects the data if it is copied without the secret, like in a backup or from a separate disk.
+	ModeSecret Mode = "secret"
+
+	// ModePassphrase derives the key from a passphrase with scrypt
+	ModePassphrase Mode = "passphrase"
+)
+
+// the scrypt parameters for new repos, they are stored in the params so that they can be raised later
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// the value that is sealed into Params.Check
+var checkValue = []byte("ssb-go at-rest key check")
+
+// Params are stored in the repo and say how the key is derived
+type Params struct {
+	Mode Mode   `json:"mode"`
+	Salt string `json:"salt"`
+
+	// the scrypt parameters for ModePassphrase
+	N int `json:"n,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+
+	// Check is a known value sealed with the key, so that a wrong key is noticed before anything is read
+	Check string `json:"check"`
+}
+
+// NewParams returns the params for a new repo and the key for it.
+// ModeSecret needs the key pair, ModePassphrase the passphrase.
+func NewParams(mode Mode, kp *ssb.KeyPair, passphrase []byte) (*Params, *Key, error) {
+	var salt [16]byte
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return nil, nil, errors.Wrap(err, "atrest: failed to make salt")
+	}
+	p := Params{
+		Mode: mode,
+		Salt: base64.StdEncoding.EncodeToString(salt[:]),
+	}
+	if mode == ModePassphrase {
+		p.N, p.R, p.P = scryptN, scryptR, scryptP
+	}
+
+	k, err := p.derive(kp, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	check, err := Seal(k, checkValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.Check = base64.StdEncoding.EncodeToString(check)
+	return &p, k, nil
+}
+
+// Key derives the key and checks that it's the one the params were made with
+func (p Params) Key(kp *ssb.KeyPair, passphrase []byte) (*Key, error) {
+	k, err := p.derive(kp, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	check, err := base64.StdEncoding.DecodeString(p.Check)
+	if err != nil {
+		return nil, errors.Wrap(err, "atrest: invalid check value")
+	}
+	got, err := Open(k, check)
+	if err != nil || !hmac.Equal(got, checkValue) {
+		return nil, ErrWrongKey
+	}
+	return k, nil
+}
+
+func (p Params) derive(kp *ssb.KeyPair, passphrase []byte) (*Key, error) {
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "atrest: invalid salt")
+	}
+
+	var k Key
+	switch p.Mode {
+	case ModeSecret:
+		if kp == nil {
+			return nil, errors.New("atrest: the key pair is needed to derive the key")
+		}
+		mac := hmac.New(sha256.New, kp.Pair.Secret[:])
+		mac.Write([]byte("ssb-go at-rest key"))
+		mac.Write(salt)
+		copy(k[:], mac.Sum(nil))
+
+	case ModePassphrase:
+		if len(passphrase) == 0 {
+			return nil, ssb.ErrNoPassphrase
+		}
+		derived, err := scrypt.Key(passphrase, salt, p.N, p.R, p.P, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "atrest: key derivation failed")
+		}
+		copy(k[:], derived)
+
+	default:
+		return nil, errors.Errorf("atrest: unknown mode %q", p.Mode)
+	}
+	return &k, nil
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MIT
+
+package atrest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// chunkSize is how much data a box of a stream holds at most
+const chunkSize = 64 * 1024
+
+// the nonces of a stream are a random prefix followed by the number of the chunk
+const streamPrefixSize = 16
+
+const (
+	flagMore byte = 0
+	flagLast byte = 1
+)
+
+// NewWriter returns a writer that encrypts what is written to it into w, for data that is too large for Seal.
+// The data is split into boxes that are numbered, so that they can't be reordered, and the last one is marked, so that truncation is noticed.
+// Close has to be called to write the last box, it doesn't close w.
+func NewWriter(k *Key, w io.Writer) (io.WriteCloser, error) {
+	sw := &streamWriter{k: k, w: w, buf: make([]byte, 0, chunkSize)}
+	if _, err := io.ReadFull(rand.Reader, sw.prefix[:]); err != nil {
+		return nil, errors.Wrap(err, "atrest: failed to read nonce prefix")
+	}
+	if _, err := w.Write(sw.prefix[:]); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+type streamWriter struct {
+	k      *Key
+	w      io.Writer
+	prefix [streamPrefixSize]byte
+	n      uint64
+	buf    []byte
+	closed bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("atrest: write to closed stream")
+	}
+	var written int
+	for len(p) > 0 {
+		if len(sw.buf) == chunkSize {
+			if err := sw.flush(flagMore); err != nil {
+				return written, err
+			}
+		}
+		n := copy(sw.buf[len(sw.buf):chunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (sw *streamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.flush(flagLast)
+}
+
+func (sw *streamWriter) flush(flag byte) error {
+	nonce := streamNonce(sw.prefix, sw.n)
+	sw.n++
+	box := secretbox.Seal(nil, append([]byte{flag}, sw.buf...), &nonce, (*[32]byte)(sw.k))
+	sw.buf = sw.buf[:0]
+
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(box)))
+	if _, err := sw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(box)
+	return err
+}
+
+// NewReader returns a reader that decrypts what NewWriter wrote to r.
+// Reading returns ErrBroken if the data was changed, cut off or encrypted with another key.
+func NewReader(k *Key, r io.Reader) io.Reader {
+	return &streamReader{k: k, r: bufio.NewReader(r)}
+}
+
+type streamReader struct {
+	k       *Key
+	r       *bufio.Reader
+	started bool
+	prefix  [streamPrefixSize]byte
+	n       uint64
+	buf     []byte
+	last    bool
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.last {
+			// nothing may follow the last box
+			if _, err := sr.r.ReadByte(); err != io.EOF {
+				return 0, ErrBroken
+			}
+			return 0, io.EOF
+		}
+		if err := sr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+func (sr *streamReader) next() error {
+	if !sr.started {
+		if _, err := io.ReadFull(sr.r, sr.prefix[:]); err != nil {
+			return ErrBroken
+		}
+		sr.started = true
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(sr.r, hdr[:]); err != nil {
+		return ErrBroken
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < secretbox.Overhead+1 || n > secretbox.Overhead+1+chunkSize {
+		return ErrBroken
+	}
+	box := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, box); err != nil {
+		return ErrBroken
+	}
+
+	nonce := streamNonce(sr.prefix, sr.n)
+	sr.n++
+	data, ok := secretbox.Open(nil, box, &nonce, (*[32]byte)(sr.k))
+	if !ok {
+		return ErrBroken
+	}
+	sr.last = data[0] == flagLast
+	sr.buf = data[1:]
+	return nil
+}
+
+func streamNonce(prefix [streamPrefixSize]byte, n uint64) [24]byte {
+	var nonce [24]byte
+	copy(nonce[:], prefix[:])
+	binary.BigEndian.PutUint64(nonce[streamPrefixSize:], n)
+	return nonce
+}
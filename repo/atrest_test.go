@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb/repo/atrest"
+)
+
+func TestSealIndexes(t *testing.T) {
+	r := require.New(t)
+
+	rpath, err := ioutil.TempDir("", "sealindexes")
+	r.NoError(err)
+	defer os.RemoveAll(rpath)
+
+	plain := New(rpath)
+	k, err := EnableAtRest(plain, atrest.ModePassphrase, nil, []byte("test"))
+	r.NoError(err)
+	rp := WithAtRestKey(plain, k)
+
+	secret := []byte("@alice follows @bob")
+	files := []string{
+		rp.GetPath(PrefixIndex, "contacts", "db", "000001.vlog"),
+		rp.GetPath(PrefixMultiLog, "userFeeds", "roaring", "data"),
+		rp.GetPath("plugin", "pubmode", "db"),
+	}
+	for _, f := range files {
+		r.NoError(os.MkdirAll(filepath.Dir(f), 0700))
+		r.NoError(ioutil.WriteFile(f, secret, 0600))
+	}
+
+	r.NoError(SealIndexes(rp))
+	for _, folder := range sealedFolders {
+		_, err := os.Stat(rp.GetPath(folder))
+		r.True(os.IsNotExist(err), "%s is still there", folder)
+	}
+	sealed, err := ioutil.ReadFile(rp.GetPath(SealedIndexesName))
+	r.NoError(err)
+	r.False(bytes.Contains(sealed, secret))
+	r.False(bytes.Contains(sealed, []byte("contacts")), "the names are sealed as well")
+
+	// without the key it stays sealed
+	r.Equal(ErrAtRestLocked, UnsealIndexes(plain))
+
+	r.NoError(UnsealIndexes(rp))
+	for _, f := range files {
+		got, err := ioutil.ReadFile(f)
+		r.NoError(err)
+		r.Equal(secret, got)
+	}
+	_, err = os.Stat(rp.GetPath(SealedIndexesName))
+	r.True(os.IsNotExist(err))
+
+	// nothing to do if there is nothing sealed
+	r.NoError(UnsealIndexes(rp))
+
+	// a changed archive isn't used
+	r.NoError(SealIndexes(rp))
+	sealed, err = ioutil.ReadFile(rp.GetPath(SealedIndexesName))
+	r.NoError(err)
+	sealed[len(sealed)/2] ^= 1
+	r.NoError(ioutil.WriteFile(rp.GetPath(SealedIndexesName), sealed, 0600))
+	r.Error(UnsealIndexes(rp))
+	_, err = os.Stat(rp.GetPath(SealedIndexesName))
+	r.NoError(err, "the archive is kept if it can't be unsealed")
+}
+
+func TestSealIndexesPlainRepo(t *testing.T) {
+	r := require.New(t)
+
+	rpath, err := ioutil.TempDir("", "sealindexes")
+	r.NoError(err)
+	defer os.RemoveAll(rpath)
+
+	rp := New(rpath)
+	idx := rp.GetPath(PrefixIndex, "contacts")
+	r.NoError(os.MkdirAll(idx, 0700))
+
+	r.NoError(SealIndexes(rp))
+	_, err = os.Stat(idx)
+	r.NoError(err, "plain repos are left alone")
+	_, err = os.Stat(rp.GetPath(SealedIndexesName))
+	r.True(os.IsNotExist(err))
+}
@@ -0,0 +1,523 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo/atrest"
+)
+
+// BackupBlobs selects what is backed up of the blob store
+type BackupBlobs string
+
+const (
+	// BackupBlobsNone skips the blobs
+	BackupBlobsNone BackupBlobs = "none"
+
+	// BackupBlobsList writes the refs of all stored blobs to blobs.list, so that they can be fetched again after a restore
+	BackupBlobsList BackupBlobs = "list"
+
+	// BackupBlobsAll copies the blobs
+	BackupBlobsAll BackupBlobs = "all"
+)
+
+// BackupManifestName is the file in the backup folder that describes the backup
+const BackupManifestName = "backup.json"
+
+// BackupOptions configure what Backup copies and where it reads from
+type BackupOptions struct {
+	// RootLog and BlobStore are the ones of the running bot.
+	// If they are nil, they are opened from the repo, which is only safe if no bot is using it.
+	RootLog   margaret.Log
+	BlobStore ssb.BlobStore
+
+	// Blobs defaults to BackupBlobsList
+	Blobs BackupBlobs
+
+	// Indexes also copies the index and sublog databases, file by file.
+	// This is only possible if no bot is using the repo, the databases of a running bot flush and compact in the background.
+	// Without them the indexes are rebuilt when a bot is started on the restored repo.
+	Indexes bool
+
+	// Progress is called with the number of copied messages, if it's set
+	Progress func(copied, total int64)
+}
+
+// BackupManifest describes a backup folder
+type BackupManifest struct {
+	Created time.Time `json:"created"`
+
+	// Sequence is the last message of the root log that is in the backup, -1 if the log was empty
+	Sequence int64 `json:"sequence"`
+
+	// Nulled is the number of deleted messages in the copied log
+	Nulled int64 `json:"nulled"`
+
+	Secrets []string `json:"secrets"`
+
+	Blobs     BackupBlobs `json:"blobs"`
+	BlobCount int         `json:"blobCount"`
+
+	Indexes bool `json:"indexes"`
+
+	// AtRest is true if the backup is encrypted like the repo it was made of
+	AtRest bool `json:"atRest,omitempty"`
+}
+
+// the folders that hold index data, relative to the repo
+var indexFolders = []string{PrefixIndex, PrefixMultiLog}
+
+// Backup copies the repo into the empty or non-existing folder dst.
+// The root log is copied up to the sequence it has when the backup starts, so messages that are added in the meantime don't tear it.
+// Secrets are copied as they are, encrypted ones stay encrypted.
+func Backup(ctx context.Context, r Interface, dst string, opts BackupOptions) (*BackupManifest, error) {
+	if opts.Blobs == "" {
+		opts.Blobs = BackupBlobsList
+	}
+	switch opts.Blobs {
+	case BackupBlobsNone, BackupBlobsList, BackupBlobsAll:
+	default:
+		return nil, errors.Errorf("repo/backup: unknown blobs mode %q", opts.Blobs)
+	}
+
+	if err := prepareEmptyDir(dst); err != nil {
+		return nil, err
+	}
+	dstRepo := New(dst)
+
+	// the backup of an encrypted repo is encrypted with the same key
+	k, err := atRestKey(r)
+	if err != nil {
+		return nil, err
+	}
+	if k != nil {
+		if err := copyFile(r.GetPath(AtRestConfigName), dstRepo.GetPath(AtRestConfigName), 0600); err != nil {
+			return nil, errors.Wrap(err, "repo/backup: failed to copy at-rest config")
+		}
+		dstRepo = WithAtRestKey(dstRepo, k)
+	}
+
+	if opts.Indexes && opts.RootLog != nil {
+		return nil, errors.New("repo/backup: the indexes of a running bot can't be copied")
+	}
+
+	rootLog := opts.RootLog
+	if rootLog == nil {
+		l, err := OpenLog(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "repo/backup: failed to open root log")
+		}
+		defer l.Close()
+		rootLog = l
+	}
+
+	manifest := BackupManifest{
+		Created: time.Now(),
+		Blobs:   opts.Blobs,
+		Indexes: opts.Indexes,
+		AtRest:  k != nil,
+	}
+
+	if opts.Indexes {
+		if err := copyIndexes(r, dstRepo, k); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest.Sequence, manifest.Nulled, err = copyLog(ctx, rootLog, dstRepo, opts.Progress)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Secrets, err = copySecrets(r, dstRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	// the version tells which migrations the copied data already went through
+	if err := copyFile(r.GetPath("version"), dstRepo.GetPath("version"), 0600); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, errors.Wrap(err, "repo/backup: failed to copy version")
+	}
+
+	if opts.Blobs != BackupBlobsNone {
+		bs := opts.BlobStore
+		if bs == nil {
+			bs, err = OpenBlobStore(r)
+			if err != nil {
+				return nil, errors.Wrap(err, "repo/backup: failed to open blob store")
+			}
+			if c, ok := bs.(io.Closer); ok {
+				defer c.Close()
+			}
+		}
+		manifest.BlobCount, err = copyBlobs(ctx, bs, dstRepo, opts.Blobs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the manifest is written last, a folder without it is an incomplete backup
+	if err := writeJSONFile(dstRepo.GetPath(BackupManifestName), manifest); err != nil {
+		return nil, errors.Wrap(err, "repo/backup: failed to write manifest")
+	}
+	return &manifest, nil
+}
+
+// copyIndexes copies the index data of r to dst, the one of encrypted repos only as sealed archive
+func copyIndexes(r, dst Interface, k *atrest.Key) error {
+	if k == nil {
+		for _, folder := range indexFolders {
+			if err := copyTree(r.GetPath(folder), dst.GetPath(folder)); err != nil {
+				return errors.Wrapf(err, "repo/backup: failed to copy %s", folder)
+			}
+		}
+		return nil
+	}
+
+	// no bot is using the repo if it is sealed
+	err := copyFile(r.GetPath(SealedIndexesName), dst.GetPath(SealedIndexesName), 0600)
+	if !os.IsNotExist(errors.Cause(err)) {
+		return errors.Wrap(err, "repo/backup: failed to copy sealed indexes")
+	}
+	return errors.Wrap(writeSealedIndexes(r, k, dst.GetPath(SealedIndexesName)), "repo/backup: failed to seal indexes")
+}
+
+func prepareEmptyDir(dst string) error {
+	entries, err := ioutil.ReadDir(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "repo/backup: failed to check destination")
+	}
+	if len(entries) > 0 {
+		return errors.Errorf("repo/backup: destination %q is not empty", dst)
+	}
+	return errors.Wrap(os.MkdirAll(dst, 0700), "repo/backup: failed to create destination")
+}
+
+// copyLog copies the messages up to the current sequence of from into the root log of dst.
+// Deleted messages are copied as deleted, so that the sequences stay the same.
+func copyLog(ctx context.Context, from margaret.Log, dst Interface, progress func(int64, int64)) (int64, int64, error) {
+	seqV, err := from.Seq().Value()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "repo/backup: failed to get current sequence")
+	}
+	last := seqV.(margaret.Seq).Seq()
+	if last < 0 {
+		return -1, 0, nil
+	}
+
+	to, err := OpenLog(dst)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "repo/backup: failed to create log")
+	}
+	defer to.Close()
+
+	src, err := from.Query(margaret.Limit(int(last + 1)))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "repo/backup: failed to query root log")
+	}
+
+	var (
+		copied int64
+		nulled int64
+
+		// deleted messages need some value to take up their sequence, before they are nulled.
+		// pending counts the ones at the start that come before any value.
+		placeholder interface{}
+		pending     int64
+	)
+	appendNulled := func(v interface{}) error {
+		seq, err := to.Append(v)
+		if err != nil {
+			return errors.Wrap(err, "repo/backup: failed to append placeholder")
+		}
+		return errors.Wrap(to.Null(seq), "repo/backup: failed to null placeholder")
+	}
+
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		if err != nil && !margaret.IsErrNulled(err) {
+			return 0, 0, errors.Wrap(err, "repo/backup: failed to read root log")
+		}
+		if err != nil {
+			v = err
+		}
+
+		if errv, ok := v.(error); ok {
+			if !margaret.IsErrNulled(errv) {
+				return 0, 0, errors.Wrapf(errv, "repo/backup: broken message at %d", copied)
+			}
+			nulled++
+			copied++
+			if placeholder == nil {
+				pending++
+				continue
+			}
+			if err := appendNulled(placeholder); err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+
+		if placeholder == nil {
+			for ; pending > 0; pending-- {
+				if err := appendNulled(v); err != nil {
+					return 0, 0, err
+				}
+			}
+		}
+		placeholder = v
+
+		if _, err := to.Append(v); err != nil {
+			return 0, 0, errors.Wrapf(err, "repo/backup: failed to copy message %d", copied)
+		}
+		copied++
+		if progress != nil {
+			progress(copied, last+1)
+		}
+	}
+
+	if pending > 0 {
+		return 0, 0, errors.Errorf("repo/backup: the log only holds deleted messages")
+	}
+	if copied != last+1 {
+		return 0, 0, errors.Errorf("repo/backup: expected %d messages but copied %d", last+1, copied)
+	}
+	return last, nulled, nil
+}
+
+// copySecrets copies the default secret and the named ones and returns their names (- for the default one)
+func copySecrets(r, dst Interface) ([]string, error) {
+	var names []string
+
+	if err := copyFile(r.GetPath("secret"), dst.GetPath("secret"), ssb.SecretPerms); err == nil {
+		names = append(names, "-")
+	} else if !os.IsNotExist(errors.Cause(err)) {
+		return nil, errors.Wrap(err, "repo/backup: failed to copy secret")
+	}
+
+	entries, err := ioutil.ReadDir(r.GetPath("secrets"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return names, nil
+		}
+		return nil, errors.Wrap(err, "repo/backup: failed to list secrets")
+	}
+	if err := os.MkdirAll(dst.GetPath("secrets"), 0700); err != nil {
+		return nil, errors.Wrap(err, "repo/backup: failed to create secrets folder")
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := copyFile(r.GetPath("secrets", e.Name()), dst.GetPath("secrets", e.Name()), ssb.SecretPerms); err != nil {
+			return nil, errors.Wrapf(err, "repo/backup: failed to copy secret %s", e.Name())
+		}
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+// BlobListName is the file that holds the refs of the blobs for BackupBlobsList
+const BlobListName = "blobs.list"
+
+func copyBlobs(ctx context.Context, bs ssb.BlobStore, dst Interface, mode BackupBlobs) (int, error) {
+	var (
+		list   *bufio.Writer
+		dstBS  ssb.BlobStore
+		listFn = dst.GetPath(BlobListName)
+	)
+	if mode == BackupBlobsList {
+		f, err := os.Create(listFn)
+		if err != nil {
+			return 0, errors.Wrap(err, "repo/backup: failed to create blob list")
+		}
+		defer f.Close()
+		list = bufio.NewWriter(f)
+	} else {
+		var err error
+		dstBS, err = OpenBlobStore(dst)
+		if err != nil {
+			return 0, errors.Wrap(err, "repo/backup: failed to create blob store")
+		}
+		// the at-rest wrapper has an index to close
+		if c, ok := dstBS.(io.Closer); ok {
+			defer c.Close()
+		}
+	}
+
+	src := bs.List()
+	var n int
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "repo/backup: failed to list blobs")
+		}
+		ref, ok := v.(*ssb.BlobRef)
+		if !ok {
+			return 0, errors.Errorf("repo/backup: unexpected blob list entry: %T", v)
+		}
+
+		if list != nil {
+			fmt.Fprintln(list, ref.Ref())
+			n++
+			continue
+		}
+
+		rd, err := bs.Get(ref)
+		if err != nil {
+			// removed since it was listed
+			continue
+		}
+		got, err := dstBS.Put(rd)
+		if c, ok := rd.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return 0, errors.Wrapf(err, "repo/backup: failed to copy blob %s", ref.Ref())
+		}
+		if !got.Equal(ref) {
+			return 0, errors.Errorf("repo/backup: blob %s changed while copying", ref.Ref())
+		}
+		n++
+	}
+
+	if list != nil {
+		if err := list.Flush(); err != nil {
+			return 0, errors.Wrap(err, "repo/backup: failed to write blob list")
+		}
+	}
+	return n, nil
+}
+
+// ReadBackupManifest reads the manifest of the backup in folder src
+func ReadBackupManifest(src string) (*BackupManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(src, BackupManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("repo: %s has no %s, it's not a (complete) backup", src, BackupManifestName)
+		}
+		return nil, errors.Wrap(err, "repo: failed to read backup manifest")
+	}
+	var m BackupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "repo: failed to decode backup manifest")
+	}
+	return &m, nil
+}
+
+// RestoreOptions configure Restore
+type RestoreOptions struct {
+	// SkipIndexes doesn't restore the index data of the backup, so that the bot rebuilds it
+	SkipIndexes bool
+}
+
+// Restore copies the backup in folder src into the empty or non-existing repo folder dst.
+// It returns the manifest of the backup and if the index data was restored. If it wasn't, the indexes need to be rebuilt.
+// Blobs that were only listed need to be fetched from peers again, the list is copied to the repo.
+func Restore(src, dst string, opts RestoreOptions) (*BackupManifest, bool, error) {
+	m, err := ReadBackupManifest(src)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := prepareEmptyDir(dst); err != nil {
+		return nil, false, err
+	}
+
+	copyPart := func(rel string) error {
+		err := copyTree(filepath.Join(src, rel), filepath.Join(dst, rel))
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil
+		}
+		return errors.Wrapf(err, "repo/restore: failed to copy %s", rel)
+	}
+
+	parts := []string{"log", "version", AtRestConfigName, "secret", "secrets", "blobs", BlobListName}
+	withIndexes := m.Indexes && !opts.SkipIndexes
+	if withIndexes {
+		parts = append(parts, indexFolders...)
+		parts = append(parts, SealedIndexesName)
+	}
+	for _, p := range parts {
+		if err := copyPart(p); err != nil {
+			return nil, false, err
+		}
+	}
+	return m, withIndexes, nil
+}
+
+// copyTree copies the file or folder from to the path to, keeping the permissions
+func copyTree(from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		// skip lock files, they belong to the process that has the databases open
+		if strings.HasSuffix(info.Name(), ".lock") || (info.Size() == 0 && len(info.Name()) == 41 && info.Name()[0] == '.') {
+			return nil
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(from, to string, perm os.FileMode) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	// the umask might have stripped some of the permissions
+	if err := out.Chmod(perm); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
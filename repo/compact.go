@@ -0,0 +1,299 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+)
+
+// Compaction happens in two steps.
+// CompactLog copies the entries of the root log that are not nulled into logs/compact, which can be done while the bot is running.
+// FinishCompaction then needs the repo for itself. It catches up with what changed since the copy, puts the new log in place and drops the indexes, since the sequence numbers in them don't fit the new log anymore.
+const (
+	compactLogName      = "compact"
+	compactStateName    = "compact.json"
+	compactSeqsName     = "compact.seqs"
+	compactOldLogName   = "precompact"
+	compactSeqsEntryLen = 8
+)
+
+// CompactStats describe a compaction of the root log
+type CompactStats struct {
+	Started time.Time `json:"started"`
+
+	// Sequence is the last entry of the root log that was looked at
+	Sequence int64 `json:"sequence"`
+
+	// Copied is the number of entries in the compacted log, Dropped the number of nulled ones that were left out
+	Copied  int64 `json:"copied"`
+	Dropped int64 `json:"dropped"`
+
+	// Nulled is the number of entries that were nulled after they were copied, they stay in the compacted log as nulled entries
+	Nulled int64 `json:"nulled"`
+}
+
+// CompactLog writes a copy of the root log without its nulled entries, which replaces the root log when FinishCompaction is called.
+// from is the opened root log of r. Only reading from it, the copy is made while it is in use.
+// An unfinished compaction from before is thrown away.
+func CompactLog(ctx context.Context, r Interface, from margaret.Log, progress func(done, total int64)) (*CompactStats, error) {
+	if err := removeCompaction(r); err != nil {
+		return nil, err
+	}
+
+	sv, err := from.Seq().Value()
+	if err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to get sequence of root log")
+	}
+	stats := CompactStats{
+		Started:  time.Now(),
+		Sequence: sv.(margaret.Seq).Seq(),
+	}
+
+	to, err := OpenLog(r, compactLogName)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to create log")
+	}
+	defer to.Close()
+
+	seqsFile, err := os.Create(r.GetPath("logs", compactSeqsName))
+	if err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to create sequence map")
+	}
+	defer seqsFile.Close()
+	seqs := bufio.NewWriter(seqsFile)
+
+	err = copyCompacted(ctx, from, -1, stats.Sequence, to, seqs, &stats, progress)
+	if err != nil {
+		return nil, err
+	}
+	if err := seqs.Flush(); err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to write sequence map")
+	}
+	if err := seqsFile.Sync(); err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to write sequence map")
+	}
+
+	// the state is written last, without it the copy is incomplete
+	if err := writeJSONFile(r.GetPath("logs", compactStateName), stats); err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to write state")
+	}
+	return &stats, nil
+}
+
+// copyCompacted appends the entries after the sequence after up to last from the root log to the compacted one and their old sequences to seqs
+func copyCompacted(ctx context.Context, from margaret.Log, after, last int64, to margaret.Log, seqs io.Writer, stats *CompactStats, progress func(done, total int64)) error {
+	if last <= after {
+		return nil
+	}
+	specs := []margaret.QuerySpec{margaret.Limit(int(last - after)), margaret.SeqWrap(true)}
+	if after >= 0 {
+		specs = append(specs, margaret.Gt(margaret.BaseSeq(after)))
+	}
+	src, err := from.Query(specs...)
+	if err != nil {
+		return errors.Wrap(err, "repo/compact: failed to query root log")
+	}
+
+	var (
+		seq  = after
+		done int64
+		buf  [compactSeqsEntryLen]byte
+	)
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		if err != nil && !margaret.IsErrNulled(err) {
+			return errors.Wrap(err, "repo/compact: failed to read root log")
+		}
+		if err != nil {
+			v = err
+		}
+		seq++
+		done++
+		if progress != nil {
+			progress(done, last-after)
+		}
+
+		if errv, ok := v.(error); ok {
+			if !margaret.IsErrNulled(errv) {
+				return errors.Wrapf(errv, "repo/compact: broken entry at %d", seq)
+			}
+			stats.Dropped++
+			continue
+		}
+
+		sw, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			return errors.Errorf("repo/compact: unexpected entry type %T", v)
+		}
+		seq = sw.Seq().Seq()
+		if _, err := to.Append(sw.Value()); err != nil {
+			return errors.Wrapf(err, "repo/compact: failed to copy entry %d", seq)
+		}
+		binary.BigEndian.PutUint64(buf[:], uint64(seq))
+		if _, err := seqs.Write(buf[:]); err != nil {
+			return errors.Wrap(err, "repo/compact: failed to write sequence map")
+		}
+		stats.Copied++
+	}
+
+	if seq != last {
+		return errors.Errorf("repo/compact: expected entries up to %d but got to %d", last, seq)
+	}
+	return nil
+}
+
+// FinishCompaction replaces the root log with the one written by CompactLog, if there is one.
+// It needs the repo for itself, so it has to be called while the repo is locked and before the root log is opened.
+// The indexes are removed and need to be rebuilt.
+// It returns nil if there was no compaction to finish.
+func FinishCompaction(ctx context.Context, r Interface) (*CompactStats, error) {
+	data, err := ioutil.ReadFile(r.GetPath("logs", compactStateName))
+	if os.IsNotExist(err) {
+		// an incomplete copy is of no use
+		return nil, removeCompaction(r)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to read state")
+	}
+	var stats CompactStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to decode state")
+	}
+
+	// if the swap was interrupted, some of these steps were already done
+	_, err = os.Stat(r.GetPath("logs", compactLogName))
+	hasCompacted := err == nil
+	_, err = os.Stat(r.GetPath("log"))
+	hasLog := err == nil
+
+	if hasCompacted && hasLog {
+		err := catchUpCompaction(ctx, r, &stats)
+		if err == errCompactMismatch {
+			// the root log is still intact, the compaction needs to be started over
+			return nil, removeCompaction(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Rename(r.GetPath("log"), r.GetPath("logs", compactOldLogName)); err != nil {
+			return nil, errors.Wrap(err, "repo/compact: failed to move old log")
+		}
+	}
+	if hasCompacted {
+		if err := os.Rename(r.GetPath("logs", compactLogName), r.GetPath("log")); err != nil {
+			return nil, errors.Wrap(err, "repo/compact: failed to move compacted log into place")
+		}
+	}
+
+	for _, folder := range indexFolders {
+		if err := os.RemoveAll(r.GetPath(folder)); err != nil {
+			return nil, errors.Wrapf(err, "repo/compact: failed to remove %s", folder)
+		}
+	}
+	if err := os.RemoveAll(r.GetPath("logs", compactOldLogName)); err != nil {
+		return nil, errors.Wrap(err, "repo/compact: failed to remove old log")
+	}
+	return &stats, removeCompaction(r)
+}
+
+// removeCompaction removes the files of CompactLog, the state goes first so that a partial removal doesn't look like a finished copy
+func removeCompaction(r Interface) error {
+	for _, name := range []string{compactStateName, compactSeqsName, compactLogName} {
+		if err := os.RemoveAll(r.GetPath("logs", name)); err != nil {
+			return errors.Wrap(err, "repo/compact: failed to clean up")
+		}
+	}
+	return nil
+}
+
+var errCompactMismatch = errors.New("repo/compact: the compacted log doesn't match its sequence map")
+
+// catchUpCompaction nulls the copied entries that were nulled in the root log since the copy was made
+// and copies the entries that were added since then.
+func catchUpCompaction(ctx context.Context, r Interface, stats *CompactStats) error {
+	from, err := OpenLog(r)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := OpenLog(r, compactLogName)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	seqsFile, err := os.OpenFile(r.GetPath("logs", compactSeqsName), os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "repo/compact: failed to open sequence map")
+	}
+	defer seqsFile.Close()
+
+	seqs := bufio.NewReader(seqsFile)
+	var (
+		buf     [compactSeqsEntryLen]byte
+		newSeq  int64
+		lastOld int64 = -1
+	)
+	for ; ; newSeq++ {
+		_, err := io.ReadFull(seqs, buf[:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "repo/compact: failed to read sequence map")
+		}
+		lastOld = int64(binary.BigEndian.Uint64(buf[:]))
+
+		_, err = from.Get(margaret.BaseSeq(lastOld))
+		if margaret.IsErrNulled(err) {
+			if err := to.Null(margaret.BaseSeq(newSeq)); err != nil {
+				return errors.Wrapf(err, "repo/compact: failed to null entry %d", newSeq)
+			}
+			stats.Nulled++
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "repo/compact: failed to check entry %d", lastOld)
+		}
+	}
+
+	// the map has an entry for everything in the compacted log, unless an earlier attempt crashed in between
+	tv, err := to.Seq().Value()
+	if err != nil {
+		return errors.Wrap(err, "repo/compact: failed to get sequence of compacted log")
+	}
+	if got := tv.(margaret.Seq).Seq() + 1; got != newSeq {
+		return errCompactMismatch
+	}
+
+	sv, err := from.Seq().Value()
+	if err != nil {
+		return errors.Wrap(err, "repo/compact: failed to get sequence of root log")
+	}
+	last := sv.(margaret.Seq).Seq()
+
+	// an earlier attempt that was interrupted might have copied some already
+	after := stats.Sequence
+	if lastOld > after {
+		after = lastOld
+	}
+	err = copyCompacted(ctx, from, after, last, to, seqsFile, stats, nil)
+	if err != nil {
+		return err
+	}
+	stats.Sequence = last
+	return nil
+}
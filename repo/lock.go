@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LockFileName is the file in the repo that holds the lock of the process that uses it
+const LockFileName = "lock"
+
+// LockInfo is the content of the lock file
+type LockInfo struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Host    string    `json:"host"`
+
+	// ProcStart identifies the process beyond its PID, which can be reused after it exited.
+	// It is the start time from /proc on linux and empty where that isn't available.
+	// The fields are only informational, the lock is held by the operating system.
+	ProcStart string `json:"procStart,omitempty"`
+}
+
+// ErrLocked is returned by Lock if another process holds the lock of the repo
+type ErrLocked struct {
+	Path string
+	Info LockInfo
+}
+
+func (e ErrLocked) Error() string {
+	return fmt.Sprintf("repo: %s is in use by process %d on %s (started %s)", e.Path, e.Info.PID, e.Info.Host, e.Info.Started.Format(time.RFC3339))
+}
+
+// errLockHeld is returned by lockFile if another process holds the lock
+var errLockHeld = errors.New("repo: lock is held")
+
+// IsLocked returns true if err is an ErrLocked
+func IsLocked(err error) bool {
+	_, ok := errors.Cause(err).(ErrLocked)
+	return ok
+}
+
+// the start time of this process, to tell it apart from a previous one with the same PID
+var processStarted = time.Now()
+
+// Lockfile is the lock of a repo, taken with Lock
+type Lockfile struct {
+	f    *os.File
+	info LockInfo
+
+	once sync.Once
+	err  error
+}
+
+// Info returns what was written to the lock file
+func (l *Lockfile) Info() LockInfo { return l.info }
+
+// Close releases the lock.
+// The file is emptied but not removed, another process might already wait on it and would hold a lock on a removed file.
+func (l *Lockfile) Close() error {
+	l.once.Do(func() {
+		err := l.f.Truncate(0)
+		if uerr := unlockFile(l.f); err == nil {
+			err = uerr
+		}
+		if cerr := l.f.Close(); err == nil {
+			err = cerr
+		}
+		l.err = errors.Wrap(err, "repo: failed to release lock")
+	})
+	return l.err
+}
+
+// Lock takes the lock of the repo, an exclusive lock on the lock file that the operating system releases when the process exits.
+// Only one process (and only one user inside the process, like a bot) should open the log and indexes of a repo at a time.
+// The file holds the PID and start time of the process, if another one holds the lock they are returned with ErrLocked.
+func Lock(r Interface) (*Lockfile, error) {
+	host, _ := os.Hostname()
+	info := LockInfo{
+		PID:       os.Getpid(),
+		Started:   processStarted,
+		Host:      host,
+		ProcStart: procStart(os.Getpid()),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: failed to encode lock")
+	}
+
+	if err := os.MkdirAll(r.GetPath(), 0700); err != nil {
+		return nil, errors.Wrap(err, "repo: failed to create repo folder")
+	}
+	f, err := os.OpenFile(r.GetPath(LockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: failed to open lock file")
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if err != errLockHeld {
+			return nil, errors.Wrap(err, "repo: failed to take lock")
+		}
+		// the holder might not have written its info yet, it's only used for the message
+		var held LockInfo
+		if data, err := ioutil.ReadFile(r.GetPath(LockFileName)); err == nil {
+			json.Unmarshal(data, &held)
+		}
+		return nil, ErrLocked{Path: r.GetPath(), Info: held}
+	}
+
+	// whatever a previous holder left in it is replaced
+	if err := f.Truncate(0); err != nil {
+		unlockFile(f)
+		f.Close()
+		return nil, errors.Wrap(err, "repo: failed to write lock file")
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		unlockFile(f)
+		f.Close()
+		return nil, errors.Wrap(err, "repo: failed to write lock file")
+	}
+	return &Lockfile{f: f, info: info}, nil
+}
+
+// ReadLock returns the info of the process that holds the lock of the repo or nil if it isn't held.
+// The info is empty if the holder didn't write it yet.
+func ReadLock(r Interface) (*LockInfo, error) {
+	f, err := os.Open(r.GetPath(LockFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "repo: failed to open lock file")
+	}
+	defer f.Close()
+
+	if err := lockFile(f); err == nil {
+		unlockFile(f)
+		return nil, nil
+	} else if err != errLockHeld {
+		return nil, errors.Wrap(err, "repo: failed to check lock")
+	}
+
+	var info LockInfo
+	if data, err := ioutil.ReadAll(f); err == nil {
+		json.Unmarshal(data, &info)
+	}
+	return &info, nil
+}
+
+// procStart returns the start time of the process from /proc/<pid>/stat, in clock ticks since boot.
+// It returns an empty string if that isn't available.
+func procStart(pid int) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ""
+	}
+	// the command name in the second field can contain spaces, the fields after it can't
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return ""
+	}
+	// starttime is field 22, the fields after the name start at 3
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return ""
+	}
+	if _, err := strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return ""
+	}
+	return fields[19]
+}
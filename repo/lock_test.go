@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	r := require.New(t)
+
+	rpath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(rpath)
+	testRepo := New(rpath)
+
+	lock, err := Lock(testRepo)
+	r.NoError(err)
+	r.Equal(os.Getpid(), lock.Info().PID)
+
+	held, err := ReadLock(testRepo)
+	r.NoError(err)
+	r.NotNil(held)
+	r.Equal(os.Getpid(), held.PID)
+
+	_, err = Lock(testRepo)
+	r.Error(err)
+	r.True(IsLocked(err), "wrong error: %v", err)
+
+	r.NoError(lock.Close())
+	r.NoError(lock.Close(), "second close should be a noop")
+	held, err = ReadLock(testRepo)
+	r.NoError(err)
+	r.Nil(held)
+
+	lock, err = Lock(testRepo)
+	r.NoError(err)
+	r.NoError(lock.Close())
+
+	// only the emptied lock file is left behind
+	entries, err := ioutil.ReadDir(rpath)
+	r.NoError(err)
+	r.Len(entries, 1)
+	r.Equal(LockFileName, entries[0].Name())
+	r.EqualValues(0, entries[0].Size())
+}
+
+func TestLockLeftovers(t *testing.T) {
+	r := require.New(t)
+
+	rpath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(rpath)
+	testRepo := New(rpath)
+	host, _ := os.Hostname()
+
+	// files that nobody holds a lock on don't block, whatever is in them
+	for _, content := range [][]byte{
+		nil,
+		[]byte("{broken"),
+		mustJSON(t, LockInfo{PID: os.Getpid(), Started: time.Now(), Host: host}),
+		mustJSON(t, LockInfo{PID: 1, Started: time.Now(), Host: host + "-other"}),
+	} {
+		r.NoError(ioutil.WriteFile(testRepo.GetPath(LockFileName), content, 0600))
+
+		held, err := ReadLock(testRepo)
+		r.NoError(err)
+		r.Nil(held)
+
+		lock, err := Lock(testRepo)
+		r.NoError(err)
+		r.NoError(lock.Close())
+	}
+}
+
+func TestLockConcurrent(t *testing.T) {
+	r := require.New(t)
+
+	rpath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(rpath)
+	testRepo := New(rpath)
+
+	const n = 10
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		locks  []*Lockfile
+		locked int
+	)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			lock, err := Lock(testRepo)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				locks = append(locks, lock)
+			} else if IsLocked(err) {
+				locked++
+			} else {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	r.Len(locks, 1, "exactly one Lock should succeed")
+	r.Equal(n-1, locked)
+	r.NoError(locks[0].Close())
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
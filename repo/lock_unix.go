@@ -0,0 +1,24 @@
+// SPDX-License-Identifier: MIT
+
+// +build !windows
+
+package repo
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on f without waiting for it.
+// flocks belong to the open file, so they also exclude other opens in the same process.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLockHeld
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
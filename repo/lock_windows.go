@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: MIT
+
+// +build windows
+
+package repo
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// the locked byte is far beyond the content, locked ranges can't be read by others on windows
+const (
+	lockOffsetLow  = 0xFFFFFFFE
+	lockOffsetHigh = 0x7FFFFFFF
+)
+
+// lockFile takes an exclusive lock on f without waiting for it
+func lockFile(f *os.File) error {
+	ol := windows.Overlapped{Offset: lockOffsetLow, OffsetHigh: lockOffsetHigh}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errLockHeld
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	ol := windows.Overlapped{Offset: lockOffsetLow, OffsetHigh: lockOffsetHigh}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
@@ -4,8 +4,10 @@ package repo
 
 import (
 	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/offset2"
 	"go.cryptoscope.co/ssb/message/multimsg"
+	"go.cryptoscope.co/ssb/repo/atrest"
 )
 
 func OpenLog(r Interface, path ...string) (multimsg.AlterableLog, error) {
@@ -15,7 +17,16 @@ func OpenLog(r Interface, path ...string) (multimsg.AlterableLog, error) {
 		path[0] = "logs"
 	}
 
+	k, err := atRestKey(r)
+	if err != nil {
+		return nil, err
+	}
+	var codec margaret.Codec = multimsg.MargaretCodec{}
+	if k != nil {
+		codec = atrest.NewCodec(codec, k)
+	}
+
 	// TODO use proper log message type here
-	log, err := offset2.Open(r.GetPath(path...), multimsg.MargaretCodec{})
+	log, err := offset2.Open(r.GetPath(path...), codec)
 	return multimsg.NewWrappedLog(log), errors.Wrap(err, "failed to open log")
 }
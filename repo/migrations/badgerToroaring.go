@@ -1,7 +1,12 @@
 package migrations
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
@@ -10,6 +15,62 @@ import (
 	"go.cryptoscope.co/ssb/repo"
 )
 
+func init() {
+	Register(Migration{
+		Version: 2,
+		Name:    "remove-badger-sublogs",
+		DryRun: func(_ context.Context, _ logging.Interface, r repo.Interface) (string, error) {
+			paths, err := badgerSublogs(r)
+			if err != nil {
+				return "", err
+			}
+			if len(paths) == 0 {
+				return "no badger sublogs left", nil
+			}
+			return fmt.Sprintf("remove the badger sublogs %s, they are rebuilt as roaring bitmaps on the next start", strings.Join(paths, ", ")), nil
+		},
+		Up: func(_ context.Context, log logging.Interface, r repo.Interface, progress ProgressFunc) error {
+			paths, err := badgerSublogs(r)
+			if err != nil {
+				return err
+			}
+			for i, p := range paths {
+				for _, name := range []string{"db", "state.json"} {
+					if err := os.RemoveAll(filepath.Join(p, name)); err != nil {
+						return errors.Wrapf(err, "failed to remove badger sublog %s", p)
+					}
+				}
+				level.Debug(log).Log("event", "removed badger sublog", "path", p)
+				progress(int64(i+1), int64(len(paths)))
+			}
+			return nil
+		},
+	})
+}
+
+// badgerSublogs returns the folders of the sublogs that still have a badger database
+func badgerSublogs(r repo.Interface) ([]string, error) {
+	entries, err := ioutil.ReadDir(r.GetPath(repo.PrefixMultiLog))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to list sublogs")
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		p := r.GetPath(repo.PrefixMultiLog, e.Name())
+		if _, err := os.Stat(filepath.Join(p, "db")); err == nil {
+			paths = append(paths, p)
+		} else if !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "failed to check sublog")
+		}
+	}
+	return paths, nil
+}
+
 func StillUsingBadger(log logging.Interface, r repo.Interface) (bool, error) {
 	v := CurrentVersion(r)
 	switch {
@@ -19,7 +80,8 @@ func StillUsingBadger(log logging.Interface, r repo.Interface) (bool, error) {
 		level.Error(log).Log("event", "repo is not version 1 yet", "v", v)
 		return false, nil
 	default:
-		return false, errors.Errorf("sbot/repo migrate: invalid version: %d", v)
+		// remove-badger-sublogs already ran
+		return false, nil
 	}
 
 	// check the db and the state file
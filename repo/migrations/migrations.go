@@ -10,7 +10,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.cryptoscope.co/luigi"
@@ -22,33 +24,138 @@ import (
 	"go.cryptoscope.co/margaret/codec/msgpack"
 	"go.cryptoscope.co/margaret/offset2"
 	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/multimsg"
 	"go.cryptoscope.co/ssb/repo"
 )
 
-func CurrentVersion(r repo.Interface) int {
+func init() {
+	Register(Migration{
+		Version:     1,
+		Name:        "multi-message-log",
+		Destructive: true,
+		DryRun: func(_ context.Context, log logging.Interface, r repo.Interface) (string, error) {
+			isMulti, err := isMultiMessageLog(r)
+			if err != nil {
+				return "", err
+			}
+			if isMulti {
+				return "the root log already stores multi messages", nil
+			}
+			from, err := checkIfVersion0(r, log)
+			if err != nil {
+				return "", err
+			}
+			defer from.(io.Closer).Close()
+			sv, err := from.Seq().Value()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("copy %d messages into a new root log and rebuild the indexes, the old log is kept as log-bak-v0", sv.(margaret.Seq).Seq()+1), nil
+		},
+		Up: func(ctx context.Context, log logging.Interface, r repo.Interface, progress ProgressFunc) error {
+			isMulti, err := isMultiMessageLog(r)
+			if err != nil || isMulti {
+				return err
+			}
+			if err := upgradeToMultiMessage(ctx, log, r, progress); err != nil {
+				return err
+			}
+			// the indexes only hold data derived from the log, the bot rebuilds them on the next start
+			for _, folder := range []string{repo.PrefixIndex, repo.PrefixMultiLog} {
+				if err := os.RemoveAll(r.GetPath(folder)); err != nil {
+					return errors.Wrapf(err, "failed to remove %s", folder)
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// ReadVersion returns the version of the repo, 0 if it doesn't have one yet
+func ReadVersion(r repo.Interface) (int, error) {
 	version, err := ioutil.ReadFile(r.GetPath("version"))
 	if os.IsNotExist(err) {
-		return 0
+		return 0, nil
 	} else if err != nil {
-		log.Println("CurrentVersion error:", err)
-		return -1
+		return -1, errors.Wrap(err, "repo/migrations: failed to read version file")
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(version)))
+	if err != nil {
+		return -1, errors.Wrapf(err, "repo/migrations: failed to parse version file content (%q)", string(version))
 	}
-	v, err := strconv.Atoi(string(version))
+	return v, nil
+}
+
+// CurrentVersion is like ReadVersion but returns -1 if the version can't be read
+func CurrentVersion(r repo.Interface) int {
+	v, err := ReadVersion(r)
 	if err != nil {
-		log.Printf("CurrentVersion failed to parse file content (%q):%s", string(version), err)
+		log.Println("CurrentVersion error:", err)
 		return -1
 	}
 	return v
 }
 
-// SetVersion should be atomic
+// SetVersion writes the version file of the repo.
+// The new version is written to a temporary file which is then moved over the old one, so that the file is never half written.
 func SetVersion(r repo.Interface, to int) error {
 	fname := r.GetPath("version")
-	vstr := []byte(strconv.Itoa(to))
-	err := ioutil.WriteFile(fname, vstr, 0700)
-	return errors.Wrap(err, "SetVersion failed to write file")
+	if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return errors.Wrap(err, "SetVersion failed to create repo folder")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fname), ".version-")
+	if err != nil {
+		return errors.Wrap(err, "SetVersion failed to create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.Itoa(to)); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "SetVersion failed to write file")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "SetVersion failed to sync file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "SetVersion failed to close file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), fname), "SetVersion failed to replace file")
+}
+
+// isMultiMessageLog returns true if the root log is missing, empty or already stores multi messages.
+// Repos that were created by the bot before it wrote version files are like that.
+func isMultiMessageLog(r repo.Interface) (bool, error) {
+	logPath := r.GetPath("log")
+	if _, err := os.Stat(logPath); os.IsNotExist(err) {
+		return true, nil
+	}
+	// encryption at rest came after multi messages
+	if _, err := os.Stat(r.GetPath(repo.AtRestConfigName)); err == nil {
+		return true, nil
+	}
+	l, err := offset2.Open(logPath, multimsg.MargaretCodec{})
+	if err != nil {
+		return false, errors.Wrap(err, "repo/migrations: failed to open root log")
+	}
+	defer l.Close()
+
+	sv, err := l.Seq().Value()
+	if err != nil {
+		return false, errors.Wrap(err, "repo/migrations: failed to get root log sequence")
+	}
+	if sv.(margaret.Seq).Seq() == margaret.SeqEmpty.Seq() {
+		return true, nil
+	}
+	// legacy entries are msgpack maps and don't start with a multi message type
+	_, err = l.Get(margaret.BaseSeq(0))
+	if margaret.IsErrNulled(err) {
+		return true, nil
+	}
+	return err == nil, nil
 }
 
+// UpgradeToMultiMessage copies a version 0 root log into the multi message format and sets the repo to version 1
 func UpgradeToMultiMessage(log logging.Interface, r repo.Interface) (bool, error) {
 	v := CurrentVersion(r)
 	switch {
@@ -61,45 +168,48 @@ func UpgradeToMultiMessage(log logging.Interface, r repo.Interface) (bool, error
 		return false, errors.Errorf("sbot/repo migrate: invalid version: %d", v)
 	}
 
+	if err := upgradeToMultiMessage(context.TODO(), log, r, func(int64, int64) {}); err != nil {
+		return false, err
+	}
+	return true, SetVersion(r, 1)
+}
+
+func upgradeToMultiMessage(ctx context.Context, log logging.Interface, r repo.Interface, progress ProgressFunc) error {
 	from, err := checkIfVersion0(r, log)
 	if err != nil {
-		return false, errors.Wrap(err, "pre-check failed failed")
+		return errors.Wrap(err, "pre-check failed failed")
 	}
 
 	to, err := repo.OpenLog(r, "migrate-v1")
 	if err != nil {
-		return false, errors.Wrap(err, "error opening new log")
+		return errors.Wrap(err, "error opening new log")
 	}
 
-	gotMsgs, err := copyOffset(log, from, to)
+	gotMsgs, err := copyOffset(ctx, log, from, to, progress)
 	if err != nil {
-		return false, errors.Wrap(err, "error copying new log")
+		return errors.Wrap(err, "error copying new log")
 	}
 
 	if err := validateNewLog(log, gotMsgs, to); err != nil {
-		return false, errors.Wrap(err, "error validating new log")
+		return errors.Wrap(err, "error validating new log")
 	}
 
 	err = from.(io.Closer).Close()
 	if err != nil {
-		return false, errors.Wrap(err, "error closing from log")
+		return errors.Wrap(err, "error closing from log")
 	}
 	err = to.(io.Closer).Close()
 	if err != nil {
-		return false, errors.Wrap(err, "error closing to log")
+		return errors.Wrap(err, "error closing to log")
 	}
 
 	err = os.Rename(r.GetPath("log"), r.GetPath("log-bak-v0"))
 	if err != nil {
-		return false, errors.Wrap(err, "error moving old log into backup position")
+		return errors.Wrap(err, "error moving old log into backup position")
 	}
 
 	err = os.Rename(r.GetPath("logs", "migrate-v1"), r.GetPath("log"))
-	if err != nil {
-		return false, errors.Wrap(err, "error moving migrated log into position")
-	}
-
-	return true, SetVersion(r, 1)
+	return errors.Wrap(err, "error moving migrated log into position")
 }
 
 func checkIfVersion0(r repo.Interface, log logging.Interface) (margaret.Log, error) {
@@ -141,7 +251,7 @@ func checkIfVersion0(r repo.Interface, log logging.Interface) (margaret.Log, err
 	return from, nil
 }
 
-func copyOffset(log logging.Interface, from, to margaret.Log) ([]ssb.MessageRef, error) {
+func copyOffset(ctx context.Context, log logging.Interface, from, to margaret.Log, progress ProgressFunc) ([]ssb.MessageRef, error) {
 
 	sv, err := from.Seq().Value()
 	if err != nil {
@@ -159,6 +269,9 @@ func copyOffset(log logging.Interface, from, to margaret.Log) ([]ssb.MessageRef,
 	i := 0
 	took := time.Now()
 	onePercent := fromSeq.Seq() / 10
+	if onePercent < 1 {
+		onePercent = 1
+	}
 
 	var got []ssb.MessageRef
 	track := luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
@@ -174,6 +287,10 @@ func copyOffset(log logging.Interface, from, to margaret.Log) ([]ssb.MessageRef,
 		got = append(got, *msg.Key)
 
 		seq, err := to.Append(v)
+		if err != nil {
+			return err
+		}
+		progress(seq.Seq()+1, fromSeq.Seq()+1)
 		if seq.Seq()%onePercent == 0 {
 			log.Log("level", "debug", "msg", "copy progress", "left", fromSeq.Seq()-seq.Seq(), "i", i, "took", time.Since(took))
 			i++
@@ -183,7 +300,7 @@ func copyOffset(log logging.Interface, from, to margaret.Log) ([]ssb.MessageRef,
 	})
 
 	log.Log("event", "start-copy", "seq", fromSeq.Seq())
-	err = luigi.Pump(context.TODO(), track, fromSrc)
+	err = luigi.Pump(ctx, track, fromSrc)
 	if err != nil {
 		return nil, errors.Wrap(err, "migrate: pumping messages failed")
 	}
@@ -208,6 +325,9 @@ func validateNewLog(log logging.Interface, got []ssb.MessageRef, to margaret.Log
 	i := 0
 	n := len(got)
 	onePercent := n / 10
+	if onePercent < 1 {
+		onePercent = 1
+	}
 	for {
 		v, err := newTarget.Next(context.TODO())
 		if luigi.IsEOS(err) {
@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: MIT
+
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cryptix/go/logging"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// ProgressFunc is called by migrations with the amount of work they did and the total amount, in units of their choice (like messages)
+type ProgressFunc func(done, total int64)
+
+// Migration upgrades a repo from Version-1 to Version
+type Migration struct {
+	Version int
+	Name    string
+
+	// Destructive migrations remove or rewrite data that can't be recreated from the repo afterwards.
+	// Run makes a backup of the repo before it applies them.
+	Destructive bool
+
+	// DryRun describes what Up would do, without changing the repo.
+	// It should also check that Up can be applied, so that problems show up before anything is changed.
+	DryRun func(ctx context.Context, log logging.Interface, r repo.Interface) (string, error)
+
+	// Up applies the migration. It doesn't need to set the version, Run does that once it returned without an error.
+	Up func(ctx context.Context, log logging.Interface, r repo.Interface, progress ProgressFunc) error
+}
+
+var registered []Migration
+
+// Register adds m to the list of known migrations.
+// Migrations have to be registered in order, without gaps, starting at version 1.
+func Register(m Migration) {
+	if want := Latest() + 1; m.Version != want {
+		panic(fmt.Sprintf("repo/migrations: %q has version %d but the next one has to be %d", m.Name, m.Version, want))
+	}
+	if m.Up == nil || m.DryRun == nil {
+		panic(fmt.Sprintf("repo/migrations: %q needs Up and DryRun", m.Name))
+	}
+	registered = append(registered, m)
+}
+
+// Registered returns all the known migrations, ordered by version
+func Registered() []Migration {
+	return append([]Migration(nil), registered...)
+}
+
+// Latest returns the version a repo has after all known migrations are applied
+func Latest() int {
+	if len(registered) == 0 {
+		return 0
+	}
+	return registered[len(registered)-1].Version
+}
+
+// ErrRepoTooNew is returned if a repo was migrated by a newer version of the software than the running one
+type ErrRepoTooNew struct {
+	Version, Latest int
+}
+
+func (e ErrRepoTooNew) Error() string {
+	return fmt.Sprintf("repo/migrations: the repo has version %d but this build only supports up to version %d. Please upgrade to open it", e.Version, e.Latest)
+}
+
+// Check returns ErrRepoTooNew if the repo has a newer version than Latest and an error if the version can't be read
+func Check(r repo.Interface) error {
+	v, err := ReadVersion(r)
+	if err != nil {
+		return err
+	}
+	if latest := Latest(); v > latest {
+		return ErrRepoTooNew{Version: v, Latest: latest}
+	}
+	return nil
+}
+
+// Pending returns the migrations that still need to be applied to the repo
+func Pending(r repo.Interface) ([]Migration, error) {
+	if err := Check(r); err != nil {
+		return nil, err
+	}
+	v, err := ReadVersion(r)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range registered {
+		if m.Version > v {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Options configure Run
+type Options struct {
+	// DryRun only asks the pending migrations what they would do
+	DryRun bool
+
+	// BackupDir is where backups before destructive migrations are put.
+	// Each one gets its own folder in it. Defaults to the backups folder inside the repo.
+	BackupDir string
+
+	// NoBackup skips the backups before destructive migrations
+	NoBackup bool
+
+	// Progress is called with the progress of each migration, if it's set
+	Progress func(m Migration, done, total int64)
+}
+
+// Step is what Run did (or would do) for one migration
+type Step struct {
+	Version int
+	Name    string
+
+	// Plan is the description of the dry run
+	Plan string
+
+	// Backup is the folder the repo was backed up to before the migration, if it was destructive
+	Backup string
+
+	Took time.Duration
+}
+
+// Run applies all pending migrations in order and sets the version after each one.
+// The repo must not be in use while it runs.
+//
+// A repo without a root log is new and set to the latest version right away.
+func Run(ctx context.Context, log logging.Interface, r repo.Interface, opts Options) (steps []Step, err error) {
+	pending, err := Pending(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(r.GetPath("log")); os.IsNotExist(err) {
+		if opts.DryRun {
+			return nil, nil
+		}
+		return nil, SetVersion(r, Latest())
+	}
+
+	// the migrations work on the plain index data of encrypted repos, it is sealed again when they are done
+	if !opts.DryRun {
+		if err := repo.UnsealIndexes(r); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if sealErr := repo.SealIndexes(r); sealErr != nil && err == nil {
+				err = sealErr
+			}
+		}()
+	}
+
+	for _, m := range pending {
+		plan, err := m.DryRun(ctx, log, r)
+		if err != nil {
+			return steps, errors.Wrapf(err, "repo/migrations: dry run of %d (%s) failed", m.Version, m.Name)
+		}
+		step := Step{Version: m.Version, Name: m.Name, Plan: plan}
+		if opts.DryRun {
+			steps = append(steps, step)
+			continue
+		}
+
+		if m.Destructive && !opts.NoBackup {
+			step.Backup, err = backupBefore(ctx, r, m, opts.BackupDir)
+			if err != nil {
+				return steps, err
+			}
+			level.Info(log).Log("event", "migration backup", "version", m.Version, "path", step.Backup)
+		}
+
+		level.Info(log).Log("event", "migration start", "version", m.Version, "name", m.Name, "plan", plan)
+		var progress ProgressFunc = func(int64, int64) {}
+		if opts.Progress != nil {
+			m := m
+			progress = func(done, total int64) { opts.Progress(m, done, total) }
+		}
+
+		start := time.Now()
+		if err := m.Up(ctx, log, r, progress); err != nil {
+			return steps, errors.Wrapf(err, "repo/migrations: migration %d (%s) failed", m.Version, m.Name)
+		}
+		if err := SetVersion(r, m.Version); err != nil {
+			return steps, err
+		}
+		step.Took = time.Since(start)
+		level.Info(log).Log("event", "migration done", "version", m.Version, "took", step.Took)
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// backupBefore copies the repo before the destructive migration m.
+// Nothing else uses the repo while migrations run, so the indexes can be copied as well.
+func backupBefore(ctx context.Context, r repo.Interface, m Migration, dir string) (string, error) {
+	if dir == "" {
+		dir = r.GetPath("backups")
+	}
+	dst := filepath.Join(dir, fmt.Sprintf("pre-v%d-%s", m.Version, time.Now().Format("20060102-150405")))
+	_, err := repo.Backup(ctx, r, dst, repo.BackupOptions{
+		Blobs:   repo.BackupBlobsList,
+		Indexes: true,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "repo/migrations: backup before migration %d failed", m.Version)
+	}
+	return dst, nil
+}
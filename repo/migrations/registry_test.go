@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MIT
+
+package migrations
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestSetVersion(t *testing.T) {
+	r := require.New(t)
+
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+	r.NoError(os.MkdirAll(testPath, 0700))
+	testRepo := repo.New(testPath)
+
+	v, err := ReadVersion(testRepo)
+	r.NoError(err)
+	r.Equal(0, v)
+
+	r.NoError(SetVersion(testRepo, 1))
+	r.NoError(SetVersion(testRepo, 2))
+	r.Equal(2, CurrentVersion(testRepo))
+
+	// no temporary files are left behind
+	entries, err := ioutil.ReadDir(testPath)
+	r.NoError(err)
+	r.Len(entries, 1)
+
+	r.NoError(SetVersion(testRepo, Latest()+1))
+	err = Check(testRepo)
+	r.Error(err)
+	tooNew, ok := err.(ErrRepoTooNew)
+	r.True(ok, "wrong error: %v", err)
+	r.Equal(Latest()+1, tooNew.Version)
+
+	_, err = Run(context.TODO(), testutils.NewRelativeTimeLogger(nil), testRepo, Options{})
+	r.Error(err)
+}
+
+func TestRegistered(t *testing.T) {
+	r := require.New(t)
+
+	ms := Registered()
+	r.NotEmpty(ms)
+	for i, m := range ms {
+		r.Equal(i+1, m.Version)
+	}
+	r.Equal(ms[len(ms)-1].Version, Latest())
+
+	r.Panics(func() {
+		Register(Migration{Version: Latest() + 2, Name: "gap"})
+	})
+}
+
+func TestRunNewRepo(t *testing.T) {
+	r := require.New(t)
+
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+	// the folder of a new repo doesn't exist yet
+	testRepo := repo.New(testPath)
+
+	steps, err := Run(context.TODO(), testutils.NewRelativeTimeLogger(nil), testRepo, Options{})
+	r.NoError(err)
+	r.Len(steps, 0)
+	r.Equal(Latest(), CurrentVersion(testRepo))
+}
+
+func TestRunBadgerSublogs(t *testing.T) {
+	r := require.New(t)
+	logger := testutils.NewRelativeTimeLogger(nil)
+
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+	testRepo := repo.New(testPath)
+
+	// an unversioned repo from before the version file, with a leftover badger sublog
+	rootLog, err := repo.OpenLog(testRepo)
+	r.NoError(err)
+	r.NoError(rootLog.Close())
+	dbPath := testRepo.GetPath(repo.PrefixMultiLog, multilogs.IndexNameFeeds, "db")
+	r.NoError(os.MkdirAll(dbPath, 0700))
+	r.NoError(ioutil.WriteFile(filepath.Join(dbPath, "000001.vlog"), []byte("data"), 0600))
+
+	steps, err := Run(context.TODO(), logger, testRepo, Options{DryRun: true})
+	r.NoError(err)
+	r.Len(steps, 2)
+	r.Equal(1, steps[0].Version)
+	r.Equal(2, steps[1].Version)
+	r.Contains(steps[1].Plan, multilogs.IndexNameFeeds)
+	r.Equal(0, CurrentVersion(testRepo), "dry run changed the version")
+	_, err = os.Stat(dbPath)
+	r.NoError(err, "dry run removed the sublog")
+
+	var progressed int64
+	backupDir := filepath.Join("testrun", t.Name()+"-backups")
+	os.RemoveAll(backupDir)
+	steps, err = Run(context.TODO(), logger, testRepo, Options{
+		BackupDir: backupDir,
+		Progress: func(m Migration, done, total int64) {
+			if m.Version == 2 {
+				progressed = done
+			}
+		},
+	})
+	r.NoError(err)
+	r.Len(steps, 2)
+	r.Equal(Latest(), CurrentVersion(testRepo))
+	r.EqualValues(1, progressed)
+
+	_, err = os.Stat(dbPath)
+	r.True(os.IsNotExist(err), "badger sublog still exists")
+
+	// only the migration that rewrites the log made a backup, the sublogs are rebuilt anyway
+	r.NotEqual("", steps[0].Backup)
+	r.Equal("", steps[1].Backup)
+	backedUp, err := ReadVersion(repo.New(steps[0].Backup))
+	r.NoError(err)
+	r.Equal(0, backedUp)
+
+	steps, err = Run(context.TODO(), logger, testRepo, Options{})
+	r.NoError(err)
+	r.Len(steps, 0)
+}
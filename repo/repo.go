@@ -21,6 +21,7 @@ import (
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/blobstore"
+	"go.cryptoscope.co/ssb/repo/atrest"
 )
 
 var _ Interface = repo{}
@@ -208,7 +209,19 @@ func OpenBadgerIndex(r Interface, name string, f LibrarianIndexCreater) (*badger
 
 func OpenBlobStore(r Interface) (ssb.BlobStore, error) {
 	bs, err := blobstore.New(r.GetPath("blobs"))
-	return bs, errors.Wrap(err, "error opening blob store")
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening blob store")
+	}
+
+	k, err := atRestKey(r)
+	if err != nil || k == nil {
+		return bs, err
+	}
+	idx, err := OpenMKV(r.GetPath("blobs", "atrest"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening at-rest blob index")
+	}
+	return atrest.NewBlobStore(bs, k, idx), nil
 }
 
 var lockFileExistsRe = regexp.MustCompile(`cannot access DB \"(.*)\": lock file \"(.*)\" exists`)
@@ -7,14 +7,21 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/ssb"
 )
 
+// DefaultKeyPair loads the default secret of the repo or creates it. It uses ssb.SecretPassphrase if the secret is encrypted.
 func DefaultKeyPair(r Interface) (*ssb.KeyPair, error) {
+	return DefaultKeyPairWithPassphrase(r, ssb.SecretPassphrase)
+}
+
+// DefaultKeyPairWithPassphrase is like DefaultKeyPair but asks passphrase if the secret is encrypted
+func DefaultKeyPairWithPassphrase(r Interface, passphrase ssb.PassphraseFunc) (*ssb.KeyPair, error) {
 	secPath := r.GetPath("secret")
-	keyPair, err := ssb.LoadKeyPair(secPath)
+	keyPair, err := ssb.LoadKeyPairWithPassphrase(secPath, passphrase)
 	if err != nil {
 		if !os.IsNotExist(errors.Cause(err)) {
 			return nil, errors.Wrap(err, "repo: error opening key pair")
@@ -32,19 +39,60 @@ func DefaultKeyPair(r Interface) (*ssb.KeyPair, error) {
 }
 
 func NewKeyPair(r Interface, name, algo string) (*ssb.KeyPair, error) {
-	return newKeyPair(r, name, algo, nil)
+	return newKeyPair(r, name, algo, nil, nil)
 }
 
 func NewKeyPairFromSeed(r Interface, name, algo string, seed io.Reader) (*ssb.KeyPair, error) {
-	return newKeyPair(r, name, algo, seed)
+	return newKeyPair(r, name, algo, seed, nil)
+}
+
+// NewEncryptedKeyPair is like NewKeyPair but the secret is encrypted with passphrase.
+// Like with NewKeyPair, the name - is the default keypair of the repo.
+func NewEncryptedKeyPair(r Interface, name, algo string, passphrase []byte) (*ssb.KeyPair, error) {
+	return newKeyPair(r, name, algo, nil, passphrase)
+}
+
+// RewrapKeyPair encrypts the secret name (- for the default one) with newPassphrase.
+// passphrase is only asked if the secret is already encrypted.
+func RewrapKeyPair(r Interface, name string, passphrase ssb.PassphraseFunc, newPassphrase []byte) (*ssb.KeyPair, error) {
+	if name != "-" {
+		if err := CheckKeyPairName(name); err != nil {
+			return nil, err
+		}
+	}
+	secPath := secretPath(r, name)
+	keyPair, err := ssb.RewrapKeyPair(secPath, passphrase, newPassphrase)
+	if err != nil {
+		return nil, errors.Wrapf(err, "repo: failed to rewrap %q", secPath)
+	}
+	return keyPair, nil
+}
+
+// CheckKeyPairName returns an error if name can't be used for a secret in the secrets folder of the repo.
+// Names can't be empty, contain path separators or point out of the folder.
+func CheckKeyPairName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return errors.Errorf("repo: invalid key pair name %q", name)
+	case strings.ContainsAny(name, "/\\\x00"):
+		return errors.Errorf("repo: key pair name %q contains a path separator or NUL", name)
+	}
+	return nil
 }
 
-func newKeyPair(r Interface, name, algo string, seed io.Reader) (*ssb.KeyPair, error) {
-	var secPath string
+func secretPath(r Interface, name string) string {
 	if name == "-" {
-		secPath = r.GetPath("secret")
-	} else {
-		secPath = r.GetPath("secrets", name)
+		return r.GetPath("secret")
+	}
+	return r.GetPath("secrets", name)
+}
+
+func newKeyPair(r Interface, name, algo string, seed io.Reader, passphrase []byte) (*ssb.KeyPair, error) {
+	secPath := secretPath(r, name)
+	if name != "-" {
+		if err := CheckKeyPairName(name); err != nil {
+			return nil, err
+		}
 		err := os.MkdirAll(filepath.Dir(secPath), 0700)
 		if err != nil && !os.IsExist(errors.Cause(err)) {
 			return nil, err
@@ -53,7 +101,7 @@ func newKeyPair(r Interface, name, algo string, seed io.Reader) (*ssb.KeyPair, e
 	if algo != ssb.RefAlgoFeedSSB1 && algo != ssb.RefAlgoFeedGabby { //  enums would be nice
 		return nil, errors.Errorf("invalid feed refrence algo")
 	}
-	if _, err := ssb.LoadKeyPair(secPath); err == nil {
+	if _, err := os.Stat(secPath); err == nil {
 		return nil, errors.Errorf("new key-pair name already taken")
 	}
 	keyPair, err := ssb.NewKeyPair(seed)
@@ -61,23 +109,44 @@ func newKeyPair(r Interface, name, algo string, seed io.Reader) (*ssb.KeyPair, e
 		return nil, errors.Wrap(err, "repo: no keypair but couldn't create one either")
 	}
 	keyPair.Id.Algo = algo
-	if err := ssb.SaveKeyPair(keyPair, secPath); err != nil {
+	if passphrase != nil {
+		err = ssb.SaveEncryptedKeyPair(keyPair, passphrase, secPath)
+	} else {
+		err = ssb.SaveKeyPair(keyPair, secPath)
+	}
+	if err != nil {
 		return nil, errors.Wrap(err, "repo: error saving new identity file")
 	}
 	log.Printf("saved identity %s to %s", keyPair.Id.Ref(), secPath)
 	return keyPair, nil
 }
 
+// LoadKeyPair loads the secret name of the repo. It uses ssb.SecretPassphrase if the secret is encrypted.
 func LoadKeyPair(r Interface, name string) (*ssb.KeyPair, error) {
+	return LoadKeyPairWithPassphrase(r, name, ssb.SecretPassphrase)
+}
+
+// LoadKeyPairWithPassphrase is like LoadKeyPair but asks passphrase if the secret is encrypted
+func LoadKeyPairWithPassphrase(r Interface, name string, passphrase ssb.PassphraseFunc) (*ssb.KeyPair, error) {
+	if err := CheckKeyPairName(name); err != nil {
+		return nil, err
+	}
 	secPath := r.GetPath("secrets", name)
-	keyPair, err := ssb.LoadKeyPair(secPath)
+	keyPair, err := ssb.LoadKeyPairWithPassphrase(secPath, passphrase)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Load: failed to open %q", secPath)
 	}
 	return keyPair, nil
 }
 
+// AllKeyPairs loads the named secrets of the repo, skipping the ones that can't be loaded.
+// It uses ssb.SecretPassphrase for encrypted secrets.
 func AllKeyPairs(r Interface) (map[string]*ssb.KeyPair, error) {
+	return AllKeyPairsWithPassphrase(r, ssb.SecretPassphrase)
+}
+
+// AllKeyPairsWithPassphrase is like AllKeyPairs but asks passphrase for encrypted secrets
+func AllKeyPairsWithPassphrase(r Interface, passphrase ssb.PassphraseFunc) (map[string]*ssb.KeyPair, error) {
 	kps := make(map[string]*ssb.KeyPair)
 	err := filepath.Walk(r.GetPath("secrets"), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -89,7 +158,7 @@ func AllKeyPairs(r Interface) (map[string]*ssb.KeyPair, error) {
 		if info.IsDir() {
 			return nil
 		}
-		if kp, err := ssb.LoadKeyPair(path); err == nil {
+		if kp, err := ssb.LoadKeyPairWithPassphrase(path, passphrase); err == nil {
 			kps[filepath.Base(path)] = kp
 			return nil
 		}
@@ -69,6 +69,28 @@ type Status struct {
 	Blobs    []BlobWant
 	Root     margaret.BaseSeq
 	Indicies IndexStates
+
+	// ConnDecisions are the recent decisions of the connection tracker, if it keeps them
+	ConnDecisions []ConnDecision `json:",omitempty"`
+
+	// Addresses are the multiserver addresses the bot can be reached at
+	Addresses []string `json:",omitempty"`
+
+	Bandwidth *BandwidthStats `json:",omitempty"`
+}
+
+// BandwidthStats are the byte counters of the network since the bot started
+type BandwidthStats struct {
+	// RX and TX are counted on the wire, including handshakes and encryption overhead
+	RX, TX uint64
+
+	// Peers are the muxrpc bytes per connected peer, counted since it connected
+	Peers []PeerBandwidth
+}
+
+type PeerBandwidth struct {
+	Peer   string
+	RX, TX uint64
 }
 
 type IndexStates []IndexState
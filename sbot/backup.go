@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Backup writes a consistent copy of the repo of the running bot into the folder dst.
+// The root log and blob store of the bot are used, RootLog, BlobStore and Indexes of opts are ignored.
+// The indexes can't be copied while they are open, they are rebuilt after a restore.
+func (s *Sbot) Backup(ctx context.Context, dst string, opts repo.BackupOptions) (*repo.BackupManifest, error) {
+	opts.RootLog = s.RootLog
+	opts.BlobStore = s.BlobStore
+	opts.Indexes = false
+
+	m, err := repo.Backup(ctx, s.repo(), dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	level.Info(s.info).Log("event", "backup done", "dst", dst, "seq", m.Sequence, "blobs", m.BlobCount)
+	return m, nil
+}
@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestBackupRestore(t *testing.T) {
+	r := require.New(t)
+
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+
+	bot, opts := makeTestBot(t)
+
+	// the deleted messages at the start need placeholders in the backup
+	for i := 0; i < 2; i++ {
+		_, err := bot.PublishAs("one", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	r.NoError(bot.RootLog.Null(margaret.BaseSeq(0)))
+	r.NoError(bot.RootLog.Null(margaret.BaseSeq(1)))
+
+	for i := 0; i < 3; i++ {
+		_, err := bot.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+
+	blob, err := bot.BlobStore.Put(strings.NewReader("a blob to keep"))
+	r.NoError(err)
+
+	backupPath := filepath.Join(testPath, "backup")
+	m, err := bot.Backup(context.TODO(), backupPath, repo.BackupOptions{Blobs: repo.BackupBlobsAll})
+	r.NoError(err)
+	r.EqualValues(4, m.Sequence)
+	r.EqualValues(2, m.Nulled)
+	r.Equal(1, m.BlobCount)
+	r.Contains(m.Secrets, "-")
+	r.Contains(m.Secrets, "one")
+	r.Contains(m.Secrets, "two")
+
+	// messages after the backup started are not in it
+	_, err = bot.PublishLog.Publish(map[string]interface{}{"type": "test", "after": true})
+	r.NoError(err)
+
+	_, err = bot.Backup(context.TODO(), backupPath, repo.BackupOptions{})
+	r.Error(err, "backup into a non-empty folder")
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	restorePath := filepath.Join(testPath, "restored")
+	m2, withIndexes, err := repo.Restore(backupPath, restorePath, repo.RestoreOptions{})
+	r.NoError(err)
+	r.False(withIndexes)
+	r.Equal(m.Sequence, m2.Sequence)
+
+	// the bot rebuilds the indexes of the restored repo
+	restored, err := New(append(opts, WithRepoPath(restorePath))...)
+	r.NoError(err)
+	restored.WaitUntilIndexesAreSynced()
+	r.True(restored.KeyPair.Id.Equal(bot.KeyPair.Id))
+
+	seqV, err := restored.RootLog.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(4, seqV.(margaret.Seq).Seq())
+
+	for seq := int64(0); seq < 2; seq++ {
+		_, err := restored.RootLog.Get(margaret.BaseSeq(seq))
+		r.True(margaret.IsErrNulled(err), "expected %d to be nulled: %v", seq, err)
+	}
+	for seq := int64(2); seq < 5; seq++ {
+		v, err := restored.RootLog.Get(margaret.BaseSeq(seq))
+		r.NoError(err)
+		msg, ok := v.(ssb.Message)
+		r.True(ok, "wrong type at %d: %T", seq, v)
+		r.True(msg.Author().Equal(bot.KeyPair.Id))
+	}
+
+	rd, err := restored.BlobStore.Get(blob)
+	r.NoError(err)
+	content, err := ioutil.ReadAll(rd)
+	r.NoError(err)
+	r.True(bytes.Equal([]byte("a blob to keep"), content))
+
+	restored.Shutdown()
+	r.NoError(restored.Close())
+}
+
+func TestBackupBlobList(t *testing.T) {
+	r := require.New(t)
+
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+
+	bot, _ := makeTestBot(t)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+
+	blob, err := bot.BlobStore.Put(strings.NewReader("only listed"))
+	r.NoError(err)
+
+	backupPath := filepath.Join(testPath, "backup")
+	m, err := bot.Backup(context.TODO(), backupPath, repo.BackupOptions{})
+	r.NoError(err)
+	r.Equal(repo.BackupBlobsList, m.Blobs)
+	r.EqualValues(-1, m.Sequence)
+
+	list, err := ioutil.ReadFile(filepath.Join(backupPath, repo.BlobListName))
+	r.NoError(err)
+	r.Equal(blob.Ref()+"\n", string(list))
+
+	_, err = os.Stat(filepath.Join(backupPath, "blobs"))
+	r.True(os.IsNotExist(err), "listed blobs should not be copied")
+}
+
+// TestBackupWhilePublishing checks that a backup of a running bot leaves the open indexes out and that they are rebuilt after the restore
+func TestBackupWhilePublishing(t *testing.T) {
+	r := require.New(t)
+
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+
+	bot, opts := makeTestBot(t)
+
+	publish := func(i int) {
+		_, err := bot.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	for i := 0; i < 10; i++ {
+		publish(i)
+	}
+	bot.WaitUntilIndexesAreSynced()
+
+	stop := make(chan struct{})
+	published := make(chan int)
+	go func() {
+		i := 10
+		for {
+			select {
+			case <-stop:
+				published <- i
+				return
+			default:
+			}
+			_, err := bot.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+			if err != nil {
+				t.Error(err)
+			}
+			i++
+		}
+	}()
+
+	backupPath := filepath.Join(testPath, "backup")
+	m, err := bot.Backup(context.TODO(), backupPath, repo.BackupOptions{Indexes: true})
+	close(stop)
+	r.NoError(err)
+	r.True(<-published > 10)
+	r.False(m.Indexes)
+	_, err = os.Stat(filepath.Join(backupPath, repo.PrefixMultiLog))
+	r.True(os.IsNotExist(err), "the open indexes should not be copied")
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	restorePath := filepath.Join(testPath, "restored")
+	_, withIndexes, err := repo.Restore(backupPath, restorePath, repo.RestoreOptions{})
+	r.NoError(err)
+	r.False(withIndexes)
+
+	restored, err := New(append(opts, WithRepoPath(restorePath))...)
+	r.NoError(err)
+	defer func() {
+		restored.Shutdown()
+		r.NoError(restored.Close())
+	}()
+	restored.WaitUntilIndexesAreSynced()
+
+	// the rebuilt index of the own feed matches the restored log, so publishing continues the feed
+	uf, ok := restored.GetMultiLog("userFeeds")
+	r.True(ok)
+	own, err := uf.Get(restored.KeyPair.Id.StoredAddr())
+	r.NoError(err)
+	seqV, err := own.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(m.Sequence, seqV.(margaret.Seq).Seq())
+
+	ref, err := restored.PublishLog.Publish(map[string]interface{}{"type": "test", "after": true})
+	r.NoError(err)
+	msg, err := restored.Get(*ref)
+	r.NoError(err)
+	r.EqualValues(m.Sequence+2, msg.Seq())
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/machinebox/progress"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Compact writes a copy of the root log without the entries that were removed with NullFeed or NullContent, while the bot keeps running.
+// The copy replaces the root log the next time the bot is started, which also rebuilds the indexes. See repo.FinishCompaction.
+// progressFn is called like the one set with FSCKWithProgress. It's okay to pass nil, the progress is logged in that case.
+func (s *Sbot) Compact(ctx context.Context, progressFn func(percentage float64, timeLeft time.Duration)) (*repo.CompactStats, error) {
+	s.compactMu.Lock()
+	if s.compacting {
+		s.compactMu.Unlock()
+		return nil, errors.New("sbot: a compaction is already running")
+	}
+	s.compacting = true
+	s.compactMu.Unlock()
+	defer func() {
+		s.compactMu.Lock()
+		s.compacting = false
+		s.compactMu.Unlock()
+	}()
+
+	if progressFn == nil {
+		progressFn = func(percentage float64, timeLeft time.Duration) {
+			level.Info(s.info).Log("event", "compact-progress", "done", percentage, "time-left", timeLeft.String())
+		}
+	}
+
+	var (
+		pc      processedCounter
+		started = make(chan int64, 1)
+		done    = make(chan struct{})
+	)
+	pctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(done)
+		var total int64
+		select {
+		case total = <-started:
+		case <-pctx.Done():
+			return
+		}
+		p := progress.NewTicker(pctx, &pc, total, 3*time.Second)
+		for remaining := range p {
+			timeLeft := remaining.Estimated().Sub(time.Now()).Round(time.Second)
+			progressFn(remaining.Percent(), timeLeft)
+		}
+	}()
+
+	stats, err := repo.CompactLog(ctx, s.repo(), s.RootLog, func(n, total int64) {
+		if n == 1 {
+			started <- total
+		}
+		pc.Incr()
+	})
+	cancel()
+	<-done // no progress calls after we returned
+	if err != nil {
+		return nil, err
+	}
+
+	level.Info(s.info).Log("event", "root log copied for compaction", "copied", stats.Copied, "dropped", stats.Dropped, "took", time.Since(stats.Started), "msg", "the copy is put in place when the bot is started again")
+	return stats, nil
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+func TestCompact(t *testing.T) {
+	r := require.New(t)
+
+	os.RemoveAll(filepath.Join("testrun", t.Name()))
+
+	bot, opts := makeTestBot(t)
+
+	for i := 0; i < 2; i++ {
+		_, err := bot.PublishAs("one", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := bot.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	r.NoError(bot.RootLog.Null(margaret.BaseSeq(0)))
+	r.NoError(bot.RootLog.Null(margaret.BaseSeq(1)))
+
+	stats, err := bot.Compact(context.TODO(), nil)
+	r.NoError(err)
+	r.EqualValues(4, stats.Sequence)
+	r.EqualValues(3, stats.Copied)
+	r.EqualValues(2, stats.Dropped)
+
+	// changes after the copy are caught up with when the bot starts again
+	r.NoError(bot.RootLog.Null(margaret.BaseSeq(2)))
+	_, err = bot.PublishLog.Publish(map[string]interface{}{"type": "test", "after": true})
+	r.NoError(err)
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	compacted, err := New(opts...)
+	r.NoError(err)
+	compacted.WaitUntilIndexesAreSynced()
+
+	seqV, err := compacted.RootLog.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(3, seqV.(margaret.Seq).Seq())
+
+	_, err = compacted.RootLog.Get(margaret.BaseSeq(0))
+	r.True(margaret.IsErrNulled(err), "expected 0 to be nulled: %v", err)
+	for seq := int64(1); seq < 4; seq++ {
+		v, err := compacted.RootLog.Get(margaret.BaseSeq(seq))
+		r.NoError(err)
+		msg, ok := v.(ssb.Message)
+		r.True(ok, "wrong type at %d: %T", seq, v)
+		r.True(msg.Author().Equal(compacted.KeyPair.Id))
+	}
+
+	// the indexes were rebuilt for the new sequences
+	uf, ok := compacted.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+	feed, err := uf.Get(compacted.KeyPair.Id.StoredAddr())
+	r.NoError(err)
+	seqV, err = feed.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(2, seqV.(margaret.Seq).Seq())
+	rootSeq, err := feed.Get(margaret.BaseSeq(2))
+	r.NoError(err)
+	r.EqualValues(3, rootSeq.(margaret.Seq).Seq())
+
+	compacted.Shutdown()
+	r.NoError(compacted.Close())
+}
@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb/plugins/feedgc"
+)
+
+// CollectFeeds sweeps the stored feeds now instead of waiting for the next interval, see WithFeedGC.
+// With dryRun it only reports which feeds would be dropped.
+func (s *Sbot) CollectFeeds(ctx context.Context, dryRun bool) (*feedgc.Report, error) {
+	if s.feedGC == nil {
+		return nil, errors.New("sbot: feed garbage collection is not enabled")
+	}
+	return s.feedGC.Sweep(ctx, time.Now(), dryRun)
+}
@@ -123,20 +123,33 @@ func (s *Sbot) FSCK(opts ...FSCKOption) error {
 
 	switch opt.mode {
 	case FSCKModeLength:
-		return lengthFSCK(opt.feedsIdx, s.RootLog)
+		return lengthFSCK(opt.feedsIdx, s.RootLog, s.quotaEvicted)
 
 	case FSCKModeSequences:
-		return sequenceFSCK(s.RootLog, opt.progressFn)
+		return sequenceFSCK(s.RootLog, opt.progressFn, s.quotaEvicted)
 
 	default:
 		return errors.New("sbot: unknown fsck mode")
 	}
 }
 
+// quotaEvicted returns up to which sequence the storage quota evicted the messages of ref
+func (s *Sbot) quotaEvicted(ref *ssb.FeedRef) int64 {
+	if s.quota == nil {
+		return 0
+	}
+	state, err := s.quota.State(ref)
+	if err != nil {
+		return 0
+	}
+	return state.Evicted
+}
+
 // lengthFSCK just checks the length of each stored feed.
 // It expects a multilog as first parameter where each sublog is one feed
-// and each entry maps to another entry in the receiveLog
-func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log) error {
+// and each entry maps to another entry in the receiveLog.
+// Feeds that were cut down by the storage quota can start later once the root log is compacted, evicted tells by how much at most.
+func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log, evicted func(*ssb.FeedRef) int64) error {
 	feeds, err := authorMlog.List()
 	if err != nil {
 		return err
@@ -181,7 +194,7 @@ func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log) error {
 		msg := rv.(ssb.Message)
 
 		// margaret indexes are 0-based, therefore +1
-		if msg.Seq() != currentSeqFromIndex.Seq()+1 {
+		if offset := msg.Seq() - currentSeqFromIndex.Seq() - 1; offset < 0 || offset > evicted(authorRef) {
 			return ssb.ErrWrongSequence{
 				Ref:     authorRef,
 				Stored:  currentSeqFromIndex,
@@ -214,8 +227,9 @@ func (p *processedCounter) N() int64 {
 func (p *processedCounter) Err() error { return nil }
 
 // sequenceFSCK goes through every message in the receiveLog
-// and checks tha the sequence of a feed is correctly increasing by one each message
-func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
+// and checks tha the sequence of a feed is correctly increasing by one each message.
+// Feeds that were cut down by the storage quota start after the messages it evicted.
+func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc, evicted func(*ssb.FeedRef) int64) error {
 	ctx := context.Background()
 
 	// the last sequence number we saw of that author
@@ -290,7 +304,8 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 		currSeq, has := lastSequence[authorRef]
 
 		if !has {
-			if msgSeq != 1 { // not seen yet, so has to be the first
+			// not seen yet, so has to be the first one that wasn't evicted
+			if msgSeq < 1 || msgSeq > evicted(msg.Author())+1 {
 				seqErr := ssb.ErrWrongSequence{
 					Ref:     msg.Author(),
 					Stored:  sw.Seq(),
@@ -300,7 +315,7 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 				lastSequence[authorRef] = -1
 				continue
 			}
-			lastSequence[authorRef] = 1
+			lastSequence[authorRef] = msgSeq
 			continue
 		}
 
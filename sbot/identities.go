@@ -3,38 +3,283 @@
 package sbot
 
 import (
+	"fmt"
+	"os"
+	"sort"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/private"
 	"go.cryptoscope.co/ssb/repo"
 )
 
-func (sbot *Sbot) PublishAs(nick string, val interface{}) (*ssb.MessageRef, error) {
-	r := repo.New(sbot.repoPath)
+// identity is a key pair of the repo that the bot publishes and reads private messages for
+type identity struct {
+	name string // - for the key pair of the bot
+	kp   *ssb.KeyPair
 
-	uf, ok := sbot.GetMultiLog(multilogs.IndexNameFeeds)
-	if !ok {
-		return nil, errors.Errorf("requried idx not present: userFeeds")
+	publisher ssb.Publisher // opened on first use
+}
+
+// loadIdentities adds the key pair of the bot and the ones in the secrets folder of the repo
+func (s *Sbot) loadIdentities(r repo.Interface) error {
+	s.identMu.Lock()
+	defer s.identMu.Unlock()
+
+	s.identities = make(map[string]*identity)
+	s.identities[s.KeyPair.Id.Ref()] = &identity{
+		name:      "-",
+		kp:        s.KeyPair,
+		publisher: s.PublishLog,
 	}
 
-	kp, err := repo.LoadKeyPair(r, nick)
+	kps, err := repo.AllKeyPairsWithPassphrase(r, s.getPassphrase())
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "sbot: failed to load identities")
+	}
+	for name, kp := range kps {
+		if _, has := s.identities[kp.Id.Ref()]; has {
+			continue
+		}
+		s.identities[kp.Id.Ref()] = &identity{name: name, kp: kp}
+	}
+	return nil
+}
+
+// Identities returns the key pairs the bot publishes and reads private messages for.
+// The one of the bot comes first, the others are sorted by their name in the repo.
+func (s *Sbot) Identities() []*ssb.KeyPair {
+	s.identMu.Lock()
+	defer s.identMu.Unlock()
+
+	ids := make([]*identity, 0, len(s.identities))
+	for _, id := range s.identities {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].name == "-" || ids[j].name == "-" {
+			return ids[i].name == "-"
+		}
+		return ids[i].name < ids[j].name
+	})
+
+	kps := make([]*ssb.KeyPair, len(ids))
+	for i, id := range ids {
+		kps[i] = id.kp
+	}
+	return kps
+}
+
+// IsIdentity returns true if ref is one of the local identities
+func (s *Sbot) IsIdentity(ref *ssb.FeedRef) bool {
+	s.identMu.Lock()
+	defer s.identMu.Unlock()
+	_, has := s.identities[ref.Ref()]
+	return has
+}
+
+// CreateIdentity makes a new key pair in the repo and starts to publish, read private messages and replicate for it.
+// If name is empty, the next free name like identity-1 is used.
+func (s *Sbot) CreateIdentity(name, algo string) (*ssb.KeyPair, error) {
+	if name != "" {
+		if name == "-" {
+			return nil, errors.Errorf("sbot: identity name - is the key pair of the bot")
+		}
+		if err := repo.CheckKeyPairName(name); err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to create identity")
+		}
+	}
+	r := repo.New(s.repoPath)
+
+	s.identMu.Lock()
+	if name == "" {
+		for i := 1; ; i++ {
+			name = fmt.Sprintf("identity-%d", i)
+			if _, err := os.Stat(r.GetPath("secrets", name)); os.IsNotExist(err) {
+				break
+			}
+		}
+	}
+	kp, err := s.newKeyPair(r, name, algo)
+	if err != nil {
+		s.identMu.Unlock()
+		return nil, errors.Wrap(err, "sbot: failed to create identity")
+	}
+	s.identities[kp.Id.Ref()] = &identity{name: name, kp: kp}
+	s.identMu.Unlock()
+
+	s.addIdentity(kp)
+	return kp, nil
+}
+
+// newKeyPair creates the secret name in the repo.
+// It is encrypted with the passphrase of the bot if the default secret is encrypted or the repo is encrypted at rest.
+func (s *Sbot) newKeyPair(r repo.Interface, name, algo string) (*ssb.KeyPair, error) {
+	encrypted := s.atRestKey != nil
+	if !encrypted {
+		var err error
+		encrypted, err = ssb.IsEncryptedKeyPair(r.GetPath("secret"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "failed to check default secret")
+		}
+	}
+	if !encrypted {
+		return repo.NewKeyPair(r, name, algo)
+	}
+
+	passphrase, err := s.getPassphrase()(r.GetPath("secrets", name))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get passphrase for new secret")
+	}
+	return repo.NewEncryptedKeyPair(r, name, algo, passphrase)
+}
+
+// addIdentity tells the private read index and the replicator about a new identity.
+// The private read index looks at the existing messages for it in the background, WaitUntilIndexesAreSynced waits for that.
+func (s *Sbot) addIdentity(kp *ssb.KeyPair) {
+	if s.privateReads != nil {
+		s.idxInSync.Add(1)
+		s.idxDone.Go(func() error {
+			defer s.idxInSync.Done()
+			err := s.privateReads.AddKeyPair(s.rootCtx, s.RootLog, kp)
+			if err != nil && s.rootCtx.Err() == nil {
+				level.Warn(s.info).Log("event", "private reads", "msg", "failed to index earlier messages of new identity", "id", kp.Id.Ref(), "err", err)
+			}
+			return nil
+		})
+	}
+	if gr, ok := s.Replicator.(*graphReplicator); ok {
+		gr.addIdentity(kitlog.With(s.info, "event", "update-replicate"), kp.Id)
+	}
+}
+
+// PublisherAs returns the publisher for the local identity ref
+func (s *Sbot) PublisherAs(ref *ssb.FeedRef) (ssb.Publisher, error) {
+	s.identMu.Lock()
+	defer s.identMu.Unlock()
+
+	id, has := s.identities[ref.Ref()]
+	if !has {
+		return nil, errors.Errorf("sbot: %s is not a local identity", ref.Ref())
+	}
+	return s.openPublisher(id)
+}
+
+// openPublisher needs to be called with identMu locked
+func (s *Sbot) openPublisher(id *identity) (ssb.Publisher, error) {
+	if id.publisher != nil {
+		return id.publisher, nil
+	}
+
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return nil, errors.Errorf("requried idx not present: userFeeds")
 	}
 
 	var pubopts = []message.PublishOption{
 		message.UseNowTimestamps(true),
 	}
-	if sbot.signHMACsecret != nil { // all feeds use the same settings right now
-		pubopts = append(pubopts, message.SetHMACKey(sbot.signHMACsecret))
+	if s.signHMACsecret != nil { // all feeds use the same settings right now
+		pubopts = append(pubopts, message.SetHMACKey(s.signHMACsecret))
 	}
 
-	pl, err := message.OpenPublishLog(sbot.RootLog, uf, kp, pubopts...)
+	pl, err := message.OpenPublishLog(s.RootLog, uf, id.kp, pubopts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "publishAs: failed to create publish log")
 	}
+	id.publisher = pl
+	return pl, nil
+}
 
+// PrivateReadAs returns the private messages for the local identity ref, unboxed.
+// It needs the privLogs multilog, see EnablePrivateReads.
+func (s *Sbot) PrivateReadAs(ref *ssb.FeedRef) (margaret.Log, error) {
+	s.identMu.Lock()
+	id, has := s.identities[ref.Ref()]
+	s.identMu.Unlock()
+	if !has {
+		return nil, errors.Errorf("sbot: %s is not a local identity", ref.Ref())
+	}
+
+	pl, ok := s.GetMultiLog("privLogs")
+	if !ok {
+		return nil, errors.Errorf("sbot: private reads are not enabled")
+	}
+	userPrivs, err := pl.Get(ref.StoredAddr())
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open private index of identity")
+	}
+	return private.NewUnboxerLog(s.RootLog, userPrivs, id.kp), nil
+}
+
+// PublishAs publishes val with the identity that is stored under nick in the repo.
+// Key pairs that were added to the repo while the bot is running are loaded, too.
+func (s *Sbot) PublishAs(nick string, val interface{}) (*ssb.MessageRef, error) {
+	s.identMu.Lock()
+	var id *identity
+	for _, candidate := range s.identities {
+		if candidate.name == nick {
+			id = candidate
+			break
+		}
+	}
+	var added *ssb.KeyPair
+	if id == nil {
+		kp, err := repo.LoadKeyPairWithPassphrase(repo.New(s.repoPath), nick, s.getPassphrase())
+		if err != nil {
+			s.identMu.Unlock()
+			return nil, err
+		}
+		if known, has := s.identities[kp.Id.Ref()]; has {
+			id = known
+		} else {
+			id = &identity{name: nick, kp: kp}
+			s.identities[kp.Id.Ref()] = id
+			added = kp
+		}
+	}
+	pl, err := s.openPublisher(id)
+	s.identMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if added != nil {
+		s.addIdentity(added)
+	}
 	return pl.Publish(val)
 }
+
+// MigrateFeed moves the local identity old to the local identity new.
+// It publishes the feed/migrate message on old and countersigns it on new.
+// Replication and the graph treat follows of old as follows of new once both messages are indexed.
+func (s *Sbot) MigrateFeed(old, new *ssb.FeedRef) (announced, countersigned *ssb.MessageRef, err error) {
+	if old.Equal(new) {
+		return nil, nil, errors.Errorf("sbot: can't migrate a feed to itself")
+	}
+	oldPub, err := s.PublisherAs(old)
+	if err != nil {
+		return nil, nil, err
+	}
+	newPub, err := s.PublisherAs(new)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mig := ssb.NewFeedMigration(old, new)
+	announced, err = oldPub.Publish(mig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "sbot: failed to publish migration on the old feed")
+	}
+	countersigned, err = newPub.Publish(mig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "sbot: failed to countersign migration on the new feed")
+	}
+	return announced, countersigned, nil
+}
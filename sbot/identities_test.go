@@ -160,3 +160,126 @@ func TestMultipleIdentities(t *testing.T) {
 	mainbot.Shutdown()
 	r.NoError(mainbot.Close())
 }
+
+func TestCreateIdentity(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	mainbot, err := New(
+		WithInfo(log.NewLogfmtLogger(os.Stderr)),
+		WithRepoPath(tRepoPath),
+		LateOption(MountSimpleIndex("get", indexes.OpenGet)),
+		EnablePrivateReads(),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+	r.Len(mainbot.Identities(), 1)
+
+	kp, err := mainbot.CreateIdentity("", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+	_, err = os.Stat(filepath.Join(tRepoPath, "secrets", "identity-1"))
+	r.NoError(err)
+
+	ids := mainbot.Identities()
+	r.Len(ids, 2)
+	r.True(ids[0].Id.Equal(mainbot.KeyPair.Id), "the bot comes first")
+	r.True(ids[1].Id.Equal(kp.Id))
+	r.True(mainbot.IsIdentity(kp.Id))
+
+	pub, err := mainbot.PublisherAs(kp.Id)
+	r.NoError(err)
+	ref, err := pub.Publish(map[string]interface{}{"type": "test"})
+	r.NoError(err)
+	msg, err := mainbot.Get(*ref)
+	r.NoError(err)
+	r.True(msg.Author().Equal(kp.Id))
+
+	// the private index unboxes for the new identity
+	ciph, err := private.Box([]byte(`{"type":"test","text":"hello new me"}`), kp.Id)
+	r.NoError(err)
+	_, err = mainbot.PublishLog.Publish(ciph)
+	r.NoError(err)
+	mainbot.WaitUntilIndexesAreSynced()
+
+	privs, err := mainbot.PrivateReadAs(kp.Id)
+	r.NoError(err)
+	v, err := privs.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(0, v.(margaret.Seq).Seq())
+
+	other, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	_, err = mainbot.PublisherAs(other.Id)
+	r.Error(err, "not a local identity")
+
+	for _, name := range []string{"-", ".", "..", "../escape", "sub/dir", `back\slash`} {
+		_, err = mainbot.CreateIdentity(name, ssb.RefAlgoFeedSSB1)
+		r.Error(err, "name %q", name)
+	}
+	_, err = os.Stat(filepath.Join(tRepoPath, "escape"))
+	r.True(os.IsNotExist(err))
+	_, err = mainbot.PublishAs("../secret", map[string]interface{}{"type": "test"})
+	r.Error(err)
+
+	// private messages from before an identity was added are indexed for it, too
+	later, err := repo.NewKeyPair(repo.New(tRepoPath), "later", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+	ciph, err = private.Box([]byte(`{"type":"test","text":"hello future me"}`), later.Id)
+	r.NoError(err)
+	_, err = mainbot.PublishLog.Publish(ciph)
+	r.NoError(err)
+	mainbot.WaitUntilIndexesAreSynced()
+
+	_, err = mainbot.PublishAs("later", map[string]interface{}{"type": "test"})
+	r.NoError(err)
+	mainbot.WaitUntilIndexesAreSynced()
+
+	privs, err = mainbot.PrivateReadAs(later.Id)
+	r.NoError(err)
+	v, err = privs.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(0, v.(margaret.Seq).Seq(), "one private message")
+	src, err := privs.Query()
+	r.NoError(err)
+	pm, err := src.Next(context.Background())
+	r.NoError(err)
+	r.Contains(string(pm.(ssb.Message).ContentBytes()), "hello future me")
+
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+}
+
+func TestCreateIdentityEncrypted(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	pass := func(string) ([]byte, error) { return []byte("correct horse"), nil }
+	_, err := repo.NewEncryptedKeyPair(repo.New(tRepoPath), "-", ssb.RefAlgoFeedSSB1, []byte("correct horse"))
+	r.NoError(err)
+
+	mainbot, err := New(
+		WithInfo(log.NewLogfmtLogger(os.Stderr)),
+		WithRepoPath(tRepoPath),
+		WithPassphrase(pass),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+
+	// the new secret is encrypted like the default one
+	kp, err := mainbot.CreateIdentity("enc", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+	encrypted, err := ssb.IsEncryptedKeyPair(filepath.Join(tRepoPath, "secrets", "enc"))
+	r.NoError(err)
+	r.True(encrypted)
+
+	loaded, err := repo.LoadKeyPairWithPassphrase(repo.New(tRepoPath), "enc", pass)
+	r.NoError(err)
+	r.True(loaded.Id.Equal(kp.Id))
+
+	mainbot.Shutdown()
+	r.NoError(mainbot.Close())
+}
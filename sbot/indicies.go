@@ -48,6 +48,13 @@ func MountPlugin(plug ssb.Plugin, mode plugins2.AuthMode) Option {
 			}
 		}
 
+		if wfm, ok := plug.(plugins2.NeedsFeedMigrations); ok && s.FeedMigrations != nil {
+			err := wfm.WantFeedMigrations(s.FeedMigrations)
+			if err != nil {
+				return errors.Wrap(err, "sbot/mount plug: failed to fulfill feed migrations requirement")
+			}
+		}
+
 		switch mode {
 		case plugins2.AuthPublic:
 			s.public.Register(plug)
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestRepoLock(t *testing.T) {
+	r := require.New(t)
+
+	bot, opts := makeTestBot(t)
+
+	_, err := New(opts...)
+	r.Error(err)
+	r.True(repo.IsLocked(err), "wrong error: %v", err)
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+
+	// closing released the lock
+	bot, err = New(opts...)
+	r.NoError(err)
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
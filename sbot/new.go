@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/blobstore"
@@ -22,20 +23,30 @@ import (
 	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/network"
+	"go.cryptoscope.co/ssb/plugins/admin"
 	"go.cryptoscope.co/ssb/plugins/blobs"
+	"go.cryptoscope.co/ssb/plugins/conn"
 	"go.cryptoscope.co/ssb/plugins/control"
+	"go.cryptoscope.co/ssb/plugins/feedgc"
+	"go.cryptoscope.co/ssb/plugins/feedstream"
 	"go.cryptoscope.co/ssb/plugins/friends"
 	"go.cryptoscope.co/ssb/plugins/get"
 	"go.cryptoscope.co/ssb/plugins/gossip"
+	"go.cryptoscope.co/ssb/plugins/identities"
 	"go.cryptoscope.co/ssb/plugins/legacyinvites"
+	"go.cryptoscope.co/ssb/plugins/peerinvites"
 	privplug "go.cryptoscope.co/ssb/plugins/private"
 	"go.cryptoscope.co/ssb/plugins/publish"
+	"go.cryptoscope.co/ssb/plugins/pubmode"
+	"go.cryptoscope.co/ssb/plugins/quota"
 	"go.cryptoscope.co/ssb/plugins/rawread"
 	"go.cryptoscope.co/ssb/plugins/replicate"
 	"go.cryptoscope.co/ssb/plugins/status"
+	"go.cryptoscope.co/ssb/plugins/tunnel"
 	"go.cryptoscope.co/ssb/plugins/whoami"
 	"go.cryptoscope.co/ssb/private"
 	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/repo/migrations"
 )
 
 func (s *Sbot) Close() error {
@@ -69,10 +80,27 @@ func (s *Sbot) Close() error {
 		return s.closeErr
 	}
 
+	// the databases are closed, so the index data of an encrypted repo can be sealed again
+	if err := repo.SealIndexes(s.repo()); err != nil {
+		s.closeErr = errors.Wrap(err, "sbot: failed to seal indexes")
+		return s.closeErr
+	}
+
+	// the lock goes last, after everything that writes to the repo is closed
+	if err := s.repoLock.Close(); err != nil {
+		s.closeErr = err
+		return s.closeErr
+	}
+
 	level.Info(closeEvt).Log("msg", "closers closed")
 	return nil
 }
 
+// repo returns the repo of the bot, with the key if it's encrypted at rest
+func (s *Sbot) repo() repo.Interface {
+	return repo.WithAtRestKey(repo.New(s.repoPath), s.atRestKey)
+}
+
 // is called by New() in options, sorry
 func initSbot(s *Sbot) (*Sbot, error) {
 	log := s.info
@@ -80,7 +108,26 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	s.rootCtx, s.Shutdown = ctxutils.WithError(s.rootCtx, ssb.ErrShuttingDown)
 	ctx := s.rootCtx
 
-	r := repo.New(s.repoPath)
+	r := s.repo()
+
+	// refuse repos that were migrated by a newer version
+	if err := migrations.Check(r); err != nil {
+		return nil, err
+	}
+
+	// the index data of an encrypted repo is only plain while the bot is running
+	if err := repo.UnsealIndexes(r); err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to unseal indexes")
+	}
+
+	// a compacted root log is put in place before anything opens it or the indexes
+	compacted, err := repo.FinishCompaction(ctx, r)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to finish compaction of the root log")
+	}
+	if compacted != nil {
+		level.Info(log).Log("event", "root log compacted", "copied", compacted.Copied, "dropped", compacted.Dropped, "nulled", compacted.Nulled, "msg", "rebuilding indexes")
+	}
 
 	// optionize?!
 	s.RootLog, err = repo.OpenLog(r)
@@ -103,6 +150,9 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "sbot: failed to open blob store")
 		}
+		if c, ok := s.BlobStore.(io.Closer); ok {
+			s.closers.addCloser(c)
+		}
 	}
 
 	wantsLog := kitlog.With(log, "module", "WantManager")
@@ -114,6 +164,16 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	s.WantManager = wm
 	s.closers.addCloser(wm)
 
+	// opened before the late options, plugins might need it
+	fm, serveFM, err := indexes.OpenFeedMigrations(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open feed migrations index")
+	}
+	s.closers.addCloser(serveFM)
+	s.serveIndex(indexes.FolderNameFeedMigrations, serveFM)
+	s.simpleIndex[indexes.FolderNameFeedMigrations] = fm
+	s.FeedMigrations = fm.(graph.FeedMigrations)
+
 	for _, opt := range s.lateInit {
 		err := opt(s)
 		if err != nil {
@@ -134,6 +194,14 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		}
 	}
 
+	// createFeedStream needs it
+	if _, ok := s.simpleIndex[indexes.FolderNameTimestamps]; !ok {
+		err = MountSimpleIndex(indexes.FolderNameTimestamps, indexes.OpenTimestamps)(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to open timestamps index")
+		}
+	}
+
 	if _, ok := s.simpleIndex["content-delete-requests"]; !ok {
 		var dcrTrigger dropContentTrigger
 		dcrTrigger.logger = kitlog.With(log, "module", "dcrTrigger")
@@ -157,6 +225,18 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		return nil, errors.Wrap(err, "sbot: failed to create publish log")
 	}
 
+	if err := s.loadIdentities(r); err != nil {
+		return nil, err
+	}
+
+	if _, ok := s.mlogIndicies["privLogs"]; !ok && s.enablePrivateReads {
+		s.privateReads = multilogs.NewPrivateRead(kitlog.With(log, "module", "privLogs"), s.Identities()...)
+		err = MountMultiLog("privLogs", s.privateReads.OpenRoaring)(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to open private reads index")
+		}
+	}
+
 	// LogBuilder doesn't fully work yet
 	if mt, ok := s.mlogIndicies["msgTypes"]; ok {
 		level.Warn(s.info).Log("event", "bot init", "msg", "using experimental bytype:contact graph implementation")
@@ -178,6 +258,44 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		s.GraphBuilder = gb
 	}
 
+	if ma, ok := s.GraphBuilder.(graph.MigrationAware); ok {
+		ma.SetFeedMigrations(s.FeedMigrations)
+	}
+
+	if s.storagePolicy != nil {
+		s.quota, err = quota.New(
+			kitlog.With(log, "plugin", "quota"),
+			r,
+			s.KeyPair.Id,
+			s,
+			s.RootLog,
+			s.RootLog,
+			s,
+			uf,
+			s.GraphBuilder,
+			*s.storagePolicy,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to open storage policies")
+		}
+		s.closers.addCloser(s.quota)
+		// in the index group so that Close waits for it before closing the database.
+		// the first sweep waits for the indexes, an incomplete graph would put feeds into the wrong hops.
+		s.idxDone.Go(func() error {
+			synced := make(chan struct{})
+			go func() {
+				s.WaitUntilIndexesAreSynced()
+				close(synced)
+			}()
+			select {
+			case <-synced:
+			case <-s.rootCtx.Done():
+				return nil
+			}
+			return s.quota.Serve(s.rootCtx)
+		})
+	}
+
 	if s.disableNetwork {
 		return s, nil
 	}
@@ -189,19 +307,43 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		}
 	}
 
-	// TODO: make plugabble
-	// var peerPlug *peerinvites.Plugin
-	// if mt, ok := s.mlogIndicies[multilogs.IndexNameFeeds]; ok {
-	// 	peerPlug = peerinvites.New(kitlog.With(log, "plugin", "peerInvites"), s, mt, s.RootLog, s.PublishLog)
-	// 	s.public.Register(peerPlug)
-	// 	_, peerServ, err := peerPlug.OpenIndex(r)
-	// 	if err != nil {
-	// 		return nil, errors.Wrap(err, "sbot: failed to open about idx")
-	// 	}
-	// 	s.serveIndex(ctx, "contacts", peerServ)
-	// }
+	if s.feedGCOpts != nil {
+		s.feedGC, err = feedgc.New(
+			kitlog.With(log, "plugin", "feedgc"),
+			r,
+			int(s.hopCount),
+			s,
+			s.Replicator.Lister(),
+			s.GraphBuilder,
+			uf,
+			s,
+			*s.feedGCOpts,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to open feed garbage collection")
+		}
+		s.closers.addCloser(s.feedGC)
+		// like the storage policies, the sweeps need the complete graph
+		s.idxDone.Go(func() error {
+			synced := make(chan struct{})
+			go func() {
+				s.WaitUntilIndexesAreSynced()
+				close(synced)
+			}()
+			select {
+			case <-synced:
+			case <-s.rootCtx.Done():
+				return nil
+			}
+			return s.feedGC.Serve(s.rootCtx)
+		})
+	}
 
-	var inviteService *legacyinvites.Service
+	var (
+		inviteService *legacyinvites.Service
+		tunnelPlug    *tunnel.Plugin
+		peerPlug      *peerinvites.Plugin
+	)
 
 	mkHandler := func(conn net.Conn) (muxrpc.Handler, error) {
 		// bypassing badger-close bug to go through with an accept (or not) before closing the bot
@@ -212,15 +354,18 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "sbot: expected an address containing an shs-bs addr")
 		}
-		if s.KeyPair.Id.Equal(remote) {
+		// all the local identities may use the master calls
+		if s.IsIdentity(remote) {
 			return s.master.MakeHandler(conn)
 		}
 
-		// if peerPlug != nil {
-		// 	if err := peerPlug.Authorize(remote); err == nil {
-		// 		return peerPlug.Handler(), nil
-		// 	}
-		// }
+		if peerPlug != nil {
+			if err := peerPlug.Authorize(remote); err == nil {
+				var h muxrpc.HandlerMux
+				h.Register(peerPlug.Method(), peerPlug.Handler())
+				return &h, nil
+			}
+		}
 
 		if inviteService != nil {
 			err := inviteService.Authorize(remote)
@@ -252,8 +397,9 @@ func initSbot(s *Sbot) (*Sbot, error) {
 
 		// shit - don't see a way to pass being a different feedtype with shs1
 		// we also need to pass this up the stack...!
-		remote.Algo = ssb.RefAlgoFeedGabby
-		err = auth.Authorize(remote)
+		ggRemote := *remote
+		ggRemote.Algo = ssb.RefAlgoFeedGabby
+		err = auth.Authorize(&ggRemote)
 		if err == nil {
 			level.Debug(log).Log("TODO", "found gg feed, using that. overhaul shs1 to support more payload in the handshake")
 			return s.public.MakeHandler(conn)
@@ -262,26 +408,43 @@ func initSbot(s *Sbot) (*Sbot, error) {
 			level.Warn(log).Log("event", "no stored feeds - attempting re-sync with trust-on-first-use")
 			return s.public.MakeHandler(conn)
 		}
+
+		if s.enableRoom && tunnelPlug != nil {
+			// strangers can only use the room, blocked peers not even that
+			blocks := s.Replicator.Lister().BlockList()
+			if blocks.Has(remote) || blocks.Has(&ggRemote) {
+				return nil, errors.Errorf("sbot: %s is blocked", remote.ShortRef())
+			}
+			var h muxrpc.HandlerMux
+			h.Register(tunnelPlug.Method(), tunnelPlug.Handler())
+			return &h, nil
+		}
 		return nil, err
 	}
 
-	s.master.Register(publish.NewPlug(kitlog.With(log, "plugin", "publish"), s.PublishLog, s.RootLog))
+	s.master.Register(publish.NewPlug(kitlog.With(log, "plugin", "publish"), s.PublishLog, s.RootLog, s))
 
 	if pl, ok := s.mlogIndicies["privLogs"]; ok {
 		userPrivs, err := pl.Get(s.KeyPair.Id.StoredAddr())
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to open user private index")
 		}
-		s.master.Register(privplug.NewPlug(kitlog.With(log, "plugin", "private"), s.PublishLog, private.NewUnboxerLog(s.RootLog, userPrivs, s.KeyPair)))
+		s.master.Register(privplug.NewPlug(kitlog.With(log, "plugin", "private"), s.PublishLog, private.NewUnboxerLog(s.RootLog, userPrivs, s.KeyPair), s))
 	}
 
+	s.master.Register(identities.New(kitlog.With(log, "plugin", "identities"), s))
+
 	// whoami
 	whoami := whoami.New(kitlog.With(log, "plugin", "whoami"), s.KeyPair.Id)
 	s.public.Register(whoami)
 	s.master.Register(whoami)
 
 	// blobs
-	blobs := blobs.New(kitlog.With(log, "plugin", "blobs"), *s.KeyPair.Id, s.BlobStore, wm)
+	var blobOpts []interface{}
+	if bl := s.bandwidth.BlobLimiter(); bl != nil {
+		blobOpts = append(blobOpts, bl)
+	}
+	blobs := blobs.New(kitlog.With(log, "plugin", "blobs"), *s.KeyPair.Id, s.BlobStore, wm, blobOpts...)
 	s.public.Register(blobs)
 	s.master.Register(blobs) // TODO: does not need to open a createWants on this one?!
 
@@ -320,27 +483,60 @@ func initSbot(s *Sbot) (*Sbot, error) {
 
 	s.master.Register(get.New(s))
 
+	if idx, ok := s.simpleIndex[indexes.FolderNameTimestamps]; ok {
+		ts, ok := idx.(*indexes.Timestamps)
+		if !ok {
+			return nil, errors.Errorf("sbot: unexpected timestamps index type %T", idx)
+		}
+		s.master.Register(feedstream.New(kitlog.With(log, "plugin", "feedstream"), s.KeyPair.Id, s.RootLog, ts, s.GraphBuilder))
+	}
+
 	// raw log plugins
 	s.master.Register(rawread.NewRXLog(s.RootLog)) // createLogStream
 	s.master.Register(hist)                        // createHistoryStream
 
-	s.master.Register(replicate.NewPlug(uf))
-
-	s.master.Register(friends.New(log, *s.KeyPair.Id, s.GraphBuilder))
+	var policies replicate.Policies
+	if s.quota != nil {
+		policies = s.quota
+	}
+	s.master.Register(replicate.NewPlug(uf, policies))
+
+	s.master.Register(friends.New(log, *s.KeyPair.Id, s.GraphBuilder,
+		friends.HopCount(s.hopCount),
+		s.Replicator,
+	))
+
+	if s.connPolicy != nil && s.networkConnTracker == nil {
+		policy := *s.connPolicy
+		if policy.Wanted == nil {
+			lister := s.Replicator.Lister()
+			policy.Wanted = func(ref *ssb.FeedRef) bool {
+				return lister.ReplicationList().Has(ref)
+			}
+		}
+		if policy.Logger == nil {
+			policy.Logger = kitlog.With(log, "module", "conntracker")
+		}
+		s.networkConnTracker = network.NewPolicyTracker(policy)
+	}
 
 	// tcp+shs
 	opts := network.Options{
 		Logger:              s.info,
 		Dialer:              s.dialer,
 		ListenAddr:          s.listenAddr,
+		WebsocketAddr:       s.wsAddr,
+		SOCKS5Addr:          s.socksAddr,
+		SOCKS5All:           s.socksAll,
+		OnionAddr:           s.onionAddr,
 		AdvertsSend:         s.enableAdverts,
 		AdvertsConnectTo:    s.enableDiscovery,
 		KeyPair:             s.KeyPair,
 		AppKey:              s.appKey[:],
 		MakeHandler:         mkHandler,
 		ConnTracker:         s.networkConnTracker,
-		BefreCryptoWrappers: s.preSecureWrappers,
-		AfterSecureWrappers: s.postSecureWrappers,
+		BefreCryptoWrappers: append([]netwrap.ConnWrapper{s.bandwidth.PreSecureWrapper()}, s.preSecureWrappers...),
+		AfterSecureWrappers: append([]netwrap.ConnWrapper{s.bandwidth.PostSecureWrapper()}, s.postSecureWrappers...),
 
 		EventCounter:    s.eventCounter,
 		SystemGauge:     s.systemGauge,
@@ -366,9 +562,66 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	}
 	s.master.Register(inviteService.MasterPlugin())
 
+	if s.pubMode != nil {
+		pub, err := pubmode.New(
+			kitlog.With(log, "plugin", "pubmode"),
+			r,
+			s.KeyPair.Id,
+			s.Network,
+			s.PublishLog,
+			s.GraphBuilder,
+			*s.pubMode,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to start pub mode")
+		}
+		s.closers.addCloser(pub)
+		// the follows of redeemed invites are the pub's to prune, too
+		inviteService.OnRedeem(pub.Invited)
+		// in the index group so that Close waits for it before closing the database
+		s.idxDone.Go(func() error {
+			return pub.Serve(s.rootCtx)
+		})
+	}
+
+	if s.enablePeerInvites {
+		if _, ok := s.simpleIndex["get"]; !ok {
+			err = MountSimpleIndex("get", indexes.OpenGet)(s)
+			if err != nil {
+				return nil, errors.Wrap(err, "sbot: failed to open get index for peer invites")
+			}
+		}
+
+		peerOpts := []interface{}{peerinvites.AppKey(s.appKey), s.Network}
+		if s.signHMACsecret != nil {
+			var k [32]byte
+			copy(k[:], s.signHMACsecret)
+			peerOpts = append(peerOpts, peerinvites.HMACSecret(&k))
+		}
+		peerPlug = peerinvites.New(kitlog.With(log, "plugin", "peerInvites"), s.KeyPair.Id, s, s.RootLog, s.PublishLog, peerOpts...)
+		peerIdx, peerServ, err := peerPlug.OpenIndex(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to open peer invites index")
+		}
+		s.serveIndex("peerInvites", peerServ)
+		s.closers.addCloser(peerIdx)
+		s.public.Register(peerPlug)
+		s.master.Register(peerPlug.MasterPlugin())
+	}
+
+	acceptor, _ := s.Network.(tunnel.Acceptor)
+	tunnelPlug = tunnel.New(kitlog.With(log, "plugin", "tunnel"), s.KeyPair.Id, acceptor, s.enableRoom)
+	s.public.Register(tunnelPlug)
+	s.master.Register(tunnelPlug)
+
 	// TODO: should be gossip.connect but conflicts with our namespace assumption
 	s.master.Register(control.NewPlug(kitlog.With(log, "plugin", "ctrl"), s.Network, s))
 	s.master.Register(status.New(s))
+	s.master.Register(admin.New(kitlog.With(log, "plugin", "admin"), s))
+
+	if ce, ok := s.Network.(ssb.ConnEventer); ok {
+		s.master.Register(conn.New(kitlog.With(log, "plugin", "conn"), ce))
+	}
 
 	return s, nil
 }
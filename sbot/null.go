@@ -72,12 +72,24 @@ func (s *Sbot) NullFeed(ref *ssb.FeedRef) error {
 		return err
 	}
 
+	if s.quota != nil {
+		err = s.quota.Forget(ref)
+		if err != nil {
+			err = errors.Wrapf(err, "NullFeed: error while deleting the storage quota of the feed")
+			return err
+		}
+	}
+
 	return nil
 }
 
 // Drop indicies deletes the following folders of the indexes.
 // TODO: check that sbot isn't running?
 func DropIndicies(r repo.Interface) error {
+	// the folders of an encrypted repo are sealed while no bot is running
+	if err := repo.UnsealIndexes(r); err != nil {
+		return err
+	}
 
 	// drop indicies
 	var mlogs = []string{
@@ -105,7 +117,7 @@ func DropIndicies(r repo.Interface) error {
 		}
 	}
 	log.Println("removed index folders")
-	return nil
+	return repo.SealIndexes(r)
 }
 
 func RebuildIndicies(path string) error {
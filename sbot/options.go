@@ -27,8 +27,13 @@ import (
 	"go.cryptoscope.co/ssb/graph"
 	"go.cryptoscope.co/ssb/internal/netwraputil"
 	"go.cryptoscope.co/ssb/message/multimsg"
+	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/network"
+	"go.cryptoscope.co/ssb/plugins/feedgc"
+	"go.cryptoscope.co/ssb/plugins/pubmode"
+	"go.cryptoscope.co/ssb/plugins/quota"
 	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/repo/atrest"
 )
 
 type MuxrpcEndpointWrapper func(muxrpc.Endpoint) muxrpc.Endpoint
@@ -59,10 +64,17 @@ type Sbot struct {
 	disableNetwork     bool
 	appKey             []byte
 	listenAddr         net.Addr
+	wsAddr             net.Addr
+	socksAddr          string
+	socksAll           bool
+	onionAddr          *network.OnionAddr
 	dialer             netwrap.Dialer
 	edpWrapper         MuxrpcEndpointWrapper
 	networkConnTracker ssb.ConnTracker
+	connPolicy         *network.ConnPolicy
 	preSecureWrappers  []netwrap.ConnWrapper
+	bandwidthLimits    network.BandwidthLimits
+	bandwidth          *network.Bandwidth
 	postSecureWrappers []netwrap.ConnWrapper
 
 	public ssb.PluginManager
@@ -72,12 +84,34 @@ type Sbot struct {
 
 	enableAdverts   bool
 	enableDiscovery bool
+	enableRoom      bool
 
-	repoPath string
-	KeyPair  *ssb.KeyPair
+	enablePeerInvites bool
+
+	pubMode *pubmode.Options
+
+	storagePolicy *quota.Options
+	quota         *quota.Service
+
+	feedGCOpts *feedgc.Options
+	feedGC     *feedgc.Service
+
+	repoPath   string
+	repoLock   *repo.Lockfile
+	atRestKey  *atrest.Key
+	passphrase ssb.PassphraseFunc
+	KeyPair    *ssb.KeyPair
 
 	RootLog multimsg.AlterableLog
 
+	identMu            sync.Mutex
+	identities         map[string]*identity
+	enablePrivateReads bool
+	privateReads       *multilogs.Private
+
+	compactMu  sync.Mutex
+	compacting bool
+
 	PublishLog     ssb.Publisher
 	signHMACsecret []byte
 
@@ -90,6 +124,9 @@ type Sbot struct {
 
 	GraphBuilder graph.Builder
 
+	// FeedMigrations resolves feeds that moved to a new key
+	FeedMigrations graph.FeedMigrations
+
 	BlobStore   ssb.BlobStore
 	WantManager ssb.WantManager
 
@@ -126,6 +163,24 @@ func WithRepoPath(path string) Option {
 	}
 }
 
+// WithAtRestKey sets the key for a repo that is encrypted at rest.
+// Without it, the key is derived as configured in the repo, using the passphrase of WithPassphrase if it needs one.
+func WithAtRestKey(k *atrest.Key) Option {
+	return func(s *Sbot) error {
+		s.atRestKey = k
+		return nil
+	}
+}
+
+// WithRepoLock hands a lock of the repo that the caller already holds to the bot, like one kept through the migrations.
+// The bot releases it when it is closed or fails to start.
+func WithRepoLock(lock *repo.Lockfile) Option {
+	return func(s *Sbot) error {
+		s.repoLock = lock
+		return nil
+	}
+}
+
 func DisableNetworkNode() Option {
 	return func(s *Sbot) error {
 		s.disableNetwork = true
@@ -141,6 +196,38 @@ func WithListenAddr(addr string) Option {
 	}
 }
 
+// WithWebsocketAddress also accepts secret-handshake connections over websockets on the passed address
+func WithWebsocketAddress(addr string) Option {
+	return func(s *Sbot) error {
+		var err error
+		s.wsAddr, err = net.ResolveTCPAddr("tcp", addr)
+		return errors.Wrap(err, "failed to parse websocket listen addr")
+	}
+}
+
+// WithSOCKS5 dials onion: addresses through the SOCKS5 proxy at addr (like tor on localhost:9050).
+// If all is true, every other outgoing connection goes through the proxy as well.
+func WithSOCKS5(addr string, all bool) Option {
+	return func(s *Sbot) error {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return errors.Wrap(err, "failed to parse socks5 proxy addr")
+		}
+		s.socksAddr = addr
+		s.socksAll = all
+		return nil
+	}
+}
+
+// WithOnionAddress announces the onion service (xyz.onion:port) as an address of the bot.
+// The service itself needs to be configured in tor and forward to the listen address.
+func WithOnionAddress(hostport string) Option {
+	return func(s *Sbot) error {
+		var err error
+		s.onionAddr, err = network.ParseOnionHostPort(hostport)
+		return err
+	}
+}
+
 func WithDialer(dial netwrap.Dialer) Option {
 	return func(s *Sbot) error {
 		s.dialer = dial
@@ -155,6 +242,16 @@ func WithNetworkConnTracker(ct ssb.ConnTracker) Option {
 	}
 }
 
+// WithConnPolicy uses a connection tracker that enforces the limits of the passed policy (see network.NewPolicyTracker).
+// If the policy has no Wanted function, the feeds of the replication list are preferred when the bot is full.
+// It is ignored if WithNetworkConnTracker is used as well.
+func WithConnPolicy(p network.ConnPolicy) Option {
+	return func(s *Sbot) error {
+		s.connPolicy = &p
+		return nil
+	}
+}
+
 func WithUNIXSocket() Option {
 	return func(s *Sbot) error {
 		// this races because sbot might not be done with init yet
@@ -247,7 +344,7 @@ func WithNamedKeyPair(name string) Option {
 	return func(s *Sbot) error {
 		r := repo.New(s.repoPath)
 		var err error
-		s.KeyPair, err = repo.LoadKeyPair(r, name)
+		s.KeyPair, err = repo.LoadKeyPairWithPassphrase(r, name, s.getPassphrase())
 		return errors.Wrapf(err, "loading named key-pair %q failed", name)
 	}
 }
@@ -255,11 +352,28 @@ func WithNamedKeyPair(name string) Option {
 func WithJSONKeyPair(blob string) Option {
 	return func(s *Sbot) error {
 		var err error
-		s.KeyPair, err = ssb.ParseKeyPair(strings.NewReader(blob))
+		s.KeyPair, err = ssb.ParseKeyPairWithPassphrase(strings.NewReader(blob), s.getPassphrase())
 		return errors.Wrap(err, "JSON KeyPair decode failed")
 	}
 }
 
+// WithPassphrase sets where the passphrases for encrypted secrets and repos come from, instead of ssb.SecretPassphrase.
+// It has to come before WithNamedKeyPair and WithJSONKeyPair.
+func WithPassphrase(passphrase ssb.PassphraseFunc) Option {
+	return func(s *Sbot) error {
+		s.passphrase = passphrase
+		return nil
+	}
+}
+
+// getPassphrase returns the passphrase func of the bot, ssb.SecretPassphrase if none was set
+func (s *Sbot) getPassphrase() ssb.PassphraseFunc {
+	if s.passphrase == nil {
+		return ssb.SecretPassphrase
+	}
+	return s.passphrase
+}
+
 func WithKeyPair(kp *ssb.KeyPair) Option {
 	return func(s *Sbot) error {
 		s.KeyPair = kp
@@ -281,6 +395,14 @@ func WithContext(ctx context.Context) Option {
 	}
 }
 
+// WithBandwidthLimits limits the bytes per second of all connections, of each peer and of sending blobs
+func WithBandwidthLimits(l network.BandwidthLimits) Option {
+	return func(s *Sbot) error {
+		s.bandwidthLimits = l
+		return nil
+	}
+}
+
 // TODO: remove all this network stuff and make them options on network
 func WithPreSecureConnWrapper(cw netwrap.ConnWrapper) Option {
 	return func(s *Sbot) error {
@@ -329,6 +451,63 @@ func EnableAdvertismentDialing(do bool) Option {
 	}
 }
 
+// EnablePeerInvites mounts the peerInvites plugin, which lets friends create invites that we (acting as a pub) confirm.
+// It also mounts the get index if it isn't already since the plugin needs to look up invite messages.
+func EnablePeerInvites() Option {
+	return func(s *Sbot) error {
+		s.enablePeerInvites = true
+		return nil
+	}
+}
+
+// EnablePrivateReads mounts the privLogs index, which unboxes private messages for all the identities of the bot, and the private plugin.
+// Identities that are created later are added to it.
+func EnablePrivateReads() Option {
+	return func(s *Sbot) error {
+		s.enablePrivateReads = true
+		return nil
+	}
+}
+
+// EnablePubMode makes the bot act as a pub. It announces its address in a pub message,
+// follows feeds back according to the policy and, if configured, unfollows feeds that stopped connecting.
+func EnablePubMode(opts pubmode.Options) Option {
+	return func(s *Sbot) error {
+		if _, err := pubmode.ParseFollowBack(string(opts.FollowBack)); err != nil {
+			return err
+		}
+		s.pubMode = &opts
+		return nil
+	}
+}
+
+// WithStoragePolicy limits how much of the replicated feeds is kept, per hop or per feed.
+// The oldest messages of a feed that goes over its limits are nulled. The feeds of the bot are never touched.
+func WithStoragePolicy(opts quota.Options) Option {
+	return func(s *Sbot) error {
+		s.storagePolicy = &opts
+		return nil
+	}
+}
+
+// WithFeedGC drops stored feeds that are out of hop range and not on the replication list for longer than the grace period.
+// It needs the network node, the replicator decides which feeds are wanted.
+func WithFeedGC(opts feedgc.Options) Option {
+	return func(s *Sbot) error {
+		s.feedGCOpts = &opts
+		return nil
+	}
+}
+
+// EnableRoom makes the bot act as a room, relaying tunnel connections between the peers that are connected to it.
+// Peers that are not in the hops range can still connect to the room but only get access to the tunnel calls.
+func EnableRoom(do bool) Option {
+	return func(s *Sbot) error {
+		s.enableRoom = do
+		return nil
+	}
+}
+
 func WithHMACSigning(key []byte) Option {
 	return func(s *Sbot) error {
 		if n := len(key); n != 32 {
@@ -432,6 +611,8 @@ func New(fopts ...Option) (*Sbot, error) {
 		s.listenAddr = &net.TCPAddr{Port: network.DefaultPort}
 	}
 
+	s.bandwidth = network.NewBandwidth(s.bandwidthLimits)
+
 	if s.info == nil {
 		logger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stdout))
 		logger = kitlog.With(logger, "ts", kitlog.DefaultTimestampUTC, "caller", kitlog.DefaultCaller)
@@ -444,13 +625,36 @@ func New(fopts ...Option) (*Sbot, error) {
 
 	r := repo.New(s.repoPath)
 
+	// two processes writing to the same log corrupt it
+	var err error
+	if s.repoLock == nil {
+		s.repoLock, err = repo.Lock(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to lock repo")
+		}
+	}
+	lock := s.repoLock
+
 	if s.KeyPair == nil {
-		var err error
-		s.KeyPair, err = repo.DefaultKeyPair(r)
+		s.KeyPair, err = repo.DefaultKeyPairWithPassphrase(r, s.getPassphrase())
 		if err != nil {
+			lock.Close()
 			return nil, errors.Wrap(err, "sbot: failed to get keypair")
 		}
 	}
 
-	return initSbot(&s)
+	if s.atRestKey == nil {
+		s.atRestKey, err = repo.UnlockAtRest(r, s.KeyPair, s.getPassphrase())
+		if err != nil {
+			lock.Close()
+			return nil, errors.Wrap(err, "sbot: failed to unlock repo")
+		}
+	}
+
+	bot, err := initSbot(&s)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return bot, nil
 }
@@ -0,0 +1,241 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+	"golang.org/x/sync/errgroup"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/plugins/quota"
+)
+
+// TestQuotaFetchAfterEviction checks that a feed that was cut down to the newest message can still be fetched
+func TestQuotaFetchAfterEviction(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	botgroup, ctx := errgroup.WithContext(ctx)
+	logger := testutils.NewRelativeTimeLogger(nil)
+	bs := newBotServer(ctx, logger)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	ali, err := New(
+		WithInfo(log.With(logger, "bot", "ali")),
+		WithRepoPath(filepath.Join(tRepoPath, "ali")),
+		WithListenAddr(":0"),
+	)
+	r.NoError(err)
+	botgroup.Go(bs.Serve(ali))
+
+	bob, err := New(
+		WithInfo(log.With(logger, "bot", "bob")),
+		WithRepoPath(filepath.Join(tRepoPath, "bob")),
+		WithListenAddr(":0"),
+		WithStoragePolicy(quota.Options{
+			Hops:     map[int]quota.Limits{0: {MaxAge: time.Hour}},
+			Interval: time.Hour,
+		}),
+	)
+	r.NoError(err)
+	botgroup.Go(bs.Serve(bob))
+
+	_, err = bob.PublishLog.Publish(ssb.NewContactFollow(ali.KeyPair.Id))
+	r.NoError(err)
+	ali.Replicate(bob.KeyPair.Id)
+	bob.Replicate(ali.KeyPair.Id)
+
+	uf, ok := bob.GetMultiLog("userFeeds")
+	r.True(ok)
+	alisLog, err := uf.Get(ali.KeyPair.Id.StoredAddr())
+	r.NoError(err)
+
+	fetch := func(want int64) {
+		for i := 0; i < 50; i++ {
+			err = bob.Network.Connect(ctx, ali.Network.GetListenAddr())
+			r.NoError(err)
+			time.Sleep(100 * time.Millisecond)
+			bob.Network.GetConnTracker().CloseAll()
+
+			seqv, err := alisLog.Seq().Value()
+			r.NoError(err)
+			if seq, ok := seqv.(margaret.Seq); ok && seq.Seq() == want {
+				return
+			}
+		}
+		t.Fatalf("feed not fetched up to %d", want)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err = ali.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	fetch(4)
+	bob.WaitUntilIndexesAreSynced()
+
+	// everything is too old now
+	state, err := bob.quota.Check(ali.KeyPair.Id, time.Now().Add(2*time.Hour))
+	r.NoError(err)
+	r.NotNil(state)
+	r.EqualValues(4, state.Evicted)
+	r.EqualValues(1, state.Messages)
+
+	for i := 5; i < 10; i++ {
+		_, err = ali.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	fetch(9)
+
+	rootSeq, err := alisLog.Get(margaret.BaseSeq(9))
+	r.NoError(err)
+	msg, err := bob.RootLog.Get(rootSeq.(margaret.Seq))
+	r.NoError(err)
+	r.EqualValues(10, msg.(ssb.Message).Seq())
+
+	cancel()
+	ali.Shutdown()
+	bob.Shutdown()
+	r.NoError(ali.Close())
+	r.NoError(bob.Close())
+	r.NoError(botgroup.Wait())
+}
+
+// TestQuotaCompactFetch checks that a feed that was cut down and then dropped from the compacted root log can still be fetched
+func TestQuotaCompactFetch(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	botgroup, ctx := errgroup.WithContext(ctx)
+	logger := testutils.NewRelativeTimeLogger(nil)
+	bs := newBotServer(ctx, logger)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	ali, err := New(
+		WithInfo(log.With(logger, "bot", "ali")),
+		WithRepoPath(filepath.Join(tRepoPath, "ali")),
+		WithListenAddr(":0"),
+	)
+	r.NoError(err)
+	botgroup.Go(bs.Serve(ali))
+
+	bobOpts := []Option{
+		WithInfo(log.With(logger, "bot", "bob")),
+		WithRepoPath(filepath.Join(tRepoPath, "bob")),
+		WithListenAddr(":0"),
+		WithStoragePolicy(quota.Options{
+			Hops:     map[int]quota.Limits{0: {MaxAge: time.Hour}},
+			Interval: time.Hour,
+		}),
+	}
+	bob, err := New(bobOpts...)
+	r.NoError(err)
+	botgroup.Go(bs.Serve(bob))
+
+	_, err = bob.PublishLog.Publish(ssb.NewContactFollow(ali.KeyPair.Id))
+	r.NoError(err)
+	ali.Replicate(bob.KeyPair.Id)
+	bob.Replicate(ali.KeyPair.Id)
+
+	alisLog := func() margaret.Log {
+		uf, ok := bob.GetMultiLog(multilogs.IndexNameFeeds)
+		r.True(ok)
+		l, err := uf.Get(ali.KeyPair.Id.StoredAddr())
+		r.NoError(err)
+		return l
+	}
+
+	fetch := func(want int64) {
+		for i := 0; i < 50; i++ {
+			err = bob.Network.Connect(ctx, ali.Network.GetListenAddr())
+			r.NoError(err)
+			time.Sleep(100 * time.Millisecond)
+			bob.Network.GetConnTracker().CloseAll()
+
+			seqv, err := alisLog().Seq().Value()
+			r.NoError(err)
+			if seq, ok := seqv.(margaret.Seq); ok && seq.Seq() == want {
+				return
+			}
+		}
+		t.Fatalf("feed not fetched up to %d", want)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err = ali.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	fetch(4)
+	bob.WaitUntilIndexesAreSynced()
+
+	state, err := bob.quota.Check(ali.KeyPair.Id, time.Now().Add(2*time.Hour))
+	r.NoError(err)
+	r.EqualValues(4, state.Evicted)
+
+	stats, err := bob.Compact(context.TODO(), nil)
+	r.NoError(err)
+	r.EqualValues(4, stats.Dropped)
+
+	bob.Shutdown()
+	r.NoError(bob.Close())
+
+	bob, err = New(bobOpts...)
+	r.NoError(err)
+	botgroup.Go(bs.Serve(bob))
+	bob.WaitUntilIndexesAreSynced()
+
+	// only the newest message is left and the sublog starts with it
+	seqv, err := alisLog().Seq().Value()
+	r.NoError(err)
+	r.EqualValues(0, seqv.(margaret.Seq).Seq())
+	offset, err := multilogs.FeedOffset(bob.RootLog, alisLog())
+	r.NoError(err)
+	r.EqualValues(4, offset)
+	r.NoError(bob.FSCK())
+	r.NoError(bob.FSCK(FSCKWithMode(FSCKModeSequences)))
+
+	for i := 5; i < 10; i++ {
+		_, err = ali.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	fetch(5)
+	bob.WaitUntilIndexesAreSynced()
+
+	rootSeq, err := alisLog().Get(margaret.BaseSeq(5))
+	r.NoError(err)
+	msg, err := bob.RootLog.Get(rootSeq.(margaret.Seq))
+	r.NoError(err)
+	r.EqualValues(10, msg.(ssb.Message).Seq())
+
+	// the check continues from what was evicted before the compaction
+	state, err = bob.quota.Check(ali.KeyPair.Id, time.Now())
+	r.NoError(err)
+	r.EqualValues(10, state.Latest)
+	r.EqualValues(4, state.Evicted)
+	r.EqualValues(6, state.Messages)
+
+	// dropping the feed forgets what was evicted
+	r.NoError(bob.NullFeed(ali.KeyPair.Id))
+	_, err = bob.quota.State(ali.KeyPair.Id)
+	r.Equal(quota.ErrNoState, err)
+
+	cancel()
+	ali.Shutdown()
+	bob.Shutdown()
+	r.NoError(ali.Close())
+	r.NoError(bob.Close())
+	r.NoError(botgroup.Wait())
+}
@@ -18,38 +18,204 @@ import (
 var _ ssb.Replicator = (*Sbot)(nil)
 
 type graphReplicator struct {
-	builder graph.Builder
-	current *lister
+	builder  graph.Builder
+	current  *lister
+	self     *ssb.FeedRef
+	hopCount int
+
+	// the feeds in the hop range of each local identity, by their ref
+	rangesMu sync.Mutex
+	ranges   map[string]*ssb.StrFeedSet
+
+	// set if the graph can tell about changes, the hop ranges are watched until unwatch is called
+	notifier graph.Notifier
+	unwatch  []func()
+
+	manualWants, manualBlocked *ssb.StrFeedSet
 }
 
 func (s *Sbot) newGraphReplicator() (*graphReplicator, error) {
 	var r graphReplicator
 	r.builder = s.GraphBuilder
 	r.current = newLister()
+	r.self = s.KeyPair.Id
+	r.hopCount = int(s.hopCount)
+	r.ranges = make(map[string]*ssb.StrFeedSet)
+	r.manualWants = ssb.NewFeedSet(0)
+	r.manualBlocked = ssb.NewFeedSet(0)
+	for _, kp := range s.Identities() {
+		r.ranges[kp.Id.Ref()] = ssb.NewFeedSet(0)
+	}
 
 	replicateEvt := log.With(s.info, "event", "update-replicate")
-	update := r.makeUpdater(replicateEvt, s.KeyPair.Id, int(s.hopCount))
+	update := r.makeUpdater(replicateEvt)
 
-	// update for new messages but only every 15seconds
-	go debounce(s.rootCtx, 15*time.Second, s.RootLog.Seq(), update)
+	if n, ok := s.GraphBuilder.(graph.Notifier); ok {
+		// start listening before the first walk so that we don't miss anything
+		r.notifier = n
+		r.listen(s.rootCtx, n)
+		for ref := range r.ranges {
+			self, err := ssb.ParseFeedRef(ref)
+			if err != nil {
+				return nil, err
+			}
+			r.watch(self)
+		}
+		update()
+	} else {
+		// update for new messages but only every 15seconds
+		go debounce(s.rootCtx, 15*time.Second, s.RootLog.Seq(), update)
+	}
 
 	return &r, nil
 }
 
-// makeUpdater returns a func that does the hop-walk and block checks, used together with debounce
-func (r *graphReplicator) makeUpdater(log log.Logger, self *ssb.FeedRef, hopCount int) func() {
-	return func() {
-		start := time.Now()
-		newWants := r.builder.Hops(self, hopCount)
-		level.Debug(log).Log("feed-want-count", newWants.Count(), "hops", hopCount, "took", time.Since(start))
+// addIdentity replicates the hop range of a new local identity, too
+func (r *graphReplicator) addIdentity(log log.Logger, id *ssb.FeedRef) {
+	r.rangesMu.Lock()
+	if _, has := r.ranges[id.Ref()]; has {
+		r.rangesMu.Unlock()
+		return
+	}
+	r.ranges[id.Ref()] = ssb.NewFeedSet(0)
+	r.rangesMu.Unlock()
+	r.watch(id)
+	r.walkHops(log, id)
+}
 
-		refs, err := newWants.List()
-		if err != nil {
-			level.Error(log).Log("msg", "want list failed", "err", err, "wants", newWants.Count())
+// watch keeps the hop range of self updated in the graph, if it can tell about changes
+func (r *graphReplicator) watch(self *ssb.FeedRef) {
+	if r.notifier == nil {
+		return
+	}
+	release := r.notifier.WatchHops(self, r.hopCount)
+	r.rangesMu.Lock()
+	r.unwatch = append(r.unwatch, release)
+	r.rangesMu.Unlock()
+}
+
+// addInRange adds feed to the hop range of the local identity self
+func (r *graphReplicator) addInRange(self, feed *ssb.FeedRef) {
+	r.rangesMu.Lock()
+	defer r.rangesMu.Unlock()
+	rng, ok := r.ranges[self.Ref()]
+	if !ok {
+		return
+	}
+	rng.AddRef(feed)
+	if !r.current.blocked.Has(feed) {
+		r.current.feedWants.AddRef(feed)
+	}
+}
+
+// removeFromRange removes feed from the hop range of self and stops replicating it, unless it is wanted otherwise
+func (r *graphReplicator) removeFromRange(self, feed *ssb.FeedRef) {
+	r.rangesMu.Lock()
+	defer r.rangesMu.Unlock()
+	rng, ok := r.ranges[self.Ref()]
+	if !ok {
+		return
+	}
+	rng.Delete(feed)
+	if r.manualWants.Has(feed) {
+		return
+	}
+	for _, other := range r.ranges {
+		if other.Has(feed) {
 			return
 		}
-		for _, ref := range refs {
-			r.current.feedWants.AddRef(ref)
+	}
+	r.current.feedWants.Delete(feed)
+}
+
+// rewant replicates an unblocked feed again if it is wanted by hand or still in the hop range of a local identity.
+// A feed that is in range through friends doesn't get a HopChange when it is unblocked, so the hops are looked at again.
+func (r *graphReplicator) rewant(feed *ssb.FeedRef) {
+	r.rangesMu.Lock()
+	defer r.rangesMu.Unlock()
+	wanted := r.manualWants.Has(feed)
+	for ref, rng := range r.ranges {
+		self, err := ssb.ParseFeedRef(ref)
+		if err != nil {
+			continue
+		}
+		if hops := r.builder.Hops(self, r.hopCount); rng.Has(feed) || (hops != nil && hops.Has(feed)) {
+			rng.AddRef(feed)
+			wanted = true
+		}
+	}
+	if wanted {
+		r.current.feedWants.AddRef(feed)
+	}
+}
+
+// listen applies the changes to the graph as they are indexed, instead of walking it again.
+// Blocks only count if they come from the identity of the bot, which is the one that peers connect to.
+func (r *graphReplicator) listen(ctx context.Context, n graph.Notifier) {
+	doneHops := n.HopChanges().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		hc, ok := v.(graph.HopChange)
+		if !ok || hc.Max != r.hopCount {
+			return nil
+		}
+		if !hc.Added {
+			r.removeFromRange(hc.From, hc.Feed)
+		} else {
+			r.addInRange(hc.From, hc.Feed)
+		}
+		return nil
+	}))
+
+	doneEdges := n.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		e, ok := v.(graph.Edge)
+		if !ok || !e.From.Equal(r.self) {
+			return nil
+		}
+		if e.Blocking {
+			r.current.blocked.AddRef(e.To)
+			r.current.feedWants.Delete(e.To)
+		} else if !r.manualBlocked.Has(e.To) {
+			r.current.blocked.Delete(e.To)
+			r.rewant(e.To)
+		}
+		return nil
+	}))
+
+	go func() {
+		<-ctx.Done()
+		doneHops()
+		doneEdges()
+
+		r.rangesMu.Lock()
+		for _, release := range r.unwatch {
+			release()
+		}
+		r.unwatch = nil
+		r.rangesMu.Unlock()
+	}()
+}
+
+// makeUpdater returns a func that does the hop-walks and block checks, used together with debounce if the graph can't tell about changes
+func (r *graphReplicator) makeUpdater(log log.Logger) func() {
+	return func() {
+		r.rangesMu.Lock()
+		selfs := make([]*ssb.FeedRef, 0, len(r.ranges))
+		for ref := range r.ranges {
+			self, err := ssb.ParseFeedRef(ref)
+			if err != nil {
+				continue
+			}
+			selfs = append(selfs, self)
+		}
+		r.rangesMu.Unlock()
+
+		for _, self := range selfs {
+			r.walkHops(log, self)
 		}
 
 		// make sure we dont fetch and allow blocked feeds
@@ -59,7 +225,7 @@ func (r *graphReplicator) makeUpdater(log log.Logger, self *ssb.FeedRef, hopCoun
 			return
 		}
 
-		newBlocked := g.BlockedList(self)
+		newBlocked := g.BlockedList(r.self)
 		lst, err := newBlocked.List()
 		if err == nil {
 			for _, bf := range lst {
@@ -70,6 +236,22 @@ func (r *graphReplicator) makeUpdater(log log.Logger, self *ssb.FeedRef, hopCoun
 	}
 }
 
+// walkHops adds the feeds in the hop range of self
+func (r *graphReplicator) walkHops(log log.Logger, self *ssb.FeedRef) {
+	start := time.Now()
+	newWants := r.builder.Hops(self, r.hopCount)
+	level.Debug(log).Log("feed-want-count", newWants.Count(), "hops", r.hopCount, "self", self.ShortRef(), "took", time.Since(start))
+
+	refs, err := newWants.List()
+	if err != nil {
+		level.Error(log).Log("msg", "want list failed", "err", err, "wants", newWants.Count())
+		return
+	}
+	for _, ref := range refs {
+		r.addInRange(self, ref)
+	}
+}
+
 func debounce(ctx context.Context, interval time.Duration, obs luigi.Observable, work func()) {
 	var seqMu sync.Mutex
 	var seq = margaret.SeqEmpty
@@ -108,14 +290,32 @@ func debounce(ctx context.Context, interval time.Duration, obs luigi.Observable,
 	}
 }
 
-func (r *graphReplicator) Block(ref *ssb.FeedRef)   { r.current.blocked.AddRef(ref) }
-func (r *graphReplicator) Unblock(ref *ssb.FeedRef) { r.current.blocked.Delete(ref) }
+func (r *graphReplicator) Block(ref *ssb.FeedRef) {
+	r.current.blocked.AddRef(ref)
+	r.manualBlocked.AddRef(ref)
+}
+
+func (r *graphReplicator) Unblock(ref *ssb.FeedRef) {
+	r.current.blocked.Delete(ref)
+	r.manualBlocked.Delete(ref)
+}
+
+func (r *graphReplicator) Replicate(ref *ssb.FeedRef) {
+	r.current.feedWants.AddRef(ref)
+	r.manualWants.AddRef(ref)
+}
 
-func (r *graphReplicator) Replicate(ref *ssb.FeedRef)     { r.current.feedWants.AddRef(ref) }
-func (r *graphReplicator) DontReplicate(ref *ssb.FeedRef) { r.current.feedWants.Delete(ref) }
+func (r *graphReplicator) DontReplicate(ref *ssb.FeedRef) {
+	r.current.feedWants.Delete(ref)
+	r.manualWants.Delete(ref)
+}
 
 func (r *graphReplicator) Lister() ssb.ReplicationLister { return r.current }
 
+// ManualReplicationList and ManualBlockList return the feeds that were added through Replicate and Block, and not by the graph
+func (r *graphReplicator) ManualReplicationList() *ssb.StrFeedSet { return r.manualWants }
+func (r *graphReplicator) ManualBlockList() *ssb.StrFeedSet       { return r.manualBlocked }
+
 type lister struct {
 	feedWants *ssb.StrFeedSet
 	blocked   *ssb.StrFeedSet
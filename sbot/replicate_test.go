@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+func TestReplicateUnblockInRange(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	bot, err := New(
+		WithInfo(log.NewNopLogger()),
+		WithRepoPath(tRepoPath),
+		WithHops(1),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+
+	rep, err := bot.newGraphReplicator()
+	r.NoError(err)
+	wants := rep.Lister().ReplicationList()
+
+	uf, ok := bot.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+	newPublisher := func() (*ssb.FeedRef, ssb.Publisher) {
+		kp, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		pub, err := message.OpenPublishLog(bot.RootLog, uf, kp)
+		r.NoError(err)
+		return kp.Id, pub
+	}
+	alice, alicePub := newPublisher()
+	carol, _ := newPublisher()
+
+	// carol is in range because alice is a friend of the bot
+	_, err = bot.PublishLog.Publish(ssb.NewContactFollow(alice))
+	r.NoError(err)
+	_, err = alicePub.Publish(ssb.NewContactFollow(bot.KeyPair.Id))
+	r.NoError(err)
+	_, err = alicePub.Publish(ssb.NewContactFollow(carol))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+	r.True(wants.Has(carol))
+
+	_, err = bot.PublishLog.Publish(ssb.NewContactBlock(carol))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+	r.False(wants.Has(carol))
+	r.True(rep.Lister().BlockList().Has(carol))
+
+	_, err = bot.PublishLog.Publish(ssb.NewContactUnfollow(carol))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+	r.False(rep.Lister().BlockList().Has(carol))
+	r.True(wants.Has(carol), "unblocked feed in range is not replicated again")
+}
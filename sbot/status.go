@@ -16,6 +16,11 @@ import (
 	multiserver "go.mindeco.de/ssb-multiserver"
 )
 
+// BandwidthStats returns the byte counters of the network
+func (sbot *Sbot) BandwidthStats() ssb.BandwidthStats {
+	return sbot.bandwidth.Stats()
+}
+
 func (sbot *Sbot) Status() (ssb.Status, error) {
 	v, err := sbot.RootLog.Seq().Value()
 	if err != nil {
@@ -44,6 +49,17 @@ func (sbot *Sbot) Status() (ssb.Status, error) {
 		})
 	}
 
+	if cd, ok := sbot.Network.GetConnTracker().(ssb.ConnDecider); ok {
+		s.ConnDecisions = cd.Decisions()
+	}
+
+	bw := sbot.BandwidthStats()
+	s.Bandwidth = &bw
+
+	if ma, ok := sbot.Network.(ssb.MultiserverAddresser); ok {
+		s.Addresses = ma.MultiserverAddresses()
+	}
+
 	var idxState ssb.IndexStates
 	sbot.indexStateMu.Lock()
 
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/network"
+)
+
+func TestTunnelRelay(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	botgroup, ctx := errgroup.WithContext(ctx)
+	logger := testutils.NewRelativeTimeLogger(nil)
+	bs := newBotServer(ctx, logger)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	newBot := func(name string, opts ...Option) *Sbot {
+		bot, err := New(append([]Option{
+			WithInfo(log.With(logger, "bot", name)),
+			WithRepoPath(filepath.Join(tRepoPath, name)),
+			WithListenAddr(":0"),
+		}, opts...)...)
+		r.NoError(err)
+		botgroup.Go(bs.Serve(bot))
+		return bot
+	}
+	room := newBot("room", EnableRoom(true))
+	ali := newBot("ali")
+	bob := newBot("bob")
+	carl := newBot("carl")
+
+	// ali and bob know each other but not the room
+	ali.Replicate(bob.KeyPair.Id)
+	bob.Replicate(ali.KeyPair.Id)
+	ali.Replicate(carl.KeyPair.Id)
+
+	// the room doesn't want carl around
+	_, err := room.PublishLog.Publish(ssb.NewContactBlock(carl.KeyPair.Id))
+	r.NoError(err)
+	room.WaitUntilIndexesAreSynced()
+
+	r.NoError(ali.Network.Connect(ctx, room.Network.GetListenAddr()))
+	r.NoError(bob.Network.Connect(ctx, room.Network.GetListenAddr()))
+	// refused by the room
+	carl.Network.Connect(ctx, room.Network.GetListenAddr())
+
+	tunnelTo := func(target *ssb.FeedRef) error {
+		addr, err := network.ParseTunnelAddress("tunnel:" + room.KeyPair.Id.Ref() + ":" + target.Ref())
+		r.NoError(err)
+		return ali.Network.Connect(ctx, addr)
+	}
+
+	// bob might not be an attendant right away
+	var connected bool
+	for i := 0; i < 50 && !connected; i++ {
+		if err := tunnelTo(bob.KeyPair.Id); err == nil {
+			_, connected = ali.Network.GetEndpointFor(bob.KeyPair.Id)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	r.True(connected, "ali didn't reach bob through the room")
+
+	_, has := bob.Network.GetEndpointFor(ali.KeyPair.Id)
+	r.True(has, "bob has no endpoint for ali")
+
+	r.Error(tunnelTo(room.KeyPair.Id), "the room can't be reached through itself")
+	r.Error(tunnelTo(carl.KeyPair.Id), "blocked peers can't use the room")
+
+	cancel()
+	for _, bot := range []*Sbot{room, ali, bob, carl} {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}
+	r.NoError(botgroup.Wait())
+}
@@ -4,6 +4,7 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -14,21 +15,23 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/netwrap"
-	"go.cryptoscope.co/ssb/indexes"
 	"go.cryptoscope.co/ssb/message/legacy"
 	"go.cryptoscope.co/ssb/plugins2"
 	"go.cryptoscope.co/ssb/plugins2/bytype"
 	"golang.org/x/crypto/nacl/auth"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/client"
+	"go.cryptoscope.co/ssb/plugins/peerinvites"
 	"go.cryptoscope.co/ssb/sbot"
 )
 
 // first js creates an invite
 // go will play introducer node
 // second js peer will try to use/redeem the invite
-func XTestPeerInviteJSCreate(t *testing.T) {
+func TestPeerInviteJSCreate(t *testing.T) {
 
 	r := require.New(t)
 
@@ -39,7 +42,7 @@ func XTestPeerInviteJSCreate(t *testing.T) {
 
 	ts.startGoBot(
 		sbot.LateOption(sbot.MountPlugin(&bytype.Plugin{}, plugins2.AuthMaster)),
-		sbot.LateOption(sbot.MountSimpleIndex("get", indexes.OpenGet)),
+		sbot.EnablePeerInvites(),
 	)
 	bob := ts.gobot
 
@@ -151,7 +154,7 @@ func XTestPeerInviteJSCreate(t *testing.T) {
 	// 2nd node does it's dance
 	before := fmt.Sprintf(`
 	var fs = require('fs')
-	fs.readFile('invite.txt', 'utf8', (err, invite) => {
+	fs.readFile('peer_invite.txt', 'utf8', (err, invite) => {
 		t.error(err)
 		t.comment("opened invite:"+invite)
 		sbot.peerInvites.openInvite(invite, (err, inv_msg, content) => {
@@ -212,7 +215,7 @@ sbot.on('rpc:connect', (rpc) => {
 	// 2nd node does it's dance
 	reuseBefore := fmt.Sprintf(`
 		var fs = require('fs')
-		fs.readFile('invite.txt', 'utf8', (err, invite) => {
+		fs.readFile('peer_invite.txt', 'utf8', (err, invite) => {
 			t.error(err)
 			t.comment('cant use again')
 			sbot.peerInvites.openInvite(invite, (err, inv_msg, content) => {
@@ -228,3 +231,94 @@ sbot.on('rpc:connect', (rpc) => {
 
 	ts.wait()
 }
+
+// go creates the invite and acts as the pub, js redeems it
+func TestPeerInviteGoCreate(t *testing.T) {
+	r := require.New(t)
+
+	ts := newSession(t, nil, nil)
+
+	ts.startGoBot(sbot.EnablePeerInvites())
+	bob := ts.gobot
+
+	for i := 3; i > 0; i-- {
+		_, err := bob.PublishLog.Publish(ssb.Post{
+			Type: "test-post",
+			Text: fmt.Sprintf("hello, world! %d", i),
+		})
+		r.NoError(err)
+	}
+
+	master, err := client.NewTCP(bob.KeyPair, bob.Network.GetListenAddr())
+	r.NoError(err)
+
+	reply, err := master.Async(context.TODO(), "str", muxrpc.Method{"peerInvites", "create"}, map[string]interface{}{})
+	r.NoError(err)
+	master.Close()
+
+	invite, ok := reply.(string)
+	r.True(ok, "not a string: %T", reply)
+	r.True(strings.HasPrefix(invite, "inv:"))
+	t.Log(invite)
+
+	tok, err := peerinvites.ParseToken(invite)
+	r.NoError(err)
+	guest, err := tok.GuestKeyPair()
+	r.NoError(err)
+
+	// the guest key is only valid once bob indexed his own invite
+	bob.WaitUntilIndexesAreSynced()
+
+	acceptInvite := fmt.Sprintf(`
+	let inv = %q
+	sbot.peerInvites.openInvite(inv, (err, invMsg, content) => {
+		t.error(err, 'opened invite')
+		t.comment('content:'+JSON.stringify(content))
+
+		sbot.peerInvites.acceptInvite(inv, (err) => {
+			t.error(err, 'accepted invite')
+
+			sbot.publish({
+				type: 'contact',
+				following: true,
+				contact: testBob
+			}, (err) => {
+				t.error(err, 'followed bob')
+				run()
+			})
+		})
+	})
+	`, invite)
+
+	after := `sbot.on('rpc:connect', (rpc) => {
+	rpc.on('closed', () => {
+		pull(
+			sbot.createUserStream({id: testBob}),
+			pull.collect(function(err, msgs) {
+				t.error(err, 'query worked')
+				t.true(msgs.length >= 4, 'got bobs messages')
+				exit()
+			})
+		)
+	})
+})`
+
+	alice := ts.startJSBotWithName("alice", acceptInvite, after)
+
+	<-ts.doneJS
+
+	// bob confirmed and followed alice
+	follows, err := bob.GraphBuilder.Follows(bob.KeyPair.Id)
+	r.NoError(err)
+	r.True(follows.Has(alice), "bob doesn't follow alice")
+
+	// the invite can't be used again
+	guestClient, err := client.NewTCP(guest, bob.Network.GetListenAddr())
+	if err == nil {
+		_, err = guestClient.Async(context.TODO(), json.RawMessage{}, muxrpc.Method{"peerInvites", "getInvite"}, tok.Invite.Ref())
+		r.Error(err, "guest key %s still valid", guest.Id.Ref())
+		guestClient.Close()
+	}
+
+	ts.wait()
+}